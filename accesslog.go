@@ -0,0 +1,167 @@
+package apiproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// LogFormat selects the format of the lines written by AccessLog
+type LogFormat int
+
+const (
+	// CommonLogFormat writes lines in the Common Log Format, followed by the
+	// cache status and the upstream latency in milliseconds, e.g.
+	//
+	//	127.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET /users HTTP/1.1" 200 2326 HIT 0
+	CommonLogFormat LogFormat = iota
+	// JSONLogFormat writes a JSON object per line
+	JSONLogFormat
+)
+
+// AccessLog returns a handler passing requests to h, a proxy built by this
+// package, and writing a line describing each one to w once it is answered:
+// its status code, the bytes of body sent, the cache status (HIT, MISS,
+// STALE, REVALIDATED or BYPASS, or - if the cache wasn't involved) and the
+// time spent waiting on the upstream. Use LogWriter to write the lines
+// through a *log.Logger.
+func AccessLog(h http.Handler, w io.Writer, format LogFormat) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &httpcache.RequestInfo{}
+		lw := &loggingWriter{ResponseWriter: rw}
+		h.ServeHTTP(lw, r.WithContext(httpcache.WithRequestInfo(r.Context(), info)))
+
+		entry := accessEntry{
+			Time:       start,
+			Remote:     remoteHost(r),
+			User:       "-",
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Status:     lw.status,
+			Bytes:      lw.bytes,
+			Cache:      "-",
+			UpstreamMs: info.BackendLatency.Milliseconds(),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if user, _, ok := r.BasicAuth(); ok && user != "" {
+			entry.User = user
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if info.Status != "" {
+			entry.Cache = strings.ToUpper(info.Status)
+		}
+
+		var line []byte
+		switch format {
+		case JSONLogFormat:
+			line, _ = json.Marshal(entry)
+			line = append(line, '\n')
+		default:
+			line = []byte(fmt.Sprintf("%s - %s [%s] %q %d %d %s %d\n",
+				entry.Remote, entry.User, start.Format("02/Jan/2006:15:04:05 -0700"),
+				r.Method+" "+r.RequestURI+" "+r.Proto, entry.Status, entry.Bytes,
+				entry.Cache, entry.UpstreamMs))
+		}
+		mu.Lock()
+		w.Write(line)
+		mu.Unlock()
+	})
+}
+
+// accessEntry is a line written by AccessLog in JSONLogFormat
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Remote     string    `json:"remote"`
+	User       string    `json:"user"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Cache      string    `json:"cache"`
+	UpstreamMs int64     `json:"upstream_ms"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// remoteHost returns the address of the client that made r, without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loggingWriter records the status code and body length of a response. It
+// passes flushes and hijacks on, so that streamed and upgraded responses keep
+// working.
+type loggingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *loggingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loggingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *loggingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *loggingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("apiproxy: ResponseWriter does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// LogWriter returns an io.Writer writing each line written to it as a message
+// of l, e.g. to pass to AccessLog
+func LogWriter(l *log.Logger) io.Writer {
+	return logWriter{l}
+}
+
+type logWriter struct {
+	l *log.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if err := w.l.Output(2, strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
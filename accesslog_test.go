@@ -0,0 +1,82 @@
+package apiproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		fmt.Fprint(w, "hello")
+	})
+	target, _ := url.Parse(b.URL)
+	proxy := NewCachingReverseProxy(target, Options{MaxTTL: time.Hour})
+
+	var buf bytes.Buffer
+	h := AccessLog(proxy, &buf, CommonLogFormat)
+	serve(h, "/users?page=2")
+	req := httptest.NewRequest("GET", "/users?page=2", nil)
+	req.SetBasicAuth("acme", "secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+	wants := []string{
+		`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users\?page=2 HTTP/1\.1" 200 5 MISS \d+$`,
+		`^192\.0\.2\.1 - acme \[[^]]+\] "GET /users\?page=2 HTTP/1\.1" 200 5 HIT 0$`,
+	}
+	for i, want := range wants {
+		if !regexp.MustCompile(want).MatchString(lines[i]) {
+			t.Errorf("line %d = %q, want it to match %s", i, lines[i], want)
+		}
+	}
+
+	buf.Reset()
+	notFound := AccessLog(http.NotFoundHandler(), &buf, CommonLogFormat)
+	serve(notFound, "/missing")
+	if want := `"GET /missing HTTP/1.1" 404 19 - 0`; !strings.Contains(buf.String(), want) {
+		t.Errorf("got %q, want a line ending in %s for a request the cache wasn't involved in", buf.String(), want)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	h := AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "created")
+	}), &buf, JSONLogFormat)
+	serve(h, "/items")
+
+	var entry accessEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%q: %v", buf.String(), err)
+	}
+	if entry.Remote != "192.0.2.1" || entry.User != "-" || entry.Method != "GET" || entry.URI != "/items" ||
+		entry.Proto != "HTTP/1.1" || entry.Status != http.StatusCreated || entry.Bytes != 7 || entry.Cache != "-" {
+		t.Errorf("got %+v", entry)
+	}
+	if time.Since(entry.Time) > time.Minute {
+		t.Errorf("time = %v, want the time of the request", entry.Time)
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := LogWriter(log.New(&buf, "access: ", 0))
+	fmt.Fprint(w, "a line\n")
+	if got := buf.String(); got != "access: a line\n" {
+		t.Errorf("got %q, want a single line with the logger's prefix", got)
+	}
+}
@@ -0,0 +1,176 @@
+package apiproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator attaches upstream credentials to requests
+type Authenticator interface {
+	// Authenticate adds credentials to req, which it may modify
+	Authenticate(req *http.Request) error
+}
+
+// HeaderAuth is an Authenticator setting a header, such as an API key or a
+// static bearer token
+type HeaderAuth struct {
+	Name  string
+	Value string
+}
+
+// Authenticate sets the header on req
+func (a HeaderAuth) Authenticate(req *http.Request) error {
+	req.Header.Set(a.Name, a.Value)
+	return nil
+}
+
+// BasicAuth is an Authenticator using HTTP Basic authentication
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Authenticate sets the Authorization header of req
+func (a BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// tokenExpiryMargin is how long before it expires a token is refreshed, so
+// that it doesn't expire in flight
+const tokenExpiryMargin = 30 * time.Second
+
+// ClientCredentials is an Authenticator using bearer tokens obtained with the
+// OAuth 2.0 client credentials grant, see
+// https://tools.ietf.org/html/rfc6749#section-4.4. A token is requested from
+// TokenURL when first needed and reused until shortly before it expires, or
+// until a request using it is answered with 401 Unauthorized.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// Client requests tokens. If nil, net/http.DefaultClient is used.
+	Client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Authenticate sets the Authorization header of req to a current token
+func (a *ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := a.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns a current access token, requesting a new one if needed.
+// Concurrent callers wait for a single token request.
+func (a *ClientCredentials) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && (a.expiry.IsZero() || time.Now().Before(a.expiry)) {
+		return a.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("apiproxy: token request returned %s", resp.Status)
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", fmt.Errorf("apiproxy: malformed token response: %s", err)
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("apiproxy: token response has no access_token")
+	}
+	a.token = tok.AccessToken
+	a.expiry = time.Time{}
+	if tok.ExpiresIn > 0 {
+		lifetime := time.Duration(tok.ExpiresIn) * time.Second
+		if lifetime > 2*tokenExpiryMargin {
+			lifetime -= tokenExpiryMargin
+		}
+		a.expiry = time.Now().Add(lifetime)
+	}
+	return a.token, nil
+}
+
+// Reset drops the current token, so that the next request gets a new one
+func (a *ClientCredentials) Reset() {
+	a.mu.Lock()
+	a.token = ""
+	a.mu.Unlock()
+}
+
+// AuthTransport is an implementation of net/http.RoundTripper that adds the
+// credentials of Auth to each request, so that clients can reach the target
+// through the proxy without holding its secrets. If the target answers with
+// 401 Unauthorized and Auth has a Reset method, like ClientCredentials, it is
+// called so that the next request gets fresh credentials.
+type AuthTransport struct {
+	// Transport is the underlying transport. If nil, net/http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	Auth Authenticator
+}
+
+// RoundTrip sends a copy of req carrying Auth's credentials
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	if err := t.Auth.Authenticate(req); err != nil {
+		return nil, err
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		if r, ok := t.Auth.(interface{ Reset() }); ok {
+			r.Reset()
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,103 @@
+package apiproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAuthTransport(t *testing.T) {
+	cases := []struct {
+		name string
+		auth Authenticator
+		want string
+	}{
+		{"header", HeaderAuth{"Authorization", "token abc"}, "token abc"},
+		{"basic", BasicAuth{"user", "pass"}, "Basic dXNlcjpwYXNz"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.Header.Get("Authorization"))
+			})
+			req, _ := http.NewRequest("GET", b.URL, nil)
+			resp, err := (&AuthTransport{Auth: c.auth}).RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got, _ := ioutil.ReadAll(resp.Body); string(got) != c.want {
+				t.Errorf("Authorization = %q, want %q", got, c.want)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("credentials were added to the caller's request")
+			}
+		})
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	var issued int32
+	tokens := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.Method != "POST" || id != "client" || secret != "secret" ||
+			r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			http.Error(w, "bad token request", http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"t%d","expires_in":3600}`, n)
+	})
+	// the target rejects the first token, as if it had been revoked
+	target := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer t1" && atomic.LoadInt32(&issued) == 1 && r.URL.Path == "/revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	})
+
+	auth := &ClientCredentials{
+		TokenURL:     tokens.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	}
+	tr := &AuthTransport{Auth: auth}
+
+	for i := 0; i < 2; i++ {
+		if _, got := roundTrip(t, tr, target.URL); got != "Bearer t1" {
+			t.Fatalf("request %d: Authorization = %q, want Bearer t1", i, got)
+		}
+	}
+	if tokens.count() != 1 {
+		t.Errorf("got %d token requests, want 1 while the token is current", tokens.count())
+	}
+
+	if resp, _ := roundTrip(t, tr, target.URL+"/revoked"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %d, want 401", resp.StatusCode)
+	}
+	if _, got := roundTrip(t, tr, target.URL); got != "Bearer t2" {
+		t.Errorf("after a 401, Authorization = %q, want a new token Bearer t2", got)
+	}
+	if tokens.count() != 2 {
+		t.Errorf("got %d token requests, want 2", tokens.count())
+	}
+}
+
+func TestClientCredentialsError(t *testing.T) {
+	tokens := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+	})
+	target := newBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	tr := &AuthTransport{Auth: &ClientCredentials{TokenURL: tokens.URL}}
+	req, _ := http.NewRequest("GET", target.URL, nil)
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Error("got no error for a failed token request")
+	}
+	if target.count() != 0 {
+		t.Error("the request was sent without credentials")
+	}
+}
@@ -1,7 +1,6 @@
 package apiproxy
 
 import (
-	"fmt"
 	"net/http"
 	"net/textproto"
 	"regexp"
@@ -32,10 +31,6 @@ func (t *RequestModifyingTransport) RoundTrip(req *http.Request) (resp *http.Res
 		transport = http.DefaultTransport
 	}
 
-	for k, v := range resp.Header {
-		fmt.Println(k, v)
-	}
-
 	return transport.RoundTrip(req)
 }
 
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bcicen/apiproxy"
+	"github.com/bcicen/apiproxy/httpcache"
+	"github.com/bcicen/apiproxy/httpcache/bolt"
+	"github.com/bcicen/apiproxy/httpcache/memcache"
+	"github.com/bcicen/apiproxy/httpcache/redis"
+	"github.com/bcicen/apiproxy/httpcache/s3"
+	_ "github.com/bcicen/apiproxy/httpcache/zstd"
+	"golang.org/x/crypto/acme/autocert"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the contents of the config file
+type config struct {
+	// Listen is the address to serve the proxy on
+	Listen string `yaml:"listen"`
+	// Log is the level of messages logged: "debug", "error" or "none"
+	Log string `yaml:"log"`
+	// AccessLog is the file each request is logged to, "-" for standard
+	// output, or none if empty, in AccessLogFormat: "common" or "json"
+	AccessLog       string `yaml:"access_log"`
+	AccessLogFormat string `yaml:"access_log_format"`
+	// ShutdownTimeout bounds the wait for requests in flight on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ReloadEndpoint makes POST /-/reload reload the config file
+	ReloadEndpoint bool `yaml:"reload_endpoint"`
+	// APIKeys, if set, only lets the clients it lists use the proxy
+	APIKeys *apiKeysConfig `yaml:"api_keys"`
+	// TLS, if set, serves the proxy over HTTPS
+	TLS    *tlsConfig    `yaml:"tls"`
+	Cache  cacheConfig   `yaml:"cache"`
+	Routes []routeConfig `yaml:"routes"`
+}
+
+// tlsConfig configures the certificate the proxy is served with: one loaded
+// from Cert and Key, reloaded when they change, or one obtained from Let's
+// Encrypt for the Autocert hosts
+type tlsConfig struct {
+	Cert     string          `yaml:"cert"`
+	Key      string          `yaml:"key"`
+	Autocert *autocertConfig `yaml:"autocert"`
+}
+
+// autocertConfig configures certificates obtained from Let's Encrypt
+type autocertConfig struct {
+	// Hosts are the host names certificates are requested for
+	Hosts []string `yaml:"hosts"`
+	// CacheDir is the directory certificates are kept in across restarts
+	CacheDir string `yaml:"cache_dir"`
+	// Email is the contact address of the Let's Encrypt account
+	Email string `yaml:"email"`
+}
+
+// cacheConfig selects the cache backend shared by all routes
+type cacheConfig struct {
+	// Backend is one of "memory", "disk", "bolt", "redis", "memcache" or
+	// "s3"
+	Backend string `yaml:"backend"`
+	// MaxTTL is how long entries are kept at most
+	MaxTTL time.Duration `yaml:"max_ttl"`
+	// MaxBytes bounds each route's memory cache, if positive
+	MaxBytes int64 `yaml:"max_bytes"`
+	// SweepInterval is how often expired entries are removed from memory
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+	// Compress is the compression of the stored entries: "none", "gzip" or
+	// "zstd"
+	Compress string `yaml:"compress"`
+	// Dir is the directory of the disk cache
+	Dir string `yaml:"dir"`
+	// Path is the database file of the bolt cache
+	Path string `yaml:"path"`
+	// Addr is the address of the redis server
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	// Servers are the addresses of the memcached servers
+	Servers []string `yaml:"servers"`
+	// S3 locates the bucket of the s3 cache
+	S3 *s3Config `yaml:"s3"`
+}
+
+// s3Config describes an s3.Config. The credentials default to the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables.
+type s3Config struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	Prefix          string `yaml:"prefix"`
+	PathStyle       bool   `yaml:"path_style"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	// LocalTTL, if positive, buffers entries in memory for that long
+	LocalTTL time.Duration `yaml:"local_ttl"`
+}
+
+// cache returns the s3 cache keeping entries for at most maxTTL, sweeping
+// its local buffer every sweepInterval
+func (conf *s3Config) cache(maxTTL, sweepInterval time.Duration) (httpcache.Cache, error) {
+	sc := s3.Config{
+		Endpoint:        conf.Endpoint,
+		Region:          conf.Region,
+		Bucket:          conf.Bucket,
+		Prefix:          conf.Prefix,
+		PathStyle:       conf.PathStyle,
+		AccessKeyID:     conf.AccessKeyID,
+		SecretAccessKey: conf.SecretAccessKey,
+		SessionToken:    conf.SessionToken,
+	}
+	if sc.AccessKeyID == "" {
+		sc.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		sc.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sc.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	c, err := s3.New(sc, maxTTL)
+	if err != nil {
+		return nil, err
+	}
+	if conf.LocalTTL > 0 {
+		local := httpcache.NewMemoryCache(conf.LocalTTL)
+		local.StartJanitor(sweepInterval)
+		c.Local = local
+	}
+	return httpcache.FromCacheCtx(c), nil
+}
+
+// routeConfig describes an apiproxy.Route
+type routeConfig struct {
+	Host   string `yaml:"host"`
+	Prefix string `yaml:"prefix"`
+	Target string `yaml:"target"`
+	// TTL overrides cache.max_ttl for the route's memory cache
+	TTL    time.Duration `yaml:"ttl"`
+	Shared bool          `yaml:"shared"`
+	// Offline serves the route from its cache only, and OfflineHeader names
+	// a request header doing so for the requests carrying it
+	Offline       bool   `yaml:"offline"`
+	OfflineHeader string `yaml:"offline_header"`
+	// CacheNamespace partitions the route's cache: "client" by the api_keys
+	// client, "credentials" by Authorization header, or "header:NAME" by the
+	// values of header NAME
+	CacheNamespace string `yaml:"cache_namespace"`
+	// StatusTTLs caches responses with the listed status codes for at most
+	// the given time
+	StatusTTLs map[int]time.Duration `yaml:"status_ttls"`
+	// MaxBodyBytes is the largest response body stored, if positive
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// CacheableContentTypes lists the only media types stored, if set
+	CacheableContentTypes []string `yaml:"cacheable_content_types"`
+	// HeuristicFreshness keeps responses without explicit freshness
+	// information fresh for that fraction of the time since they were last
+	// modified
+	HeuristicFreshness float64 `yaml:"heuristic_freshness"`
+	// CacheHeader and CacheKeyHeader name headers set to how each response
+	// was produced and to its cache key, and SuppressAproxyHeaders removes
+	// the X-Aproxy ones
+	CacheHeader           string `yaml:"cache_header"`
+	CacheKeyHeader        string `yaml:"cache_key_header"`
+	SuppressAproxyHeaders bool   `yaml:"suppress_aproxy_headers"`
+	// Upstream configures the connections to Target
+	Upstream *upstreamConfig `yaml:"upstream"`
+	// RateLimit caps the rate of requests sent to Target
+	RateLimit *rateLimitConfig `yaml:"rate_limit"`
+	// Auth adds credentials to the requests sent to Target
+	Auth *authConfig `yaml:"auth"`
+	// Rewrite rewrites the requests sent to Target and its responses
+	Rewrite *rewriteConfig `yaml:"rewrite"`
+	// Fixtures is a HAR archive whose responses are stored in the cache at
+	// startup
+	Fixtures string `yaml:"fixtures"`
+	// WarmFile lists paths on Target, one per line, fetched at startup to
+	// prime the cache
+	WarmFile string `yaml:"warm_file"`
+	// BackgroundWorkers bounds the goroutines revalidating, refreshing and
+	// warming the cache in the background, if positive
+	BackgroundWorkers int `yaml:"background_workers"`
+}
+
+// authConfig describes an apiproxy.Authenticator: a header, if Header is set,
+// Basic authentication, if Username is set, or the OAuth 2.0 client
+// credentials grant, if TokenURL is set
+type authConfig struct {
+	Header string `yaml:"header"`
+	Value  string `yaml:"value"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// authenticator returns the configured Authenticator
+func (conf *authConfig) authenticator() (apiproxy.Authenticator, error) {
+	switch {
+	case conf.Header != "":
+		return apiproxy.HeaderAuth{Name: conf.Header, Value: conf.Value}, nil
+	case conf.Username != "":
+		return apiproxy.BasicAuth{Username: conf.Username, Password: conf.Password}, nil
+	case conf.TokenURL != "":
+		return &apiproxy.ClientCredentials{
+			TokenURL:     conf.TokenURL,
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			Scopes:       conf.Scopes,
+		}, nil
+	}
+	return nil, fmt.Errorf("auth needs header, username or token_url")
+}
+
+// rewriteConfig describes an apiproxy.Rewrite
+type rewriteConfig struct {
+	StripPrefix     string              `yaml:"strip_prefix"`
+	RequestHeaders  headerRewriteConfig `yaml:"request_headers"`
+	ResponseHeaders headerRewriteConfig `yaml:"response_headers"`
+	Body            []bodyRewriteConfig `yaml:"body"`
+}
+
+// headerRewriteConfig describes an apiproxy.HeaderRewrite
+type headerRewriteConfig struct {
+	Rename map[string]string `yaml:"rename"`
+	Remove []string          `yaml:"remove"`
+	Set    map[string]string `yaml:"set"`
+}
+
+// bodyRewriteConfig describes an apiproxy.BodyRewrite
+type bodyRewriteConfig struct {
+	ContentTypes []string `yaml:"content_types"`
+	Pattern      string   `yaml:"pattern"`
+	Replace      string   `yaml:"replace"`
+}
+
+// rewrite returns the configured Rewrite
+func (conf *rewriteConfig) rewrite() (*apiproxy.Rewrite, error) {
+	rw := &apiproxy.Rewrite{
+		StripPrefix: conf.StripPrefix,
+		Request:     apiproxy.HeaderRewrite(conf.RequestHeaders),
+		Response:    apiproxy.HeaderRewrite(conf.ResponseHeaders),
+	}
+	for i, bc := range conf.Body {
+		if bc.Pattern == "" {
+			return nil, fmt.Errorf("rewrite body %d: no pattern", i)
+		}
+		re, err := regexp.Compile(bc.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite body %d: %s", i, err)
+		}
+		rw.Body = append(rw.Body, apiproxy.BodyRewrite{ContentTypes: bc.ContentTypes, Pattern: re, Replacement: bc.Replace})
+	}
+	return rw, nil
+}
+
+// upstreamConfig describes an apiproxy.Upstream
+type upstreamConfig struct {
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host"`
+	RequestTimeout        time.Duration `yaml:"request_timeout"`
+
+	// ClientCert and ClientKey are the certificate presented to a target
+	// requiring mutual TLS, reloaded when they change
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	// CAFile holds the certificates the target's must be signed by, instead
+	// of the system roots
+	CAFile string `yaml:"ca_file"`
+}
+
+// certReloadInterval is how often certificate files are checked for changes
+const certReloadInterval = time.Minute
+
+// tlsClientConfig returns the TLS configuration for reaching the target, or
+// nil to use the default one, and the client certificate it presents if any
+func (conf *upstreamConfig) tlsClientConfig(logger httpcache.Logger) (*tls.Config, *apiproxy.CertReloader, error) {
+	var certs *apiproxy.CertReloader
+	if conf.ClientCert != "" {
+		var err error
+		if certs, err = apiproxy.NewCertReloader(conf.ClientCert, conf.ClientKey); err != nil {
+			return nil, nil, err
+		}
+		certs.Logger = logger
+	} else if conf.CAFile == "" {
+		return nil, nil, nil
+	}
+	config, err := apiproxy.ClientTLSConfig(certs, conf.CAFile)
+	return config, certs, err
+}
+
+// apiKeysConfig describes an apiproxy.Gateway
+type apiKeysConfig struct {
+	// Header carries the API keys, apiproxy.DefaultAPIKeyHeader if empty
+	Header  string         `yaml:"header"`
+	Clients []clientConfig `yaml:"clients"`
+}
+
+// clientConfig describes an apiproxy.Client
+type clientConfig struct {
+	Name      string           `yaml:"name"`
+	Key       string           `yaml:"key"`
+	RateLimit *rateLimitConfig `yaml:"rate_limit"`
+	Quota     *quotaConfig     `yaml:"quota"`
+}
+
+// quotaConfig describes an apiproxy.Quota
+type quotaConfig struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+}
+
+// gateway returns the Gateway passing the requests of the configured clients
+// to h
+func (conf *apiKeysConfig) gateway(h http.Handler) (*apiproxy.Gateway, error) {
+	keys := make(map[string]*apiproxy.Client, len(conf.Clients))
+	for i, cc := range conf.Clients {
+		if cc.Key == "" {
+			return nil, fmt.Errorf("api_keys client %d: no key", i)
+		}
+		if _, ok := keys[cc.Key]; ok {
+			return nil, fmt.Errorf("api_keys client %d: duplicate key", i)
+		}
+		client := &apiproxy.Client{Name: cc.Name}
+		if rl := cc.RateLimit; rl != nil {
+			if rl.Requests <= 0 || rl.Per <= 0 {
+				return nil, fmt.Errorf("api_keys client %d: rate_limit needs requests and per", i)
+			}
+			client.RateLimit = &apiproxy.RateLimit{Requests: rl.Requests, Per: rl.Per, Burst: rl.Burst, MaxWait: rl.MaxWait}
+		}
+		if q := cc.Quota; q != nil {
+			if q.Requests <= 0 || q.Per <= 0 {
+				return nil, fmt.Errorf("api_keys client %d: quota needs requests and per", i)
+			}
+			client.Quota = &apiproxy.Quota{Requests: q.Requests, Per: q.Per}
+		}
+		keys[cc.Key] = client
+	}
+	g := apiproxy.NewGateway(h, keys)
+	g.Header = conf.Header
+	return g, nil
+}
+
+// rateLimitConfig describes an apiproxy.RateLimit
+type rateLimitConfig struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"`
+	MaxWait  time.Duration `yaml:"max_wait"`
+}
+
+// readConfig reads the config file at path, in TOML if its name ends in
+// .toml and in YAML otherwise
+func readConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := &config{Listen: ":8080", Log: "error"}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if b, err = tomlToYAML(b); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if err := yaml.Unmarshal(b, conf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(conf.Routes) == 0 {
+		return nil, fmt.Errorf("%s: no routes", path)
+	}
+	if conf.ShutdownTimeout <= 0 {
+		conf.ShutdownTimeout = 30 * time.Second
+	}
+	if conf.Cache.MaxTTL <= 0 {
+		conf.Cache.MaxTTL = 10 * time.Minute
+	}
+	if conf.Cache.SweepInterval <= 0 {
+		conf.Cache.SweepInterval = time.Minute
+	}
+	return conf, nil
+}
+
+// tomlToYAML converts the TOML document b to YAML, so that TOML configs are
+// decoded by the yaml tags of the config types like YAML ones
+func tomlToYAML(b []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(intKeys(doc))
+}
+
+// intKeys returns v, decoded from TOML, with the keys of its tables that are
+// integers converted to integers, as TOML keys are always strings, e.g. the
+// status codes of status_ttls
+func intKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			if n, err := strconv.Atoi(k); err == nil {
+				m[n] = intKeys(e)
+			} else {
+				m[k] = intKeys(e)
+			}
+		}
+		return m
+	case []map[string]interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = intKeys(e)
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = intKeys(e)
+		}
+		return l
+	}
+	return v
+}
+
+// readLines returns the lines of the file at path, skipping blank lines and
+// those starting with #
+func readLines(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// handler returns the handler serving proxy, reloaded by r, logging requests
+// if configured to
+func (conf *config) handler(proxy *apiproxy.Proxy, r *reloader) (http.Handler, error) {
+	h := http.Handler(proxy)
+	if conf.APIKeys != nil {
+		g, err := conf.APIKeys.gateway(h)
+		if err != nil {
+			return nil, err
+		}
+		h = g
+	}
+	if conf.ReloadEndpoint {
+		reload, next := proxy.ReloadHandler(r.load), h
+		h = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == reloadPath {
+				reload.ServeHTTP(w, req)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+	if conf.AccessLog == "" {
+		return h, nil
+	}
+
+	var format apiproxy.LogFormat
+	switch conf.AccessLogFormat {
+	case "common", "":
+		format = apiproxy.CommonLogFormat
+	case "json":
+		format = apiproxy.JSONLogFormat
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", conf.AccessLogFormat)
+	}
+	w := io.Writer(os.Stdout)
+	if conf.AccessLog != "-" {
+		f, err := os.OpenFile(conf.AccessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return apiproxy.AccessLog(h, w, format), nil
+}
+
+// tlsConfig returns the TLS configuration the proxy is served with, or nil if
+// it is served over plain HTTP
+func (conf *config) tlsConfig() (*tls.Config, error) {
+	if conf.TLS == nil {
+		return nil, nil
+	}
+	if ac := conf.TLS.Autocert; ac != nil {
+		if len(ac.Hosts) == 0 {
+			return nil, fmt.Errorf("tls.autocert needs hosts")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(ac.Hosts...),
+			Email:      ac.Email,
+		}
+		if ac.CacheDir != "" {
+			m.Cache = autocert.DirCache(ac.CacheDir)
+		}
+		return m.TLSConfig(), nil
+	}
+	if conf.TLS.Cert == "" || conf.TLS.Key == "" {
+		return nil, fmt.Errorf("tls needs cert and key, or autocert")
+	}
+	logger, err := conf.logger()
+	if err != nil {
+		return nil, err
+	}
+	certs, err := apiproxy.NewCertReloader(conf.TLS.Cert, conf.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	certs.Logger = logger
+	certs.Watch(certReloadInterval)
+	return &tls.Config{GetCertificate: certs.GetCertificate}, nil
+}
+
+// logger returns the Logger for the configured level
+func (conf *config) logger() (httpcache.Logger, error) {
+	std := httpcache.StdLogger{Logger: log.New(os.Stderr, "", log.LstdFlags)}
+	switch conf.Log {
+	case "debug":
+		return std, nil
+	case "error":
+		return errorLogger{std}, nil
+	case "none", "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown log level %q", conf.Log)
+}
+
+// errorLogger is a Logger discarding debug messages
+type errorLogger struct {
+	httpcache.Logger
+}
+
+func (errorLogger) Debugf(format string, args ...interface{}) {}
+
+// cache returns the configured Cache shared by all routes, or nil if each
+// route gets its own memory cache
+func (conf *cacheConfig) cache() (httpcache.Cache, error) {
+	switch conf.Backend {
+	case "memory", "":
+		return nil, nil
+	case "disk":
+		return httpcache.NewDiskCache(conf.Dir, conf.MaxTTL)
+	case "bolt":
+		c, err := bolt.Open(conf.Path, conf.MaxTTL)
+		if err != nil {
+			return nil, err
+		}
+		c.StartJanitor(conf.MaxTTL)
+		return c, nil
+	case "redis":
+		return redis.NewWithAuth(conf.Addr, conf.Password, conf.DB, conf.MaxTTL), nil
+	case "memcache":
+		return memcache.New(conf.MaxTTL, conf.Servers...), nil
+	case "s3":
+		if conf.S3 == nil {
+			return nil, fmt.Errorf("cache backend s3 needs s3")
+		}
+		return conf.S3.cache(conf.MaxTTL, conf.SweepInterval)
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", conf.Backend)
+}
+
+// compression returns the configured compression of the stored entries
+func (conf *cacheConfig) compression() (httpcache.CompressionCodec, error) {
+	switch conf.Compress {
+	case "none", "":
+		return httpcache.CodecNone, nil
+	case "gzip":
+		return httpcache.CodecGzip, nil
+	case "zstd":
+		return httpcache.CodecZstd, nil
+	}
+	return httpcache.CodecNone, fmt.Errorf("unknown cache compression %q", conf.Compress)
+}
+
+// keyPrefixFunc returns the KeyPrefixFunc partitioning a cache by namespace,
+// or nil if it is empty
+func keyPrefixFunc(namespace string) (func(*http.Request) string, error) {
+	switch {
+	case namespace == "":
+		return nil, nil
+	case namespace == "client":
+		return apiproxy.ClientKeyPrefix, nil
+	case namespace == "credentials":
+		return httpcache.KeyPrefixCredentials, nil
+	case strings.HasPrefix(namespace, "header:") && len(namespace) > len("header:"):
+		return httpcache.KeyPrefixHeader(strings.TrimSpace(namespace[len("header:"):])), nil
+	}
+	return nil, fmt.Errorf("unknown cache_namespace %q", namespace)
+}
+
+// routes returns the configured routes, sharing cache unless it is nil, and
+// the client certificates they present to their targets
+func (conf *config) routes(cache httpcache.Cache) ([]apiproxy.Route, []*apiproxy.CertReloader, error) {
+	logger, err := conf.logger()
+	if err != nil {
+		return nil, nil, err
+	}
+	compression, err := conf.Cache.compression()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	routes := make([]apiproxy.Route, len(conf.Routes))
+	var clientCerts []*apiproxy.CertReloader
+	for i, rc := range conf.Routes {
+		target, err := url.Parse(rc.Target)
+		if err != nil || target.Host == "" {
+			return nil, nil, fmt.Errorf("route %d: target must be an absolute URL", i)
+		}
+		opts := apiproxy.Options{
+			Cache:                 cache,
+			MaxTTL:                conf.Cache.MaxTTL,
+			Compression:           compression,
+			Logger:                logger,
+			Shared:                rc.Shared,
+			StatusTTLs:            rc.StatusTTLs,
+			HeuristicFreshness:    rc.HeuristicFreshness,
+			MaxBodyBytes:          rc.MaxBodyBytes,
+			CacheableContentTypes: rc.CacheableContentTypes,
+			CacheHeader:           rc.CacheHeader,
+			CacheKeyHeader:        rc.CacheKeyHeader,
+			SuppressAproxyHeaders: rc.SuppressAproxyHeaders,
+			Offline:               rc.Offline,
+			OfflineHeader:         rc.OfflineHeader,
+			BackgroundWorkers:     rc.BackgroundWorkers,
+		}
+		if rc.TTL > 0 {
+			opts.MaxTTL = rc.TTL
+		}
+		if opts.KeyPrefixFunc, err = keyPrefixFunc(rc.CacheNamespace); err != nil {
+			return nil, nil, fmt.Errorf("route %d: %s", i, err)
+		}
+		if u := rc.Upstream; u != nil {
+			opts.Upstream = &apiproxy.Upstream{
+				DialTimeout:           u.DialTimeout,
+				TLSHandshakeTimeout:   u.TLSHandshakeTimeout,
+				ResponseHeaderTimeout: u.ResponseHeaderTimeout,
+				IdleConnTimeout:       u.IdleConnTimeout,
+				MaxIdleConnsPerHost:   u.MaxIdleConnsPerHost,
+				MaxConnsPerHost:       u.MaxConnsPerHost,
+				RequestTimeout:        u.RequestTimeout,
+			}
+			var certs *apiproxy.CertReloader
+			if opts.Upstream.TLSClientConfig, certs, err = u.tlsClientConfig(logger); err != nil {
+				return nil, nil, fmt.Errorf("route %d: %s", i, err)
+			}
+			if certs != nil {
+				clientCerts = append(clientCerts, certs)
+			}
+		}
+		if rl := rc.RateLimit; rl != nil {
+			if rl.Requests <= 0 || rl.Per <= 0 {
+				return nil, nil, fmt.Errorf("route %d: rate_limit needs requests and per", i)
+			}
+			opts.RateLimit = &apiproxy.RateLimit{Requests: rl.Requests, Per: rl.Per, Burst: rl.Burst, MaxWait: rl.MaxWait}
+		}
+		if rc.Fixtures != "" {
+			if opts.Fixtures, err = ioutil.ReadFile(rc.Fixtures); err != nil {
+				return nil, nil, fmt.Errorf("route %d: %s", i, err)
+			}
+		}
+		if rc.WarmFile != "" {
+			if opts.Warm, err = readLines(rc.WarmFile); err != nil {
+				return nil, nil, fmt.Errorf("route %d: %s", i, err)
+			}
+		}
+		if rc.Auth != nil {
+			if opts.Auth, err = rc.Auth.authenticator(); err != nil {
+				return nil, nil, fmt.Errorf("route %d: %s", i, err)
+			}
+		}
+		if rc.Rewrite != nil {
+			if opts.Rewrite, err = rc.Rewrite.rewrite(); err != nil {
+				return nil, nil, fmt.Errorf("route %d: %s", i, err)
+			}
+		}
+		if opts.Cache == nil {
+			opts.MaxBytes = conf.Cache.MaxBytes
+			opts.JanitorInterval = conf.Cache.SweepInterval
+		}
+		routes[i] = apiproxy.Route{Host: rc.Host, Prefix: rc.Prefix, Target: target, Options: opts}
+	}
+	return routes, clientCerts, nil
+}
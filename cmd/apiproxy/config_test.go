@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes conf to a file named name in a temporary directory and
+// returns its path
+func writeConfig(t *testing.T, name, conf string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const sampleYAML = `
+cache:
+  backend: memory
+routes:
+  - prefix: /github
+    target: https://api.github.com
+    ttl: 1m
+    status_ttls:
+      404: 30s
+    upstream:
+      max_idle_conns_per_host: 16
+`
+
+const sampleTOML = `
+[cache]
+backend = "memory"
+
+[[routes]]
+prefix = "/github"
+target = "https://api.github.com"
+ttl = "1m"
+status_ttls = { 404 = "30s" }
+
+[routes.upstream]
+max_idle_conns_per_host = 16
+`
+
+func TestReadConfig(t *testing.T) {
+	for name, sample := range map[string]string{"apiproxy.yaml": sampleYAML, "apiproxy.toml": sampleTOML} {
+		t.Run(name, func(t *testing.T) {
+			conf, err := readConfig(writeConfig(t, name, sample))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.Listen != ":8080" || conf.Log != "error" {
+				t.Errorf("listen %q, log %q, want the defaults :8080, error", conf.Listen, conf.Log)
+			}
+			if conf.ShutdownTimeout != 30*time.Second {
+				t.Errorf("shutdown_timeout = %v, want the default 30s", conf.ShutdownTimeout)
+			}
+			if conf.Cache.MaxTTL != 10*time.Minute || conf.Cache.SweepInterval != time.Minute {
+				t.Errorf("max_ttl %v, sweep_interval %v, want the defaults 10m, 1m", conf.Cache.MaxTTL, conf.Cache.SweepInterval)
+			}
+			if len(conf.Routes) != 1 {
+				t.Fatalf("got %d routes, want 1", len(conf.Routes))
+			}
+			rc := conf.Routes[0]
+			if rc.Prefix != "/github" || rc.Target != "https://api.github.com" || rc.TTL != time.Minute {
+				t.Errorf("route %+v, want /github to https://api.github.com for 1m", rc)
+			}
+			if want := map[int]time.Duration{404: 30 * time.Second}; !reflect.DeepEqual(rc.StatusTTLs, want) {
+				t.Errorf("status_ttls = %v, want %v", rc.StatusTTLs, want)
+			}
+			if rc.Upstream == nil || rc.Upstream.MaxIdleConnsPerHost != 16 {
+				t.Errorf("upstream = %+v, want max_idle_conns_per_host 16", rc.Upstream)
+			}
+		})
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, conf, wantErr string
+	}{
+		{name: "apiproxy.yaml", conf: "listen: :9090\n", wantErr: "no routes"},
+		{name: "apiproxy.toml", conf: "listen = \":9090\"\n", wantErr: "no routes"},
+		{name: "apiproxy.yaml", conf: "routes: {", wantErr: "apiproxy.yaml"},
+		{name: "apiproxy.toml", conf: "routes = [", wantErr: "apiproxy.toml"},
+	}
+	for _, tt := range tests {
+		_, err := readConfig(writeConfig(t, tt.name, tt.conf))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s %q: error %v, want one mentioning %q", tt.name, tt.conf, err, tt.wantErr)
+		}
+	}
+	if _, err := readConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("missing file: no error")
+	}
+}
+
+func TestConfigRoutes(t *testing.T) {
+	conf, err := readConfig(writeConfig(t, "apiproxy.yaml", sampleYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, _, err := conf.routes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Prefix != "/github" {
+		t.Errorf("routes = %+v, want the /github one", routes)
+	}
+
+	conf.Routes[0].Target = "/relative"
+	if _, _, err := conf.routes(nil); err == nil || !strings.Contains(err.Error(), "absolute URL") {
+		t.Errorf("relative target: error %v, want one asking for an absolute URL", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Command apiproxy runs a caching reverse proxy configured by a YAML file, or
+// a TOML one if its name ends in .toml, with the same keys, e.g.
+//
+//	listen: ":8080"
+//	log: error
+//	access_log: "-"
+//	cache:
+//	  backend: memory
+//	  max_ttl: 10m
+//	routes:
+//	  - prefix: /github
+//	    target: https://api.github.com
+//	    upstream:
+//	      dial_timeout: 5s
+//	      response_header_timeout: 10s
+//	      request_timeout: 30s
+//	      max_idle_conns_per_host: 16
+//	      client_cert: /etc/apiproxy/client.pem
+//	      client_key: /etc/apiproxy/client-key.pem
+//	    rate_limit:
+//	      requests: 5000
+//	      per: 1h
+//	      max_wait: 5s
+//	    auth:
+//	      header: Authorization
+//	      value: token 0123456789abcdef
+//	  - prefix: /gitlab
+//	    target: https://gitlab.com
+//	    ttl: 1m
+//	    status_ttls:
+//	      404: 30s
+//
+// or in TOML, with durations as strings,
+//
+//	listen = ":8080"
+//	[cache]
+//	backend = "memory"
+//	max_ttl = "10m"
+//	[[routes]]
+//	prefix = "/gitlab"
+//	target = "https://gitlab.com"
+//	status_ttls = { 404 = "30s" }
+//
+// tls, if set, serves the proxy over HTTPS with a certificate loaded from
+// cert and key, or obtained from Let's Encrypt with autocert (hosts,
+// cache_dir, email), which needs listen to be reachable on port 443.
+// Certificate files, including the client certificates of routes, are
+// reloaded when they change or the process receives SIGHUP.
+//
+// api_keys, if set, only lets the clients it lists use the proxy, each
+// presenting its key in the header named by api_keys.header (X-Api-Key by
+// default) or as a bearer token, e.g.
+//
+//	api_keys:
+//	  clients:
+//	    - name: acme
+//	      key: 3f1c9a...
+//	      rate_limit:
+//	        requests: 10
+//	        per: 1s
+//	      quota:
+//	        requests: 100000
+//	        per: 24h
+//
+// Other requests are answered with 401 Unauthorized, and those over their
+// client's rate_limit or quota with 429 Too Many Requests, before they reach
+// the cache.
+//
+// The routes are reloaded from the config file when the process receives
+// SIGHUP, or, if reload_endpoint is true, a POST to /-/reload, without
+// dropping requests in flight or the responses cached for routes that keep
+// their host and prefix; a config that fails to load is reported and the
+// previous one kept. listen, tls, access_log, api_keys, reload_endpoint and
+// the cache backend are only read at startup.
+//
+// On SIGINT or SIGTERM, the proxy stops accepting connections and waits up to
+// shutdown_timeout (30s by default) for the requests in flight and the
+// background revalidations before closing the cache backend.
+//
+// access_log names the file requests are logged to, or "-" for standard
+// output, in access_log_format: common (the default) or json.
+//
+// cache.backend is one of memory (the default, one cache per route, bounded
+// by max_bytes if set, with expired entries removed every sweep_interval, 1m
+// by default), disk (dir), bolt (path), redis (addr, password, db),
+// memcache (servers) or s3 (s3: endpoint, region, bucket, prefix,
+// path_style, access_key_id, secret_access_key, taken from the AWS_*
+// environment variables if unset, and local_ttl, which buffers entries in
+// memory). cache.compress: gzip or zstd compresses the stored entries.
+//
+// A route's upstream configures the connections to its target: dial_timeout,
+// tls_handshake_timeout, response_header_timeout, idle_conn_timeout,
+// max_idle_conns_per_host, max_conns_per_host and request_timeout, which
+// bounds each request as a whole; requests that time out are answered with
+// 504 Gateway Timeout. client_cert and client_key are presented to targets
+// requiring mutual TLS, and ca_file replaces the system roots in verifying
+// the target's certificate.
+//
+// A route's rate_limit caps the requests sent to its target, holding back
+// requests over it for up to max_wait (if set) before answering them with 429
+// Too Many Requests; burst bounds how many may be sent at once, by default
+// requests. A route's auth adds credentials to the requests sent to its
+// target: a header (header, value), Basic authentication (username,
+// password) or OAuth 2.0 client credentials (token_url, client_id,
+// client_secret, scopes). A route's warm_file lists paths on its target, one
+// per line, fetched at startup to prime the cache, and its
+// background_workers, e.g. 8, bounds the goroutines warming, revalidating and
+// refreshing its cache in the background. A route's heuristic_freshness,
+// e.g. 0.1, keeps responses with a Last-Modified header but no explicit
+// freshness fresh for that fraction of their age, rather than for the max_ttl
+// of the cache. A route's max_body_bytes and
+// cacheable_content_types, e.g. [application/json, "text/*"], keep larger
+// responses and other media types out of the cache. A route's cache_header,
+// e.g. X-Cache, is set on responses to HIT, MISS, STALE, REVALIDATED or
+// BYPASS, its cache_key_header to their cache key, and
+// suppress_aproxy_headers removes the X-Aproxy-From-Cache and
+// X-Aproxy-Cacheable headers. A route's cache_namespace partitions its cache
+// so that responses differing per caller are never served to another:
+// client by api_keys client name, credentials by Authorization header, or
+// header:NAME, e.g. header:X-Tenant-Id, by the values of a header.
+//
+// A route with offline: true never contacts its target: requests are answered
+// from the cache, however stale, or with 504 Gateway Timeout, e.g. to ride
+// out an outage; reloading the config turns it on and off without losing the
+// cache. offline_header, e.g. X-Offline, does so only for the requests
+// carrying that header.
+//
+// A route's fixtures names a HAR archive, e.g. one exported from another
+// proxy's cache, or recorded by a browser, whose responses are stored in the
+// cache at startup and on reload; with offline, the route replays them.
+//
+// A route's rewrite rewrites the requests sent to its target and the
+// responses it returns, before they are cached, e.g.
+//
+//	rewrite:
+//	  strip_prefix: /v1
+//	  request_headers:
+//	    rename: {X-Client-Token: Authorization}
+//	    set: {Accept: application/json}
+//	  response_headers:
+//	    remove: [Server, X-Powered-By]
+//	  body:
+//	    - content_types: [application/json]
+//	      pattern: 'https://api\.example\.com'
+//	      replace: https://proxy.example.com/example
+//
+// Header rules rename, then remove, then set headers. Body patterns are Go
+// regular expressions, whose submatches replace may refer to as $1.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bcicen/apiproxy"
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+func main() {
+	path := flag.String("config", "apiproxy.yaml", "config file")
+	flag.Parse()
+
+	conf, err := readConfig(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache, err := conf.Cache.cache()
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := &reloader{path: *path, cache: cache}
+	proxyConf, err := r.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxy := apiproxy.NewProxy(proxyConf)
+	r.watch(proxy)
+	handler, err := conf.handler(proxy, r)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tlsConfig, err := conf.tlsConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &apiproxy.Server{Addr: conf.Listen, Handler: handler, TLSConfig: tlsConfig, Proxy: proxy}
+	if cache != nil {
+		srv.Caches = []httpcache.Cache{cache}
+	}
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s", conf.Listen)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	log.Printf("%s: shutting down", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/bcicen/apiproxy"
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// reloadPath is the path of the reload endpoint
+const reloadPath = "/-/reload"
+
+// reloader builds the proxy configuration from the config file at path,
+// whose listen, tls, access_log and cache settings are only read at startup
+type reloader struct {
+	path string
+	// cache is the cache shared by all routes, kept across reloads
+	cache httpcache.Cache
+
+	mu sync.Mutex
+	// stop stops watching the client certificates of the current routes
+	stop []func()
+}
+
+// load reads the config file and returns the proxy configuration it describes
+func (r *reloader) load() (apiproxy.Config, error) {
+	conf, err := readConfig(r.path)
+	if err != nil {
+		return apiproxy.Config{}, err
+	}
+	routes, clientCerts, err := conf.routes(r.cache)
+	if err != nil {
+		return apiproxy.Config{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, stop := range r.stop {
+		stop()
+	}
+	r.stop = r.stop[:0]
+	for _, certs := range clientCerts {
+		r.stop = append(r.stop, certs.Watch(certReloadInterval))
+	}
+	return apiproxy.Config{Routes: routes}, nil
+}
+
+// watch reloads proxy each time the process receives SIGHUP
+func (r *reloader) watch(proxy *apiproxy.Proxy) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			conf, err := r.load()
+			if err != nil {
+				log.Printf("reloading %s: %s", r.path, err)
+				continue
+			}
+			proxy.Reload(conf)
+			log.Printf("reloaded %s", r.path)
+		}
+	}()
+}
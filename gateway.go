@@ -0,0 +1,226 @@
+package apiproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is a client of a Gateway, identified by its API key
+type Client struct {
+	// Name identifies the client, e.g. to the handlers behind the Gateway,
+	// see ClientFromContext
+	Name string
+	// RateLimit, if set, caps the rate of the client's requests. Requests
+	// over it are held back for up to its MaxWait, then answered with 429 Too
+	// Many Requests.
+	RateLimit *RateLimit
+	// Quota, if set, caps the number of the client's requests in each period
+	Quota *Quota
+}
+
+// Quota is a limit of Requests in each period Per, e.g. 10000 a day, counted
+// from the first request of the period
+type Quota struct {
+	Requests int
+	Per      time.Duration
+}
+
+// DefaultAPIKeyHeader is the header clients present their API key in, unless
+// Gateway.Header is set
+const DefaultAPIKeyHeader = "X-Api-Key"
+
+// Gateway is a handler authenticating clients by API key and enforcing their
+// rate limits and quotas before passing their requests to Handler, e.g. a
+// proxy built by this package, so that requests it refuses never reach the
+// cache or the upstream. Requests without a known key are answered with 401
+// Unauthorized, and those over their client's limits with 429 Too Many
+// Requests and a Retry-After header. The key is removed from the requests
+// passed on.
+type Gateway struct {
+	Handler http.Handler
+
+	// Header is the header carrying the API key, DefaultAPIKeyHeader if
+	// empty. Keys are also accepted as bearer tokens in the Authorization
+	// header.
+	Header string
+	// Keys maps API keys to their clients
+	Keys map[string]*Client
+	// Lookup, if set, is called for the keys missing from Keys, e.g. to read
+	// them from a database, and returns false for those that are unknown.
+	// Its clients' limits are enforced per key.
+	Lookup func(key string) (*Client, bool)
+
+	mu     sync.Mutex
+	limits map[string]*clientLimits
+}
+
+// clientLimits tracks the requests made with a key
+type clientLimits struct {
+	bucket *bucket
+	// start is when the current quota period started, and used how many
+	// requests were made in it
+	start time.Time
+	used  int
+}
+
+// NewGateway returns a new Gateway passing the requests of the clients of
+// keys to h
+func NewGateway(h http.Handler, keys map[string]*Client) *Gateway {
+	return &Gateway{Handler: h, Keys: keys}
+}
+
+type clientKey struct{}
+
+// ClientFromContext returns the Client a Gateway authenticated the request
+// with context ctx as, if any
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(*Client)
+	return c, ok
+}
+
+// ClientKeyPrefix is an httpcache.Transport.KeyPrefixFunc partitioning the
+// cache of a proxy behind a Gateway by the name of the client making each
+// request, so clients must have distinct names. Requests that didn't go
+// through a Gateway share the empty namespace.
+func ClientKeyPrefix(r *http.Request) string {
+	if c, ok := ClientFromContext(r.Context()); ok {
+		return c.Name
+	}
+	return ""
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, fromAuthorization := g.apiKey(r)
+	client, ok := g.client(key)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="apiproxy"`)
+		http.Error(w, "missing or unknown API key", http.StatusUnauthorized)
+		return
+	}
+
+	wait, err := g.admit(key, client, time.Now())
+	if err != nil {
+		secs := int64((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			g.cancel(key)
+			return
+		}
+	}
+
+	r2 := cloneRequest(r)
+	if fromAuthorization {
+		r2.Header.Del("Authorization")
+	} else {
+		r2.Header.Del(g.header())
+	}
+	g.Handler.ServeHTTP(w, r2.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+}
+
+func (g *Gateway) header() string {
+	if g.Header != "" {
+		return g.Header
+	}
+	return DefaultAPIKeyHeader
+}
+
+// apiKey returns the API key of r, and true if it is a bearer token
+func (g *Gateway) apiKey(r *http.Request) (string, bool) {
+	if key := r.Header.Get(g.header()); key != "" {
+		return key, false
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:]), true
+	}
+	return "", false
+}
+
+// client returns the client of key, and false if it is unknown
+func (g *Gateway) client(key string) (*Client, bool) {
+	if key == "" {
+		return nil, false
+	}
+	if c, ok := g.Keys[key]; ok && c != nil {
+		return c, true
+	}
+	if g.Lookup != nil {
+		if c, ok := g.Lookup(key); ok && c != nil {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Errors of the requests refused by Gateway.admit
+var (
+	errQuotaExceeded     = errors.New("client quota exceeded")
+	errRateLimitExceeded = errors.New("client rate limit exceeded")
+)
+
+// admit counts a request made with key by client at now against its limits,
+// returning how long it must wait for its turn, or, without counting it, the
+// limit it is over along with when it may retry
+func (g *Gateway) admit(key string, client *Client, now time.Time) (time.Duration, error) {
+	if client.RateLimit == nil && client.Quota == nil {
+		return 0, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limits == nil {
+		g.limits = make(map[string]*clientLimits)
+	}
+	l, ok := g.limits[key]
+	if !ok {
+		l = &clientLimits{start: now}
+		g.limits[key] = l
+	}
+
+	if q := client.Quota; q != nil && q.Requests > 0 && q.Per > 0 {
+		if now.Sub(l.start) >= q.Per {
+			l.start, l.used = now, 0
+		}
+		if l.used >= q.Requests {
+			return l.start.Add(q.Per).Sub(now), errQuotaExceeded
+		}
+	}
+	var wait time.Duration
+	if rl := client.RateLimit; rl != nil && rl.Requests > 0 && rl.Per > 0 {
+		if l.bucket == nil {
+			l.bucket = newBucket(*rl, now)
+		}
+		if wait, ok = l.bucket.take(*rl, now); !ok {
+			return wait, errRateLimitExceeded
+		}
+	}
+	l.used++
+	return wait, nil
+}
+
+// cancel returns the token taken by a request made with key that was not
+// passed on
+func (g *Gateway) cancel(key string) {
+	g.mu.Lock()
+	if l, ok := g.limits[key]; ok {
+		if l.bucket != nil {
+			l.bucket.tokens++
+		}
+		if l.used > 0 {
+			l.used--
+		}
+	}
+	g.mu.Unlock()
+}
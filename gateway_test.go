@@ -0,0 +1,112 @@
+package apiproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// whoami answers with the name of the client a Gateway authenticated the
+// request as, and the API key headers it was passed on with
+var whoami = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	c, _ := ClientFromContext(r.Context())
+	fmt.Fprintf(w, "%s %q %q", c.Name, r.Header.Get(DefaultAPIKeyHeader), r.Header.Get("Authorization"))
+})
+
+func TestGatewayAuthentication(t *testing.T) {
+	g := NewGateway(whoami, map[string]*Client{"k1": {Name: "acme"}})
+	g.Lookup = func(key string) (*Client, bool) {
+		if key == "k2" {
+			return &Client{Name: "looked up"}, true
+		}
+		return nil, false
+	}
+
+	tests := []struct {
+		name     string
+		header   []string
+		wantCode int
+		wantBody string
+	}{
+		{"no key", nil, http.StatusUnauthorized, ""},
+		{"unknown key", []string{DefaultAPIKeyHeader, "nope"}, http.StatusUnauthorized, ""},
+		{"unknown bearer token", []string{"Authorization", "Bearer nope"}, http.StatusUnauthorized, ""},
+		{"header", []string{DefaultAPIKeyHeader, "k1"}, http.StatusOK, `acme "" ""`},
+		{"bearer token", []string{"Authorization", "bearer k1"}, http.StatusOK, `acme "" ""`},
+		{"lookup", []string{DefaultAPIKeyHeader, "k2"}, http.StatusOK, `looked up "" ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(g, "/", tt.header...)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusUnauthorized {
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("401 without a WWW-Authenticate header")
+				}
+				return
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("got %s, want %s, with the key removed", got, tt.wantBody)
+			}
+		})
+	}
+
+	g.Header = "X-Token"
+	if rec := serve(g, "/", "X-Token", "k1"); rec.Code != http.StatusOK {
+		t.Errorf("got %d with the key in the configured header, want 200", rec.Code)
+	}
+}
+
+func TestGatewayLimits(t *testing.T) {
+	g := NewGateway(whoami, map[string]*Client{
+		"limited": {Name: "limited", RateLimit: &RateLimit{Requests: 2, Per: time.Hour}},
+		"quota":   {Name: "quota", Quota: &Quota{Requests: 3, Per: 24 * time.Hour}},
+		"other":   {Name: "other", RateLimit: &RateLimit{Requests: 2, Per: time.Hour}},
+	})
+
+	check := func(key string, n int, wantRetryAfter time.Duration) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if rec := serve(g, "/", DefaultAPIKeyHeader, key); rec.Code != http.StatusOK {
+				t.Fatalf("%s: request %d got %d, want 200", key, i, rec.Code)
+			}
+		}
+		rec := serve(g, "/", DefaultAPIKeyHeader, key)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: request %d got %d, want 429", key, n, rec.Code)
+		}
+		secs, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		if err != nil || time.Duration(secs)*time.Second != wantRetryAfter {
+			t.Errorf("%s: Retry-After = %q, want %v", key, rec.Header().Get("Retry-After"), wantRetryAfter)
+		}
+	}
+	check("limited", 2, 30*time.Minute)
+	check("quota", 3, 24*time.Hour)
+	// limits are per key
+	check("other", 2, 30*time.Minute)
+}
+
+func TestGatewayAdmit(t *testing.T) {
+	g := &Gateway{}
+	client := &Client{Quota: &Quota{Requests: 1, Per: time.Hour}}
+	start := time.Now()
+	if _, err := g.admit("k", client, start); err != nil {
+		t.Fatal(err)
+	}
+	if wait, err := g.admit("k", client, start.Add(20*time.Minute)); err != errQuotaExceeded || wait != 40*time.Minute {
+		t.Errorf("got %v, %v, want %v until the period ends", wait, err, errQuotaExceeded)
+	}
+	if _, err := g.admit("k", client, start.Add(time.Hour)); err != nil {
+		t.Errorf("in the next period got %v, want the quota renewed", err)
+	}
+
+	// a request that is not passed on gives its token back
+	g.cancel("k")
+	if _, err := g.admit("k", client, start.Add(time.Hour)); err != nil {
+		t.Errorf("after cancel got %v, want the request not counted", err)
+	}
+}
@@ -0,0 +1,163 @@
+package apiproxy
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPassiveCooldown is how long a target marked down by passive checks
+// is left alone when PassiveCooldown is not set
+const defaultPassiveCooldown = 30 * time.Second
+
+// HealthCheck configures the active health checks of a HostPool target
+type HealthCheck struct {
+	// Path is requested from the target with GET each Interval. The check
+	// fails unless the target answers with a status below 400 within
+	// Timeout, or Interval if Timeout is 0. If Path is empty, the target is
+	// not checked.
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Failures is the number of consecutive failed checks marking the target
+	// down, 1 if unset. A single successful check marks it up again.
+	Failures int
+}
+
+// StartHealthChecks starts checking every target with the same HealthCheck:
+// requesting path each interval, a target is marked down after failures
+// consecutive checks fail to get a response below 400, and up again after a
+// check succeeds. Call Stop to end the checks. Calling StartHealthChecks again
+// replaces the running checks.
+func (p *HostPool) StartHealthChecks(path string, interval time.Duration, failures int) {
+	checks := make([]HealthCheck, len(p.targets))
+	for i := range checks {
+		checks[i] = HealthCheck{Path: path, Interval: interval, Failures: failures}
+	}
+	p.StartHealthChecksWith(checks)
+}
+
+// StartHealthChecksWith starts checking each target as configured by the
+// HealthCheck at the same index in checks, in a goroutine of its own. Targets
+// without a HealthCheck are not checked. Call Stop to end the checks. Calling
+// it again replaces the running checks.
+func (p *HostPool) StartHealthChecksWith(checks []HealthCheck) {
+	p.checkMu.Lock()
+	defer p.checkMu.Unlock()
+	p.stopLocked()
+
+	stop := make(chan struct{})
+	p.stop = stop
+	for i, hc := range checks {
+		if i >= len(p.targets) || hc.Path == "" || hc.Interval <= 0 {
+			continue
+		}
+		p.checks.Add(1)
+		go p.runChecks(i, hc, stop)
+	}
+}
+
+// runChecks checks target i as configured by hc until stop is closed
+func (p *HostPool) runChecks(i int, hc HealthCheck, stop chan struct{}) {
+	defer p.checks.Done()
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = hc.Interval
+	}
+	failures := hc.Failures
+	if failures <= 0 {
+		failures = 1
+	}
+
+	failed := 0
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var ok bool
+			p.Workers.Do(func() { ok = p.check(p.targets[i], hc.Path, timeout) })
+			if ok {
+				failed = 0
+				p.setDown(i, false)
+			} else if failed++; failed >= failures {
+				// down until a check succeeds, not just for a passive cooldown
+				atomic.StoreInt64(&p.retryUntil[i], 0)
+				p.setDown(i, true)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check returns true if target answers a GET for path with a status below 400
+// within timeout
+func (p *HostPool) check(target *url.URL, path string, timeout time.Duration) bool {
+	u := *target
+	u.Path = path
+	u.RawQuery = ""
+	client := &http.Client{Transport: p.transport(), Timeout: timeout}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+// Stop ends the health checks started by StartHealthChecks, if any, and waits
+// for them to exit. Targets keep their last state.
+func (p *HostPool) Stop() {
+	p.checkMu.Lock()
+	defer p.checkMu.Unlock()
+	p.stopLocked()
+}
+
+func (p *HostPool) stopLocked() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	p.checks.Wait()
+	p.stop = nil
+}
+
+// observe records whether a request to target i succeeded, for passive
+// checks
+func (p *HostPool) observe(i int, ok bool) {
+	if ok {
+		atomic.StoreInt32(&p.failures[i], 0)
+		return
+	}
+	if n := atomic.AddInt32(&p.failures[i], 1); p.PassiveFailures > 0 && int(n) >= p.PassiveFailures {
+		cooldown := p.PassiveCooldown
+		if cooldown <= 0 {
+			cooldown = defaultPassiveCooldown
+		}
+		atomic.StoreInt64(&p.retryUntil[i], time.Now().Add(cooldown).UnixNano())
+		p.setDown(i, true)
+	}
+}
+
+// setDown marks target i down or up, calling OnStateChange if that changes
+// its state
+func (p *HostPool) setDown(i int, down bool) {
+	var old, state int32 = 1, 0
+	if down {
+		old, state = 0, 1
+	}
+	if !down {
+		atomic.StoreInt32(&p.failures[i], 0)
+	}
+	if !atomic.CompareAndSwapInt32(&p.down[i], old, state) {
+		return
+	}
+	if !down {
+		atomic.StoreInt64(&p.retryUntil[i], 0)
+	}
+	if p.OnStateChange != nil {
+		p.OnStateChange(p.targets[i], !down)
+	}
+}
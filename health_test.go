@@ -0,0 +1,163 @@
+package apiproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flaky is a backend whose health can be switched, failing with 503 while it
+// is down
+type flaky struct {
+	*backend
+	down int32
+}
+
+func newFlaky(t *testing.T, name string) (*flaky, *url.URL) {
+	f := &flaky{}
+	f.backend = newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&f.down) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, name)
+	})
+	u, _ := url.Parse(f.URL)
+	return f, u
+}
+
+func (f *flaky) set(down bool) {
+	var v int32
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&f.down, v)
+}
+
+// stateChanges records the calls to HostPool.OnStateChange
+type stateChanges struct {
+	mu      sync.Mutex
+	changes []string
+}
+
+func (s *stateChanges) record(target *url.URL, healthy bool) {
+	s.mu.Lock()
+	s.changes = append(s.changes, fmt.Sprintf("%s %v", target.Host, healthy))
+	s.mu.Unlock()
+}
+
+func (s *stateChanges) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.changes...)
+}
+
+// waitHealthy waits up to a second for the healthy targets of p to number n
+func waitHealthy(t *testing.T, p *HostPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(p.Healthy()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d healthy targets, want %d", len(p.Healthy()), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	a, ua := newFlaky(t, "a")
+	_, ub := newFlaky(t, "b")
+	pool := NewHostPool(ua, ub)
+	var changes stateChanges
+	pool.OnStateChange = changes.record
+	pool.StartHealthChecks("/health", 10*time.Millisecond, 2)
+	defer pool.Stop()
+
+	a.set(true)
+	waitHealthy(t, pool, 1)
+	if h := pool.Healthy(); h[0] != ub {
+		t.Errorf("healthy target %s, want %s", h[0], ub)
+	}
+	for i := 0; i < 4; i++ {
+		if _, body := roundTrip(t, pool, "http://api.example.com/"); body != "b" {
+			t.Errorf("request %d answered by %q, want the healthy target", i, body)
+		}
+	}
+
+	a.set(false)
+	waitHealthy(t, pool, 2)
+	want := []string{ua.Host + " false", ua.Host + " true"}
+	if got := changes.get(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("state changes %q, want %q", got, want)
+	}
+
+	pool.Stop()
+	a.set(true)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(pool.Healthy()); n != 2 {
+		t.Errorf("%d healthy targets once checks stopped, want both kept up", n)
+	}
+}
+
+func TestPassiveHealth(t *testing.T) {
+	a, ua := newFlaky(t, "a")
+	_, ub := newFlaky(t, "b")
+	pool := NewHostPool(ua, ub)
+	pool.PassiveFailures = 2
+	pool.PassiveCooldown = 50 * time.Millisecond
+
+	a.set(true)
+	for i := 0; i < 4; i++ {
+		roundTrip(t, pool, "http://api.example.com/")
+	}
+	if h := pool.Healthy(); len(h) != 1 || h[0] != ub {
+		t.Fatalf("healthy targets %v, want only %s once a failed twice", h, ub)
+	}
+	requests := a.count()
+	for i := 0; i < 4; i++ {
+		if _, body := roundTrip(t, pool, "http://api.example.com/"); body != "b" {
+			t.Errorf("request %d answered by %q, want the healthy target", i, body)
+		}
+	}
+	if a.count() != requests {
+		t.Errorf("target marked down got %d requests during its cooldown", a.count()-requests)
+	}
+
+	// tried again after the cooldown, it is up once it answers
+	a.set(false)
+	time.Sleep(60 * time.Millisecond)
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		_, body := roundTrip(t, pool, "http://api.example.com/")
+		seen[body] = true
+	}
+	if !seen["a"] || len(pool.Healthy()) != 2 {
+		t.Errorf("answered by %v with %d healthy targets, want a back in rotation", seen, len(pool.Healthy()))
+	}
+}
+
+func TestHealthChecksAllDown(t *testing.T) {
+	a, ua := newFlaky(t, "a")
+	b, ub := newFlaky(t, "b")
+	pool := NewHostPool(ua, ub)
+	pool.StartHealthChecks("/health", 10*time.Millisecond, 1)
+	defer pool.Stop()
+
+	a.set(true)
+	b.set(true)
+	waitHealthy(t, pool, 0)
+	pool.Stop()
+	ra, rb := a.count(), b.count()
+	for i := 0; i < 4; i++ {
+		if resp, _ := roundTrip(t, pool, "http://api.example.com/"); resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("request %d: got %d, want a target's own 503", i, resp.StatusCode)
+		}
+	}
+	if a.count() == ra || b.count() == rb {
+		t.Errorf("targets got %d and %d requests with all of them down, want both still tried", a.count()-ra, b.count()-rb)
+	}
+}
@@ -0,0 +1,89 @@
+package apiproxy
+
+import (
+	"net/http"
+)
+
+// Hooks is an implementation of net/http.RoundTripper that runs hooks around
+// each request passed to the underlying transport, e.g. to inject auth
+// headers, rewrite paths or strip sensitive response headers. Below a caching
+// transport, request hooks don't change the cache key and response hooks run
+// before responses are stored.
+//
+// Hooks must be registered before the Hooks is used.
+type Hooks struct {
+	// Transport is the underlying transport. If nil, net/http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	intercepts []func(*http.Request) *http.Response
+	requests   []func(*http.Request)
+	responses  []func(*http.Response) error
+}
+
+// OnRequest adds a hook called with each request before it is sent, in the
+// order they were added. Hooks may modify the request, which is a copy of the
+// one passed to RoundTrip, including its URL and Header.
+func (h *Hooks) OnRequest(f func(*http.Request)) *Hooks {
+	h.requests = append(h.requests, f)
+	return h
+}
+
+// Intercept adds a hook that may answer requests itself, after the OnRequest
+// hooks have run: if it returns a response, the request is not sent and later
+// Intercept hooks are skipped.
+func (h *Hooks) Intercept(f func(*http.Request) *http.Response) *Hooks {
+	h.intercepts = append(h.intercepts, f)
+	return h
+}
+
+// OnResponse adds a hook called with each response, in the order they were
+// added, including those returned by Intercept hooks. Hooks may modify the
+// response. If one returns an error, the response is discarded and RoundTrip
+// returns the error.
+func (h *Hooks) OnResponse(f func(*http.Response) error) *Hooks {
+	h.responses = append(h.responses, f)
+	return h
+}
+
+// RoundTrip runs the hooks around sending req with the underlying transport
+func (h *Hooks) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(h.requests) > 0 {
+		req = cloneRequest(req)
+		u := *req.URL
+		req.URL = &u
+		for _, f := range h.requests {
+			f(req)
+		}
+	}
+
+	var resp *http.Response
+	for _, f := range h.intercepts {
+		if resp = f(req); resp != nil {
+			break
+		}
+	}
+	if resp == nil {
+		var err error
+		if resp, err = h.transport().RoundTrip(req); err != nil {
+			return nil, err
+		}
+	}
+	if resp.Request == nil {
+		resp.Request = req
+	}
+
+	for _, f := range h.responses {
+		if err := f(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (h *Hooks) transport() http.RoundTripper {
+	if h.Transport != nil {
+		return h.Transport
+	}
+	return http.DefaultTransport
+}
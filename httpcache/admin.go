@@ -0,0 +1,135 @@
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminHandler returns an http.Handler for inspecting the cache of t while
+// debugging, meant to be mounted below a path of its own:
+//
+//	mux.Handle("/-/cache/", http.StripPrefix("/-/cache", httpcache.AdminHandler(t)))
+//
+// It answers, in JSON:
+//
+//	GET /stats           the transport's Stats
+//	GET /keys            every stored entry, with its size, age and freshness
+//	GET /entry?key=K     the entry stored at K, with its status and headers
+//	DELETE /entry?key=K  removes the entry stored at K
+//
+// Listing keys needs a ListableCache, such as MemoryCache or DiskCache. Stored
+// headers may carry sensitive data, so the handler must not be reachable by
+// clients.
+func AdminHandler(t *Transport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimSuffix(r.URL.Path, "/") {
+		case "", "/stats":
+			writeJSON(w, t.Stats())
+		case "/keys":
+			l, ok := t.cache().(ListableCache)
+			if !ok {
+				http.Error(w, "cache can't list its keys", http.StatusNotImplemented)
+				return
+			}
+			now := time.Now()
+			entries := []entryInfo{}
+			for _, key := range l.Keys() {
+				if b, ok := l.Get(key); ok {
+					entries = append(entries, describeEntry(key, b, now, false))
+				}
+			}
+			writeJSON(w, entries)
+		case "/entry":
+			key := r.URL.Query().Get("key")
+			if key == "" {
+				http.Error(w, "key parameter is required", http.StatusBadRequest)
+				return
+			}
+			switch r.Method {
+			case "GET", "HEAD":
+				b, ok := t.cache().Get(key)
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				writeJSON(w, describeEntry(key, b, time.Now(), true))
+			case "DELETE":
+				t.cache().Delete(key)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.Header().Set("Allow", "GET, HEAD, DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// entryInfo describes a stored entry for AdminHandler
+type entryInfo struct {
+	Key   string
+	Size  int
+	Error string `json:",omitempty"`
+
+	// Vary is set for the records listing the headers a key's responses vary
+	// on, which hold no response
+	Vary []string `json:",omitempty"`
+
+	StoredAt *time.Time `json:",omitempty"`
+	Age      string     `json:",omitempty"`
+	Expires  *time.Time `json:",omitempty"`
+	Fresh    bool
+
+	Status string      `json:",omitempty"`
+	Header http.Header `json:",omitempty"`
+}
+
+// describeEntry returns what AdminHandler reports about b, the entry stored at
+// key, at now, including the stored response's status and headers if
+// withHeader is true
+func describeEntry(key string, b []byte, now time.Time, withHeader bool) entryInfo {
+	info := entryInfo{Key: key, Size: len(b)}
+	e, err := decodeEntry(b)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	if e.vary != nil {
+		info.Vary = e.vary
+		return info
+	}
+
+	if !e.storedAt.IsZero() {
+		info.StoredAt = &e.storedAt
+		info.Age = now.Sub(e.storedAt).Round(time.Second).String()
+	}
+	if !e.expires.IsZero() {
+		info.Expires = &e.expires
+	}
+	info.Fresh = e.fresh(now)
+
+	if withHeader {
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(e.resp)), nil)
+		if err != nil {
+			info.Error = err.Error()
+			return info
+		}
+		resp.Body.Close()
+		info.Status = resp.Status
+		info.Header = resp.Header
+	}
+	return info
+}
+
+// writeJSON writes v to w as indented JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
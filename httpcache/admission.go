@@ -0,0 +1,88 @@
+package httpcache
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// An Admitter decides whether a response may be stored under key. A Transport
+// consults its Admitter, if any, before each store.
+type Admitter interface {
+	Admit(key string) bool
+}
+
+// sketchDepth is the number of rows in a FrequencyAdmitter's count-min sketch
+const sketchDepth = 4
+
+// FrequencyAdmitter is an Admitter that only admits keys once they have been
+// seen a given number of times, keeping one-hit wonders from polluting the
+// cache. Frequencies are estimated with a count-min sketch, which may
+// overestimate but never underestimates them. All counts are halved
+// periodically so that keys that were popular long ago don't stay admitted
+// forever.
+type FrequencyAdmitter struct {
+	threshold uint32
+
+	mu         sync.Mutex
+	rows       [sketchDepth][]uint32
+	additions  int
+	resetAfter int
+}
+
+// NewFrequencyAdmitter returns a new FrequencyAdmitter admitting keys seen at
+// least threshold times, counted in a sketch width counters wide. The width
+// should be a small multiple of the number of distinct keys expected.
+func NewFrequencyAdmitter(threshold, width int) *FrequencyAdmitter {
+	if width <= 0 {
+		panic("width must be >0")
+	}
+	a := &FrequencyAdmitter{
+		threshold:  uint32(threshold),
+		resetAfter: 10 * width,
+	}
+	for i := range a.rows {
+		a.rows[i] = make([]uint32, width)
+	}
+	return a
+}
+
+// Admit records an occurrence of key and returns true if key has now been
+// seen at least the threshold number of times
+func (a *FrequencyAdmitter) Admit(key string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var estimate uint32
+	for i := range a.rows {
+		row := a.rows[i]
+		idx := (h1 + uint32(i)*h2) % uint32(len(row))
+		if row[idx] < ^uint32(0) {
+			row[idx]++
+		}
+		if i == 0 || row[idx] < estimate {
+			estimate = row[idx]
+		}
+	}
+
+	a.additions++
+	if a.additions >= a.resetAfter {
+		a.age()
+	}
+
+	return estimate >= a.threshold
+}
+
+// age halves all counters
+func (a *FrequencyAdmitter) age() {
+	for _, row := range a.rows {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+	a.additions = 0
+}
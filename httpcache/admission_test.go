@@ -0,0 +1,30 @@
+package httpcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrequencyAdmitter(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.Admitter = NewFrequencyAdmitter(2, 64)
+	tr.NotCachedHeader = "X-Not-Cached"
+
+	tests := []struct {
+		path, notCached string
+		fromCache       bool
+	}{
+		{"/a", NotCachedAdmission, false},
+		{"/b", NotCachedAdmission, false},
+		{"/a", "", false},
+		{"/a", "", true},
+		{"/b", "", false},
+	}
+	for i, tt := range tests {
+		resp, _ := mustGet(t, tr, origin.URL+tt.path)
+		if got := resp.Header.Get("X-Not-Cached"); got != tt.notCached || (resp.Header.Get(XFromCache) == "1") != tt.fromCache {
+			t.Errorf("request %d for %s: X-Not-Cached %q, from cache %q, want %q, %v", i, tt.path, got, resp.Header.Get(XFromCache), tt.notCached, tt.fromCache)
+		}
+	}
+}
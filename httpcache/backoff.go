@@ -0,0 +1,92 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errBackingOff is the error MustRevalidateFailure gets for requests not sent
+// while backing off from their host
+var errBackingOff = errors.New("backing off from upstream after Retry-After")
+
+// backoffs holds the hosts a HonorRetryAfter Transport is backing off from
+type backoffs struct {
+	mu    sync.Mutex
+	hosts map[string]backoff
+}
+
+// backoff is the answer a host gave when it asked to be left alone until
+// until
+type backoff struct {
+	until      time.Time
+	statusCode int
+}
+
+// retryAfter returns when resp, a 429 Too Many Requests or 503 Service
+// Unavailable response received at now, asks for requests to resume, and
+// false if it doesn't, see https://tools.ietf.org/html/rfc7231#section-7.1.3
+func retryAfter(resp *http.Response, now time.Time) (time.Time, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return time.Time{}, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+		return now.Add(time.Duration(secs) * time.Second), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// set records that host asked to be left alone until until with a response
+// with statusCode
+func (b *backoffs) set(host string, until time.Time, statusCode int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.hosts == nil {
+		b.hosts = make(map[string]backoff)
+	}
+	b.hosts[host] = backoff{until, statusCode}
+}
+
+// get returns the backoff in force for host at now, if any
+func (b *backoffs) get(host string, now time.Time) (backoff, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bo, ok := b.hosts[host]
+	if ok && !now.Before(bo.until) {
+		delete(b.hosts, host)
+		return backoff{}, false
+	}
+	return bo, ok
+}
+
+// response returns the answer to req while backing off at now: bo's status
+// code, with a Retry-After header for the rest of the window
+func (bo backoff) response(req *http.Request, now time.Time) *http.Response {
+	secs := int64((bo.until.Sub(now) + time.Second - 1) / time.Second)
+	body := []byte("upstream asked to retry later\n")
+	return &http.Response{
+		Status:     strconv.Itoa(bo.statusCode) + " " + http.StatusText(bo.statusCode),
+		StatusCode: bo.statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": {"text/plain; charset=utf-8"},
+			"Retry-After":  {strconv.FormatInt(secs, 10)},
+		},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,68 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterBackoff(t *testing.T) {
+	busy := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		statusHandler(http.StatusServiceUnavailable, "busy")(w, r)
+	}
+	tests := []struct {
+		name         string
+		cc           string
+		maxStaleness time.Duration
+		// the answers to the request getting the origin's Retry-After and to
+		// the one sent after it while backing off
+		wantRetry, wantBackoff         string
+		wantRetryCode, wantBackoffCode int
+	}{
+		{
+			name:      "stale",
+			wantRetry: "stale", wantRetryCode: http.StatusOK,
+			wantBackoff: "stale", wantBackoffCode: http.StatusOK,
+		},
+		{
+			name: "inside MaxStaleness", maxStaleness: 102 * time.Second,
+			wantRetry: "stale", wantRetryCode: http.StatusOK,
+			wantBackoff: "stale", wantBackoffCode: http.StatusOK,
+		},
+		{
+			name: "outside MaxStaleness", maxStaleness: 99 * time.Second,
+			wantRetry: "busy", wantRetryCode: http.StatusServiceUnavailable,
+			wantBackoff: "upstream asked to retry later\n", wantBackoffCode: http.StatusServiceUnavailable,
+		},
+		{
+			name: "must-revalidate", cc: "must-revalidate",
+			wantRetry: "busy", wantRetryCode: http.StatusServiceUnavailable,
+			wantBackoff: "stored response could not be revalidated\n", wantBackoffCode: http.StatusGatewayTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("stale", tt.cc, 100*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.HonorRetryAfter = true
+			tr.MaxStaleness = tt.maxStaleness
+			mustGet(t, tr, origin.URL)
+
+			origin.set(busy)
+			resp, body := mustGet(t, tr, origin.URL)
+			if resp.StatusCode != tt.wantRetryCode || body != tt.wantRetry {
+				t.Errorf("Retry-After response: got %d %q, want %d %q", resp.StatusCode, body, tt.wantRetryCode, tt.wantRetry)
+			}
+
+			requests := origin.count()
+			resp, body = mustGet(t, tr, origin.URL)
+			if resp.StatusCode != tt.wantBackoffCode || body != tt.wantBackoff {
+				t.Errorf("backing off: got %d %q, want %d %q", resp.StatusCode, body, tt.wantBackoffCode, tt.wantBackoff)
+			}
+			if n := origin.count(); n != requests {
+				t.Errorf("origin got %d requests while backing off", n-requests)
+			}
+		})
+	}
+}
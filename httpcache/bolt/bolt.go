@@ -0,0 +1,171 @@
+// Package bolt provides a bbolt interface for http caching, keeping entries in
+// a single embedded database file for single-node deployments.
+package bolt
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+	"go.etcd.io/bbolt"
+)
+
+// bucket is the name of the bucket holding the entries
+var bucket = []byte("httpcache")
+
+// Cache is an implementation of httpcache.Cache that stores responses in a
+// bbolt database. Each entry is prefixed with the time it was stored, so
+// maxTTL keeps applying across restarts; expired entries are reported as
+// misses and removed by the janitor. Errors talking to the database are
+// reported as misses.
+type Cache struct {
+	db     *bbolt.DB
+	maxTTL time.Duration
+
+	// janitorMu guards stopJanitor, which is non-nil while a janitor started
+	// by StartJanitor is running
+	janitorMu   sync.Mutex
+	stopJanitor chan chan struct{}
+}
+
+// Open returns a new Cache storing entries in the database file at path for
+// at most maxTTL, creating it if it doesn't exist
+func Open(path string, maxTTL time.Duration) (*Cache, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	c, err := New(db, maxTTL)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// New returns a new Cache storing entries in db for at most maxTTL
+func New(db *bbolt.DB, maxTTL time.Duration) (*Cache, error) {
+	if maxTTL <= time.Duration(0) {
+		panic("maxTTL must be >0")
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{db: db, maxTTL: maxTTL}, nil
+}
+
+// expired returns true if the stored value v has outlived maxTTL
+func (c *Cache) expired(v []byte, now time.Time) bool {
+	if len(v) < 8 {
+		return true
+	}
+	storedAt := time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))
+	return now.Sub(storedAt) > c.maxTTL
+}
+
+// Get returns the response corresponding to key if present
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	c.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(bucket).Get([]byte(key))
+		if v == nil || c.expired(v, time.Now()) {
+			return nil
+		}
+		// v is only valid for the life of the transaction
+		resp = append([]byte(nil), v[8:]...)
+		ok = true
+		return nil
+	})
+	return resp, ok
+}
+
+// Set saves a response to the cache as key
+func (c *Cache) Set(key string, resp []byte) {
+	v := make([]byte, 8, 8+len(resp))
+	binary.BigEndian.PutUint64(v, uint64(time.Now().UnixNano()))
+	v = append(v, resp...)
+	c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), v)
+	})
+}
+
+// Delete removes the response with key from the cache
+func (c *Cache) Delete(key string) {
+	c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// Sweep removes all expired entries, and returns the number removed
+func (c *Cache) Sweep() (removed int, err error) {
+	now := time.Now()
+	err = c.db.Update(func(tx *bbolt.Tx) error {
+		cur := tx.Bucket(bucket).Cursor()
+		for k, v := cur.First(); k != nil; {
+			if !c.expired(v, now) {
+				k, v = cur.Next()
+				continue
+			}
+			k = append([]byte(nil), k...)
+			if err := cur.Delete(); err != nil {
+				return err
+			}
+			removed++
+			// the cursor can't be advanced with Next after Delete
+			k, v = cur.Seek(k)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// StartJanitor starts a goroutine that removes expired entries every
+// interval, so that entries which are never requested again don't fill the
+// database. Calling StartJanitor again replaces the running janitor; Close
+// ends it.
+func (c *Cache) StartJanitor(interval time.Duration) {
+	c.janitorMu.Lock()
+	defer c.janitorMu.Unlock()
+	c.stopJanitorLocked()
+
+	stop := make(chan chan struct{})
+	c.stopJanitor = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Sweep()
+			case done := <-stop:
+				close(done)
+				return
+			}
+		}
+	}()
+}
+
+func (c *Cache) stopJanitorLocked() {
+	if c.stopJanitor == nil {
+		return
+	}
+	done := make(chan struct{})
+	c.stopJanitor <- done
+	<-done
+	c.stopJanitor = nil
+}
+
+// Close ends the janitor, if any, waiting for a sweep in progress to finish,
+// then closes the database
+func (c *Cache) Close() error {
+	c.janitorMu.Lock()
+	c.stopJanitorLocked()
+	c.janitorMu.Unlock()
+	return c.db.Close()
+}
+
+var _ httpcache.Cache = (*Cache)(nil)
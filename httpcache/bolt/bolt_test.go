@@ -0,0 +1,129 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func openTestCache(t *testing.T, maxTTL time.Duration) *Cache {
+	t.Helper()
+	c, err := Open(filepath.Join(t.TempDir(), "cache.db"), maxTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// put stores resp at key as if it had been set at storedAt
+func put(t *testing.T, c *Cache, key string, storedAt time.Time, resp string) {
+	t.Helper()
+	v := make([]byte, 8, 8+len(resp))
+	binary.BigEndian.PutUint64(v, uint64(storedAt.UnixNano()))
+	v = append(v, resp...)
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// keys returns the keys in the database, expired or not
+func keys(t *testing.T, c *Cache) []string {
+	t.Helper()
+	var ks []string
+	c.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, _ []byte) error {
+			ks = append(ks, string(k))
+			return nil
+		})
+	})
+	return ks
+}
+
+func TestCache(t *testing.T) {
+	c := openTestCache(t, time.Hour)
+	defer c.Close()
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got a hit before Set")
+	}
+	c.Set("a", []byte("hello"))
+	if got, ok := c.Get("a"); !ok || string(got) != "hello" {
+		t.Errorf("got %q, %v, want hello", got, ok)
+	}
+	put(t, c, "old", time.Now().Add(-2*time.Hour), "stale")
+	if _, ok := c.Get("old"); ok {
+		t.Error("got a hit for an entry older than maxTTL")
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("got a hit after Delete")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	c := openTestCache(t, time.Hour)
+	defer c.Close()
+	now, old := time.Now(), time.Now().Add(-2*time.Hour)
+	// expired keys next to each other, at the start and at the end, so that
+	// the cursor lands on an expired key after each Delete
+	for _, e := range []struct {
+		key      string
+		storedAt time.Time
+	}{
+		{"a", old}, {"b", old}, {"c", now}, {"d", old}, {"e", old}, {"f", old}, {"g", now}, {"h", old},
+	} {
+		put(t, c, e.key, e.storedAt, e.key)
+	}
+	// too short to hold a timestamp
+	c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte("i"), []byte{1})
+	})
+
+	removed, err := c.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 7 {
+		t.Errorf("removed %d entries, want 7", removed)
+	}
+	if got := keys(t, c); len(got) != 2 || got[0] != "c" || got[1] != "g" {
+		t.Errorf("kept %q, want [c g]", got)
+	}
+	if removed, _ := c.Sweep(); removed != 0 {
+		t.Errorf("a second sweep removed %d entries, want 0", removed)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	c := openTestCache(t, time.Hour)
+	put(t, c, "old", time.Now().Add(-2*time.Hour), "stale")
+	c.StartJanitor(time.Hour)
+	// replacing the janitor stops the first one
+	c.StartJanitor(5 * time.Millisecond)
+	for deadline := time.Now().Add(5 * time.Second); len(keys(t, c)) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the janitor did not remove the expired entry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	if c.stopJanitor != nil {
+		t.Error("the janitor is still registered after Close")
+	}
+}
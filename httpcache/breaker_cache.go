@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerCache is an implementation of Cache that stops using an underlying
+// Cache (e.g. a remote backend) once it has become persistently slow, so that
+// requests fall through to the origin instead of paying the backend's latency
+// on top.
+//
+// After Threshold consecutive Get or Set calls taking longer than
+// SlowThreshold, the breaker opens for Cooldown: Get reports a miss and Set is
+// skipped. Once Cooldown has passed calls are attempted again, and the first
+// fast one closes the breaker. Delete is always passed through, so that
+// invalidations are not lost while the breaker is open.
+type BreakerCache struct {
+	Cache         Cache
+	SlowThreshold time.Duration
+	Threshold     int
+	Cooldown      time.Duration
+
+	mu        sync.Mutex
+	slow      int
+	openUntil time.Time
+}
+
+// NewBreakerCache returns a new Cache that wraps c in a circuit breaker which
+// opens for cooldown after threshold consecutive calls slower than slow
+func NewBreakerCache(c Cache, slow time.Duration, threshold int, cooldown time.Duration) *BreakerCache {
+	return &BreakerCache{
+		Cache:         c,
+		SlowThreshold: slow,
+		Threshold:     threshold,
+		Cooldown:      cooldown,
+	}
+}
+
+// Get returns the []byte representation of the response from the underlying
+// cache, or a miss while the breaker is open
+func (c *BreakerCache) Get(key string) (resp []byte, ok bool) {
+	if c.Open() {
+		return nil, false
+	}
+	start := time.Now()
+	resp, ok = c.Cache.Get(key)
+	c.record(time.Since(start))
+	return resp, ok
+}
+
+// Set saves response resp to the underlying cache with key, unless the breaker
+// is open
+func (c *BreakerCache) Set(key string, resp []byte) {
+	if c.Open() {
+		return
+	}
+	start := time.Now()
+	c.Cache.Set(key, resp)
+	c.record(time.Since(start))
+}
+
+// Delete removes key from the underlying cache
+func (c *BreakerCache) Delete(key string) {
+	c.Cache.Delete(key)
+}
+
+// Open returns true if the underlying cache is currently being bypassed
+func (c *BreakerCache) Open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Before(c.openUntil)
+}
+
+// record tracks the duration d of a call to the underlying cache, opening the
+// breaker once Threshold consecutive calls have been slow
+func (c *BreakerCache) record(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d <= c.SlowThreshold {
+		c.slow = 0
+		return
+	}
+
+	c.slow++
+	if c.slow >= c.Threshold {
+		c.openUntil = time.Now().Add(c.Cooldown)
+	}
+}
@@ -0,0 +1,79 @@
+package httpcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowCache is a Cache taking delay to answer each Get and Set
+type slowCache struct {
+	Cache
+
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+func (c *slowCache) setDelay(d time.Duration) {
+	c.mu.Lock()
+	c.delay = d
+	c.mu.Unlock()
+}
+
+func (c *slowCache) wait() {
+	c.mu.Lock()
+	d := c.delay
+	c.mu.Unlock()
+	time.Sleep(d)
+}
+
+func (c *slowCache) Get(key string) ([]byte, bool) {
+	c.wait()
+	return c.Cache.Get(key)
+}
+
+func (c *slowCache) Set(key string, resp []byte) {
+	c.wait()
+	c.Cache.Set(key, resp)
+}
+
+func TestBreakerCache(t *testing.T) {
+	mem := NewMemoryCache(time.Hour)
+	slow := &slowCache{Cache: mem}
+	c := NewBreakerCache(slow, 10*time.Millisecond, 2, 100*time.Millisecond)
+	c.Set("a", []byte("1"))
+
+	// a fast call between slow ones starts the count over
+	slow.setDelay(20 * time.Millisecond)
+	c.Get("a")
+	slow.setDelay(0)
+	c.Get("a")
+	slow.setDelay(20 * time.Millisecond)
+	c.Get("a")
+	if c.Open() {
+		t.Fatalf("open after calls that were not consecutively slow")
+	}
+
+	c.Get("a")
+	if !c.Open() {
+		t.Fatalf("not open after 2 consecutive slow calls")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Get hit while open")
+	}
+	c.Set("b", []byte("2"))
+	c.Delete("a")
+	if _, ok := mem.Get("b"); ok {
+		t.Errorf("Set passed through while open")
+	}
+	if _, ok := mem.Get("a"); ok {
+		t.Errorf("Delete not passed through while open")
+	}
+
+	slow.setDelay(0)
+	time.Sleep(100 * time.Millisecond)
+	c.Set("b", []byte("2"))
+	if got, ok := c.Get("b"); !ok || string(got) != "2" || c.Open() {
+		t.Errorf("Get after the cooldown = %q, %v, open %v, want the entry", got, ok, c.Open())
+	}
+}
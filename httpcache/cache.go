@@ -1,6 +1,9 @@
 package httpcache
 
-import "net/http"
+import (
+	"context"
+	"net/url"
+)
 
 // A Cache interface is used by the Transport to store and retrieve responses.
 type Cache interface {
@@ -13,7 +16,75 @@ type Cache interface {
 	Delete(key string)
 }
 
-// cacheKey returns the cache key for req.
-func cacheKey(req *http.Request) string {
-	return req.URL.String()
+// CacheCtx is like Cache for backends, typically remote ones, whose operations
+// can fail and should be abandoned once the request they are made for is
+// cancelled. Wrap one with FromCacheCtx to use it as a Transport's Cache.
+type CacheCtx interface {
+	Get(ctx context.Context, key string) (responseBytes []byte, ok bool, err error)
+	Set(ctx context.Context, key string, responseBytes []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// FromCacheCtx returns a Cache backed by c. A Transport using it passes c the
+// context of each request, and logs its failures and carries on without the
+// cache. Other users, such as the Cache wrappers in this package, use
+// context.Background and see failures as misses.
+func FromCacheCtx(c CacheCtx) Cache {
+	return ctxCache{c}
+}
+
+// ToCacheCtx returns a CacheCtx backed by c. Unless c was returned by
+// FromCacheCtx, its operations ignore their context and never fail.
+func ToCacheCtx(c Cache) CacheCtx {
+	if cc, ok := c.(ctxCache); ok {
+		return cc.c
+	}
+	return legacyCache{c}
+}
+
+// ctxCache adapts a CacheCtx to Cache
+type ctxCache struct {
+	c CacheCtx
+}
+
+func (c ctxCache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.c.Get(context.Background(), key)
+	return b, ok && err == nil
+}
+
+func (c ctxCache) Set(key string, b []byte) {
+	c.c.Set(context.Background(), key, b)
+}
+
+func (c ctxCache) Delete(key string) {
+	c.c.Delete(context.Background(), key)
+}
+
+// legacyCache adapts a Cache to CacheCtx
+type legacyCache struct {
+	c Cache
+}
+
+func (c legacyCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, ok := c.c.Get(key)
+	return b, ok, nil
+}
+
+func (c legacyCache) Set(ctx context.Context, key string, b []byte) error {
+	c.c.Set(key, b)
+	return nil
+}
+
+func (c legacyCache) Delete(ctx context.Context, key string) error {
+	c.c.Delete(key)
+	return nil
+}
+
+// cacheKey returns the cache key for a request with method for u. GET and
+// HEAD requests share the plain URL as their key.
+func cacheKey(method string, u *url.URL) string {
+	if method == "GET" || method == "HEAD" {
+		return u.String()
+	}
+	return method + " " + u.String()
 }
@@ -0,0 +1,122 @@
+package httpcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cacheControl holds the directives of a Cache-Control header, keyed by
+// lowercased directive name. Directives without an argument map to "".
+type cacheControl map[string]string
+
+// parseCacheControl returns the Cache-Control directives in headers
+func parseCacheControl(headers http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range headerAllCommaSepValues(headers, "cache-control") {
+		if v == "" {
+			continue
+		}
+		name, arg := v, ""
+		if i := strings.IndexByte(v, '='); i >= 0 {
+			name, arg = v[:i], strings.Trim(strings.TrimSpace(v[i+1:]), `"`)
+		}
+		cc[strings.ToLower(strings.TrimSpace(name))] = arg
+	}
+	return cc
+}
+
+// has returns true if cc contains directive
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// noCache returns true if cc forbids using a stored response without first
+// revalidating it, i.e. it has no-cache or max-age=0
+func (cc cacheControl) noCache() bool {
+	return cc.has("no-cache") || cc["max-age"] == "0"
+}
+
+// requestNoCache returns true if req must not be answered from the cache
+// without revalidation, including if it was made with a context from
+// WithRefresh. The HTTP/1.0 Pragma: no-cache is honored for requests without
+// Cache-Control.
+func requestNoCache(req *http.Request, cc cacheControl) bool {
+	if refreshRequested(req) {
+		return true
+	}
+	if len(cc) == 0 && strings.EqualFold(req.Header.Get("Pragma"), "no-cache") {
+		return true
+	}
+	return cc.noCache()
+}
+
+// requestFreshness applies the Cache-Control directives cc of req to resp, a
+// stored response that is fresh if fresh is true and otherwise stale for
+// staleFor (negative while fresh with an expiry), see
+// https://tools.ietf.org/html/rfc7234#section-5.2.1. It returns whether resp
+// may be served without revalidation, and true if the directives decided it
+// rather than resp's own freshness, in which case stale-while-revalidate
+// doesn't apply. max-stale doesn't extend past MaxStaleness.
+func (t *Transport) requestFreshness(req *http.Request, cc cacheControl, resp *http.Response, fresh bool, staleFor time.Duration) (bool, bool) {
+	if requestNoCache(req, cc) {
+		return false, true
+	}
+	if maxAge, ok := deltaSeconds(cc["max-age"]); ok && responseAge(resp) > maxAge {
+		return false, true
+	}
+	if ttl, ok := contextTTL(req); ok && responseAge(resp) > ttl {
+		return false, true
+	}
+	if fresh {
+		if minFresh, ok := deltaSeconds(cc["min-fresh"]); ok && staleFor < 0 && -staleFor < minFresh {
+			return false, true
+		}
+		return true, false
+	}
+
+	maxStale, ok := cc["max-stale"]
+	if !ok {
+		return false, false
+	}
+	if t.revalidationRequired(parseCacheControl(resp.Header)) || t.tooStale(staleFor) {
+		return false, false
+	}
+	if maxStale == "" {
+		return true, true
+	}
+	if d, ok := deltaSeconds(maxStale); ok && staleFor <= d {
+		return true, true
+	}
+	return false, false
+}
+
+// revalidationRequired returns true if a stored response with Cache-Control
+// directives cc must not be served once stale, whatever the request or the
+// transport accept: it has must-revalidate, or in a shared cache
+// proxy-revalidate or s-maxage, see
+// https://tools.ietf.org/html/rfc7234#section-5.2.2.1
+func (t *Transport) revalidationRequired(cc cacheControl) bool {
+	return cc.has("must-revalidate") || t.Shared && (cc.has("proxy-revalidate") || cc.has("s-maxage"))
+}
+
+// onlyIfCached returns the 504 Gateway Timeout answering req, whose
+// only-if-cached directive forbids contacting the origin, when no stored
+// response may answer it
+func onlyIfCached(req *http.Request) *http.Response {
+	body := []byte("no stored response\n")
+	return &http.Response{
+		Status:        "504 Gateway Timeout",
+		StatusCode:    http.StatusGatewayTimeout,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,39 @@
+package httpcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheControlDirectives(t *testing.T) {
+	tests := []struct {
+		name          string
+		cc            string
+		shared        bool
+		first, second []string
+		hit           bool
+	}{
+		{name: "fresh", cc: "max-age=60", hit: true},
+		{name: "request no-cache", cc: "max-age=60", second: []string{"Cache-Control", "no-cache"}},
+		{name: "request max-age=0", cc: "max-age=60", second: []string{"Cache-Control", "max-age=0"}},
+		{name: "Pragma no-cache", cc: "max-age=60", second: []string{"Pragma", "no-cache"}},
+		{name: "response no-cache", cc: "no-cache, max-age=60"},
+		{name: "response max-age=0", cc: "max-age=0"},
+		{name: "response no-store", cc: "no-store, max-age=60"},
+		{name: "request no-store", cc: "max-age=60", first: []string{"Cache-Control", "no-store"}},
+		{name: "private", cc: "private, max-age=60", hit: true},
+		{name: "private shared", cc: "private, max-age=60", shared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("body", tt.cc, -10*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.Shared = tt.shared
+			mustGet(t, tr, origin.URL, tt.first...)
+			resp, body := mustGet(t, tr, origin.URL, tt.second...)
+			if hit := resp.Header.Get(XFromCache) == "1"; hit != tt.hit || body != "body" {
+				t.Errorf("got %q, served from the cache %v, want %v", body, hit, tt.hit)
+			}
+		})
+	}
+}
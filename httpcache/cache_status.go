@@ -0,0 +1,57 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Values for the fwd parameter of the Cache-Status header, see
+// https://www.rfc-editor.org/rfc/rfc9211#section-2.2
+const (
+	fwdBypass  = "bypass"
+	fwdMethod  = "method"
+	fwdRequest = "request"
+	fwdStale   = "stale"
+	fwdURIMiss = "uri-miss"
+)
+
+// fwdReason returns why req, which is not cacheable, was forwarded
+func (t *Transport) fwdReason(req *http.Request) string {
+	if t.Cacheable == nil && !t.cacheableMethod(t.method(req)) && !t.postQuery(req) {
+		return fwdMethod
+	}
+	return fwdBypass
+}
+
+// setCacheStatus adds a Cache-Status header to resp describing how it was
+// produced, if CacheStatusID is set. fwd is empty for responses served from
+// the cache. A cache appends its entry after those of caches closer to the
+// origin, so any existing Cache-Status values are kept.
+func (t *Transport) setCacheStatus(resp *http.Response, key, fwd string, stored bool) {
+	if t.CacheStatusID == "" {
+		return
+	}
+
+	status := t.CacheStatusID
+	if fwd == "" {
+		status += "; hit"
+	} else {
+		status += "; fwd=" + fwd
+	}
+	if stored {
+		status += "; stored"
+	}
+	if t.CacheStatusKey && key != "" {
+		status += "; key=" + quoteSFString(key)
+	}
+
+	resp.Header.Add("Cache-Status", status)
+}
+
+// quoteSFString returns s as a structured field string (RFC 8941), escaping
+// backslashes and double quotes
+func quoteSFString(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
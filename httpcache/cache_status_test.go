@@ -0,0 +1,45 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCacheStatus(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Status", "origin-cdn; hit")
+		staleHandler("body", "max-age=60", -10*time.Second)(w, r)
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.CacheStatusID = "apiproxy"
+
+	tests := []struct {
+		name, method string
+		key          bool
+		want         string
+	}{
+		{"miss", "GET", false, "apiproxy; fwd=uri-miss; stored"},
+		{"hit", "GET", false, "apiproxy; hit"},
+		{"uncacheable method", "DELETE", false, "apiproxy; fwd=method"},
+		{"key", "GET", true, `apiproxy; fwd=uri-miss; stored; key="a\\\"b"`},
+	}
+	for _, tt := range tests {
+		if tt.key {
+			tr.CacheStatusKey = true
+			tr.KeyFunc = func(*http.Request) string { return `a\"b` }
+		}
+		req := httptest.NewRequest(tt.method, origin.URL, nil)
+		req.RequestURI = ""
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.Header["Cache-Status"], []string{"origin-cdn; hit", tt.want}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Cache-Status = %q, want %q", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultCircuitCooldown is how long circuits stay open when CircuitCooldown
+// is not set
+const defaultCircuitCooldown = 10 * time.Second
+
+// errCircuitOpen is the error MustRevalidateFailure gets for requests not
+// sent while the circuit for their host is open
+var errCircuitOpen = errors.New("upstream circuit open")
+
+// circuits holds the state of the per-host circuit breakers of a Transport
+type circuits struct {
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// circuit is the breaker of a single host. It is closed while openUntil is
+// zero, open until openUntil, and half-open after it, with probing set while
+// the single request let through to probe the host is in flight.
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns true if a request may be sent to host at now, in which case
+// its outcome must be reported with done
+func (c *circuits) allow(host string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.hosts[host]
+	if !ok || h.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(h.openUntil) || h.probing {
+		return false
+	}
+	h.probing = true
+	return true
+}
+
+// done records the outcome of a request allowed to host: whether it failed,
+// or if known is false, that it ended without telling, e.g. because the
+// client went away. After threshold consecutive failures, or a failed probe,
+// the circuit opens for cooldown.
+func (c *circuits) done(host string, known, failed bool, threshold int, cooldown time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.hosts[host]
+	switch {
+	case !known:
+		if ok {
+			h.probing = false
+		}
+	case !failed:
+		delete(c.hosts, host)
+	default:
+		if !ok {
+			if c.hosts == nil {
+				c.hosts = make(map[string]*circuit)
+			}
+			h = &circuit{}
+			c.hosts[host] = h
+		}
+		h.failures++
+		if h.probing || h.failures >= threshold {
+			h.openUntil = time.Now().Add(cooldown)
+			h.probing = false
+		}
+	}
+}
+
+// circuitOpen returns the response to req while the circuit for its host is
+// open
+func (t *Transport) circuitOpen(req *http.Request) *http.Response {
+	if t.CircuitOpenResponse != nil {
+		return t.CircuitOpenResponse(req)
+	}
+	body := []byte("upstream unavailable\n")
+	return &http.Response{
+		Status:        "502 Bad Gateway",
+		StatusCode:    http.StatusBadGateway,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+}
+
+// circuitCooldown returns how long circuits stay open
+func (t *Transport) circuitCooldown() time.Duration {
+	if t.CircuitCooldown > 0 {
+		return t.CircuitCooldown
+	}
+	return defaultCircuitCooldown
+}
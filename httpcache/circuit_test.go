@@ -0,0 +1,83 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitOpen(t *testing.T) {
+	tests := []struct {
+		name         string
+		cc           string
+		maxStaleness time.Duration
+		stored       bool
+		wantCode     int
+		wantBody     string
+	}{
+		{name: "not stored", wantCode: http.StatusBadGateway, wantBody: "upstream unavailable\n"},
+		{name: "stale", stored: true, wantCode: http.StatusOK, wantBody: "stale"},
+		{name: "inside MaxStaleness", stored: true, maxStaleness: 102 * time.Second, wantCode: http.StatusOK, wantBody: "stale"},
+		{name: "outside MaxStaleness", stored: true, maxStaleness: 99 * time.Second, wantCode: http.StatusBadGateway, wantBody: "upstream unavailable\n"},
+		{name: "must-revalidate", stored: true, cc: "must-revalidate", wantCode: http.StatusGatewayTimeout, wantBody: "stored response could not be revalidated\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("stale", tt.cc, 100*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.CircuitThreshold = 2
+			tr.CircuitCooldown = time.Minute
+			tr.MaxStaleness = tt.maxStaleness
+			if tt.stored {
+				mustGet(t, tr, origin.URL)
+			}
+
+			// open the circuit
+			origin.set(statusHandler(http.StatusInternalServerError, "down"))
+			for i := 0; i < tr.CircuitThreshold; i++ {
+				mustGet(t, tr, origin.URL+"/fail")
+			}
+
+			requests := origin.count()
+			resp, body := mustGet(t, tr, origin.URL)
+			if resp.StatusCode != tt.wantCode || body != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, tt.wantCode, tt.wantBody)
+			}
+			if n := origin.count(); n != requests {
+				t.Errorf("origin got %d requests while the circuit is open", n-requests)
+			}
+		})
+	}
+}
+
+func TestCircuitProbe(t *testing.T) {
+	origin := newTestOrigin(t, statusHandler(http.StatusInternalServerError, "down"))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.CircuitThreshold = 1
+	tr.CircuitCooldown = 50 * time.Millisecond
+
+	steps := []struct {
+		wait     time.Duration
+		handler  http.HandlerFunc
+		wantCode int
+	}{
+		{wantCode: http.StatusInternalServerError},
+		{wantCode: http.StatusBadGateway},
+		// the probe fails and opens the circuit again
+		{wait: 60 * time.Millisecond, wantCode: http.StatusInternalServerError},
+		{wantCode: http.StatusBadGateway},
+		// the probe succeeds and closes it
+		{wait: 60 * time.Millisecond, handler: statusHandler(http.StatusOK, "up"), wantCode: http.StatusOK},
+		{wantCode: http.StatusOK},
+	}
+	for i, s := range steps {
+		time.Sleep(s.wait)
+		if s.handler != nil {
+			origin.set(s.handler)
+		}
+		resp, _ := mustGet(t, tr, origin.URL)
+		if resp.StatusCode != s.wantCode {
+			t.Errorf("step %d: status = %d, want %d", i, resp.StatusCode, s.wantCode)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package httpcache
+
+import "sync"
+
+// flightGroup tracks the cache keys of requests in flight to the origin, so
+// that concurrent misses for the same key can wait for the first one
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]chan struct{}
+}
+
+// join returns a channel that is closed when the request in flight for key
+// completes, and false; or, if there is none, registers the caller's request
+// and returns true, in which case the caller must call leave once it has
+// stored the response
+func (g *flightGroup) join(key string) (<-chan struct{}, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if done, ok := g.flights[key]; ok {
+		return done, false
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]chan struct{})
+	}
+	g.flights[key] = make(chan struct{})
+	return nil, true
+}
+
+// leave marks the request in flight for key as complete, releasing the
+// requests waiting for it
+func (g *flightGroup) leave(key string) {
+	g.mu.Lock()
+	done := g.flights[key]
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(done)
+}
@@ -0,0 +1,120 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingOrigin returns an origin answering with Cache-Control cc once
+// release is closed
+func blockingOrigin(t *testing.T, cc string, release chan struct{}) *testOrigin {
+	return newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Cache-Control", cc)
+		fmt.Fprint(w, "hello")
+	})
+}
+
+// waitFor polls cond until it is true, failing t after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestCoalesceMisses(t *testing.T) {
+	const clients = 5
+	tests := []struct {
+		name         string
+		cc           string
+		wantRequests int
+	}{
+		{name: "stored", cc: "max-age=60", wantRequests: 1},
+		// the waiters go to the origin alone once the leader's response
+		// turns out not storable
+		{name: "not stored", cc: "no-store", wantRequests: clients},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			origin := blockingOrigin(t, tt.cc, release)
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.CoalesceMisses = true
+
+			var wg sync.WaitGroup
+			bodies := make([]string, clients)
+			for i := 0; i < clients; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var err error
+					if _, bodies[i], err = get(t, tr, origin.URL); err != nil {
+						t.Errorf("client %d: %v", i, err)
+					}
+				}(i)
+				if i == 0 {
+					waitFor(t, "the leader", func() bool { return origin.count() == 1 })
+				}
+			}
+			waitFor(t, "the waiters", func() bool { return atomic.LoadUint64(&tr.stats.coalesced) == clients-1 })
+			close(release)
+			wg.Wait()
+
+			for i, body := range bodies {
+				if body != "hello" {
+					t.Errorf("client %d: body = %q, want %q", i, body, "hello")
+				}
+			}
+			if n := origin.count(); n != tt.wantRequests {
+				t.Errorf("origin got %d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestCoalescedWaiterCanceled(t *testing.T) {
+	release := make(chan struct{})
+	origin := blockingOrigin(t, "max-age=60", release)
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.CoalesceMisses = true
+
+	leader := make(chan string)
+	go func() {
+		_, body, err := get(t, tr, origin.URL)
+		if err != nil {
+			t.Errorf("leader: %v", err)
+		}
+		leader <- body
+	}()
+	waitFor(t, "the leader", func() bool { return origin.count() == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", origin.URL, nil).WithContext(ctx)
+	req.RequestURI = ""
+	errc := make(chan error)
+	go func() {
+		_, err := tr.RoundTrip(req)
+		errc <- err
+	}()
+	waitFor(t, "the waiter", func() bool { return atomic.LoadUint64(&tr.stats.coalesced) == 1 })
+	cancel()
+
+	err := <-errc
+	if !errors.Is(err, ErrUpstream) || !errors.Is(err, context.Canceled) {
+		t.Errorf("waiter error = %v, want an ErrUpstream wrapping context.Canceled", err)
+	}
+	close(release)
+	if body := <-leader; body != "hello" {
+		t.Errorf("leader body = %q, want %q", body, "hello")
+	}
+}
@@ -0,0 +1,380 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errUnknownCodec = &Error{ErrSerialize, errors.New("unknown compression codec")}
+
+// CompressionCodec identifies the algorithm used to compress a cache entry at
+// rest.
+type CompressionCodec byte
+
+const (
+	// CodecNone stores entries uncompressed
+	CodecNone CompressionCodec = iota
+	// CodecGzip stores entries gzip-compressed
+	CodecGzip
+	// CodecZstd stores entries zstd-compressed, for the best ratio. It is
+	// implemented by the httpcache/zstd package, which must be imported for
+	// its Codec to be registered.
+	CodecZstd
+)
+
+// maxCodec is the largest CompressionCodec, so that every codec has a tag
+const maxCodec CompressionCodec = 0x3f
+
+// codecTag is added to the CompressionCodec of an entry to make the tag it is
+// prefixed with. Tags start at 0xc0, above the first byte of every entry
+// envelope (see entryVersion), so that entries stored uncompressed, e.g.
+// before a cache was wrapped in a CompressingCache, aren't mistaken for
+// compressed ones.
+const codecTag byte = 0xc0
+
+// Codec compresses and decompresses the entries of a CompressionCodec other
+// than CodecNone and CodecGzip, see RegisterCodec
+type Codec interface {
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[CompressionCodec]Codec)
+)
+
+// RegisterCodec makes c the implementation of codec, typically in the init
+// function of the package providing it, as httpcache/zstd does for CodecZstd.
+// It panics if codec is CodecNone or CodecGzip, already registered, or above
+// 63.
+func RegisterCodec(codec CompressionCodec, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if codec > maxCodec {
+		panic("httpcache: compression codec " + strconv.Itoa(int(codec)) + " out of range")
+	}
+	if _, ok := codecs[codec]; ok || codec == CodecNone || codec == CodecGzip {
+		panic("httpcache: compression codec " + strconv.Itoa(int(codec)) + " registered twice")
+	}
+	codecs[codec] = c
+}
+
+// registeredCodec returns the Codec registered for codec, if any
+func registeredCodec(codec CompressionCodec) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[codec]
+	return c, ok
+}
+
+// knownCodec returns true if entries can be compressed with codec
+func knownCodec(codec CompressionCodec) bool {
+	_, ok := registeredCodec(codec)
+	return ok || codec == CodecNone || codec == CodecGzip
+}
+
+// CompressingCache is an implementation of Cache that compresses entries before
+// passing them to an underlying Cache.
+//
+// Each stored entry is prefixed with a tag naming the codec it was written
+// with, so entries remain readable after CompressionCodec is changed. Entries
+// without a tag, stored in the underlying Cache before it was wrapped, are
+// read as they are.
+type CompressingCache struct {
+	Cache            Cache
+	CompressionCodec CompressionCodec
+
+	// Logger receives the failures to compress entries, which are then not
+	// stored. If nil, they are discarded.
+	Logger Logger
+}
+
+// NewCompressingCache returns a new Cache that compresses entries with codec
+// before storing them in c. It panics if codec is unknown, e.g. CodecZstd
+// without the httpcache/zstd package imported.
+func NewCompressingCache(c Cache, codec CompressionCodec) *CompressingCache {
+	if !knownCodec(codec) {
+		panic("httpcache: unknown compression codec " + strconv.Itoa(int(codec)))
+	}
+	return &CompressingCache{
+		Cache:            c,
+		CompressionCodec: codec,
+	}
+}
+
+// NewGzipCache returns a new Cache that gzip-compresses entries before storing
+// them in c
+func NewGzipCache(c Cache) *CompressingCache {
+	return NewCompressingCache(c, CodecGzip)
+}
+
+// Get returns the decompressed []byte representation of the response and true
+// if present, false if not or if the entry can't be decompressed
+func (c *CompressingCache) Get(key string) (resp []byte, ok bool) {
+	b, ok := c.Cache.Get(key)
+	if !ok || len(b) == 0 {
+		return nil, false
+	}
+
+	if b[0] < codecTag {
+		return b, true
+	}
+	resp, err := decompress(CompressionCodec(b[0]-codecTag), b[1:])
+	if err != nil {
+		c.Cache.Delete(key)
+		return nil, false
+	}
+	return resp, true
+}
+
+// Set compresses response resp and saves it to the underlying cache with key.
+// If it can't be compressed, it is not stored, the entry previously stored
+// with key is removed, and the failure is logged.
+func (c *CompressingCache) Set(key string, resp []byte) {
+	b, err := compress(c.CompressionCodec, resp)
+	if err != nil {
+		c.logger().Errorf("%s: %s", key, &Error{ErrSerialize, err})
+		c.Cache.Delete(key)
+		return
+	}
+	c.Cache.Set(key, b)
+}
+
+func (c *CompressingCache) logger() Logger {
+	if c.Logger == nil {
+		return nopLogger{}
+	}
+	return c.Logger
+}
+
+// Delete removes key from the underlying cache
+func (c *CompressingCache) Delete(key string) {
+	c.Cache.Delete(key)
+}
+
+// Keys returns the keys of the underlying cache, or nil if it can't list them
+func (c *CompressingCache) Keys() []string {
+	if k, ok := c.Cache.(interface{ Keys() []string }); ok {
+		return k.Keys()
+	}
+	return nil
+}
+
+// Len returns the number of entries in the underlying cache, or 0 if it
+// can't report it
+func (c *CompressingCache) Len() int {
+	if l, ok := c.Cache.(interface{ Len() int }); ok {
+		return l.Len()
+	}
+	return 0
+}
+
+// Size returns the compressed size of the entries in the underlying cache, or
+// 0 if it can't report it
+func (c *CompressingCache) Size() int64 {
+	if sz, ok := c.Cache.(interface{ Size() int64 }); ok {
+		return sz.Size()
+	}
+	return 0
+}
+
+// Evictions and Expirations return the counts of entries the underlying cache
+// removed, see MemoryCache.Evictions, or 0 if it can't report them
+func (c *CompressingCache) Evictions() uint64 {
+	if e, ok := c.Cache.(interface{ Evictions() uint64 }); ok {
+		return e.Evictions()
+	}
+	return 0
+}
+
+func (c *CompressingCache) Expirations() uint64 {
+	if e, ok := c.Cache.(interface{ Expirations() uint64 }); ok {
+		return e.Expirations()
+	}
+	return 0
+}
+
+// DeletePrefix removes every entry of the underlying cache whose key starts
+// with prefix, if it can list its keys, and returns how many it removed
+func (c *CompressingCache) DeletePrefix(prefix string) int {
+	if d, ok := c.Cache.(interface{ DeletePrefix(string) int }); ok {
+		return d.DeletePrefix(prefix)
+	}
+	n := 0
+	for _, key := range c.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.Cache.Delete(key)
+			n++
+		}
+	}
+	return n
+}
+
+// compress returns b compressed with codec, prefixed with the codec tag
+func compress(codec CompressionCodec, b []byte) ([]byte, error) {
+	if codec > maxCodec {
+		return nil, errUnknownCodec
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(codecTag + byte(codec))
+
+	switch codec {
+	case CodecNone:
+		buf.Write(b)
+	case CodecGzip:
+		if err := gzipTo(&buf, b); err != nil {
+			return nil, err
+		}
+	default:
+		c, ok := registeredCodec(codec)
+		if !ok {
+			return nil, errUnknownCodec
+		}
+		z, err := c.Compress(b)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(z)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// gzipWriters holds gzip.Writers for reuse, each of which allocates about a
+// megabyte of compression state
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// gzipTo writes b gzip-compressed to buf
+func gzipTo(buf *bytes.Buffer, b []byte) error {
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(buf)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// decompress returns b decompressed with codec
+func decompress(codec CompressionCodec, b []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return b, nil
+	case CodecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(zr)
+	default:
+		c, ok := registeredCodec(codec)
+		if !ok {
+			return nil, errUnknownCodec
+		}
+		return c.Decompress(b)
+	}
+}
+
+// gzipResponse gzips the body of resp in place if req accepts gzip and resp
+// has a body that isn't already encoded
+func gzipResponse(req *http.Request, resp *http.Response) {
+	if req.Method == "HEAD" || resp.Header.Get("Content-Encoding") != "" || !acceptsGzip(req.Header) {
+		return
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return
+	}
+	var buf bytes.Buffer
+	if err := gzipTo(&buf, body); err != nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return
+	}
+	b := buf.Bytes()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Add("Vary", "Accept-Encoding")
+	// the gzipped representation is no longer byte-identical to the original
+	if etag := resp.Header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("Etag", "W/"+etag)
+	}
+}
+
+// gunzipResponse decodes the body of resp in place if it is gzip-encoded, as
+// it is read, and removes Accept-Encoding from its Vary header, since the
+// decoded response is the same whatever the client accepts
+func gunzipResponse(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	// the headers of a 304 are merged into the decoded response it confirms
+	bodiless := resp.StatusCode == http.StatusNotModified || resp.StatusCode == http.StatusNoContent ||
+		resp.Request != nil && resp.Request.Method == "HEAD"
+	if !bodiless {
+		body := resp.Body
+		zr, err := gzip.NewReader(body)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == nil {
+			resp.Body = readCloser{zr, body}
+		}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Uncompressed = true
+	if etag := resp.Header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("Etag", "W/"+etag)
+	}
+
+	var vary []string
+	for _, name := range headerAllCommaSepValues(resp.Header, "Vary") {
+		if !strings.EqualFold(name, "Accept-Encoding") {
+			vary = append(vary, name)
+		}
+	}
+	resp.Header.Del("Vary")
+	if len(vary) > 0 {
+		resp.Header.Set("Vary", strings.Join(vary, ", "))
+	}
+	return nil
+}
+
+// acceptsGzip returns true if the Accept-Encoding header in headers allows
+// gzip
+func acceptsGzip(headers http.Header) bool {
+	for _, v := range headerAllCommaSepValues(headers, "accept-encoding") {
+		coding, q := v, ""
+		if i := strings.IndexByte(v, ';'); i >= 0 {
+			coding, q = v[:i], strings.TrimSpace(v[i+1:])
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if f, err := strconv.ParseFloat(strings.TrimPrefix(q, "q="), 64); err == nil && f == 0 {
+			return false
+		}
+		return true
+	}
+	return false
+}
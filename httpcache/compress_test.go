@@ -0,0 +1,183 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Codecs registered by the tests
+const (
+	codecReverse CompressionCodec = 32 + iota
+	codecFailing
+)
+
+// reverseCodec "compresses" entries by reversing them
+type reverseCodec struct{}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+func (reverseCodec) Compress(b []byte) ([]byte, error)   { return reverse(b), nil }
+func (reverseCodec) Decompress(b []byte) ([]byte, error) { return reverse(b), nil }
+
+// failingCodec fails to compress anything
+type failingCodec struct{ reverseCodec }
+
+func (failingCodec) Compress(b []byte) ([]byte, error) { return nil, errors.New("compression failed") }
+
+func init() {
+	RegisterCodec(codecReverse, reverseCodec{})
+	RegisterCodec(codecFailing, failingCodec{})
+}
+
+// recordingLogger records the errors logged
+type recordingLogger struct{ errors []string }
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {}
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCompressingCacheCodecs(t *testing.T) {
+	entry := bytes.Repeat([]byte(`{"id":1,"name":"x"},`), 100)
+	codecs := []CompressionCodec{CodecNone, CodecGzip, codecReverse}
+	for _, written := range codecs {
+		for _, current := range codecs {
+			t.Run(fmt.Sprintf("%d read as %d", written, current), func(t *testing.T) {
+				mem := NewMemoryCache(time.Hour)
+				NewCompressingCache(mem, written).Set("k", entry)
+				stored, _ := mem.Get("k")
+				if stored[0] != codecTag+byte(written) {
+					t.Errorf("entry tagged with %#x, want %#x", stored[0], codecTag+byte(written))
+				}
+				got, ok := NewCompressingCache(mem, current).Get("k")
+				if !ok || !bytes.Equal(got, entry) {
+					t.Errorf("Get = %.20q, %v, want the entry", got, ok)
+				}
+			})
+		}
+	}
+}
+
+func TestNewCompressingCacheUnknownCodec(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic for an unknown codec")
+		}
+	}()
+	NewCompressingCache(NewMemoryCache(time.Hour), CompressionCodec(250))
+}
+
+func TestCompressingCacheSetFailure(t *testing.T) {
+	mem := NewMemoryCache(time.Hour)
+	NewCompressingCache(mem, CodecGzip).Set("k", []byte("old"))
+
+	var logger recordingLogger
+	c := NewCompressingCache(mem, codecFailing)
+	c.Logger = &logger
+	c.Set("k", []byte("new"))
+	if _, ok := c.Get("k"); ok {
+		t.Errorf("the previous entry is still stored")
+	}
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], "compression failed") {
+		t.Errorf("logged %q, want the compression failure", logger.errors)
+	}
+}
+
+func TestCompressingCacheUntaggedEntries(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write(bytes.Repeat([]byte("x"), 1000))
+	})
+	mem := NewMemoryCache(time.Hour)
+	mustGet(t, NewTransport(mem), origin.URL)
+	for _, key := range mem.Keys() {
+		if stored, _ := mem.Get(key); len(stored) == 0 || stored[0] >= codecTag {
+			t.Fatalf("entry stored uncompressed at %s starts with a codec tag", key)
+		}
+	}
+
+	// the cache is then switched to compression, keeping its entries
+	tr := NewTransport(NewCompressingCache(mem, CodecGzip))
+	if _, body := mustGet(t, tr, origin.URL); body != strings.Repeat("x", 1000) || origin.count() != 1 {
+		t.Errorf("got %d bytes after %d requests to the origin, want the uncompressed entry served", len(body), origin.count())
+	}
+}
+
+func TestRegisterCodecOutOfRange(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic for a codec without a tag")
+		}
+	}()
+	RegisterCodec(maxCodec+1, reverseCodec{})
+}
+
+func TestCompressOnServe(t *testing.T) {
+	entry := strings.Repeat(`{"id":1,"name":"x"},`, 100)
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		staleHandler(entry, "max-age=60", -10*time.Second)(w, r)
+	})
+	mem := NewMemoryCache(time.Hour)
+	tr := NewTransport(mem)
+	tr.CompressOnServe = true
+	mustGet(t, tr, origin.URL)
+
+	resp, body := mustGet(t, tr, origin.URL, "Accept-Encoding", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Etag") != `W/"v1"` || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("got headers %v, want a gzipped response with a weak ETag", resp.Header)
+	}
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(zr); string(b) != entry || resp.ContentLength != int64(len(body)) {
+		t.Errorf("gzipped body decodes to %d bytes, Content-Length %d for %d, want the entry", len(b), resp.ContentLength, len(body))
+	}
+
+	resp, body = mustGet(t, tr, origin.URL)
+	if resp.Header.Get("Content-Encoding") != "" || body != entry {
+		t.Errorf("got %d bytes encoded %q for a client not accepting gzip, want the entry", len(body), resp.Header.Get("Content-Encoding"))
+	}
+	if stored, _ := mem.Get(mem.Keys()[0]); !bytes.Contains(stored, []byte(entry)) || origin.count() != 1 {
+		t.Errorf("the stored entry is not the uncompressed response")
+	}
+}
+
+func BenchmarkGzipCache(b *testing.B) {
+	entry := bytes.Repeat([]byte(`{"id":1,"name":"x","tags":["a","b"]},`), 500)
+	caches := []struct {
+		name string
+		wrap func(Cache) Cache
+	}{
+		{"memory", func(c Cache) Cache { return c }},
+		{"gzip", func(c Cache) Cache { return NewGzipCache(c) }},
+	}
+	for _, bc := range caches {
+		b.Run(bc.name, func(b *testing.B) {
+			mem := NewMemoryCache(time.Hour)
+			c := bc.wrap(mem)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.Set("k", entry)
+				if _, ok := c.Get("k"); !ok {
+					b.Fatal("miss")
+				}
+			}
+			b.ReportMetric(float64(mem.Size()), "stored-bytes")
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// notModifiedHeaders are the headers copied from a cached response into a 304
+// Not Modified response answering a conditional request for it, see
+// https://tools.ietf.org/html/rfc7232#section-4.1
+var notModifiedHeaders = []string{
+	"Age",
+	"Cache-Control",
+	"Content-Location",
+	"Date",
+	"Etag",
+	"Expires",
+	"Last-Modified",
+	"Vary",
+	XFromCache,
+	XCacheable,
+}
+
+// clientNotModified returns true if the preconditions of req show that the
+// client already has the cached response resp, so that a 304 Not Modified can
+// be sent instead
+func clientNotModified(req *http.Request, resp *http.Response) bool {
+	if _, ok := req.Header["If-None-Match"]; ok {
+		return noneMatchFails(req, resp)
+	}
+	return notModifiedSince(req, resp)
+}
+
+// noneMatchFails returns true if the If-None-Match precondition of req matches
+// the ETag of the cached response resp, using the weak comparison required by
+// RFC 7232
+func noneMatchFails(req *http.Request, resp *http.Response) bool {
+	etag := strings.TrimPrefix(resp.Header.Get("Etag"), "W/")
+	for _, v := range headerAllCommaSepValues(req.Header, "If-None-Match") {
+		if v == "*" || (etag != "" && strings.TrimPrefix(v, "W/") == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince returns true if req carries an If-Modified-Since
+// precondition that the cached response resp does not satisfy, i.e. resp was
+// not modified since. If-Modified-Since is ignored when If-None-Match is
+// present, as required by RFC 7232.
+func notModifiedSince(req *http.Request, resp *http.Response) bool {
+	if req.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ims)
+}
+
+// notModified returns a bodiless 304 Not Modified response answering req in
+// place of the cached response resp, and closes the body of resp
+func notModified(req *http.Request, resp *http.Response) *http.Response {
+	resp.Body.Close()
+
+	header := make(http.Header)
+	for _, name := range notModifiedHeaders {
+		if v, ok := resp.Header[name]; ok {
+			header[name] = v
+		}
+	}
+
+	return &http.Response{
+		Status:     "304 Not Modified",
+		StatusCode: http.StatusNotModified,
+		Proto:      resp.Proto,
+		ProtoMajor: resp.ProtoMajor,
+		ProtoMinor: resp.ProtoMinor,
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// addValidators sets the preconditions of req, which is about to be sent to
+// the origin, from the validators of the stale stored response, so that the
+// origin can answer with 304 Not Modified if it is still current. It returns
+// false if stale has no validators.
+func addValidators(req *http.Request, stale *http.Response) bool {
+	etag := stale.Header.Get("Etag")
+	lastModified := stale.Header.Get("Last-Modified")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return etag != "" || lastModified != ""
+}
+
+// notModifiedSkipHeaders are the headers of a 304 Not Modified response that
+// describe the 304 itself rather than the stored response it validates
+var notModifiedSkipHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// mergeNotModified updates the stale stored response with the headers of the
+// 304 Not Modified response notModified received when revalidating it, and
+// returns it, see https://tools.ietf.org/html/rfc7234#section-4.3.4
+func mergeNotModified(stale, notModified *http.Response) *http.Response {
+	for name, v := range notModified.Header {
+		if !notModifiedSkipHeaders[name] {
+			stale.Header[name] = v
+		}
+	}
+	return stale
+}
+
+// setBodyETag sets the ETag of resp to a strong validator derived from its
+// body, which is buffered and left readable from the start. resp is left
+// without an ETag if its body can't be read.
+func setBodyETag(resp *http.Response) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	sum := sha256.Sum256(body)
+	resp.Header.Set("Etag", `"`+base64.RawURLEncoding.EncodeToString(sum[:16])+`"`)
+}
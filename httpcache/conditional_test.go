@@ -0,0 +1,111 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientConditionalVariant(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Encoding")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("ETag", `"gzip"`)
+			fmt.Fprint(w, "gzip variant")
+			return
+		}
+		w.Header().Set("ETag", `"identity"`)
+		fmt.Fprint(w, "identity variant")
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	mustGet(t, tr, origin.URL, "Accept-Encoding", "gzip")
+	mustGet(t, tr, origin.URL, "Accept-Encoding", "identity")
+	requests := origin.count()
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		ifNoneMatch    string
+		wantStatus     int
+		wantBody       string
+	}{
+		{name: "matching variant", acceptEncoding: "gzip", ifNoneMatch: `"gzip"`, wantStatus: http.StatusNotModified},
+		{name: "other variant", acceptEncoding: "identity", ifNoneMatch: `"gzip"`, wantStatus: http.StatusOK, wantBody: "identity variant"},
+		{name: "other variant's ETag", acceptEncoding: "gzip", ifNoneMatch: `"identity"`, wantStatus: http.StatusOK, wantBody: "gzip variant"},
+		{name: "identity ETag", acceptEncoding: "identity", ifNoneMatch: `"identity"`, wantStatus: http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := mustGet(t, tr, origin.URL, "Accept-Encoding", tt.acceptEncoding, "If-None-Match", tt.ifNoneMatch)
+			if resp.StatusCode != tt.wantStatus || body != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+	if n := origin.count(); n != requests {
+		t.Errorf("origin got %d requests, want the variants served from the cache", n-requests)
+	}
+}
+
+func TestClientConditionalHit(t *testing.T) {
+	modified := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		fmt.Fprint(w, "body")
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	mustGet(t, tr, origin.URL)
+
+	tests := []struct {
+		name   string
+		header []string
+		want   int
+	}{
+		{"not modified since", []string{"If-Modified-Since", modified.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"modified since", []string{"If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK},
+		{"weak match", []string{"If-None-Match", `W/"v1"`}, http.StatusNotModified},
+		{"no match, If-Modified-Since ignored", []string{"If-None-Match", `"v0"`, "If-Modified-Since", modified.Format(http.TimeFormat)}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp, body := mustGet(t, tr, origin.URL, tt.header...)
+		if resp.StatusCode != tt.want || resp.Header.Get(XFromCache) != "1" {
+			t.Errorf("%s: got %d, from cache %q, want %d from the cache", tt.name, resp.StatusCode, resp.Header.Get(XFromCache), tt.want)
+		}
+		if wantBody := map[int]string{200: "body", 304: ""}[tt.want]; body != wantBody {
+			t.Errorf("%s: body %q, want %q", tt.name, body, wantBody)
+		}
+	}
+	if n := origin.count(); n != 1 {
+		t.Errorf("origin got %d requests, want 1", n)
+	}
+}
+
+func TestGenerateETag(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.GenerateETag = true
+
+	resp, _ := mustGet(t, tr, origin.URL)
+	etag := resp.Header.Get("Etag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("ETag = %q, want a strong ETag", etag)
+	}
+	if resp, body := mustGet(t, tr, origin.URL, "If-None-Match", etag); resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("got %d %q for If-None-Match %s, want a 304", resp.StatusCode, body, etag)
+	}
+
+	// the same body gets the same ETag, another body another one
+	other := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	if resp, _ := mustGet(t, tr, other.URL); resp.Header.Get("Etag") != etag {
+		t.Errorf("ETag %q for the same body, want %q", resp.Header.Get("Etag"), etag)
+	}
+	other.set(staleHandler("other", "max-age=60", -10*time.Second))
+	if resp, _ := mustGet(t, tr, other.URL+"/x"); resp.Header.Get("Etag") == etag {
+		t.Errorf("the same ETag for another body")
+	}
+}
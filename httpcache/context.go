@@ -0,0 +1,116 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Values for RequestInfo.Status
+const (
+	StatusHit         = "hit"
+	StatusRevalidated = "revalidated"
+	StatusStale       = "stale"
+	StatusMiss        = "miss"
+	StatusBypass      = "bypass"
+)
+
+// RequestInfo records what the Transport did with a single request
+type RequestInfo struct {
+	// Status is one of StatusHit, StatusRevalidated, StatusStale, StatusMiss
+	// or StatusBypass. StatusRevalidated means a stale stored response was
+	// served after the origin confirmed it with a 304 Not Modified, and
+	// StatusStale that one was served without it: because the origin failed,
+	// while it is revalidated in the background, or as the client allowed
+	// with max-stale.
+	Status string
+	// Key is the cache key the request was looked up or stored under. It is
+	// empty for bypassed requests
+	Key string
+	// Age is the age of the response served from the cache: the age it
+	// arrived with plus the time since it was stored, as in its Age header.
+	// It is that of the 304 Not Modified for StatusRevalidated, and zero for
+	// responses from the origin.
+	Age time.Duration
+	// BackendLatency is the time spent waiting on the underlying transport
+	BackendLatency time.Duration
+	// NotCached is why the response was not stored, one of the NotCached
+	// constants. It is empty for responses that were stored or served from
+	// the cache
+	NotCached string
+	// Coalesced is set if the request waited for an identical one in flight
+	// to the origin, with CoalesceMisses, rather than being sent itself
+	Coalesced bool
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx that causes the Transport to record
+// the outcome of a request made with it into info
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the RequestInfo attached to ctx with
+// WithRequestInfo, if any
+func RequestInfoFromContext(ctx context.Context) (*RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info, ok && info != nil
+}
+
+// requestInfo returns the RequestInfo attached to ctx, or a throwaway one if
+// there is none
+func requestInfo(ctx context.Context) *RequestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok && info != nil {
+		return info
+	}
+	return &RequestInfo{}
+}
+
+type noCacheKey struct{}
+
+// WithNoCache returns a copy of ctx that makes the Transport bypass the cache
+// for requests made with it: they are neither answered from the cache nor
+// stored in it, as if caching were disabled for them alone
+func WithNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// noCacheRequested returns true if req was made with a context from
+// WithNoCache
+func noCacheRequested(req *http.Request) bool {
+	return req.Context().Value(noCacheKey{}) != nil
+}
+
+type forceRefreshKey struct{}
+
+// WithRefresh returns a copy of ctx that makes the Transport revalidate the
+// stored responses to requests made with it with the origin before serving
+// them, and store the responses it gets, as if the requests had a
+// Cache-Control: no-cache directive
+func WithRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceRefreshKey{}, true)
+}
+
+// refreshRequested returns true if req was made with a context from
+// WithRefresh
+func refreshRequested(req *http.Request) bool {
+	return req.Context().Value(forceRefreshKey{}) != nil
+}
+
+type ttlKey struct{}
+
+// WithTTL returns a copy of ctx that makes the Transport serve stored
+// responses to requests made with it only while they are at most d old, and
+// store the responses it gets for them fresh for d, in place of their own
+// freshness information, as the Cacheable hook's TTL does. Their no-store
+// and private directives are still honoured. d <= 0 has no effect.
+func WithTTL(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, ttlKey{}, d)
+}
+
+// contextTTL returns the TTL set on the context of req by WithTTL, if any
+func contextTTL(req *http.Request) (time.Duration, bool) {
+	d, ok := req.Context().Value(ttlKey{}).(time.Duration)
+	return d, ok && d > 0
+}
@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestInfoAge(t *testing.T) {
+	aged := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Age", "30")
+		fmt.Fprint(w, "aged")
+	}
+	revalidated := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "revalidated")
+	}
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		swr            time.Duration
+		wantStatus     string
+		minAge, maxAge time.Duration
+	}{
+		{name: "hit", handler: aged, wantStatus: StatusHit, minAge: 30 * time.Second, maxAge: 31 * time.Second},
+		{name: "stale", handler: staleHandler("stale", "", 100*time.Second), swr: time.Hour, wantStatus: StatusStale, minAge: 110 * time.Second, maxAge: 112 * time.Second},
+		{name: "revalidated", handler: revalidated, wantStatus: StatusRevalidated},
+		{name: "miss", handler: staleHandler("stale", "", 100*time.Second), wantStatus: StatusMiss},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, tt.handler)
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.StaleWhileRevalidate = tt.swr
+			shutdownOnCleanup(t, tr)
+			mustGet(t, tr, origin.URL)
+
+			info := &RequestInfo{}
+			req := httptest.NewRequest("GET", origin.URL, nil)
+			req.RequestURI = ""
+			resp, err := tr.RoundTrip(req.WithContext(WithRequestInfo(req.Context(), info)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if info.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", info.Status, tt.wantStatus)
+			}
+			if info.Age < tt.minAge || info.Age > tt.maxAge {
+				t.Errorf("Age = %s, want between %s and %s", info.Age, tt.minAge, tt.maxAge)
+			}
+		})
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	mem := NewMemoryCache(time.Hour)
+	tr := NewTransport(mem)
+	mustGet(t, tr, origin.URL+"/stored")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", origin.URL+"/stored", nil).WithContext(ctx)
+	req.RequestURI = ""
+	if _, err := tr.RoundTrip(req); !errors.Is(err, ErrUpstream) || !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want an ErrUpstream wrapping context.Canceled", err)
+	}
+
+	// canceled once the origin has answered
+	ctx, cancel = context.WithCancel(context.Background())
+	tr.Transport = transportFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		cancel()
+		return resp, err
+	})
+	req = httptest.NewRequest("GET", origin.URL+"/new", nil).WithContext(ctx)
+	req.RequestURI = ""
+	if _, err := tr.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want context.Canceled", err)
+	}
+	if n := origin.count(); n != 2 || mem.Len() != 1 {
+		t.Errorf("origin got %d requests and %d entries are stored, want only the first request stored", n, mem.Len())
+	}
+}
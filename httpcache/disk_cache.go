@@ -0,0 +1,217 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiskCache is an implementation of Cache that stores each entry as a file in
+// a directory, so that entries survive restarts and can outgrow memory.
+//
+// Files are named after a hash of their key and their modification time
+// records when they were stored, so maxTTL keeps applying across restarts.
+// Entries are written to a temporary file and renamed into place, so readers
+// never see a partial write. Errors reading or writing files are reported as
+// misses.
+//
+// The key of each entry is kept next to it, in a file with the same name and
+// a .key extension, so that DiskCache is a ListableCache whose entries can be
+// listed, exported and purged by prefix, e.g. with Transport.PurgePrefix.
+// Entries stored by versions that didn't keep their key are not listed.
+type DiskCache struct {
+	dir    string
+	maxTTL time.Duration
+
+	// Sync makes Set flush each entry, and the directory entry naming it, to
+	// stable storage before returning, so that stored entries survive a crash
+	// of the machine, at the cost of slower writes
+	Sync bool
+}
+
+// NewDiskCache returns a new Cache that will store items as files in dir for
+// at most maxTTL, creating dir if it doesn't exist
+func NewDiskCache(dir string, maxTTL time.Duration) (*DiskCache, error) {
+	if maxTTL <= time.Duration(0) {
+		panic("maxTTL must be >0")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir, maxTTL: maxTTL}, nil
+}
+
+// Get returns the []byte representation of the response and true if present, false if not
+func (c *DiskCache) Get(key string) (resp []byte, ok bool) {
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false
+	}
+	if time.Since(fi.ModTime()) > c.maxTTL {
+		c.remove(f.Name())
+		return nil, false
+	}
+
+	resp, err = ioutil.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+// Set saves response resp to the cache with key
+func (c *DiskCache) Set(key string, resp []byte) {
+	name := c.path(key)
+	// the key file never changes once written, as its name derives from it
+	if _, err := os.Stat(name + keyFileExt); err != nil && c.writeFile(name+keyFileExt, []byte(key)) != nil {
+		return
+	}
+	if c.writeFile(name, resp) != nil {
+		return
+	}
+	if c.Sync {
+		syncDir(c.dir)
+	}
+}
+
+// writeFile writes b to a temporary file renamed to name once complete
+func (c *DiskCache) writeFile(name string, b []byte) error {
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil && c.Sync {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// syncDir flushes the entries of directory dir to stable storage
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Delete removes key from the cache
+func (c *DiskCache) Delete(key string) {
+	c.remove(c.path(key))
+}
+
+// remove deletes the entry file name along with its key file, and returns
+// the error removing the entry
+func (c *DiskCache) remove(name string) error {
+	err := os.Remove(name)
+	os.Remove(name + keyFileExt)
+	return err
+}
+
+// Keys returns the keys of the entries in the cache that haven't expired
+func (c *DiskCache) Keys() []string {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	for _, fi := range files {
+		if !isEntryFile(fi) || time.Since(fi.ModTime()) > c.maxTTL {
+			continue
+		}
+		key, err := ioutil.ReadFile(filepath.Join(c.dir, fi.Name()+keyFileExt))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, string(key))
+	}
+	return keys
+}
+
+// Prune removes expired entries, then the least recently stored ones until the
+// entries take up at most maxBytes, and returns the number of entries removed.
+// maxBytes <= 0 only removes expired entries. Call it periodically to bound
+// the size of the cache; entries being written concurrently are left alone.
+func (c *DiskCache) Prune(maxBytes int64) (removed int, err error) {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	live := files[:0]
+	for _, fi := range files {
+		if !isEntryFile(fi) {
+			// the key file of an entry that failed to be written, long
+			// enough ago not to be one being written
+			if name := strings.TrimSuffix(fi.Name(), keyFileExt); name != fi.Name() && time.Since(fi.ModTime()) > c.maxTTL {
+				if _, err := os.Stat(filepath.Join(c.dir, name)); os.IsNotExist(err) {
+					os.Remove(filepath.Join(c.dir, fi.Name()))
+				}
+			}
+			continue
+		}
+		if time.Since(fi.ModTime()) > c.maxTTL {
+			if c.remove(filepath.Join(c.dir, fi.Name())) == nil {
+				removed++
+			}
+			continue
+		}
+		size += fi.Size()
+		live = append(live, fi)
+	}
+	if maxBytes <= 0 {
+		return removed, nil
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].ModTime().Before(live[j].ModTime())
+	})
+	for _, fi := range live {
+		if size <= maxBytes {
+			break
+		}
+		if c.remove(filepath.Join(c.dir, fi.Name())) == nil {
+			removed++
+		}
+		size -= fi.Size()
+	}
+	return removed, nil
+}
+
+// keyFileExt is the extension of the files holding the keys of entries
+const keyFileExt = ".key"
+
+// isEntryFile returns true if fi is the file of an entry, rather than that
+// of its key or a temporary file being written
+func isEntryFile(fi os.FileInfo) bool {
+	return !fi.IsDir() && !strings.HasPrefix(fi.Name(), ".tmp-") && !strings.HasSuffix(fi.Name(), keyFileExt)
+}
+
+// path returns the name of the file holding the entry for key
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
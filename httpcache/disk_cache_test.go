@@ -0,0 +1,128 @@
+package httpcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDiskCache(t *testing.T) *DiskCache {
+	t.Helper()
+	c, err := NewDiskCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// sortedKeys returns the keys of c in order
+func sortedKeys(c ListableCache) []string {
+	keys := c.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+// files returns the number of files in dir
+func files(t *testing.T, dir string) int {
+	t.Helper()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(fis)
+}
+
+func TestDiskCacheKeys(t *testing.T) {
+	c := newTestDiskCache(t)
+	c.Set("https://example.com/a", []byte("a"))
+	c.Set("https://example.com/b", []byte("b"))
+	c.Set("https://example.com/b", []byte("b2"))
+	if got := strings.Join(sortedKeys(c), " "); got != "https://example.com/a https://example.com/b" {
+		t.Errorf("Keys() = %s", got)
+	}
+
+	// expired entries aren't listed, nor those stored without their key
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path("https://example.com/a"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.path("unlisted"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sortedKeys(c), " "); got != "https://example.com/b" {
+		t.Errorf("Keys() = %s, want only the live entry with a key file", got)
+	}
+
+	c.Delete("https://example.com/b")
+	if len(c.Keys()) != 0 {
+		t.Errorf("Keys() = %q after Delete", c.Keys())
+	}
+	if _, err := os.Stat(c.path("https://example.com/b") + keyFileExt); !os.IsNotExist(err) {
+		t.Error("Delete left the key file")
+	}
+}
+
+func TestDiskCachePurgePrefix(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(r.URL.Path))
+	})
+	c := newTestDiskCache(t)
+	tr := NewTransport(c)
+	for _, path := range []string{"/users/1", "/users/2", "/repos/1"} {
+		mustGet(t, tr, origin.URL+path)
+	}
+	u, _ := url.Parse(origin.URL + "/users/")
+	if n := tr.PurgePrefix(u); n != 2 {
+		t.Errorf("PurgePrefix removed %d entries, want 2", n)
+	}
+	if keys := c.Keys(); len(keys) != 1 || !strings.HasSuffix(keys[0], "/repos/1") {
+		t.Errorf("Keys() = %q, want the entry outside the prefix kept", keys)
+	}
+	if n := files(t, c.dir); n != 2 {
+		t.Errorf("%d files left, want the entry and its key file", n)
+	}
+	mustGet(t, tr, origin.URL+"/users/1")
+	mustGet(t, tr, origin.URL+"/repos/1")
+	if n := origin.count(); n != 4 {
+		t.Errorf("got %d requests to the origin, want the purged entry fetched again", n)
+	}
+}
+
+func TestDiskCachePrune(t *testing.T) {
+	c := newTestDiskCache(t)
+	c.Set("expired", []byte("x"))
+	c.Set("live", []byte("y"))
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path("expired"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	// the key file of an entry whose write failed long ago
+	orphan := filepath.Join(c.dir, strings.Repeat("0", 64)+keyFileExt)
+	if err := ioutil.WriteFile(orphan, []byte("orphan"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := c.Prune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Prune removed %d entries, want 1", removed)
+	}
+	if n := files(t, c.dir); n != 2 {
+		t.Errorf("%d files left, want the live entry and its key file", n)
+	}
+	if got, ok := c.Get("live"); !ok || string(got) != "y" {
+		t.Errorf("got %q, %v, want the live entry kept", got, ok)
+	}
+}
@@ -0,0 +1,233 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entryVersion is the version of the envelope written by entry.encode. Entries
+// start with their version byte so that older or newer formats found in a
+// persistent Cache can be recognised instead of misparsed.
+//
+// Version 1 holds the serialized response only. Version 2 prefixes it with
+// the time the entry was stored and the time it stops being fresh. Version 3
+// adds a CRC-32 checksum of the response after those times. Version 4
+// replaces it with the ID of the Hasher that computed the checksum, the
+// length of the checksum and the checksum.
+const entryVersion byte = 4
+
+// legacyEntryPrefix is the first byte of entries stored before the envelope
+// was introduced, which hold a bare serialized response ("HTTP/1.1 200 OK...")
+const legacyEntryPrefix = 'H'
+
+// varyEntryPrefix is the first byte of entries that hold no response, but the
+// request headers the responses stored for their key vary on, see varyKey
+const varyEntryPrefix = 'V'
+
+// entryHeaderLen is the length of a version 4 envelope before the checksum,
+// v3EntryHeaderLen that of a version 3 one before the response, and
+// v2EntryHeaderLen that of a version 2 one
+const (
+	entryHeaderLen   = 1 + 8 + 8 + 1 + 1
+	v3EntryHeaderLen = 1 + 8 + 8 + 4
+	v2EntryHeaderLen = 1 + 8 + 8
+)
+
+var (
+	errUnsupportedEntry = &Error{ErrSerialize, errors.New("unsupported cache entry version")}
+	errCorruptEntry     = &Error{ErrSerialize, errors.New("cache entry checksum mismatch")}
+)
+
+// Hasher computes the checksums stored entries are verified with, see
+// Transport.Hasher
+type Hasher interface {
+	// ID identifies the Hasher in the entries it checksums, so that they are
+	// verified with it whatever the Hasher of the Transport reading them. IDs
+	// below 16 are reserved for the Hashers of this package.
+	ID() byte
+	// Sum returns the checksum of b, at most 255 bytes long
+	Sum(b []byte) []byte
+}
+
+// The Hashers of this package. CRC32Hasher, the default, is fast and catches
+// accidental corruption; SHA256Hasher is slower but resists collisions.
+var (
+	CRC32Hasher  Hasher = crc32Hasher{}
+	SHA256Hasher Hasher = sha256Hasher{}
+)
+
+type crc32Hasher struct{}
+
+func (crc32Hasher) ID() byte { return 1 }
+
+func (crc32Hasher) Sum(b []byte) []byte {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(b))
+	return sum
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) ID() byte { return 2 }
+
+func (sha256Hasher) Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[byte]Hasher{
+		CRC32Hasher.ID():  CRC32Hasher,
+		SHA256Hasher.ID(): SHA256Hasher,
+	}
+)
+
+// RegisterHasher makes the entries checksummed by h verifiable by every
+// Transport, whichever Hasher it uses itself. Hashers other than those of
+// this package must be registered, typically in an init function, before
+// the entries they checksum are read. It panics if another Hasher is
+// registered with the same ID.
+func RegisterHasher(h Hasher) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	if other, ok := hashers[h.ID()]; ok && other != h {
+		panic("httpcache: Hasher ID " + strconv.Itoa(int(h.ID())) + " registered twice")
+	}
+	hashers[h.ID()] = h
+}
+
+// hasherByID returns the registered Hasher with id, if any
+func hasherByID(id byte) (Hasher, bool) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	h, ok := hashers[id]
+	return h, ok
+}
+
+// entry is a stored response along with its caching metadata
+type entry struct {
+	// storedAt is when the response was stored. It is zero for entries
+	// written by versions that did not record it.
+	storedAt time.Time
+	// expires is when the response stops being fresh. It is zero if the
+	// response carries no explicit freshness information, in which case it is
+	// fresh for as long as the Cache keeps it.
+	expires time.Time
+	// resp is the serialized response
+	resp []byte
+	// vary, if not nil, lists the request headers the responses for this
+	// entry's key vary on, and the entry holds no response
+	vary []string
+	// hasher checksums resp in the envelope, CRC32Hasher if nil
+	hasher Hasher
+}
+
+// fresh returns true if e may be served without revalidation at now
+func (e *entry) fresh(now time.Time) bool {
+	return e.expires.IsZero() || now.Before(e.expires)
+}
+
+// encode returns e wrapped in a versioned envelope
+func (e *entry) encode() []byte {
+	if e.vary != nil {
+		return append([]byte{varyEntryPrefix}, strings.Join(e.vary, ",")...)
+	}
+	h := e.hasher
+	if h == nil {
+		h = CRC32Hasher
+	}
+	sum := h.Sum(e.resp)
+	b := make([]byte, entryHeaderLen, entryHeaderLen+len(sum)+len(e.resp))
+	b[0] = entryVersion
+	binary.BigEndian.PutUint64(b[1:9], uint64(unixNano(e.storedAt)))
+	binary.BigEndian.PutUint64(b[9:17], uint64(unixNano(e.expires)))
+	b[17] = h.ID()
+	b[18] = byte(len(sum))
+	b = append(b, sum...)
+	return append(b, e.resp...)
+}
+
+// decodeEntry returns the entry held in b, errUnsupportedEntry if b was
+// written in an unknown format or checksummed by an unregistered Hasher, or
+// errCorruptEntry if its response doesn't match its checksum
+func decodeEntry(b []byte) (*entry, error) {
+	if len(b) == 0 {
+		return nil, errUnsupportedEntry
+	}
+
+	switch b[0] {
+	case 4:
+		if len(b) < entryHeaderLen || len(b) < entryHeaderLen+int(b[18]) {
+			return nil, errUnsupportedEntry
+		}
+		h, ok := hasherByID(b[17])
+		if !ok {
+			return nil, errUnsupportedEntry
+		}
+		sum, resp := b[entryHeaderLen:entryHeaderLen+int(b[18])], b[entryHeaderLen+int(b[18]):]
+		if !bytes.Equal(h.Sum(resp), sum) {
+			return nil, errCorruptEntry
+		}
+		return &entry{
+			storedAt: fromUnixNano(int64(binary.BigEndian.Uint64(b[1:9]))),
+			expires:  fromUnixNano(int64(binary.BigEndian.Uint64(b[9:17]))),
+			resp:     resp,
+			hasher:   h,
+		}, nil
+	case 3:
+		if len(b) < v3EntryHeaderLen {
+			return nil, errUnsupportedEntry
+		}
+		resp := b[v3EntryHeaderLen:]
+		if crc32.ChecksumIEEE(resp) != binary.BigEndian.Uint32(b[17:21]) {
+			return nil, errCorruptEntry
+		}
+		return &entry{
+			storedAt: fromUnixNano(int64(binary.BigEndian.Uint64(b[1:9]))),
+			expires:  fromUnixNano(int64(binary.BigEndian.Uint64(b[9:17]))),
+			resp:     resp,
+		}, nil
+	case 2:
+		if len(b) < v2EntryHeaderLen {
+			return nil, errUnsupportedEntry
+		}
+		return &entry{
+			storedAt: fromUnixNano(int64(binary.BigEndian.Uint64(b[1:9]))),
+			expires:  fromUnixNano(int64(binary.BigEndian.Uint64(b[9:17]))),
+			resp:     b[v2EntryHeaderLen:],
+		}, nil
+	case 1:
+		return &entry{resp: b[1:]}, nil
+	case legacyEntryPrefix:
+		return &entry{resp: b}, nil
+	case varyEntryPrefix:
+		return &entry{vary: strings.Split(string(b[1:]), ",")}, nil
+	default:
+		return nil, errUnsupportedEntry
+	}
+}
+
+// unixNano returns t as nanoseconds since the Unix epoch, or 0 for the zero
+// Time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the inverse of unixNano
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
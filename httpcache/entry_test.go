@@ -0,0 +1,173 @@
+package httpcache
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sha1Hasher is a Hasher from outside the package
+type sha1Hasher struct{}
+
+func (sha1Hasher) ID() byte { return 200 }
+
+func (sha1Hasher) Sum(b []byte) []byte {
+	sum := sha1.Sum(b)
+	return sum[:]
+}
+
+func init() {
+	RegisterHasher(sha1Hasher{})
+}
+
+// unregisteredHasher is a Hasher nobody registered
+type unregisteredHasher struct{ sha1Hasher }
+
+func (unregisteredHasher) ID() byte { return 201 }
+
+func TestEntryHashers(t *testing.T) {
+	resp := []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	storedAt := time.Unix(1600000000, 0)
+	tests := []struct {
+		name    string
+		hasher  Hasher
+		corrupt bool
+		wantErr error
+	}{
+		{name: "default", hasher: nil},
+		{name: "crc32", hasher: CRC32Hasher},
+		{name: "sha256", hasher: SHA256Hasher},
+		{name: "registered", hasher: sha1Hasher{}},
+		{name: "unregistered", hasher: unregisteredHasher{}, wantErr: errUnsupportedEntry},
+		{name: "crc32 corrupt", hasher: CRC32Hasher, corrupt: true, wantErr: errCorruptEntry},
+		{name: "sha256 corrupt", hasher: SHA256Hasher, corrupt: true, wantErr: errCorruptEntry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := (&entry{storedAt: storedAt, resp: resp, hasher: tt.hasher}).encode()
+			if tt.corrupt {
+				b[len(b)-1] ^= 1
+			}
+			e, err := decodeEntry(b)
+			if err != tt.wantErr {
+				t.Fatalf("decodeEntry error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if string(e.resp) != string(resp) || !e.storedAt.Equal(storedAt) {
+				t.Errorf("decoded %q stored at %s, want %q stored at %s", e.resp, e.storedAt, resp, storedAt)
+			}
+			want := tt.hasher
+			if want == nil {
+				want = CRC32Hasher
+			}
+			if e.hasher.ID() != want.ID() {
+				t.Errorf("decoded with Hasher %d, want %d", e.hasher.ID(), want.ID())
+			}
+		})
+	}
+}
+
+func TestDecodeOlderEntries(t *testing.T) {
+	resp := []byte("HTTP/1.1 200 OK\r\n\r\n")
+	v3 := make([]byte, v3EntryHeaderLen, v3EntryHeaderLen+len(resp))
+	v3[0] = 3
+	binary.BigEndian.PutUint64(v3[1:9], uint64(time.Unix(1600000000, 0).UnixNano()))
+	binary.BigEndian.PutUint32(v3[17:21], crc32.ChecksumIEEE(resp))
+	v3 = append(v3, resp...)
+	v2 := append(append([]byte{2}, v3[1:17]...), resp...)
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"version 3", v3},
+		{"version 2", v2},
+		{"version 1", append([]byte{1}, resp...)},
+		{"legacy", resp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := decodeEntry(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(e.resp) != string(resp) {
+				t.Errorf("resp = %q, want %q", e.resp, resp)
+			}
+		})
+	}
+}
+
+func TestTransportHasherChange(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("hashed", "max-age=60", -10*time.Second))
+	cache := NewMemoryCache(time.Hour)
+	for i, h := range []Hasher{SHA256Hasher, sha1Hasher{}, nil} {
+		tr := NewTransport(cache)
+		tr.Hasher = h
+		resp, body := mustGet(t, tr, origin.URL+"/"+string(rune('a'+i)))
+		if body != "hashed" || resp.Header.Get(XFromCache) != "" {
+			t.Fatalf("hasher %d: got %q from the cache, want it from the origin", i, body)
+		}
+	}
+	// read back by a transport using another Hasher
+	tr := NewTransport(cache)
+	tr.Hasher = CRC32Hasher
+	for i := 0; i < 3; i++ {
+		resp, body := mustGet(t, tr, origin.URL+"/"+string(rune('a'+i)))
+		if body != "hashed" || resp.Header.Get(XFromCache) != "1" {
+			t.Errorf("entry %d: got %q, from cache %q, want it from the cache", i, body, resp.Header.Get(XFromCache))
+		}
+	}
+}
+
+func TestUnknownEntryVersion(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("new", "max-age=60", -10*time.Second))
+	mem := NewMemoryCache(time.Hour)
+	var logger recordingLogger
+	tr := NewTransport(mem)
+	tr.Logger = &logger
+	mustGet(t, tr, origin.URL)
+
+	// an entry written by a later version of the package
+	key := mem.Keys()[0]
+	stored, _ := mem.Get(key)
+	mem.Set(key, append([]byte{entryVersion + 1}, stored[1:]...))
+
+	if resp, body := mustGet(t, tr, origin.URL); body != "new" || resp.Header.Get(XFromCache) != "" {
+		t.Errorf("got %q, from cache %q, want the origin's response", body, resp.Header.Get(XFromCache))
+	}
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], "unsupported cache entry version") {
+		t.Errorf("logged %q, want the unsupported entry", logger.errors)
+	}
+	if resp, _ := mustGet(t, tr, origin.URL); resp.Header.Get(XFromCache) != "1" || origin.count() != 2 {
+		t.Errorf("the entry was not replaced: %d requests to the origin", origin.count())
+	}
+}
+
+func TestUnreadableEntry(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("new", "max-age=60", -10*time.Second))
+	mem := NewMemoryCache(time.Hour)
+	var logger recordingLogger
+	tr := NewTransport(mem)
+	tr.Logger = &logger
+	mustGet(t, tr, origin.URL)
+
+	// a well-formed envelope around a response that can't be parsed
+	key := mem.Keys()[0]
+	mem.Set(key, (&entry{storedAt: time.Now(), resp: []byte("not a response")}).encode())
+
+	if resp, body := mustGet(t, tr, origin.URL); body != "new" || resp.Header.Get(XFromCache) != "" {
+		t.Errorf("got %q, from cache %q, want the origin's response", body, resp.Header.Get(XFromCache))
+	}
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], ErrSerialize.Error()) {
+		t.Errorf("logged %q, want a serialization error", logger.errors)
+	}
+	if resp, _ := mustGet(t, tr, origin.URL); resp.Header.Get(XFromCache) != "1" {
+		t.Errorf("the unreadable entry was not replaced")
+	}
+}
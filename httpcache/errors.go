@@ -0,0 +1,34 @@
+package httpcache
+
+import "errors"
+
+// Error classes; use errors.Is to test which class an error belongs to
+var (
+	// ErrBackend classifies failures of the Cache backend
+	ErrBackend = errors.New("httpcache: cache backend error")
+	// ErrUpstream classifies failures of the underlying transport or origin
+	ErrUpstream = errors.New("httpcache: upstream error")
+	// ErrSerialize classifies failures to encode or decode cache entries
+	ErrSerialize = errors.New("httpcache: serialization error")
+)
+
+// Error wraps an error returned by this package with its class, one of
+// ErrBackend, ErrUpstream or ErrSerialize
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the class of e
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
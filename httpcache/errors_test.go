@@ -0,0 +1,51 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingCacheCtx is a CacheCtx whose every call fails
+type failingCacheCtx struct{}
+
+var errBackendDown = errors.New("backend down")
+
+func (failingCacheCtx) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errBackendDown
+}
+func (failingCacheCtx) Set(context.Context, string, []byte) error { return errBackendDown }
+func (failingCacheCtx) Delete(context.Context, string) error      { return errBackendDown }
+
+func TestErrorClasses(t *testing.T) {
+	err := error(&Error{ErrBackend, errBackendDown})
+	if !errors.Is(err, ErrBackend) || errors.Is(err, ErrUpstream) || !errors.Is(err, errBackendDown) {
+		t.Errorf("%v: wrong class or cause", err)
+	}
+	if want := "httpcache: cache backend error: backend down"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	var logger recordingLogger
+	tr := NewTransport(FromCacheCtx(failingCacheCtx{}))
+	tr.Logger = &logger
+	if _, body := mustGet(t, tr, origin.URL); body != "body" {
+		t.Errorf("got %q, want the origin's response despite the backend", body)
+	}
+	if len(logger.errors) == 0 {
+		t.Fatalf("the backend failures were not logged")
+	}
+	for _, e := range logger.errors {
+		if !strings.Contains(e, ErrBackend.Error()+": backend down") {
+			t.Errorf("logged %q, want an ErrBackend", e)
+		}
+	}
+
+	tr.Transport = errTransport{}
+	if _, _, err := get(t, tr, origin.URL); !errors.Is(err, ErrUpstream) || !errors.Is(err, errUnreachable) {
+		t.Errorf("error %v is not an ErrUpstream wrapping the network error", err)
+	}
+}
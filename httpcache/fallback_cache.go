@@ -0,0 +1,101 @@
+package httpcache
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// FallbackCache is an implementation of Cache that stores entries in a chain
+// of underlying Caches, e.g. a fast volatile one followed by a durable one,
+// each keeping entries for as long as it is configured to.
+//
+// Get tries the tiers in order and returns the first hit, copying it into the
+// tiers before it so that later reads are served by the fastest one. Set and
+// Delete apply to every tier. Caches report failures as misses, so a tier that
+// is failing (or bypassed by a BreakerCache) is transparently skipped in favour
+// of the next.
+type FallbackCache struct {
+	Tiers []Cache
+
+	// ValidateVersions keeps local tiers consistent with a last tier shared
+	// between nodes, such as redis. Each Set records a new version of the
+	// entry alongside it in every tier, and Get only serves an entry from an
+	// earlier tier if its version is the one held by the last tier, so a copy
+	// made stale by another node writing the shared tier is bypassed. This
+	// costs an extra small read from the last tier and from each tier tried.
+	ValidateVersions bool
+}
+
+// NewFallbackCache returns a new Cache that stores entries in each of tiers,
+// reading from them in order
+func NewFallbackCache(tiers ...Cache) *FallbackCache {
+	return &FallbackCache{Tiers: tiers}
+}
+
+// NewTieredCache returns a new FallbackCache with a small, fast front Cache,
+// such as a MemoryCache, in front of a larger persistent back one, so that hot
+// entries are served from the front while all of them persist in the back
+func NewTieredCache(front, back Cache) *FallbackCache {
+	return NewFallbackCache(front, back)
+}
+
+// Get returns the []byte representation of the response from the first tier
+// that has it
+func (c *FallbackCache) Get(key string) (resp []byte, ok bool) {
+	var version []byte
+	validate := c.ValidateVersions && len(c.Tiers) > 1
+	if validate {
+		if version, ok = c.Tiers[len(c.Tiers)-1].Get(versionKey(key)); !ok {
+			version = nil
+		}
+	}
+
+	for i, tier := range c.Tiers {
+		if validate && i < len(c.Tiers)-1 {
+			if v, ok := tier.Get(versionKey(key)); !ok || version == nil || !bytes.Equal(v, version) {
+				continue
+			}
+		}
+		if resp, ok = tier.Get(key); ok {
+			for _, missed := range c.Tiers[:i] {
+				missed.Set(key, resp)
+				if validate && version != nil {
+					missed.Set(versionKey(key), version)
+				}
+			}
+			return resp, true
+		}
+	}
+	return nil, false
+}
+
+// Set saves response resp to every tier with key
+func (c *FallbackCache) Set(key string, resp []byte) {
+	var version []byte
+	if c.ValidateVersions {
+		version = []byte(strconv.FormatInt(time.Now().UnixNano(), 36))
+	}
+	for _, tier := range c.Tiers {
+		tier.Set(key, resp)
+		if version != nil {
+			tier.Set(versionKey(key), version)
+		}
+	}
+}
+
+// Delete removes key from every tier
+func (c *FallbackCache) Delete(key string) {
+	for _, tier := range c.Tiers {
+		tier.Delete(key)
+		if c.ValidateVersions {
+			tier.Delete(versionKey(key))
+		}
+	}
+}
+
+// versionKey returns the key the version of the entry at key is stored under
+// when validating versions
+func versionKey(key string) string {
+	return "fallback-version " + key
+}
@@ -0,0 +1,62 @@
+package httpcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFallbackCache(t *testing.T) {
+	front, back := NewMemoryCache(time.Hour), NewMemoryCache(time.Hour)
+	c := NewTieredCache(front, back)
+	c.Set("a", []byte("1"))
+	if _, ok := front.Get("a"); !ok {
+		t.Fatalf("Set skipped the front tier")
+	}
+
+	// an entry the front has lost is served from the back and copied forward
+	front.Delete("a")
+	if got, ok := c.Get("a"); !ok || string(got) != "1" {
+		t.Errorf("Get = %q, %v, want the back tier's entry", got, ok)
+	}
+	if got, ok := front.Get("a"); !ok || string(got) != "1" {
+		t.Errorf("the entry was not copied to the front tier")
+	}
+
+	c.Delete("a")
+	if front.Len() != 0 || back.Len() != 0 {
+		t.Errorf("Delete left %d and %d entries", front.Len(), back.Len())
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Get hit after Delete")
+	}
+}
+
+func TestFallbackCacheValidateVersions(t *testing.T) {
+	shared := NewMemoryCache(time.Hour)
+	node := func() *FallbackCache {
+		c := NewFallbackCache(NewMemoryCache(time.Hour), shared)
+		c.ValidateVersions = true
+		return c
+	}
+	a, b := node(), node()
+
+	a.Set("k", []byte("v1"))
+	if got, _ := b.Get("k"); string(got) != "v1" {
+		t.Fatalf("b got %q, want v1 from the shared tier", got)
+	}
+	// b's local copy of v1 is now outdated
+	time.Sleep(time.Millisecond)
+	a.Set("k", []byte("v2"))
+	if got, _ := b.Get("k"); string(got) != "v2" {
+		t.Errorf("b got %q, want v2 rather than its outdated local copy", got)
+	}
+	if got, ok := b.Tiers[0].Get("k"); !ok || string(got) != "v2" {
+		t.Errorf("b's local tier holds %q, want it refreshed to v2", got)
+	}
+
+	// an entry without a version in the shared tier is never served locally
+	b.Tiers[0].Set("unversioned", []byte("x"))
+	if _, ok := b.Get("unversioned"); ok {
+		t.Errorf("served a local entry the shared tier has no version for")
+	}
+}
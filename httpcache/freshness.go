@@ -0,0 +1,127 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// freshnessFunc returns the lifetime FreshnessFunc gives the response to req
+// serialized as respBytes, and false if there is none
+func (t *Transport) freshnessFunc(req *http.Request, respBytes []byte) (time.Duration, bool) {
+	if t.FreshnessFunc == nil {
+		return 0, false
+	}
+	resp, err := bytesToResp(respBytes, req)
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+	return t.FreshnessFunc(req, resp)
+}
+
+// freshnessLifetime returns how long resp stays fresh after it was generated,
+// and false if it carries no explicit freshness information. Responses with
+// no-cache have a lifetime of zero. In a shared cache s-maxage takes precedence
+// over max-age, which takes precedence over Expires, see
+// https://tools.ietf.org/html/rfc7234#section-4.2.1
+func (t *Transport) freshnessLifetime(resp *http.Response, cc cacheControl) (time.Duration, bool) {
+	if cc.has("no-cache") {
+		return 0, true
+	}
+	if t.Shared {
+		if d, ok := deltaSeconds(cc["s-maxage"]); ok {
+			return d, true
+		}
+	}
+	if d, ok := deltaSeconds(cc["max-age"]); ok {
+		return d, true
+	}
+
+	if v := resp.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// invalid dates, such as "0", mean already expired
+			return 0, true
+		}
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// defaultMaxHeuristicLifetime caps heuristic freshness lifetimes when
+// MaxHeuristicLifetime is unset. Caches must warn about heuristically fresh
+// responses older than this, see
+// https://tools.ietf.org/html/rfc7234#section-4.2.2
+const defaultMaxHeuristicLifetime = 24 * time.Hour
+
+// heuristicLifetime returns the heuristic freshness lifetime of resp, which
+// carries no explicit freshness information, and false if it gets none, see
+// https://tools.ietf.org/html/rfc7234#section-4.2.2
+func (t *Transport) heuristicLifetime(resp *http.Response) (time.Duration, bool) {
+	if t.HeuristicFraction <= 0 || !defaultCacheableStatus(resp.StatusCode) {
+		return 0, false
+	}
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return 0, false
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	if !date.After(lastModified) {
+		return 0, false
+	}
+
+	lifetime := time.Duration(float64(date.Sub(lastModified)) * t.HeuristicFraction)
+	max := t.MaxHeuristicLifetime
+	if max <= 0 {
+		max = defaultMaxHeuristicLifetime
+	}
+	if lifetime > max {
+		lifetime = max
+	}
+	return lifetime, true
+}
+
+// responseAge returns the age of resp reported by caches closer to the origin
+// in its Age header
+func responseAge(resp *http.Response) time.Duration {
+	d, _ := deltaSeconds(resp.Header.Get("Age"))
+	return d
+}
+
+// initialAge returns the age of resp when it was received at responseTime:
+// the larger of the age reported in its Age header and the time elapsed
+// since its Date, see https://tools.ietf.org/html/rfc7234#section-4.2.3
+func initialAge(resp *http.Response, responseTime time.Time) time.Duration {
+	age := responseAge(resp)
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		if apparent := responseTime.Sub(date); apparent > age {
+			age = apparent
+		}
+	}
+	return age
+}
+
+// deltaSeconds parses v, a non-negative integer number of seconds as used by
+// Cache-Control and Age, and returns false if it isn't one
+func deltaSeconds(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
@@ -0,0 +1,103 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// validUntil is a FreshnessFunc reading the freshness of JSON bodies from
+// their valid_until field
+func validUntil(req *http.Request, resp *http.Response) (time.Duration, bool) {
+	var body struct {
+		ValidUntil *time.Time `json:"valid_until"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.ValidUntil == nil {
+		return 0, false
+	}
+	return time.Until(*body.ValidUntil), true
+}
+
+func TestFreshnessFunc(t *testing.T) {
+	in := func(d time.Duration) string {
+		return fmt.Sprintf(`{"valid_until":%q}`, time.Now().Add(d).Format(time.RFC3339))
+	}
+	tests := []struct {
+		name      string
+		cc        string
+		body      string
+		policyTTL time.Duration
+		wantHit   bool
+	}{
+		{name: "fresh body", body: in(time.Hour), wantHit: true},
+		{name: "expired body", body: in(-time.Hour)},
+		{name: "body over max-age", cc: "max-age=3600", body: in(-time.Hour)},
+		{name: "body over no-cache", cc: "no-cache", body: in(time.Hour), wantHit: true},
+		{name: "no field max-age", cc: "max-age=3600", body: `{}`, wantHit: true},
+		{name: "no field", body: `{}`, cc: "max-age=0"},
+		{name: "policy TTL prevails", body: in(-time.Hour), policyTTL: time.Hour, wantHit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.cc != "" {
+					w.Header().Set("Cache-Control", tt.cc)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, tt.body)
+			})
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.FreshnessFunc = validUntil
+			if tt.policyTTL > 0 {
+				tr.Policies = PolicyRules{{Policy: Policy{TTL: tt.policyTTL}}}
+			}
+			mustGet(t, tr, origin.URL)
+
+			resp, body := mustGet(t, tr, origin.URL)
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if hit := resp.Header.Get(XFromCache) == "1" && origin.count() == 1; hit != tt.wantHit {
+				t.Errorf("served from the cache = %v, want %v", hit, tt.wantHit)
+			}
+		})
+	}
+}
+
+func TestExplicitFreshness(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		cc      string
+		date    time.Time
+		expires string
+		fresh   bool
+	}{
+		{name: "max-age", cc: "max-age=60", date: now.Add(-30 * time.Second), fresh: true},
+		{name: "max-age passed", cc: "max-age=60", date: now.Add(-90 * time.Second)},
+		{name: "Expires", date: now, expires: now.Add(time.Hour).Format(http.TimeFormat), fresh: true},
+		{name: "Expires passed", date: now, expires: now.Add(-time.Second).Format(http.TimeFormat)},
+		{name: "invalid Expires", date: now, expires: "0"},
+		{name: "max-age over Expires", cc: "max-age=60", date: now, expires: now.Add(-time.Second).Format(http.TimeFormat), fresh: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.cc != "" {
+					w.Header().Set("Cache-Control", tt.cc)
+				}
+				if tt.expires != "" {
+					w.Header().Set("Expires", tt.expires)
+				}
+				w.Header().Set("Date", tt.date.Format(http.TimeFormat))
+			})
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			mustGet(t, tr, origin.URL)
+			if resp, _ := mustGet(t, tr, origin.URL); (resp.Header.Get(XFromCache) == "1") != tt.fresh {
+				t.Errorf("served from the cache %q, want fresh %v", resp.Header.Get(XFromCache), tt.fresh)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package httpcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ListableCache is a Cache that can enumerate its keys, such as MemoryCache
+type ListableCache interface {
+	Cache
+	Keys() []string
+}
+
+var errHandoffFormat = &Error{ErrSerialize, errors.New("malformed cache export")}
+
+// WriteEntries writes every entry in c to w, as a sequence of
+// length-prefixed key and entry pairs. Entries that expire while being
+// listed are skipped.
+func WriteEntries(w io.Writer, c ListableCache) error {
+	bw := bufio.NewWriter(w)
+	for _, key := range c.Keys() {
+		entry, ok := c.Get(key)
+		if !ok {
+			continue
+		}
+		if err := writeChunk(bw, []byte(key)); err != nil {
+			return err
+		}
+		if err := writeChunk(bw, entry); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadEntries stores every entry written by WriteEntries to r in c, and
+// returns the number of entries stored. Imported entries are stored as new,
+// so their age starts over.
+func ReadEntries(r io.Reader, c Cache) (n int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		key, err := readChunk(br)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		entry, err := readChunk(br)
+		if err != nil {
+			if err == io.EOF {
+				err = errHandoffFormat
+			}
+			return n, err
+		}
+		c.Set(string(key), entry)
+		n++
+	}
+}
+
+// ExportHandler returns an http.Handler that streams every entry in c in the
+// format read by ImportFrom, e.g. to let a new instance warm its cache from
+// the one it replaces
+func ExportHandler(c ListableCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		WriteEntries(w, c)
+	})
+}
+
+// ImportFrom fetches the entries exported by an ExportHandler at url and
+// stores them in c. It returns the number of entries stored.
+func ImportFrom(url string, c Cache) (int, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("httpcache: cache export returned %s", resp.Status)
+	}
+	return ReadEntries(resp.Body, c)
+}
+
+func writeChunk(w *bufio.Writer, b []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	if _, err := w.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(b)))]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readChunk(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errHandoffFormat
+		}
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,57 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportFrom(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	old := NewMemoryCache(time.Hour)
+	for _, path := range []string{"/a", "/b"} {
+		mustGet(t, NewTransport(old), origin.URL+path)
+	}
+	export := httptest.NewServer(ExportHandler(old))
+	defer export.Close()
+
+	c := NewMemoryCache(time.Hour)
+	if n, err := ImportFrom(export.URL, c); err != nil || n != 2 {
+		t.Fatalf("ImportFrom() = %d, %v, want the 2 entries", n, err)
+	}
+	if !reflect.DeepEqual(c.Keys(), old.Keys()) {
+		t.Errorf("imported %q, want %q", c.Keys(), old.Keys())
+	}
+	tr := NewTransport(c)
+	for _, path := range []string{"/a", "/b"} {
+		if resp, body := mustGet(t, tr, origin.URL+path); body != "body" || resp.Header.Get(XFromCache) != "1" {
+			t.Errorf("%s: got %q, from cache %q, want the imported entry", path, body, resp.Header.Get(XFromCache))
+		}
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	if _, err := ImportFrom(notFound.URL, c); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("ImportFrom a 404 = %v, want an error", err)
+	}
+}
+
+func TestReadEntriesTruncated(t *testing.T) {
+	old := NewMemoryCache(time.Hour)
+	old.Set("a", []byte("1"))
+	old.Set("b", []byte("2"))
+	var buf bytes.Buffer
+	if err := WriteEntries(&buf, old); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewMemoryCache(time.Hour)
+	n, err := ReadEntries(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), c)
+	if n != 1 || err != errHandoffFormat {
+		t.Errorf("ReadEntries() = %d, %v, want 1, %v", n, err, errHandoffFormat)
+	}
+}
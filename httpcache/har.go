@@ -0,0 +1,420 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The HAR archives written by WriteHAR follow the HAR 1.2 format, see
+// http://www.softwareishard.com/blog/har-12-spec/, with custom fields
+// recording what an import needs to restore the cache exactly: the key and
+// expiry of each entry, and the request headers the responses of a key vary
+// on.
+type harArchive struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+	// Vary maps the keys whose responses vary to the request headers they
+	// vary on
+	Vary map[string][]string `json:"_vary,omitempty"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	// Key is the key the response is stored at, and Expires when it stops
+	// being fresh, if it has explicit freshness
+	Key     string     `json:"_key,omitempty"`
+	Expires *time.Time `json:"_expires,omitempty"`
+}
+
+type harRequest struct {
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	HTTPVersion string    `json:"httpVersion"`
+	Cookies     []harPair `json:"cookies"`
+	Headers     []harPair `json:"headers"`
+	QueryString []harPair `json:"queryString"`
+	HeadersSize int64     `json:"headersSize"`
+	BodySize    int64     `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Cookies     []harPair  `json:"cookies"`
+	Headers     []harPair  `json:"headers"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int64      `json:"headersSize"`
+	BodySize    int64      `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+var errHARNoURL = &Error{ErrSerialize, errors.New("HAR entry without an absolute request URL")}
+
+// WriteHAR writes every response stored in c to w as a HAR archive, e.g. to
+// snapshot an API's responses as fixtures for integration tests, to be
+// imported with ReadHAR or Transport.ImportHAR. Entries that expire while
+// being listed, or can't be read, are skipped.
+//
+// Caches don't record requests, so the method and URL of each entry's
+// request are derived from its key, as built by the default key options,
+// with the https scheme if the key has none; the key itself is recorded so
+// that imports restore the entry as it was. Bodies are written as stored,
+// still compressed if they were, base64-encoded unless they are valid UTF-8.
+func WriteHAR(w io.Writer, c ListableCache) error {
+	archive := harArchive{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "apiproxy", Version: "1"},
+		Entries: []harEntry{},
+	}}
+	keys := c.Keys()
+	// sorted, so that archives of the same responses are identical
+	sort.Strings(keys)
+	for _, key := range keys {
+		b, ok := c.Get(key)
+		if !ok {
+			continue
+		}
+		e, err := decodeEntry(b)
+		if err != nil {
+			continue
+		}
+		if e.vary != nil {
+			if archive.Log.Vary == nil {
+				archive.Log.Vary = make(map[string][]string)
+			}
+			archive.Log.Vary[key] = e.vary
+			continue
+		}
+		he, err := harEntryOf(key, e)
+		if err != nil {
+			continue
+		}
+		archive.Log.Entries = append(archive.Log.Entries, he)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(archive)
+}
+
+// harEntryOf returns the HAR entry describing e, stored at key
+func harEntryOf(key string, e *entry) (harEntry, error) {
+	resp, err := bytesToResp(e.resp, nil)
+	if err != nil {
+		return harEntry{}, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return harEntry{}, err
+	}
+
+	method, rawurl := keyRequest(key)
+	req := harRequest{
+		Method:      method,
+		URL:         rawurl,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harPair{},
+		Headers:     []harPair{},
+		QueryString: []harPair{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if u, err := url.Parse(rawurl); err == nil {
+		req.QueryString = harPairs(u.Query())
+	}
+
+	content := harContent{Size: int64(len(body)), MimeType: resp.Header.Get("Content-Type")}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	he := harEntry{
+		StartedDateTime: e.storedAt,
+		Request:         req,
+		Response: harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+			HTTPVersion: resp.Proto,
+			Cookies:     []harPair{},
+			Headers:     harPairs(resp.Header),
+			Content:     content,
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    int64(len(body)),
+		},
+		Key: key,
+	}
+	if he.StartedDateTime.IsZero() {
+		he.StartedDateTime = time.Now()
+	}
+	if !e.expires.IsZero() {
+		expires := e.expires
+		he.Expires = &expires
+	}
+	return he, nil
+}
+
+// keyRequest returns the method and URL of the request a key built by the
+// default key options was derived from
+func keyRequest(key string) (method, rawurl string) {
+	k := key
+	if strings.HasPrefix(k, "ns=") {
+		if i := strings.IndexByte(k, ' '); i >= 0 {
+			k = k[i+1:]
+		}
+	}
+	method = "GET"
+	if i := strings.IndexByte(k, ' '); i > 0 && !strings.HasPrefix(k, "//") && !strings.Contains(k[:i], "://") {
+		method, k = k[:i], k[i+1:]
+	}
+	if i := strings.IndexByte(k, ' '); i >= 0 {
+		k = k[:i]
+	}
+	if strings.HasPrefix(k, "//") {
+		k = "https:" + k
+	}
+	return method, k
+}
+
+// harPairs returns the headers or query parameters h as HAR name and value
+// pairs, sorted by name
+func harPairs(h map[string][]string) []harPair {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := []harPair{}
+	for _, name := range names {
+		for _, v := range h[name] {
+			pairs = append(pairs, harPair{name, v})
+		}
+	}
+	return pairs
+}
+
+// readHAR decodes the HAR archive read from r
+func readHAR(r io.Reader) (*harArchive, error) {
+	var archive harArchive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, &Error{ErrSerialize, err}
+	}
+	return &archive, nil
+}
+
+// response returns the response recorded in he. Bodies of
+// entries not written by WriteHAR are decoded, as HAR requires, so their
+// Content-Encoding is dropped.
+func (he *harEntry) response() (*http.Response, error) {
+	body := []byte(he.Response.Content.Text)
+	if he.Response.Content.Encoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(he.Response.Content.Text); err != nil {
+			return nil, &Error{ErrSerialize, err}
+		}
+	}
+	resp := &http.Response{
+		Status:     strconv.Itoa(he.Response.Status) + " " + he.Response.StatusText,
+		StatusCode: he.Response.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+	}
+	if he.Response.StatusText == "" {
+		resp.Status = strconv.Itoa(he.Response.Status) + " " + http.StatusText(he.Response.Status)
+	}
+	for _, h := range he.Response.Headers {
+		resp.Header.Add(h.Name, h.Value)
+	}
+	resp.Header.Del("Transfer-Encoding")
+	if he.Key == "" {
+		resp.Header.Del("Content-Encoding")
+	}
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// request returns the request recorded in he
+func (he *harEntry) request() (*http.Request, error) {
+	u, err := url.Parse(he.Request.URL)
+	if err != nil || u.Host == "" {
+		return nil, errHARNoURL
+	}
+	method := he.Request.Method
+	if method == "" {
+		method = "GET"
+	}
+	req := &http.Request{Method: method, URL: u, Host: u.Host, Header: make(http.Header)}
+	for _, h := range he.Request.Headers {
+		if strings.HasPrefix(h.Name, ":") {
+			// HTTP/2 pseudo-headers
+			continue
+		}
+		req.Header.Add(h.Name, h.Value)
+	}
+	return req, nil
+}
+
+// ReadHAR stores in c the responses of the HAR archive written by WriteHAR to
+// r, at the keys and with the freshness they had, and returns the number of
+// responses stored. Entries of archives recorded by other tools carry no key
+// and are skipped; import those with Transport.ImportHAR.
+func ReadHAR(r io.Reader, c Cache) (int, error) {
+	archive, err := readHAR(r)
+	if err != nil {
+		return 0, err
+	}
+	for key, names := range archive.Log.Vary {
+		c.Set(key, (&entry{vary: names}).encode())
+	}
+	n := 0
+	for i := range archive.Log.Entries {
+		he := &archive.Log.Entries[i]
+		if he.Key == "" {
+			continue
+		}
+		b, err := he.encode()
+		if err != nil {
+			return n, err
+		}
+		c.Set(he.Key, b)
+		n++
+	}
+	return n, nil
+}
+
+// encode returns the cache entry recorded in he
+func (he *harEntry) encode() ([]byte, error) {
+	resp, err := he.response()
+	if err != nil {
+		return nil, err
+	}
+	b, err := dumpResponse(resp)
+	if err != nil {
+		return nil, &Error{ErrSerialize, err}
+	}
+	e := &entry{storedAt: he.StartedDateTime, resp: b}
+	if he.Expires != nil {
+		e.expires = *he.Expires
+	}
+	return e.encode(), nil
+}
+
+// ImportHAR stores the responses of the HAR archive read from r in t's
+// Cache, and returns the number of responses stored. Entries written by
+// WriteHAR are restored as they were; those recorded by other tools, e.g. a
+// browser, are keyed as t keys their request and stored as new, whatever
+// their status, with the freshness their headers and t's options give them.
+// Entries whose request can't be keyed are skipped.
+func (t *Transport) ImportHAR(r io.Reader) (int, error) {
+	archive, err := readHAR(r)
+	if err != nil {
+		return 0, err
+	}
+	cache := ToCacheCtx(t.cache())
+	for key, names := range archive.Log.Vary {
+		if err := cache.Set(context.Background(), key, (&entry{vary: names}).encode()); err != nil {
+			return 0, &Error{ErrBackend, err}
+		}
+	}
+	n := 0
+	for i := range archive.Log.Entries {
+		he := &archive.Log.Entries[i]
+		if he.Key != "" {
+			b, err := he.encode()
+			if err != nil {
+				return n, err
+			}
+			if err := cache.Set(context.Background(), he.Key, b); err != nil {
+				return n, &Error{ErrBackend, err}
+			}
+			n++
+			continue
+		}
+
+		req, err := he.request()
+		if err != nil {
+			return n, err
+		}
+		key, ok := t.key(req)
+		if !ok {
+			continue
+		}
+		resp, err := he.response()
+		if err != nil {
+			return n, err
+		}
+		resp.Request = req
+		b, err := dumpResponse(resp)
+		if err != nil {
+			return n, &Error{ErrSerialize, err}
+		}
+		if err := t.store(cache, key, req, resp, 0, b); err != nil {
+			return n, &Error{ErrBackend, err}
+		}
+		n++
+	}
+	return n, nil
+}
+
+// HARHandler returns an http.Handler that serves every response stored in c
+// as a HAR archive, see WriteHAR
+func HARHandler(c ListableCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="cache.har"`)
+		WriteHAR(w, c)
+	})
+}
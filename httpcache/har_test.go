@@ -0,0 +1,108 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHARRoundTrip(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"id":1}`)
+		case "/bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte{0xff, 0x00, 0xfe})
+		case "/vary":
+			w.Header().Set("Vary", "Accept")
+			io.WriteString(w, r.Header.Get("Accept"))
+		}
+	})
+	type request struct {
+		path, accept string
+	}
+	requests := []request{{"/json", ""}, {"/bin", ""}, {"/vary", "text/plain"}, {"/vary", "text/html"}}
+	src := NewMemoryCache(time.Hour)
+	tr := NewTransport(src)
+	want := map[request]string{}
+	for _, r := range requests {
+		_, want[r] = mustGet(t, tr, origin.URL+r.path, "Accept", r.accept)
+	}
+	var har bytes.Buffer
+	if err := WriteHAR(&har, src); err != nil {
+		t.Fatalf("WriteHAR: %v", err)
+	}
+
+	imports := map[string]func(tr *Transport, r io.Reader) (int, error){
+		"ReadHAR":   func(tr *Transport, r io.Reader) (int, error) { return ReadHAR(r, tr.Cache) },
+		"ImportHAR": (*Transport).ImportHAR,
+	}
+	for name, read := range imports {
+		t.Run(name, func(t *testing.T) {
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.Transport = errTransport{}
+			n, err := read(tr, bytes.NewReader(har.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(requests) {
+				t.Errorf("imported %d responses, want %d", n, len(requests))
+			}
+			for _, r := range requests {
+				resp, body, err := get(t, tr, origin.URL+r.path, "Accept", r.accept)
+				if err != nil {
+					t.Errorf("%s (Accept %q): %v", r.path, r.accept, err)
+					continue
+				}
+				if body != want[r] || resp.Header.Get(XFromCache) != "1" {
+					t.Errorf("%s (Accept %q): got %q, %s %q, want %q from the cache", r.path, r.accept, body, XFromCache, resp.Header.Get(XFromCache), want[r])
+				}
+			}
+		})
+	}
+}
+
+// browserHAR is an archive recorded by a browser, with no keys
+const browserHAR = `{"log": {"version": "1.2", "creator": {"name": "browser", "version": "1"}, "entries": [
+	{
+		"startedDateTime": "2026-01-02T03:04:05Z",
+		"request": {"method": "GET", "url": "http://api.example.com/users?page=1", "headers": [{"name": ":authority", "value": "api.example.com"}]},
+		"response": {"status": 200, "statusText": "OK", "headers": [
+			{"name": "Cache-Control", "value": "max-age=3600"},
+			{"name": "Content-Encoding", "value": "gzip"}
+		], "content": {"mimeType": "application/json", "text": "W10=", "encoding": "base64"}}
+	}
+]}}`
+
+func TestImportHARForeign(t *testing.T) {
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.Transport = errTransport{}
+	if n, err := ReadHAR(strings.NewReader(browserHAR), tr.Cache); err != nil || n != 0 {
+		t.Errorf("ReadHAR = %d, %v, want the entry without a key skipped", n, err)
+	}
+	if n, err := tr.ImportHAR(strings.NewReader(browserHAR)); err != nil || n != 1 {
+		t.Fatalf("ImportHAR = %d, %v, want 1 response stored", n, err)
+	}
+	resp, body := mustGet(t, tr, "http://api.example.com/users?page=1")
+	if body != "[]" || resp.Header.Get(XFromCache) != "1" {
+		t.Errorf("got %q, %s %q, want the decoded body from the cache", body, XFromCache, resp.Header.Get(XFromCache))
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		t.Errorf("Content-Encoding = %q, want none for the decoded body", ce)
+	}
+
+	relative := strings.Replace(browserHAR, "http://api.example.com", "", 1)
+	if _, err := tr.ImportHAR(strings.NewReader(relative)); !errors.Is(err, ErrSerialize) {
+		t.Errorf("ImportHAR of a relative URL: error %v, want an ErrSerialize", err)
+	}
+	if _, err := tr.ImportHAR(strings.NewReader("{")); !errors.Is(err, ErrSerialize) {
+		t.Errorf("ImportHAR of malformed JSON: error %v, want an ErrSerialize", err)
+	}
+}
@@ -1,19 +1,29 @@
 // Package httpcache provides a http.RoundTripper implementation that works as a
 // mostly RFC-compliant cache for http responses.
 //
-// It is only suitable for use as a 'private' cache (i.e. for a web-browser or an API-client
-// and not for a shared proxy).
-//
+// By default it behaves as a 'private' cache (i.e. for a web-browser or an API-client).
+// Use NewSharedTransport for a cache shared between clients, such as a proxy.
 package httpcache
 
 import (
 	"bufio"
 	"bytes"
-	"fmt"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -30,6 +40,380 @@ type Transport struct {
 	Transport http.RoundTripper
 	Cache     Cache
 	mu        sync.RWMutex
+	// disabled is non-zero while caching is turned off by SetEnabled
+	disabled int32
+	// parsed holds recently served responses, see readEntry
+	parsedMu sync.RWMutex
+	parsed   map[string]*parsedResponse
+	stats    transportStats
+	flights  flightGroup
+	// refreshes holds the keys being revalidated in the background
+	refreshes flightGroup
+	bg        background
+	backoffs  backoffs
+	// refresher, if set, tracks the requests made, see NewRefresher
+	refresher *Refresher
+	circuits  circuits
+	// negatives counts the consecutive error responses of each key, see
+	// NegativeBackoffMax
+	negatives negatives
+
+	// Shared makes the transport behave as a cache shared between clients:
+	// responses to requests with an Authorization header are only stored and
+	// served if they are explicitly shareable (see SkipCacheWhenRequestHasCookie)
+	Shared bool
+
+	// SkipCacheWhenRequestHasCookie bypasses both the cache lookup and the
+	// cache store for requests that carry a Cookie header, since their
+	// responses are often personalized. Responses explicitly marked shareable
+	// by Cache-Control public, s-maxage or must-revalidate (and not no-store)
+	// are still stored and served.
+	SkipCacheWhenRequestHasCookie bool
+
+	// StrictSharedCaching makes a Shared transport store only responses that
+	// have explicit freshness information (max-age, s-maxage, Expires) or a
+	// validator (ETag, Last-Modified), rather than keeping every cacheable
+	// response for as long as the Cache allows
+	StrictSharedCaching bool
+
+	// IgnoreCacheControl forces caching of responses whatever their
+	// Cache-Control and Expires headers say: no-store and private don't
+	// prevent storing them, and they stay fresh for as long as the Cache
+	// keeps them. Responses to requests with credentials still need to be
+	// explicitly shareable to be stored by a shared Transport.
+	IgnoreCacheControl bool
+
+	// CacheableMethods lists the request methods that are cached, after
+	// MethodAliases are applied. If empty, GET and HEAD are cached. HEAD
+	// requests are answered from stored GET responses. Responses to other
+	// methods are keyed on the method and URL only, so list only methods
+	// whose responses don't depend on the request body, or use a KeyBuilder
+	// with KeyPartBodyHash.
+	CacheableMethods []string
+
+	// CacheableStatusCodes lists the response status codes that are stored.
+	// If empty, the status codes cacheable by default per RFC 7231 are
+	// stored, as are other responses with explicit freshness information or
+	// Cache-Control: public. 206 and 304 responses are never stored.
+	CacheableStatusCodes []int
+
+	// StatusTTLs maps response status codes to how long responses with them
+	// stay fresh at most, e.g. {404: time.Minute} to keep repeated lookups of
+	// missing resources from reaching the origin while letting them show up
+	// soon once created. Responses with these status codes are stored even
+	// if CacheableStatusCodes doesn't list them.
+	StatusTTLs map[int]time.Duration
+
+	// NegativeBackoffMax, if positive, lengthens the StatusTTLs of the error
+	// responses, those with a status of 400 or above, of keys that keep
+	// failing: each error response stored for a key in a row stays fresh
+	// twice as long as the previous one, with up to a tenth added at random,
+	// up to NegativeBackoffMax. A response with a lower status from the origin
+	// starts the key over.
+	NegativeBackoffMax time.Duration
+
+	// HeuristicFraction, if positive, gives responses without explicit
+	// freshness information a heuristic freshness lifetime, if they have a
+	// Last-Modified header and a status code cacheable by default: that
+	// fraction of the time since they were last modified, e.g. the 0.1
+	// suggested by RFC 7234, capped at MaxHeuristicLifetime (24h if unset).
+	// Other such responses stay fresh for as long as the Cache keeps them.
+	HeuristicFraction    float64
+	MaxHeuristicLifetime time.Duration
+
+	// FreshnessFunc, if set, decides how long the responses stored stay
+	// fresh from when they are stored, in place of their own freshness
+	// information and of HeuristicFraction, when it returns true, e.g. to
+	// honor a valid_until field in the JSON bodies of an origin that sends
+	// no Cache-Control. It is called with each response as it is stored, its
+	// body readable in full. A Policy TTL still prevails, and StatusTTLs
+	// still cap the lifetime.
+	FreshnessFunc func(req *http.Request, resp *http.Response) (time.Duration, bool)
+
+	// Cacheable, if set, decides what is cached in place of CacheableMethods
+	// and CacheableStatusCodes, e.g. to exclude specific paths. It is called
+	// with a nil response to decide whether req may be answered from the
+	// cache, then with the response to decide whether to store it and, if the
+	// duration is positive, for how long it stays fresh, overriding its own
+	// freshness information as Policy.TTL does. Responses that may never be
+	// stored, such as partial or private ones, are not passed to it. Unless a
+	// KeyFunc or KeyBuilder is set, requests with methods other than GET and
+	// HEAD are keyed on a hash of their body as well, so that POST-based
+	// search endpoints can be cached; those with bodies over 1MB are not.
+	Cacheable func(req *http.Request, resp *http.Response) (bool, time.Duration)
+
+	// MethodAliases maps request methods to the method they are treated as for
+	// caching purposes, e.g. {"POST": "GET"} lets reads made over POST share
+	// cache entries with GET. The request body is not part of the cache key,
+	// so only alias methods whose requests may safely be answered this way.
+	MethodAliases map[string]string
+
+	// KeyHeaders lists request headers whose values are made part of the
+	// cache key of every request, for responses known up front to differ by
+	// them (e.g. X-Tenant-Id)
+	KeyHeaders []string
+
+	// BucketFunc, if set, returns an experiment bucket for each request (e.g.
+	// derived from a cookie or header) which is made part of the cache key,
+	// so that each bucket gets its own cache entries
+	BucketFunc func(req *http.Request) string
+
+	// KeyPrefixFunc, if set, returns the namespace of each request, e.g. its
+	// tenant or a hash of its credentials, see KeyPrefixHeader and
+	// KeyPrefixCredentials. Every cache key, however derived, is prefixed
+	// with it, so that responses cached for one namespace are never served
+	// to another, and PurgeNamespace can remove them all. Requests in the
+	// empty namespace share their entries.
+	KeyPrefixFunc func(req *http.Request) string
+
+	// VaryAccept lists path regexps for which the request's Accept header,
+	// normalized, is made part of the cache key. Use it for content-negotiated
+	// resources whose origin doesn't send a usable Vary header.
+	VaryAccept []*regexp.Regexp
+
+	// CachePOST lists path regexps whose POST requests are cached, keyed on
+	// their URL and a hash of their body, for APIs such as GraphQL or search
+	// engines that send idempotent queries over POST. Requests with bodies
+	// over 1MB are not cached. Unlike other POSTs, successful ones to these
+	// paths don't invalidate the responses stored for their URL. A KeyFunc or
+	// KeyBuilder must key the body itself, e.g. with KeyPartBodyHash.
+	CachePOST []*regexp.Regexp
+
+	// VaryLanguage lists path regexps for which the client's preferred
+	// language, from its Accept-Language header, is made part of the cache
+	// key. LanguageGranularity controls how much of the language tag is used.
+	VaryLanguage        []*regexp.Regexp
+	LanguageGranularity LanguageGranularity
+
+	// KeyIncludeScheme makes the scheme the client used part of the cache key,
+	// isolating entries for http and https requests to the same resource. By
+	// default both schemes share entries.
+	KeyIncludeScheme bool
+
+	// KeyBuilder, if set, derives cache keys in place of the default
+	// derivation. KeyHeaders, BucketFunc, VaryAccept, VaryLanguage and
+	// KeyIncludeScheme don't apply to the keys it builds, and MethodAliases
+	// only affects which requests are cacheable.
+	KeyBuilder KeyBuilder
+
+	// KeyFunc, if set, derives cache keys in place of both the default
+	// derivation and KeyBuilder, e.g. to drop volatile query parameters. It
+	// must be deterministic: requests that should share a stored response
+	// must get the same key. Requests for which it returns "" are not cached.
+	KeyFunc func(req *http.Request) string
+
+	// Policies, if set, picks a Policy overriding the settings above for
+	// some requests, e.g. to give each endpoint of an API its own TTL
+	Policies PolicyMatcher
+
+	// KeyObserver, if set, is called with each request and the cache key
+	// derived for it, e.g. to log keys while debugging hit rates. It does not
+	// influence the key.
+	KeyObserver func(req *http.Request, key string)
+
+	// SampleRate, if between 0 and 1, is the fraction of cacheable requests
+	// that take part in caching (both lookup and store); the others are
+	// passed straight to the underlying transport. It allows caching of a
+	// risky endpoint to be ramped up gradually. Requests are sampled at
+	// random, or by their cache key if SampleByKey is set, so that a given
+	// resource is either always or never cached.
+	SampleRate  float64
+	SampleByKey bool
+
+	// Admitter, if set, is consulted before storing each response and may
+	// prevent it from being stored, e.g. a FrequencyAdmitter
+	Admitter Admitter
+
+	// RewriteDateOnServe sets the Date header of responses served from the
+	// cache to the time they are served, for clients that mishandle old Dates.
+	// Stored entries keep their original Date.
+	RewriteDateOnServe bool
+
+	// DecodeBeforeStore keeps a single decoded copy of each response, rather
+	// than one per Content-Encoding the origin picks from the client's
+	// Accept-Encoding. Cacheable requests are sent asking for gzip only, and
+	// gzip-encoded responses are decoded, and no longer vary on
+	// Accept-Encoding. Set CompressOnServe too to gzip them again for the
+	// clients that accept it.
+	DecodeBeforeStore bool
+
+	// CompressOnServe gzips responses served from the cache that have no
+	// Content-Encoding when the client accepts gzip, independently of how the
+	// entry is stored
+	CompressOnServe bool
+
+	// CoalesceMisses makes concurrent requests that miss the cache for the
+	// same key wait for the first of them, so that only one request is sent
+	// to the origin. Once it completes the others are answered from the
+	// cache, or sent to the origin themselves if its response couldn't be
+	// stored.
+	CoalesceMisses bool
+
+	// StaleWhileRevalidate is how long responses without a
+	// stale-while-revalidate directive of their own may still be served once
+	// stale, while they are revalidated in the background. It doesn't apply
+	// to responses with no-cache or must-revalidate, or in a shared cache
+	// proxy-revalidate or s-maxage.
+	StaleWhileRevalidate time.Duration
+
+	// Workers, if set, runs the background work of the Transport: background
+	// revalidations, which are skipped while every worker is busy, and the
+	// fetches of its Refresher and of Warm, which wait for a free worker. If
+	// nil, each runs in a goroutine of its own.
+	Workers *WorkerPool
+
+	// ServeStaleOnError serves a stale stored response, rather than the
+	// failure, when the origin can't be reached or answers with a 5xx error,
+	// as if every response had an unbounded stale-if-error directive.
+	// Responses with must-revalidate, or in a shared cache proxy-revalidate
+	// or s-maxage, are still never served stale.
+	ServeStaleOnError bool
+
+	// MaxStaleIfError, if positive, bounds how long stored responses may be
+	// stale to be served in place of an origin failure, whatever their
+	// stale-if-error directives or ServeStaleOnError allow. Past it, the
+	// failure is returned.
+	MaxStaleIfError time.Duration
+
+	// MaxStaleness, if positive, is how long stored responses may be stale
+	// and still be served without first being revalidated, whatever
+	// stale-while-revalidate, the max-stale of requests or stale-if-error
+	// allow, e.g. so that a long-failing origin doesn't get unacceptably old
+	// data served. Past it, requests wait for the origin, and get its failure
+	// if it fails. Offline transports ignore it.
+	MaxStaleness time.Duration
+
+	// MustRevalidateFailure, if set, returns the response to req when the
+	// stored response it would get must be revalidated, having
+	// must-revalidate, or in a shared cache proxy-revalidate or s-maxage,
+	// and the origin can't be reached, err telling why. The transport answers
+	// with 504 Gateway Timeout if it is nil, see
+	// https://tools.ietf.org/html/rfc7234#section-5.2.2.1
+	MustRevalidateFailure func(req *http.Request, err error) *http.Response
+
+	// Offline never contacts the origin: requests are answered with any
+	// stored response, fresh or not, and with 504 Gateway Timeout otherwise,
+	// as if they had an only-if-cached directive and accepted any staleness,
+	// e.g. for demos or to ride out an outage deliberately. OfflineHeader, if
+	// set, names a request header putting the requests that carry it offline.
+	Offline       bool
+	OfflineHeader string
+
+	// Retries is how many times GET and HEAD requests are sent again after
+	// failing with a network error or a 502, 503 or 504 response. The first
+	// retry waits RetryBackoff, 100ms if unset, and each one after it twice as
+	// long as the previous one, with up to half as long again added at random.
+	// Retries stop once the next one would end past RetryBudget, if set, from
+	// the first attempt, or past the request's context deadline.
+	Retries      int
+	RetryBackoff time.Duration
+	RetryBudget  time.Duration
+
+	// HonorRetryAfter makes the transport back off from a host that answers
+	// with 429 Too Many Requests, or 503 Service Unavailable, and a
+	// Retry-After header: until the time it gives, requests for the host that
+	// can't be answered from the cache are not sent, and get a stale stored
+	// response if there is one, or the same status otherwise. The origin's
+	// answer is itself replaced by a stale stored response if there is one.
+	// Stale responses are not served past MaxStaleIfError or MaxStaleness,
+	// nor if they must be revalidated, which get the MustRevalidateFailure
+	// response instead.
+	HonorRetryAfter bool
+
+	// CircuitThreshold, if positive, opens a circuit breaker for a host after
+	// that many consecutive requests to it fail with a network error or a 5xx
+	// response. For CircuitCooldown, 10s if unset, requests for the host that
+	// can't be answered from the cache are not sent, and get a stale stored
+	// response if there is one, or the response returned by
+	// CircuitOpenResponse, a 502 Bad Gateway if it is nil. Stale responses
+	// are not served past MaxStaleIfError or MaxStaleness, nor if they must be
+	// revalidated, which get the MustRevalidateFailure response instead. Then
+	// a single request is let through to probe the host: the circuit closes
+	// if it succeeds, and opens again otherwise.
+	CircuitThreshold    int
+	CircuitCooldown     time.Duration
+	CircuitOpenResponse func(req *http.Request) *http.Response
+
+	// MaxBodyBytes, when positive, is the largest response body that is
+	// stored. Responses with a larger Content-Length, or whose body turns out
+	// larger as it is read, are passed on to the client as they arrive
+	// instead of being buffered in memory.
+	MaxBodyBytes int64
+
+	// CacheableContentTypes, if set, restricts the responses stored to those
+	// whose media type is listed, compared case-insensitively and without
+	// parameters, e.g. "application/json". An entry "type/*" matches all the
+	// subtypes of type. Responses without a Content-Type are only stored if
+	// they have no body.
+	CacheableContentTypes []string
+
+	// StreamStores passes responses on to the client as they arrive and
+	// stores them once the client has read them in full, rather than reading
+	// them in before returning them. Responses the client stops reading
+	// early, or that turn out larger than MaxBodyBytes, are not stored,
+	// though they are reported as stored. Responses that need their full body
+	// up front, to be compressed by CompressOnServe, given an ETag by
+	// GenerateETag or to answer the client's own preconditions, are still
+	// read in first. With CoalesceMisses, waiting requests are released once
+	// the response is stored.
+	StreamStores bool
+
+	// StripHeadersBeforeCache lists headers, such as X-Request-Id, that are
+	// removed from responses before they are stored. The response passed on
+	// when it is first received keeps them.
+	StripHeadersBeforeCache []string
+
+	// GenerateETag gives stored responses that have no ETag a strong one
+	// derived from their body, so that clients can revalidate them with
+	// If-None-Match even if the origin sends no validators
+	GenerateETag bool
+
+	// CacheStatusID, when set, makes the transport add an RFC 9211
+	// Cache-Status header naming this cache to every response it returns
+	CacheStatusID string
+	// CacheStatusKey adds the cache key to the Cache-Status header
+	CacheStatusKey bool
+
+	// NotCachedHeader, when set, names a header the transport sets on
+	// responses it did not store to the reason why, one of the NotCached
+	// constants. The reason is also recorded in RequestInfo.NotCached.
+	NotCachedHeader string
+
+	// CacheHeader, when set, names a header, such as X-Cache, the transport
+	// sets on every response it returns to HIT, MISS, STALE, REVALIDATED or
+	// BYPASS, after RequestInfo.Status. CacheKeyHeader, when set, names one
+	// set to the key of cacheable requests' responses, such as X-Cache-Key.
+	CacheHeader    string
+	CacheKeyHeader string
+	// SuppressAproxyHeaders removes the X-Aproxy-From-Cache and
+	// X-Aproxy-Cacheable headers from the responses returned, e.g. once
+	// CacheHeader reports the same in production.
+	SuppressAproxyHeaders bool
+
+	// OnSet, if set, is called after each response is stored with its key and
+	// the size of the entry passed to the Cache, e.g. to export metrics
+	OnSet func(key string, size int)
+
+	// OnRequest, if set, is called as each request completes with what the
+	// transport did with it, e.g. to log the cache status, key and backend
+	// latency of every request as structured fields. It is called before the
+	// response body is read.
+	OnRequest func(req *http.Request, info RequestInfo)
+
+	// Hasher computes the checksums the entries stored are verified with as
+	// they are read, CRC32Hasher if nil. Entries are verified with the Hasher
+	// they were stored with, which must be registered with RegisterHasher
+	// unless it is one of this package's.
+	Hasher Hasher
+
+	// Tracer, if set, is told about the stages of each request: the lookup of
+	// a stored response, the request sent upstream and the storing of its
+	// response
+	Tracer Tracer
+
+	// Logger receives the transport's diagnostic messages. If nil, they are
+	// discarded.
+	Logger Logger
 }
 
 // NewTransport returns a new Transport with the
@@ -40,20 +424,88 @@ func NewTransport(c Cache) *Transport {
 	}
 }
 
-// lookup returns the cached http.Response for a given key, if present and valid
-func (t *Transport) lookup(req *http.Request) *http.Response {
-	cachedVal, ok := t.Cache.Get(cacheKey(req))
+// NewSharedTransport returns a new Transport with the provided Cache
+// implementation and defaults suitable for a cache shared between clients
+func NewSharedTransport(c Cache) *Transport {
+	return &Transport{
+		Cache:                         c,
+		Shared:                        true,
+		SkipCacheWhenRequestHasCookie: true,
+	}
+}
+
+// lookup returns the cached http.Response for a given key, if present and
+// valid, whether it is still fresh and for how long it has been stale, which
+// is negative while it is fresh and zero if it has no expiry. For responses
+// that vary on request
+// headers, the variant matching req is returned. Entries that can't be read,
+// e.g. because they were truncated, are removed and reported as a miss.
+func (t *Transport) lookup(cache CacheCtx, key string, req *http.Request) (*http.Response, bool, time.Duration) {
+	ctx := req.Context()
+	e := t.getEntry(ctx, cache, key)
+	variant := e != nil && e.vary != nil
+	if variant {
+		key = varyKey(key, req, e.vary)
+		e = t.getEntry(ctx, cache, key)
+	}
+	if e == nil || e.vary != nil {
+		return nil, false, 0
+	}
+
+	resp, err := t.readEntry(key, e.resp, req)
+	if err != nil {
+		t.logger().Errorf("%s: %s", key, &Error{ErrSerialize, err})
+		t.cacheDelete(ctx, cache, key)
+		return nil, false, 0
+	}
+	if names, ok := varyHeaders(resp); !variant && (!ok || len(names) > 0) {
+		// stored before variants were kept apart, so it may not match req
+		resp.Body.Close()
+		return nil, false, 0
+	}
+
+	now := time.Now()
+	if !e.storedAt.IsZero() {
+		// the age it arrived with plus the time spent in the cache, see
+		// https://tools.ietf.org/html/rfc7234#section-4.2.3
+		age := initialAge(resp, e.storedAt) + now.Sub(e.storedAt)
+		resp.Header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+		requestInfo(ctx).Age = age
+	}
+
+	resp.Header.Set(XFromCache, "1")
+	if e.expires.IsZero() {
+		return resp, true, 0
+	}
+	return resp, e.fresh(now), now.Sub(e.expires)
+}
+
+// getEntry returns the entry stored at key, or nil if there is none, the
+// cache failed or it can't be decoded, in which case it is removed
+func (t *Transport) getEntry(ctx context.Context, cache CacheCtx, key string) *entry {
+	cachedVal, ok, err := cache.Get(ctx, key)
+	if err != nil {
+		t.logger().Errorf("%s: %s", key, &Error{ErrBackend, err})
+		return nil
+	}
 	if !ok {
 		return nil
 	}
 
-	resp, err := bytesToResp(cachedVal, req)
+	e, err := decodeEntry(cachedVal)
 	if err != nil {
-		panic(err)
+		t.logger().Errorf("%s: %s", key, err)
+		t.cacheDelete(ctx, cache, key)
+		return nil
 	}
+	return e
+}
 
-	resp.Header.Set(XFromCache, "1")
-	return resp
+// cacheDelete removes key from cache, logging failures
+func (t *Transport) cacheDelete(ctx context.Context, cache CacheCtx, key string) {
+	if err := cache.Delete(ctx, key); err != nil {
+		t.logger().Errorf("%s: %s", key, &Error{ErrBackend, err})
+	}
 }
 
 func bytesToResp(b []byte, req *http.Request) (resp *http.Response, err error) {
@@ -61,6 +513,161 @@ func bytesToResp(b []byte, req *http.Request) (resp *http.Response, err error) {
 	return http.ReadResponse(reader, req)
 }
 
+// dumpResponse returns the wire representation of resp, including its body.
+//
+// The body is buffered first and resp.Body is always left readable from the
+// start, so the client receives the complete response even if it can't be
+// serialized. Responses delimited by the connection closing (e.g. from HTTP/1.0
+// origins that send neither Content-Length nor chunked encoding) are stored
+// with a materialized Content-Length.
+func dumpResponse(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		// replay what was read, then the rest of the original body (and its error)
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil, err
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if resp.ContentLength < 0 && resp.Request != nil && resp.Request.Method != "HEAD" {
+		resp.ContentLength = int64(len(body))
+		resp.TransferEncoding = nil
+	}
+
+	b, err := httputil.DumpResponse(resp, true)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return b, err
+}
+
+var errBodyTooLarge = errors.New("response body exceeds MaxBodyBytes")
+
+// bufferBody reads the body of resp into memory, unless it is larger than max
+// bytes, in which case it returns errBodyTooLarge without reading more than
+// max+1 bytes. Whatever happens, resp.Body still yields the whole body.
+func bufferBody(resp *http.Response, max int64) error {
+	if resp.ContentLength > max {
+		return errBodyTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err == nil && int64(len(body)) > max {
+		err = errBodyTooLarge
+	}
+	if err != nil {
+		// replay what was read, then the rest of the original body
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return err
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// takeHeaders removes the headers named in names from h and returns them
+func takeHeaders(h http.Header, names []string) http.Header {
+	taken := make(http.Header)
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if v, ok := h[name]; ok {
+			taken[name] = v
+			delete(h, name)
+		}
+	}
+	return taken
+}
+
+// copyHeaders sets every header in src on dst
+func copyHeaders(dst, src http.Header) {
+	for name, v := range src {
+		dst[name] = v
+	}
+}
+
+// readCloser pairs a Reader with the Closer of the body it replaces
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// SetCache replaces the Cache used by the transport, e.g. to migrate to a
+// different backend or to flush the cache by swapping in an empty one.
+// Requests in flight finish with the Cache they started with. Use SetCache
+// rather than assigning the Cache field once the transport is in use.
+func (t *Transport) SetCache(c Cache) {
+	t.mu.Lock()
+	t.Cache = c
+	t.mu.Unlock()
+}
+
+// serveCached prepares the response resp, found in the cache at key, to be
+// returned for req
+func (t *Transport) serveCached(req *http.Request, resp *http.Response, key string) *http.Response {
+	resp.Header.Set(XCacheable, "1")
+	if clientNotModified(req, resp) {
+		resp = notModified(req, resp)
+	}
+	if t.RewriteDateOnServe {
+		resp.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if t.CompressOnServe {
+		gzipResponse(req, resp)
+	}
+	t.setCacheStatus(resp, key, "", false)
+	t.logger().Debugf("[from-cache] %s", req.URL)
+	return resp
+}
+
+// logger returns the Logger to use, discarding messages if none is set
+func (t *Transport) logger() Logger {
+	if t.Logger == nil {
+		return nopLogger{}
+	}
+	return t.Logger
+}
+
+// cache returns the Cache currently in use
+func (t *Transport) cache() Cache {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.Cache
+}
+
+// SetTransport replaces the underlying RoundTripper used to make requests. Use
+// SetTransport rather than assigning the Transport field once the transport is
+// in use.
+func (t *Transport) SetTransport(rt http.RoundTripper) {
+	t.mu.Lock()
+	t.Transport = rt
+	t.mu.Unlock()
+}
+
+// transport returns the underlying RoundTripper currently in use
+func (t *Transport) transport() http.RoundTripper {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// SetEnabled turns caching on or off at runtime, e.g. during an incident.
+// While it is off, every request is passed straight to the underlying
+// transport and nothing is looked up or stored, but stored entries are kept
+// for when it is turned back on.
+func (t *Transport) SetEnabled(enabled bool) {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+	atomic.StoreInt32(&t.disabled, disabled)
+}
+
+// Enabled returns false if caching has been turned off by SetEnabled
+func (t *Transport) Enabled() bool {
+	return atomic.LoadInt32(&t.disabled) == 0
+}
+
 // Client returns an *http.Client that caches responses.
 func (t *Transport) Client() *http.Client {
 	return &http.Client{Transport: t}
@@ -71,45 +678,510 @@ func (t *Transport) Client() *http.Client {
 // If there is a fresh Response already in cache, then it will be returned without connecting to
 // the server.
 func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	cacheable := (req.Method == "GET" || req.Method == "HEAD") && req.Header.Get("range") == ""
+	if t.OnRequest == nil && t.Tracer == nil && !t.setsInfoHeaders() {
+		return t.roundTrip(req, t.CoalesceMisses)
+	}
+
+	info, ok := req.Context().Value(requestInfoKey{}).(*RequestInfo)
+	if !ok || info == nil {
+		info = &RequestInfo{}
+		req = req.WithContext(WithRequestInfo(req.Context(), info))
+	}
+	req, end := t.trace(req, StageRequest)
+	resp, err = t.roundTrip(req, t.CoalesceMisses)
+	if resp != nil {
+		t.setInfoHeaders(resp, info)
+	}
+	end(resp, err)
+	if t.OnRequest != nil {
+		t.OnRequest(req, *info)
+	}
+	return resp, err
+}
+
+// offline returns true if req must be answered without contacting the origin,
+// see Offline
+func (t *Transport) offline(req *http.Request) bool {
+	return t.Offline || t.OfflineHeader != "" && req.Header.Get(t.OfflineHeader) != ""
+}
 
+// setsInfoHeaders returns true if the headers set by setInfoHeaders are
+// configured
+func (t *Transport) setsInfoHeaders() bool {
+	return t.CacheHeader != "" || t.CacheKeyHeader != "" || t.SuppressAproxyHeaders
+}
+
+// setInfoHeaders sets the CacheHeader and CacheKeyHeader of resp from info,
+// and removes its X-Aproxy headers if SuppressAproxyHeaders is set
+func (t *Transport) setInfoHeaders(resp *http.Response, info *RequestInfo) {
+	if t.CacheHeader != "" && info.Status != "" {
+		resp.Header.Set(t.CacheHeader, strings.ToUpper(info.Status))
+	}
+	if t.CacheKeyHeader != "" && info.Key != "" {
+		resp.Header.Set(t.CacheKeyHeader, info.Key)
+	}
+	if t.SuppressAproxyHeaders {
+		resp.Header.Del(XFromCache)
+		resp.Header.Del(XCacheable)
+	}
+}
+
+// roundTrip implements RoundTrip, coalescing the request with concurrent misses
+// for the same key if coalesce is true
+func (t *Transport) roundTrip(req *http.Request, coalesce bool) (resp *http.Response, err error) {
+	if err := req.Context().Err(); err != nil {
+		// the client is gone, don't bother with the cache or the origin
+		return nil, &Error{ErrUpstream, err}
+	}
+
+	cache := ToCacheCtx(t.cache())
+	if req.Header.Get("Range") != "" {
+		if resp, ok := t.serveRange(cache, req); ok {
+			return resp, nil
+		}
+	}
+	notCached := t.requestNotCacheable(req)
+	info := requestInfo(req.Context())
+	info.Status = StatusBypass
+	defer func() {
+		if info.Status == StatusMiss || info.Status == StatusBypass {
+			// looked up, but answered by the origin
+			info.Age = 0
+		}
+	}()
+
+	var key string
+	cacheable := notCached == ""
 	if cacheable {
-		resp = t.lookup(req)
+		if key, cacheable = t.key(req); !cacheable {
+			notCached = NotCachedNoKey
+		}
+	}
+	if cacheable && !t.sampled(key) {
+		cacheable = false
+		notCached = NotCachedSampling
+	}
+
+	fwd := fwdURIMiss
+	if !cacheable {
+		fwd = t.fwdReason(req)
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	offline := t.offline(req)
+	var stale *http.Response
+	var staleFor time.Duration
+	var staleAge string
+	if cacheable {
+		if t.KeyObserver != nil {
+			t.KeyObserver(req, key)
+		}
+		info.Status = StatusMiss
+		info.Key = key
+		if r := t.getRefresher(); r != nil && !prefetching(req) {
+			r.observe(req, key)
+		}
+		var fresh bool
+		lreq, end := t.trace(req, StageLookup)
+		resp, fresh, staleFor = t.lookup(cache, key, lreq)
+		end(resp, nil)
 		if resp != nil {
-			fmt.Printf("[from-cache] %s\n", req.URL)
-			return
+			resp.Request = req
+		}
+		if requestNoCache(req, reqCC) {
+			fwd = fwdRequest
+		}
+		constrained, servedStale := false, false
+		if resp != nil && !prefetching(req) {
+			// the client may demand a fresher response, or accept a staler one
+			fresh, constrained = t.requestFreshness(req, reqCC, resp, fresh, staleFor)
+			if fresh && constrained {
+				resp.Header.Add("Warning", `110 - "Response is Stale"`)
+				servedStale = true
+			} else if constrained {
+				fwd = fwdRequest
+			}
+		}
+		if prefetching(req) {
+			// revalidate it ahead of its expiry
+			fresh = false
+		}
+		if resp != nil && t.restricted(req) && !explicitlyShareable(resp.Header) {
+			resp.Body.Close()
+			resp = nil
+		}
+		if resp != nil && !fresh && offline && !prefetching(req) {
+			// serve it as is, the origin is out of reach
+			resp.Header.Add("Warning", `110 - "Response is Stale"`)
+			fresh, servedStale = true, true
+		}
+		if resp != nil && !fresh && !constrained && !refreshing(req) && !prefetching(req) && t.staleWhileRevalidate(resp, staleFor) {
+			// serve it as is and bring it up to date in the background
+			t.refresh(req, key)
+			resp.Header.Add("Warning", `110 - "Response is Stale"`)
+			fresh, servedStale = true, true
+		}
+		if resp != nil && !fresh {
+			// stored, but must be revalidated with the origin before it's used
+			stale = resp
+			// once revalidated, its age is that of the 304 confirming it
+			staleAge = stale.Header.Get("Age")
+			stale.Header.Del("Age")
+			stale.Header.Del(XFromCache)
+			resp = nil
+			if fwd != fwdRequest {
+				fwd = fwdStale
+			}
+		}
+		if resp != nil {
+			info.Status = StatusHit
+			if servedStale {
+				info.Status = StatusStale
+			}
+			atomic.AddUint64(&t.stats.hits, 1)
+			return t.serveCached(req, resp, key), nil
+		}
+	}
+	if reqCC.has("only-if-cached") || offline {
+		// the client won't wait for the origin, or it may not be contacted
+		if stale != nil {
+			stale.Body.Close()
+		}
+		return onlyIfCached(req), nil
+	}
+
+	var release func()
+	if cacheable && coalesce && fwd != fwdRequest {
+		done, leader := t.flights.join(key)
+		if !leader {
+			// wait for the request in flight and look again: its response is
+			// stored by now if it could be, otherwise go to the origin alone
+			if stale != nil {
+				stale.Body.Close()
+			}
+			atomic.AddUint64(&t.stats.coalesced, 1)
+			info.Coalesced = true
+			select {
+			case <-done:
+			case <-req.Context().Done():
+				return nil, &Error{ErrUpstream, req.Context().Err()}
+			}
+			return t.roundTrip(req, false)
+		}
+		// released once the response is stored, which may be after roundTrip
+		// returns if it is streamed to the cache
+		release = func() { t.flights.leave(key) }
+		defer func() {
+			if release != nil {
+				release()
+			}
+		}()
+	}
+
+	transport := t.transport()
+
+	outreq := req
+	if cacheable {
+		// the client's own preconditions are answered from the stored response,
+		// which needs the origin's full response to be stored first
+		outreq = cloneRequest(req)
+		outreq.Header.Del("If-None-Match")
+		outreq.Header.Del("If-Modified-Since")
+		if stale != nil {
+			// kept without validators too, in case the origin fails
+			addValidators(outreq, stale)
+		}
+		if t.DecodeBeforeStore {
+			outreq.Header.Set("Accept-Encoding", "gzip")
+		}
+	}
+
+	if info.Status == StatusMiss {
+		atomic.AddUint64(&t.stats.misses, 1)
+	} else {
+		atomic.AddUint64(&t.stats.bypasses, 1)
+	}
+
+	if t.HonorRetryAfter {
+		now := time.Now()
+		if bo, ok := t.backoffs.get(outreq.URL.Host, now); ok {
+			if stale != nil {
+				if resp, ok := t.serveUnreachable(req, stale, key, staleAge, staleFor, errBackingOff); ok {
+					return resp, nil
+				}
+			}
+			return bo.response(req, now), nil
+		}
+	}
+
+	breaker := t.CircuitThreshold > 0
+	if breaker && !t.circuits.allow(outreq.URL.Host, time.Now()) {
+		if stale != nil {
+			if resp, ok := t.serveUnreachable(req, stale, key, staleAge, staleFor, errCircuitOpen); ok {
+				return resp, nil
+			}
+		}
+		return t.circuitOpen(req), nil
+	}
+
+	sent := outreq
+	if t.Tracer != nil && sent == req {
+		// the tracer may add headers to the request sent upstream
+		sent = cloneRequest(req)
+	}
+	sent, endFetch := t.trace(sent, StageFetch)
+	start := time.Now()
+	resp, err = t.send(transport, sent)
+	info.BackendLatency = time.Since(start)
+	endFetch(resp, err)
+	if breaker {
+		known := err == nil || req.Context().Err() == nil
+		failed := err != nil || resp.StatusCode >= 500
+		t.circuits.done(outreq.URL.Host, known, failed, t.CircuitThreshold, t.circuitCooldown())
+	}
+	if err != nil {
+		if stale != nil {
+			if t.staleIfError(stale, reqCC, staleFor) {
+				t.logger().Errorf("%s: serving stale response: %s", key, err)
+				info.Status = StatusStale
+				return t.serveStale(req, stale, key, staleAge, true), nil
+			}
+			stale.Body.Close()
+			if req.Context().Err() == nil && t.revalidationRequired(parseCacheControl(stale.Header)) {
+				t.logger().Errorf("%s: revalidation failed: %s", key, err)
+				return t.mustRevalidateFailure(req, err), nil
+			}
+		}
+		return nil, &Error{ErrUpstream, err}
+	}
+	if until, ok := retryAfter(resp, time.Now()); ok && t.HonorRetryAfter {
+		t.logger().Errorf("%s: backing off until %s: origin returned %s", outreq.URL.Host, until.Format(time.RFC3339), resp.Status)
+		t.backoffs.set(outreq.URL.Host, until, resp.StatusCode)
+		if stale != nil && t.staleOnFailure(stale, staleFor) {
+			resp.Body.Close()
+			info.Status = StatusStale
+			return t.serveStale(req, stale, key, staleAge, false), nil
+		}
+	}
+	if stale != nil && serverError(resp.StatusCode) && t.staleIfError(stale, reqCC, staleFor) {
+		t.logger().Errorf("%s: serving stale response: origin returned %s", key, resp.Status)
+		resp.Body.Close()
+		info.Status = StatusStale
+		return t.serveStale(req, stale, key, staleAge, false), nil
+	}
+	if unsafeMethod(t.method(req)) && resp.StatusCode < 400 && !t.postQuery(req) {
+		t.invalidateAfter(req, resp)
+	}
+	if cacheable && t.DecodeBeforeStore {
+		// whether or not it turns out storable, the client may not accept
+		// the gzip asked for on its behalf
+		if err := gunzipResponse(resp); err != nil {
+			resp.Body.Close()
+			if stale != nil {
+				stale.Body.Close()
+			}
+			return nil, &Error{ErrUpstream, err}
 		}
 	}
 
-	transport := t.Transport
-	if transport == nil {
-		transport = http.DefaultTransport
+	revalidated := false
+	if stale != nil {
+		if resp.StatusCode == http.StatusNotModified {
+			// the stored response is still current: serve and store it again,
+			// updated with the headers of the 304
+			resp.Body.Close()
+			resp = mergeNotModified(stale, resp)
+			revalidated = true
+			info.Status = StatusRevalidated
+			info.Age = responseAge(resp)
+			atomic.AddUint64(&t.stats.revalidations, 1)
+		} else {
+			stale.Body.Close()
+		}
 	}
 
-	req.Header.Del("If-None-Match")
-	//fmt.Printf("REQUEST: %s\n", req.URL)
-	//for k, v := range req.Header {
-	//fmt.Println(k, v)
-	//}
+	if cacheable && t.NegativeBackoffMax > 0 && resp.StatusCode < 400 {
+		t.negatives.reset(key)
+	}
 
-	resp, err = transport.RoundTrip(req)
-	if err != nil || resp.StatusCode == http.StatusNotModified {
+	var ttl time.Duration
+	if cacheable {
+		notCached, ttl = t.responseNotCacheable(req, reqCC, resp)
+		cacheable = notCached == ""
+		if d, ok := contextTTL(req); ok {
+			ttl = d
+		}
+	}
+	if cacheable && t.Admitter != nil && !t.Admitter.Admit(key) {
 		cacheable = false
+		notCached = NotCachedAdmission
+	}
+
+	if err := req.Context().Err(); cacheable && err != nil {
+		// nobody is waiting for the body, so don't read it in to store it
+		resp.Body.Close()
+		return nil, &Error{ErrUpstream, err}
+	}
+	// the body is needed in full up front to give it an ETag, compress it or
+	// answer the client's own preconditions
+	stream := cacheable && t.StreamStores && !t.CompressOnServe && !clientNotModified(req, resp) &&
+		!(t.GenerateETag && resp.Header.Get("Etag") == "")
+	if stream && t.MaxBodyBytes > 0 && resp.ContentLength > t.MaxBodyBytes {
+		cacheable = false
+		notCached = NotCachedOversize
+	} else if cacheable && !stream && t.MaxBodyBytes > 0 {
+		if err := bufferBody(resp, t.MaxBodyBytes); err != nil {
+			cacheable = false
+			if err == errBodyTooLarge {
+				notCached = NotCachedOversize
+			} else {
+				notCached = NotCachedSerialize
+				t.logger().Errorf("%s: %s", key, err)
+			}
+		}
 	}
 
+	stored := false
+	if cacheable && t.GenerateETag && resp.Header.Get("Etag") == "" {
+		setBodyETag(resp)
+	}
 	if cacheable {
-		respBytes, err := httputil.DumpResponse(resp, true)
-		if err == nil {
-			cacheKey := cacheKey(req)
-			t.Cache.Set(cacheKey, respBytes)
-			//fmt.Printf("[cache-set] %s\n", cacheKey)
-			return bytesToResp(respBytes, req)
+		// headers stripped from the entry are still passed on to the client
+		live := takeHeaders(resp.Header, t.StripHeadersBeforeCache)
+		if stream {
+			t.streamStore(cache, key, req, resp, ttl, release)
+			release = nil
+			copyHeaders(resp.Header, live)
+			stored = true
+		} else if respBytes, dumpErr := dumpResponse(resp); dumpErr == nil {
+			if err := t.store(cache, key, req, resp, ttl, respBytes); err != nil {
+				notCached = NotCachedBackend
+			} else {
+				stored = true
+			}
+			resp, err = bytesToResp(respBytes, req)
+			if err != nil {
+				return nil, &Error{ErrSerialize, err}
+			}
+			copyHeaders(resp.Header, live)
+			if t.CompressOnServe {
+				gzipResponse(req, resp)
+			}
 		} else {
-			fmt.Printf("[ERROR] %s\n", err)
+			copyHeaders(resp.Header, live)
+			notCached = NotCachedSerialize
+			t.logger().Errorf("%s: %s", key, dumpErr)
+		}
+	}
+
+	if revalidated {
+		resp.Header.Set(XFromCache, "1")
+	}
+	if stored {
+		resp.Header.Set(XCacheable, "1")
+	} else {
+		resp.Header.Set(XCacheable, "0")
+		info.NotCached = notCached
+		if t.NotCachedHeader != "" {
+			resp.Header.Set(t.NotCachedHeader, notCached)
 		}
 	}
+	if outreq != req && resp.StatusCode == http.StatusOK && clientNotModified(req, resp) {
+		resp = notModified(req, resp)
+	}
+	t.setCacheStatus(resp, key, fwd, stored)
+	return resp, nil
+}
+
+// store saves resp, the response to req serialized as respBytes, at key,
+// fresh for ttl if it is positive. The cache's failures are logged as well as
+// returned.
+func (t *Transport) store(cache CacheCtx, key string, req *http.Request, resp *http.Response, ttl time.Duration, respBytes []byte) (err error) {
+	req, end := t.trace(req, StageStore)
+	defer func() { end(resp, err) }()
 
-	return
+	now := time.Now()
+	e := &entry{storedAt: now, resp: respBytes, hasher: t.Hasher}
+	if ttl <= 0 {
+		ttl = t.policy(req).TTL
+	}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	} else if lifetime, ok := t.freshnessFunc(req, respBytes); ok {
+		e.expires = now.Add(lifetime)
+	} else if !t.IgnoreCacheControl {
+		lifetime, ok := t.freshnessLifetime(resp, parseCacheControl(resp.Header))
+		if !ok {
+			lifetime, ok = t.heuristicLifetime(resp)
+		}
+		if ok {
+			e.expires = now.Add(lifetime - initialAge(resp, now))
+		}
+	}
+	if ttl, ok := t.statusTTL(req, resp.StatusCode); ok {
+		if t.NegativeBackoffMax > 0 && resp.StatusCode >= 400 {
+			ttl = t.negatives.ttl(key, ttl, t.NegativeBackoffMax)
+		}
+		if e.expires.IsZero() || e.expires.Sub(now) > ttl {
+			e.expires = now.Add(ttl)
+		}
+	}
+	storeKey := key
+	if names, _ := varyHeaders(resp); len(names) > 0 {
+		// record what the response varies on under key, and store it with its
+		// variants
+		if err := cache.Set(req.Context(), key, (&entry{vary: names}).encode()); err != nil {
+			t.logger().Errorf("%s: %s", key, &Error{ErrBackend, err})
+			return err
+		}
+		storeKey = varyKey(key, req, names)
+	}
+	encoded := e.encode()
+	if err := cache.Set(req.Context(), storeKey, encoded); err != nil {
+		t.logger().Errorf("%s: %s", storeKey, &Error{ErrBackend, err})
+		return err
+	}
+	atomic.AddUint64(&t.stats.stores, 1)
+	t.logger().Debugf("[cache-set] %s (%d bytes)", storeKey, len(encoded))
+	if t.OnSet != nil {
+		t.OnSet(storeKey, len(encoded))
+	}
+	return nil
+}
+
+// sampled returns true if a request with key takes part in caching under
+// SampleRate
+func (t *Transport) sampled(key string) bool {
+	if t.SampleRate <= 0 || t.SampleRate >= 1 {
+		return true
+	}
+	if !t.SampleByKey {
+		return rand.Float64() < t.SampleRate
+	}
+	// the high bits of FNV hashes barely differ between keys differing only
+	// in their last bytes, such as /items/1 and /items/2, so use SHA-256
+	sum := sha256.Sum256([]byte(key))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/float64(math.MaxUint64) < t.SampleRate
+}
+
+// restricted returns true if responses to req may only be cached if they are
+// explicitly shareable
+func (t *Transport) restricted(req *http.Request) bool {
+	if t.SkipCacheWhenRequestHasCookie && req.Header.Get("cookie") != "" {
+		return true
+	}
+	return t.Shared && req.Header.Get("authorization") != ""
+}
+
+// explicitlyShareable returns true if the Cache-Control header in headers
+// allows a shared cache to store the response of an authenticated request, see
+// https://tools.ietf.org/html/rfc7234#section-3.2. no-store always prevails.
+func explicitlyShareable(headers http.Header) bool {
+	cc := parseCacheControl(headers)
+	if cc.has("no-store") {
+		return false
+	}
+	return cc.has("public") || cc.has("must-revalidate") || cc.has("s-maxage")
 }
 
 // cloneRequest returns a clone of the provided *http.Request.
@@ -0,0 +1,640 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testOrigin is an origin server whose handler can be replaced between
+// requests and which counts the requests it gets
+type testOrigin struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handler  http.HandlerFunc
+	requests int
+}
+
+func newTestOrigin(t *testing.T, h http.HandlerFunc) *testOrigin {
+	o := &testOrigin{handler: h}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.mu.Lock()
+		h := o.handler
+		o.requests++
+		o.mu.Unlock()
+		h(w, r)
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+// set replaces the handler of o
+func (o *testOrigin) set(h http.HandlerFunc) {
+	o.mu.Lock()
+	o.handler = h
+	o.mu.Unlock()
+}
+
+// count returns the number of requests o got
+func (o *testOrigin) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests
+}
+
+// staleHandler answers with body and Cache-Control cc, plus a max-age of 10s
+// unless cc has one, dated so that the response is already stale for
+// staleFor when it is received
+func staleHandler(body, cc string, staleFor time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cc == "" {
+			cc = "max-age=10"
+		} else if parseCacheControl(http.Header{"Cache-Control": {cc}})["max-age"] == "" {
+			cc = "max-age=10, " + cc
+		}
+		w.Header().Set("Cache-Control", cc)
+		w.Header().Set("Date", time.Now().Add(-10*time.Second-staleFor).UTC().Format(http.TimeFormat))
+		fmt.Fprint(w, body)
+	}
+}
+
+// statusHandler answers with code and body
+func statusHandler(code int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		fmt.Fprint(w, body)
+	}
+}
+
+// errTransport fails every request with a network error
+type errTransport struct{}
+
+var errUnreachable = errors.New("connection refused")
+
+func (errTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errUnreachable
+}
+
+// transportFunc is an http.RoundTripper calling itself
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// get requests url through tr and returns the response with its body read. It
+// may be called from any goroutine.
+func get(t *testing.T, tr http.RoundTripper, url string, header ...string) (*http.Response, string, error) {
+	t.Helper()
+	req := httptest.NewRequest("GET", url, nil)
+	req.RequestURI = ""
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return resp, string(b), err
+}
+
+// mustGet is get failing t on errors
+func mustGet(t *testing.T, tr http.RoundTripper, url string, header ...string) (*http.Response, string) {
+	t.Helper()
+	resp, body, err := get(t, tr, url, header...)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	return resp, body
+}
+
+func TestRoundTripCachesFreshResponses(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hello")
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+
+	for i, want := range []string{"", "1"} {
+		resp, body := mustGet(t, tr, origin.URL)
+		if body != "hello" {
+			t.Errorf("request %d: body = %q, want %q", i, body, "hello")
+		}
+		if got := resp.Header.Get(XFromCache); got != want {
+			t.Errorf("request %d: %s = %q, want %q", i, XFromCache, got, want)
+		}
+	}
+	if n := origin.count(); n != 1 {
+		t.Errorf("origin got %d requests, want 1", n)
+	}
+}
+
+func TestNotModifiedExtendsFreshness(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		staleHandler("body", "", 100*time.Second)(w, r)
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	mustGet(t, tr, origin.URL)
+
+	origin.set(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != `"v1"` {
+			t.Errorf("revalidated with If-None-Match %q, want %q", r.Header.Get("If-None-Match"), `"v1"`)
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusNotModified)
+	})
+	resp, body := mustGet(t, tr, origin.URL)
+	if resp.StatusCode != http.StatusOK || body != "body" {
+		t.Fatalf("revalidation: got %d %q, want the stored 200", resp.StatusCode, body)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "max-age=3600" {
+		t.Errorf("Cache-Control = %q, want the 304's", cc)
+	}
+
+	requests := origin.count()
+	resp, body = mustGet(t, tr, origin.URL)
+	if body != "body" || resp.Header.Get(XFromCache) != "1" {
+		t.Errorf("got %q, %s %q, want the stored response", body, XFromCache, resp.Header.Get(XFromCache))
+	}
+	if n := origin.count(); n != requests {
+		t.Errorf("origin got %d requests, want the entry fresh for the 304's max-age", n-requests)
+	}
+}
+
+func TestReconfigureConcurrently(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hello")
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	shutdownOnCleanup(t, tr)
+	tr.StaleWhileRevalidate = time.Hour
+	NewRefresher(tr)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				url := fmt.Sprintf("%s/%d", origin.URL, j%4)
+				if _, body, err := get(t, tr, url); err != nil || body != "hello" {
+					t.Errorf("GET %s: got %q, %v", url, body, err)
+					return
+				}
+			}
+		}(i)
+	}
+	own := &http.Transport{}
+	defer own.CloseIdleConnections()
+	transports := []http.RoundTripper{own, http.DefaultTransport}
+	for i := 0; i < 200; i++ {
+		tr.SetCache(NewMemoryCache(time.Hour))
+		tr.SetTransport(transports[i%2])
+		tr.SetEnabled(i%3 != 0)
+		_ = tr.Stats()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestEOFDelimitedResponse(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		// an HTTP/1.0 response delimited by the connection closing
+		fmt.Fprint(buf, "HTTP/1.0 200 OK\r\nCache-Control: max-age=60\r\n\r\nhello")
+		buf.Flush()
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+
+	for i, want := range []string{"", "1"} {
+		resp, body := mustGet(t, tr, origin.URL)
+		if body != "hello" || resp.Header.Get(XFromCache) != want {
+			t.Errorf("request %d: got %q, %s %q, want %q, %q", i, body, XFromCache, resp.Header.Get(XFromCache), "hello", want)
+		}
+		if want == "1" && resp.ContentLength != 5 {
+			t.Errorf("stored response has a Content-Length of %d, want 5", resp.ContentLength)
+		}
+	}
+	if n := origin.count(); n != 1 {
+		t.Errorf("origin got %d requests, want 1", n)
+	}
+}
+
+func TestHeadSharesGetEntry(t *testing.T) {
+	origin := newTestOrigin(t, methodHandler)
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	head := func() *http.Response {
+		req := httptest.NewRequest("HEAD", origin.URL, nil)
+		req.RequestURI = ""
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// a HEAD response is not stored in place of the GET one
+	head()
+	if resp, body := mustGet(t, tr, origin.URL); body != "GET" || resp.Header.Get(XFromCache) != "" {
+		t.Fatalf("GET got %q, from cache %q, want the origin's GET response", body, resp.Header.Get(XFromCache))
+	}
+	if resp := head(); resp.Header.Get(XFromCache) != "1" || origin.count() != 2 {
+		t.Errorf("HEAD not answered from the stored GET response: %d requests to the origin", origin.count())
+	}
+
+	u, _ := url.Parse(origin.URL)
+	tr.Purge(u)
+	if resp := head(); resp.Header.Get(XFromCache) != "" || origin.count() != 3 {
+		t.Errorf("HEAD answered from the cache after Purge")
+	}
+}
+
+// failingReader fails every read with err
+type failingReader struct{ err error }
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestDumpFailureKeepsBody(t *testing.T) {
+	errReset := errors.New("connection reset")
+	requests := 0
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.NotCachedHeader = "X-Not-Cached"
+	tr.Transport = transportFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Cache-Control": {"max-age=60"}},
+			Body:       ioutil.NopCloser(io.MultiReader(strings.NewReader("partial"), failingReader{errReset})),
+			Request:    req,
+		}, nil
+	})
+
+	for i := 1; i <= 2; i++ {
+		resp, body, err := get(t, tr, "http://example.com/")
+		if body != "partial" || err != errReset {
+			t.Fatalf("request %d: got %q, %v, want what was read then the body's error", i, body, err)
+		}
+		if got := resp.Header.Get("X-Not-Cached"); got != NotCachedSerialize {
+			t.Errorf("request %d: X-Not-Cached = %q, want %q", i, got, NotCachedSerialize)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("origin got %d requests, want 2", requests)
+	}
+}
+
+func TestExplicitlyShareable(t *testing.T) {
+	tests := []struct {
+		cc, header string
+		stored     bool
+	}{
+		{"max-age=60", "Cookie", false},
+		{"public, max-age=60", "Cookie", true},
+		{"s-maxage=60", "Cookie", true},
+		{"must-revalidate, max-age=60", "Cookie", true},
+		{"public, no-store", "Cookie", false},
+		{"max-age=60", "Authorization", false},
+		{"public, max-age=60", "Authorization", true},
+	}
+	for _, tt := range tests {
+		origin := newTestOrigin(t, staleHandler("body", tt.cc, -10*time.Second))
+		tr := NewTransport(NewMemoryCache(time.Hour))
+		tr.Shared = true
+		tr.SkipCacheWhenRequestHasCookie = true
+		mustGet(t, tr, origin.URL, tt.header, "a")
+		resp, _ := mustGet(t, tr, origin.URL, tt.header, "a")
+		if stored := resp.Header.Get(XFromCache) == "1"; stored != tt.stored {
+			t.Errorf("%s with %s: served from the cache %v, want %v", tt.cc, tt.header, stored, tt.stored)
+		}
+	}
+}
+
+func TestRewriteDateOnServe(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=7200")
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	})
+	for _, rewrite := range []bool{false, true} {
+		mem := NewMemoryCache(time.Hour)
+		tr := NewTransport(mem)
+		tr.RewriteDateOnServe = rewrite
+		first, _ := mustGet(t, tr, origin.URL)
+		resp, _ := mustGet(t, tr, origin.URL)
+		if resp.Header.Get(XFromCache) != "1" {
+			t.Fatalf("not served from the cache")
+		}
+		date, _ := http.ParseTime(resp.Header.Get("Date"))
+		if recent := time.Since(date) < time.Minute; recent != rewrite {
+			t.Errorf("RewriteDateOnServe %v: served with Date %s", rewrite, resp.Header.Get("Date"))
+		}
+		if stored, _ := mem.Get(mem.Keys()[0]); !strings.Contains(string(stored), "Date: "+first.Header.Get("Date")) {
+			t.Errorf("RewriteDateOnServe %v: the stored entry lost its Date", rewrite)
+		}
+	}
+}
+
+func TestSampleByKey(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.SampleRate = 0.5
+	tr.SampleByKey = true
+	tr.NotCachedHeader = "X-Not-Cached"
+
+	sampled := 0
+	for i := 0; i < 40; i++ {
+		url := fmt.Sprintf("%s/%d", origin.URL, i)
+		mustGet(t, tr, url)
+		resp, _ := mustGet(t, tr, url)
+		hit := resp.Header.Get(XFromCache) == "1"
+		if hit {
+			sampled++
+		} else if got := resp.Header.Get("X-Not-Cached"); got != NotCachedSampling {
+			t.Errorf("%s: X-Not-Cached = %q, want %q", url, got, NotCachedSampling)
+		}
+		// a key is either always or never cached
+		if resp, _ := mustGet(t, tr, url); (resp.Header.Get(XFromCache) == "1") != hit {
+			t.Errorf("%s: sampled inconsistently", url)
+		}
+	}
+	if sampled < 10 || sampled > 30 {
+		t.Errorf("%d of 40 keys sampled at a rate of 0.5", sampled)
+	}
+}
+
+func TestSetCacheInFlight(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		staleHandler("body", "max-age=60", -10*time.Second)(w, r)
+	})
+	old, swapped := NewMemoryCache(time.Hour), NewMemoryCache(time.Hour)
+	tr := NewTransport(old)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, _, err := get(t, tr, origin.URL); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-entered
+	tr.SetCache(swapped)
+	close(release)
+	<-done
+
+	if old.Len() != 1 || swapped.Len() != 0 {
+		t.Errorf("the request in flight stored %d entries in the old cache and %d in the new one, want it to finish with the old one", old.Len(), swapped.Len())
+	}
+	go func() { <-entered }()
+	if resp, _ := mustGet(t, tr, origin.URL); resp.Header.Get(XFromCache) != "" || swapped.Len() != 1 {
+		t.Errorf("the next request was not a miss stored in the new cache")
+	}
+}
+
+func TestCachedBodyClose(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	mustGet(t, tr, origin.URL)
+
+	// a cached body closed unread, or twice, leaves the entry intact
+	req := httptest.NewRequest("GET", origin.URL, nil)
+	req.RequestURI = ""
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get(XFromCache) != "1" {
+		t.Fatalf("not served from the cache")
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	resp.Body.Close()
+	if _, body := mustGet(t, tr, origin.URL); body != "body" || origin.count() != 1 {
+		t.Errorf("got %q after %d requests to the origin, want the stored body", body, origin.count())
+	}
+}
+
+func TestRevalidateLastModified(t *testing.T) {
+	modified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified)
+		staleHandler("v1", "", 100*time.Second)(w, r)
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	mustGet(t, tr, origin.URL)
+
+	origin.set(func(w http.ResponseWriter, r *http.Request) {
+		if ims := r.Header.Get("If-Modified-Since"); ims != modified {
+			t.Errorf("revalidated with If-Modified-Since %q, want %q", ims, modified)
+		}
+		// confirmed, but still to be revalidated on the next request
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.WriteHeader(http.StatusNotModified)
+	})
+	if resp, body := mustGet(t, tr, origin.URL); body != "v1" || resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d %q, want the stored response confirmed", resp.StatusCode, body)
+	}
+
+	// a full response to the revalidation replaces the entry
+	origin.set(staleHandler("v2", "max-age=60", -10*time.Second))
+	if _, body := mustGet(t, tr, origin.URL); body != "v2" {
+		t.Errorf("got %q, want the origin's new response", body)
+	}
+	if resp, body := mustGet(t, tr, origin.URL); body != "v2" || resp.Header.Get(XFromCache) != "1" {
+		t.Errorf("got %q, from cache %q, want the new response stored", body, resp.Header.Get(XFromCache))
+	}
+}
+
+func TestAgeOnEveryHit(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Age", "30")
+		fmt.Fprint(w, "aged")
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	mustGet(t, tr, origin.URL)
+
+	for _, want := range []string{"30", "31"} {
+		if want == "31" {
+			time.Sleep(time.Second)
+		}
+		if resp, _ := mustGet(t, tr, origin.URL); resp.Header.Get("Age") != want {
+			t.Errorf("Age = %q, want %q", resp.Header.Get("Age"), want)
+		}
+	}
+}
+
+func TestStripHeadersBeforeCache(t *testing.T) {
+	id := 0
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		id++
+		w.Header().Set("X-Request-Id", fmt.Sprint(id))
+		w.Header().Set("X-Kept", "1")
+		staleHandler("body", "max-age=60", -10*time.Second)(w, r)
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.StripHeadersBeforeCache = []string{"x-request-id"}
+
+	if resp, _ := mustGet(t, tr, origin.URL); resp.Header.Get("X-Request-Id") != "1" {
+		t.Errorf("the response first received lost X-Request-Id")
+	}
+	resp, _ := mustGet(t, tr, origin.URL)
+	if resp.Header.Get(XFromCache) != "1" || resp.Header.Get("X-Kept") != "1" {
+		t.Fatalf("got %v, want the stored response with its other headers", resp.Header)
+	}
+	if _, ok := resp.Header["X-Request-Id"]; ok {
+		t.Errorf("X-Request-Id = %q served from the cache", resp.Header.Get("X-Request-Id"))
+	}
+}
+
+func TestOnSet(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	mem := NewMemoryCache(time.Hour)
+	tr := NewTransport(mem)
+	var keys []string
+	var sizes []int
+	tr.OnSet = func(key string, size int) {
+		keys = append(keys, key)
+		sizes = append(sizes, size)
+	}
+	mustGet(t, tr, origin.URL)
+	mustGet(t, tr, origin.URL)
+
+	if len(keys) != 1 {
+		t.Fatalf("OnSet called for %q, want the one response stored", keys)
+	}
+	if stored, _ := mem.Get(keys[0]); sizes[0] != len(stored) {
+		t.Errorf("OnSet reported %d bytes for %s, want the %d stored", sizes[0], keys[0], len(stored))
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	mem := NewMemoryCache(time.Hour)
+	tr := NewTransport(mem)
+	mustGet(t, tr, origin.URL)
+
+	tr.SetEnabled(false)
+	if tr.Enabled() {
+		t.Errorf("Enabled() after SetEnabled(false)")
+	}
+	for _, path := range []string{"", "/new"} {
+		if resp, _ := mustGet(t, tr, origin.URL+path); resp.Header.Get(XFromCache) != "" {
+			t.Errorf("%q served from the cache while disabled", path)
+		}
+	}
+	if mem.Len() != 1 {
+		t.Errorf("%d entries stored, want only the one stored before disabling", mem.Len())
+	}
+
+	// stored entries are served again once caching is back on
+	tr.SetEnabled(true)
+	if resp, _ := mustGet(t, tr, origin.URL); resp.Header.Get(XFromCache) != "1" || origin.count() != 3 {
+		t.Errorf("the entry stored before disabling was not served")
+	}
+}
+
+func TestStrictSharedCaching(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		strict bool
+		stored bool
+	}{
+		{name: "implicit", stored: true},
+		{name: "implicit strict", strict: true},
+		{name: "max-age strict", header: http.Header{"Cache-Control": {"max-age=60"}}, strict: true, stored: true},
+		{name: "Expires strict", header: http.Header{"Expires": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}, strict: true, stored: true},
+		{name: "ETag strict", header: http.Header{"Etag": {`"v1"`}}, strict: true, stored: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range tt.header {
+					w.Header()[name] = values
+				}
+				fmt.Fprint(w, "body")
+			})
+			tr := NewSharedTransport(NewMemoryCache(time.Hour))
+			tr.StrictSharedCaching = tt.strict
+			tr.NotCachedHeader = "X-Not-Cached"
+			resp, _ := mustGet(t, tr, origin.URL)
+			if got, want := resp.Header.Get("X-Not-Cached"), map[bool]string{false: NotCachedImplicit}[tt.stored]; got != want {
+				t.Errorf("X-Not-Cached = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCacheableMethodsAndStatusCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		methods  []string
+		statuses []int
+		method   string
+		status   int
+		cc       string
+		stored   bool
+	}{
+		{name: "default", method: "GET", status: http.StatusOK, stored: true},
+		{name: "default 404", method: "GET", status: http.StatusNotFound, stored: true},
+		{name: "default 500", method: "GET", status: http.StatusInternalServerError},
+		{name: "default 500 with max-age", method: "GET", status: http.StatusInternalServerError, cc: "max-age=60", stored: true},
+		{name: "default OPTIONS", method: "OPTIONS", status: http.StatusOK},
+		{name: "OPTIONS listed", methods: []string{"GET", "OPTIONS"}, method: "OPTIONS", status: http.StatusOK, stored: true},
+		{name: "GET not listed", methods: []string{"OPTIONS"}, method: "GET", status: http.StatusOK},
+		{name: "500 listed", statuses: []int{http.StatusInternalServerError}, method: "GET", status: http.StatusInternalServerError, stored: true},
+		{name: "200 not listed", statuses: []int{http.StatusNotFound}, method: "GET", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.cc != "" {
+					w.Header().Set("Cache-Control", tt.cc)
+				}
+				w.WriteHeader(tt.status)
+			})
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.CacheableMethods = tt.methods
+			tr.CacheableStatusCodes = tt.statuses
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(tt.method, origin.URL, nil)
+				req.RequestURI = ""
+				resp, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+			if stored := origin.count() == 1; stored != tt.stored {
+				t.Errorf("origin got %d requests, want stored %v", origin.count(), tt.stored)
+			}
+		})
+	}
+}
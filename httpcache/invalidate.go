@@ -0,0 +1,134 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Invalidate removes the cached response req would be answered with, using
+// the same key as RoundTrip. This covers all variants of a response with a
+// Vary header.
+func (t *Transport) Invalidate(req *http.Request) {
+	if key, ok := t.key(req); ok {
+		t.cacheDelete(req.Context(), ToCacheCtx(t.cache()), key)
+	}
+}
+
+// InvalidateURL removes the cached response to a request with method for u,
+// made without any headers. GET and HEAD requests share a cache key, so either
+// covers both. Entries keyed on request headers by the Transport's own
+// options, such as those selected by VaryAccept, are not removed.
+func (t *Transport) InvalidateURL(u *url.URL, method string) {
+	t.Invalidate(&http.Request{Method: method, URL: u, Header: make(http.Header)})
+}
+
+// Purge removes the cached response for u, like InvalidateURL with GET
+func (t *Transport) Purge(u *url.URL) {
+	t.InvalidateURL(u, "GET")
+}
+
+// PurgePrefix removes the cached GET responses for every URL starting with u,
+// such as all the resources below a path, and returns the number of entries
+// removed. It only applies to keys derived by the Transport's default key
+// options, in the namespace KeyPrefixFunc puts a request for u without
+// headers in, and needs a Cache with a DeletePrefix method, such as
+// MemoryCache, or a ListableCache; with other Caches it removes nothing.
+func (t *Transport) PurgePrefix(u *url.URL) int {
+	nu := normalizeURL(u)
+	if t.KeyIncludeScheme {
+		nu.Scheme = u.Scheme
+	}
+	prefix := nu.String()
+	if t.KeyPrefixFunc != nil {
+		prefix = namespaceKey(t.KeyPrefixFunc(&http.Request{Method: "GET", URL: u, Header: make(http.Header)}), prefix)
+	}
+	return t.deletePrefix(prefix)
+}
+
+// deletePrefix removes every entry whose key starts with prefix, and returns
+// the number of entries removed
+func (t *Transport) deletePrefix(prefix string) int {
+	c := t.cache()
+	if d, ok := c.(interface{ DeletePrefix(string) int }); ok {
+		return d.DeletePrefix(prefix)
+	}
+	l, ok := c.(ListableCache)
+	if !ok {
+		return 0
+	}
+	n := 0
+	for _, key := range l.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			l.Delete(key)
+			n++
+		}
+	}
+	return n
+}
+
+// PurgeHandler returns an http.Handler that removes the cached response for
+// the URL given in the "url" query parameter from t, e.g. to let operators
+// purge a resource once the origin has changed it. With a "namespace"
+// parameter, the response is removed from that namespace, see
+// Transport.KeyPrefixFunc, and without a url, the whole namespace is.
+func PurgeHandler(t *Transport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		ns, hasNS := q["namespace"]
+		if hasNS && q.Get("url") == "" {
+			t.PurgeNamespace(ns[0])
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		u, err := url.Parse(q.Get("url"))
+		if err != nil || u.Host == "" {
+			http.Error(w, "url parameter must be an absolute URL", http.StatusBadRequest)
+			return
+		}
+		if hasNS {
+			t.purgeInNamespace(ns[0], u)
+		} else {
+			t.Purge(u)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// unsafeMethod returns true if method may change the resource it is made to,
+// see https://tools.ietf.org/html/rfc7231#section-4.2.1
+func unsafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return false
+	}
+	return true
+}
+
+// invalidateAfter removes the cached responses for the resources changed by
+// the unsafe request req, which succeeded with resp: its own URL, and the URLs
+// in the Location and Content-Location headers of resp if they are on the same
+// host, see https://tools.ietf.org/html/rfc7234#section-4.4
+func (t *Transport) invalidateAfter(req *http.Request, resp *http.Response) {
+	t.invalidateGet(req, req.URL)
+	for _, name := range []string{"Location", "Content-Location"} {
+		v := resp.Header.Get(name)
+		if v == "" {
+			continue
+		}
+		u, err := req.URL.Parse(v)
+		if err != nil || u.Host != req.URL.Host {
+			continue
+		}
+		t.invalidateGet(req, u)
+	}
+}
+
+// invalidateGet removes the cached response to a GET request for u made with
+// the headers of req
+func (t *Transport) invalidateGet(req *http.Request, u *url.URL) {
+	get := cloneRequest(req)
+	get.Method = "GET"
+	get.URL = u
+	t.Invalidate(get)
+}
@@ -0,0 +1,86 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInvalidateAfterUnsafe(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	post := func(path string, status int, location string) {
+		origin.set(func(w http.ResponseWriter, r *http.Request) {
+			if location != "" {
+				w.Header().Set("Location", location)
+			}
+			w.WriteHeader(status)
+		})
+		req := httptest.NewRequest("POST", origin.URL+path, nil)
+		req.RequestURI = ""
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		origin.set(staleHandler("body", "max-age=60", -10*time.Second))
+	}
+	stored := func(path string) bool {
+		resp, _ := mustGet(t, tr, origin.URL+path)
+		return resp.Header.Get(XFromCache) == "1"
+	}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		mustGet(t, tr, origin.URL+path)
+	}
+
+	post("/a", http.StatusInternalServerError, "")
+	if !stored("/a") {
+		t.Errorf("a failed POST invalidated its URL")
+	}
+	post("/a", http.StatusCreated, "/b")
+	post("/a", http.StatusOK, "http://elsewhere.example/c")
+	if stored("/a") || stored("/b") {
+		t.Errorf("a successful POST left its URL or Location stored")
+	}
+	if !stored("/c") {
+		t.Errorf("invalidated /c through a Location on another host")
+	}
+}
+
+func TestPurgeHandler(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.KeyPrefixFunc = KeyPrefixHeader("X-Tenant")
+	h := PurgeHandler(tr)
+	purge := func(query string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/purge?"+query, nil))
+		return w.Code
+	}
+	stored := func(path, tenant string) bool {
+		resp, _ := mustGet(t, tr, origin.URL+path, "X-Tenant", tenant)
+		return resp.Header.Get(XFromCache) == "1"
+	}
+	for _, tenant := range []string{"a", "b"} {
+		mustGet(t, tr, origin.URL+"/x", "X-Tenant", tenant)
+		mustGet(t, tr, origin.URL+"/y", "X-Tenant", tenant)
+	}
+
+	if code := purge("url=/x"); code != http.StatusBadRequest {
+		t.Errorf("relative url: got %d, want 400", code)
+	}
+	if code := purge("namespace=a&url=" + url.QueryEscape(origin.URL+"/x")); code != http.StatusNoContent {
+		t.Errorf("got %d, want 204", code)
+	}
+	if stored("/x", "a") || !stored("/x", "b") || !stored("/y", "a") {
+		t.Errorf("purged more or less than /x for tenant a")
+	}
+	if code := purge("namespace=b"); code != http.StatusNoContent {
+		t.Errorf("got %d, want 204", code)
+	}
+	if stored("/x", "b") || stored("/y", "b") {
+		t.Errorf("namespace b not purged")
+	}
+}
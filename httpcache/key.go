@@ -0,0 +1,222 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LanguageGranularity selects how much of a language tag is made part of the
+// cache key by Transport.VaryLanguage
+type LanguageGranularity int
+
+const (
+	// LanguagePrimary keys on the primary subtag only, so "en-US" and "en-GB"
+	// share an "en" entry
+	LanguagePrimary LanguageGranularity = iota
+	// LanguageFull keys on the full language tag
+	LanguageFull
+)
+
+// maxKeyedBodyBytes bounds the request bodies hashed into the keys of requests
+// admitted by CachePOST or the Cacheable hook
+const maxKeyedBodyBytes = 1 << 20
+
+// postQuery returns true if req is a POST that CachePOST makes cacheable
+func (t *Transport) postQuery(req *http.Request) bool {
+	if t.method(req) != "POST" {
+		return false
+	}
+	for _, re := range t.CachePOST {
+		if re.MatchString(req.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// method returns the method req is treated as for caching purposes, after
+// applying MethodAliases
+func (t *Transport) method(req *http.Request) string {
+	if alias, ok := t.MethodAliases[req.Method]; ok {
+		return alias
+	}
+	return req.Method
+}
+
+// key returns the cache key for req, and false if req can't be keyed (and so
+// must not be cached)
+func (t *Transport) key(req *http.Request) (string, bool) {
+	key, ok := t.baseKey(req)
+	if !ok || t.KeyPrefixFunc == nil {
+		return key, ok
+	}
+	return namespaceKey(t.KeyPrefixFunc(req), key), true
+}
+
+// baseKey returns the cache key for req before it is put in its namespace
+func (t *Transport) baseKey(req *http.Request) (string, bool) {
+	keyFunc := t.policy(req).KeyFunc
+	if keyFunc == nil {
+		keyFunc = t.KeyFunc
+	}
+	if keyFunc != nil {
+		key := keyFunc(req)
+		return key, key != ""
+	}
+	if t.KeyBuilder != nil {
+		return t.KeyBuilder.Key(req)
+	}
+
+	u := normalizeURL(req.URL)
+	if t.KeyIncludeScheme {
+		u.Scheme = requestScheme(req)
+	}
+	key := cacheKey(t.method(req), u)
+
+	if m := t.method(req); t.postQuery(req) || t.Cacheable != nil && m != "GET" && m != "HEAD" {
+		// responses to these depend on the request body
+		part, ok := KeyPartBodyHash(maxKeyedBodyBytes)(req)
+		if !ok {
+			return "", false
+		}
+		key += " " + part
+	}
+
+	for _, name := range t.KeyHeaders {
+		key += " " + headerKeyPart(req.Header, name)
+	}
+
+	if t.BucketFunc != nil {
+		key += " bucket=" + t.BucketFunc(req)
+	}
+
+	for _, re := range t.VaryAccept {
+		if re.MatchString(req.URL.Path) {
+			key += " accept=" + normalizeAccept(req.Header)
+			break
+		}
+	}
+
+	for _, re := range t.VaryLanguage {
+		if re.MatchString(req.URL.Path) {
+			key += " lang=" + preferredLanguage(req.Header, t.LanguageGranularity)
+			break
+		}
+	}
+
+	return key, true
+}
+
+// normalizeURL returns a copy of u suitable for keying: without scheme, with
+// the host lowercased and with equivalent query strings made identical
+func normalizeURL(u *url.URL) *url.URL {
+	u2 := *u
+	u2.Scheme = ""
+	u2.Host = strings.ToLower(u.Host)
+	u2.ForceQuery = false
+	u2.RawQuery = normalizeQuery(u.RawQuery)
+	return &u2
+}
+
+// requestScheme returns the scheme the client used for req. Requests relayed by
+// a server (such as httputil.ReverseProxy) still carry the state of the
+// incoming connection, which takes precedence over the outgoing URL's scheme.
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	if req.RemoteAddr != "" {
+		return "http"
+	}
+	return req.URL.Scheme
+}
+
+// headerKeyPart returns the values of header name in headers as a cache key
+// component, with the name lowercased and the values trimmed of whitespace
+func headerKeyPart(headers http.Header, name string) string {
+	return strings.ToLower(name) + "=" + strings.Join(headerAllCommaSepValues(headers, name), ",")
+}
+
+// normalizeQuery canonicalizes equivalent forms of the raw query q: empty
+// parameters are dropped and parameters without a value get an empty one, so
+// "/x", "/x?" and "/x?&" share a key, as do "/x?a" and "/x?a=". Parameters are
+// sorted by name, so "/x?a=1&b=2" and "/x?b=2&a=1" share a key too; the order
+// of repeated parameters, which may be significant, is preserved.
+func normalizeQuery(q string) string {
+	if q == "" {
+		return q
+	}
+
+	params := strings.Split(q, "&")
+	n := 0
+	for _, p := range params {
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "=") {
+			p += "="
+		}
+		params[n] = p
+		n++
+	}
+	params = params[:n]
+	sort.SliceStable(params, func(i, j int) bool {
+		return paramName(params[i]) < paramName(params[j])
+	})
+	return strings.Join(params, "&")
+}
+
+// paramName returns the name of the query parameter p, of the form name=value
+func paramName(p string) string {
+	return p[:strings.IndexByte(p, '=')]
+}
+
+// normalizeAccept returns the media ranges of the Accept header in headers,
+// lowercased and stripped of parameters (including q-values) and whitespace,
+// so that equivalent headers produce the same cache key
+func normalizeAccept(headers http.Header) string {
+	var ranges []string
+	for _, v := range headerAllCommaSepValues(headers, "accept") {
+		if i := strings.IndexByte(v, ';'); i >= 0 {
+			v = v[:i]
+		}
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			ranges = append(ranges, v)
+		}
+	}
+	return strings.Join(ranges, ",")
+}
+
+// preferredLanguage returns the language with the highest q-value in the
+// Accept-Language header in headers (the first listed wins ties), lowercased
+// and reduced to granularity. It returns "" if no language is acceptable.
+func preferredLanguage(headers http.Header, granularity LanguageGranularity) string {
+	lang, best := "", 0.0
+	for _, v := range headerAllCommaSepValues(headers, "accept-language") {
+		tag, q := v, 1.0
+		if i := strings.IndexByte(v, ';'); i >= 0 {
+			tag = v[:i]
+			param := strings.TrimSpace(v[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" || q <= best {
+			continue
+		}
+		lang, best = tag, q
+	}
+
+	if granularity == LanguagePrimary {
+		if i := strings.IndexByte(lang, '-'); i >= 0 {
+			lang = lang[:i]
+		}
+	}
+	return lang
+}
@@ -0,0 +1,87 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// A KeyPart contributes one component of a cache key built by a KeyBuilder. It
+// returns false if no key can be derived for req, in which case the request is
+// not cached.
+type KeyPart func(req *http.Request) (part string, ok bool)
+
+// KeyBuilder derives cache keys from an ordered list of parts, joined by
+// spaces.
+type KeyBuilder []KeyPart
+
+// DefaultKeyBuilder derives the same keys as a Transport with no key options
+// set.
+var DefaultKeyBuilder = KeyBuilder{KeyPartURL}
+
+// Key returns the cache key for req, and false if any part can't be derived
+func (b KeyBuilder) Key(req *http.Request) (string, bool) {
+	parts := make([]string, len(b))
+	for i, part := range b {
+		p, ok := part(req)
+		if !ok {
+			return "", false
+		}
+		parts[i] = p
+	}
+	return strings.Join(parts, " "), true
+}
+
+// KeyPartMethod contributes the request method. HEAD is reported as GET, since
+// HEAD requests are answered from stored GET responses.
+func KeyPartMethod(req *http.Request) (string, bool) {
+	if req.Method == "HEAD" {
+		return "GET", true
+	}
+	return req.Method, true
+}
+
+// KeyPartURL contributes the request URL, without its scheme, with its host
+// lowercased and with equivalent query strings made identical
+func KeyPartURL(req *http.Request) (string, bool) {
+	return normalizeURL(req.URL).String(), true
+}
+
+// KeyPartHeader returns a KeyPart contributing the values of the request
+// header name
+func KeyPartHeader(name string) KeyPart {
+	return func(req *http.Request) (string, bool) {
+		return headerKeyPart(req.Header, name), true
+	}
+}
+
+// KeyPartBodyHash returns a KeyPart contributing a SHA-256 hash of the request
+// body. Requests with bodies larger than maxBytes can't be keyed. The body is
+// restored after hashing, so it can still be sent upstream, and made
+// replayable with GetBody.
+func KeyPartBodyHash(maxBytes int64) KeyPart {
+	return func(req *http.Request) (string, bool) {
+		if req.Body == nil || req.Body == http.NoBody {
+			return "body=", true
+		}
+
+		b, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+		if err != nil || int64(len(b)) > maxBytes {
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+			return "", false
+		}
+		// read in full, so it can be replayed from memory
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+
+		sum := sha256.Sum256(b)
+		return "body=" + hex.EncodeToString(sum[:]), true
+	}
+}
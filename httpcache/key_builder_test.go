@@ -0,0 +1,59 @@
+package httpcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyBuilder(t *testing.T) {
+	req := httptest.NewRequest("HEAD", "http://Example.com/x?b=1&a=2", nil)
+	req.Header.Set("X-Tenant", " t1 ")
+	b := KeyBuilder{KeyPartMethod, KeyPartURL, KeyPartHeader("X-Tenant")}
+	if key, ok := b.Key(req); !ok || key != "GET //example.com/x?a=2&b=1 x-tenant=t1" {
+		t.Errorf("Key() = %q, %v", key, ok)
+	}
+
+	unkeyable := func(*http.Request) (string, bool) { return "", false }
+	if key, ok := append(b, unkeyable).Key(req); ok {
+		t.Errorf("Key() = %q with a part failing, want no key", key)
+	}
+}
+
+func TestKeyPartBodyHash(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(body)
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.CacheableMethods = []string{"POST"}
+	tr.KeyBuilder = KeyBuilder{KeyPartMethod, KeyPartURL, KeyPartBodyHash(8)}
+
+	tests := []struct {
+		body      string
+		fromCache bool
+	}{
+		{"query a", false},
+		{"query a", true},
+		{"query b", false},
+		{"too long query", false},
+		{"too long query", false},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("POST", origin.URL, strings.NewReader(tt.body))
+		req.RequestURI = ""
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tt.body || (resp.Header.Get(XFromCache) == "1") != tt.fromCache {
+			t.Errorf("request %d: got %q, from cache %q, want %q, %v", i, body, resp.Header.Get(XFromCache), tt.body, tt.fromCache)
+		}
+	}
+}
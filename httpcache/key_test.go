@@ -0,0 +1,246 @@
+package httpcache
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// methodHandler answers with the method of each request, fresh for a minute
+func methodHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=60")
+	fmt.Fprint(w, r.Method)
+}
+
+func TestMethodAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases map[string]string
+		want    string
+	}{
+		{name: "no alias", want: "POST"},
+		{name: "POST as GET", aliases: map[string]string{"POST": "GET"}, want: "GET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, methodHandler)
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.MethodAliases = tt.aliases
+			mustGet(t, tr, origin.URL)
+
+			req := httptest.NewRequest("POST", origin.URL, strings.NewReader("q"))
+			req.RequestURI = ""
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if b, _ := ioutil.ReadAll(resp.Body); string(b) != tt.want {
+				t.Errorf("POST got %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaryAccept(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, r.Header.Get("Accept"))
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.VaryAccept = []*regexp.Regexp{regexp.MustCompile("^/api/")}
+
+	tests := []struct {
+		path, accept, want string
+	}{
+		{"/api/x", "application/json", "application/json"},
+		{"/api/x", "Application/JSON; q=0.9", "application/json"},
+		{"/api/x", "text/html", "text/html"},
+		{"/other", "application/json", "application/json"},
+		{"/other", "text/html", "application/json"},
+	}
+	for _, tt := range tests {
+		if _, body := mustGet(t, tr, origin.URL+tt.path, "Accept", tt.accept); body != tt.want {
+			t.Errorf("GET %s with Accept %q: got %q, want %q", tt.path, tt.accept, body, tt.want)
+		}
+	}
+	if n := origin.count(); n != 3 {
+		t.Errorf("origin got %d requests, want 3", n)
+	}
+}
+
+func TestKeyIncludeScheme(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		t.Run(fmt.Sprint(include), func(t *testing.T) {
+			origin := newTestOrigin(t, methodHandler)
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.KeyIncludeScheme = include
+			// the same resource requested by a client over http, then https
+			for _, state := range []*tls.ConnectionState{nil, {}} {
+				req := httptest.NewRequest("GET", origin.URL, nil)
+				req.RequestURI = ""
+				req.TLS = state
+				resp, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+			if n, want := origin.count(), map[bool]int{false: 1, true: 2}[include]; n != want {
+				t.Errorf("origin got %d requests, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestKeyObserver(t *testing.T) {
+	origin := newTestOrigin(t, methodHandler)
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	var observed []string
+	tr.KeyObserver = func(req *http.Request, key string) {
+		observed = append(observed, req.Method+" "+key)
+	}
+	tr.KeyHeaders = []string{"X-Tenant"}
+
+	mustGet(t, tr, origin.URL+"/x", "X-Tenant", "a")
+	mustGet(t, tr, origin.URL+"/x", "X-Tenant", "a")
+	req := httptest.NewRequest("DELETE", origin.URL+"/x", nil)
+	req.RequestURI = ""
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// uncacheable requests are not observed
+	key := "//" + origin.Listener.Addr().String() + "/x x-tenant=a"
+	if want := []string{"GET " + key, "GET " + key}; !reflect.DeepEqual(observed, want) {
+		t.Errorf("observed %q, want %q", observed, want)
+	}
+	if n := origin.count(); n != 2 {
+		t.Errorf("origin got %d requests, want the second GET answered from the cache", n)
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		first, second string
+		shared        bool
+	}{
+		{"/x", "/x?", true},
+		{"/x", "/x?&", true},
+		{"/x?a", "/x?a=", true},
+		{"/x?a=1&&b=2", "/x?b=2&a=1", true},
+		{"/x?a=1&a=2", "/x?a=2&a=1", false},
+		{"/x?a=1", "/x?a=2", false},
+	}
+	for _, tt := range tests {
+		origin := newTestOrigin(t, methodHandler)
+		tr := NewTransport(NewMemoryCache(time.Hour))
+		mustGet(t, tr, origin.URL+tt.first)
+		resp, _ := mustGet(t, tr, origin.URL+tt.second)
+		if shared := resp.Header.Get(XFromCache) == "1"; shared != tt.shared {
+			t.Errorf("%s then %s: shared an entry %v, want %v", tt.first, tt.second, shared, tt.shared)
+		}
+	}
+}
+
+func TestVaryLanguage(t *testing.T) {
+	tests := []struct {
+		granularity LanguageGranularity
+		first       string
+		second      string
+		shared      bool
+	}{
+		{LanguagePrimary, "en-US", "en-GB", true},
+		{LanguagePrimary, "en-US", "fr", false},
+		{LanguagePrimary, "fr;q=0.8, de;q=0.5", "de;q=0.1, FR", true},
+		{LanguagePrimary, "de, fr", "fr, de", false},
+		{LanguageFull, "en-US", "en-GB", false},
+		{LanguageFull, "en-us", "en-US;q=0.5", true},
+	}
+	for _, tt := range tests {
+		origin := newTestOrigin(t, methodHandler)
+		tr := NewTransport(NewMemoryCache(time.Hour))
+		tr.VaryLanguage = []*regexp.Regexp{regexp.MustCompile("^/")}
+		tr.LanguageGranularity = tt.granularity
+		mustGet(t, tr, origin.URL+"/", "Accept-Language", tt.first)
+		resp, _ := mustGet(t, tr, origin.URL+"/", "Accept-Language", tt.second)
+		if shared := resp.Header.Get(XFromCache) == "1"; shared != tt.shared {
+			t.Errorf("granularity %d, %q then %q: shared an entry %v, want %v", tt.granularity, tt.first, tt.second, shared, tt.shared)
+		}
+	}
+}
+
+func TestKeyHeaders(t *testing.T) {
+	tests := []struct {
+		first, second string
+		shared        bool
+	}{
+		{"a", "a", true},
+		{"a", " a ", true},
+		{"a", "b", false},
+		{"a", "", false},
+	}
+	for _, tt := range tests {
+		origin := newTestOrigin(t, methodHandler)
+		tr := NewTransport(NewMemoryCache(time.Hour))
+		tr.KeyHeaders = []string{"x-tenant-id"}
+		mustGet(t, tr, origin.URL, "X-Tenant-Id", tt.first)
+		resp, _ := mustGet(t, tr, origin.URL, "X-Tenant-Id", tt.second)
+		if shared := resp.Header.Get(XFromCache) == "1"; shared != tt.shared {
+			t.Errorf("X-Tenant-Id %q then %q: shared an entry %v, want %v", tt.first, tt.second, shared, tt.shared)
+		}
+	}
+}
+
+func TestBucketFunc(t *testing.T) {
+	origin := newTestOrigin(t, methodHandler)
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.BucketFunc = func(req *http.Request) string {
+		if c, err := req.Cookie("exp"); err == nil {
+			return c.Value
+		}
+		return "control"
+	}
+
+	tests := []struct {
+		cookie    string
+		fromCache bool
+	}{
+		{"", false},
+		{"exp=b", false},
+		{"exp=b", true},
+		{"exp=control", true},
+		{"exp=c", false},
+	}
+	for _, tt := range tests {
+		if resp, _ := mustGet(t, tr, origin.URL, "Cookie", tt.cookie); (resp.Header.Get(XFromCache) == "1") != tt.fromCache {
+			t.Errorf("Cookie %q: from cache %q, want %v", tt.cookie, resp.Header.Get(XFromCache), tt.fromCache)
+		}
+	}
+}
+
+func TestKeyHostCase(t *testing.T) {
+	origin := newTestOrigin(t, methodHandler)
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	// every host is served by origin
+	tr.Transport = transportFunc(func(req *http.Request) (*http.Response, error) {
+		out := req.Clone(req.Context())
+		out.URL.Host = origin.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(out)
+	})
+
+	mustGet(t, tr, "http://api.example.com/x?b=2&a=1")
+	resp, _ := mustGet(t, tr, "http://API.Example.COM/x?a=1&b=2")
+	if resp.Header.Get(XFromCache) != "1" || origin.count() != 1 {
+		t.Errorf("hosts differing in case did not share an entry")
+	}
+}
@@ -0,0 +1,33 @@
+package httpcache
+
+import "log"
+
+// Logger receives the diagnostic messages of a Transport. Debugf is used for
+// routine events, such as a response being served from the cache, and Errorf
+// for failures the Transport recovered from, such as an unreadable entry.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// StdLogger is a Logger writing to a standard library *log.Logger, with error
+// messages prefixed by "[ERROR] "
+type StdLogger struct {
+	*log.Logger
+}
+
+// Debugf logs a routine event
+func (l StdLogger) Debugf(format string, args ...interface{}) {
+	l.Printf(format, args...)
+}
+
+// Errorf logs a failure
+func (l StdLogger) Errorf(format string, args ...interface{}) {
+	l.Printf("[ERROR] "+format, args...)
+}
+
+// nopLogger is the Logger used when none is set, discarding all messages
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
@@ -0,0 +1,30 @@
+package httpcache
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStdLogger(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	var buf bytes.Buffer
+	mem := NewMemoryCache(time.Hour)
+	tr := NewTransport(mem)
+	tr.Logger = StdLogger{log.New(&buf, "", 0)}
+	mustGet(t, tr, origin.URL)
+	mustGet(t, tr, origin.URL)
+	if want := "[from-cache] " + origin.URL + "\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	key := mem.Keys()[0]
+	mem.Set(key, []byte{entryVersion + 1})
+	mustGet(t, tr, origin.URL)
+	if want := "[ERROR] " + key + ": " + errUnsupportedEntry.Error() + "\n"; !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,166 @@
+// Package memcache provides a memcached interface for http caching, letting a
+// fleet of proxy instances share one cache.
+//
+// Entries too large for a memcached item are stored as several chunks, along
+// with an item at the entry's key pointing to them. Deleting or overwriting
+// such an entry only replaces that item: its previous chunks stay in
+// memcached, unreachable, until they expire after the cache's maxTTL or are
+// evicted.
+package memcache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// chunkSize is the largest value stored as a single memcached item, leaving
+// room for the item overhead under memcached's default 1MB item size limit
+const chunkSize = 1<<20 - 4<<10
+
+// Markers for the first byte of the item stored at an entry's key
+const (
+	// whole marks an item holding the entire entry
+	whole byte = iota
+	// chunked marks an item holding a chunk count and a generation, the
+	// entry being stored in that many other items
+	chunked
+)
+
+// Cache is an implementation of httpcache.Cache that stores responses in
+// memcached. Keys are hashed to fit memcached's key length and character
+// limits, and entries larger than an item can hold are split across several
+// items. Entries expire in memcached after maxTTL; errors talking to memcached
+// are reported as misses.
+type Cache struct {
+	client *memcache.Client
+	maxTTL time.Duration
+
+	// Prefix is prepended to the hashed keys to avoid collision with other
+	// data stored in memcached. It defaults to DefaultPrefix.
+	Prefix string
+}
+
+// DefaultPrefix is the default Cache.Prefix
+const DefaultPrefix = "httpcache:"
+
+// New returns a new Cache storing entries in the memcached servers for at most
+// maxTTL
+func New(maxTTL time.Duration, servers ...string) *Cache {
+	return NewWithClient(memcache.New(servers...), maxTTL)
+}
+
+// NewWithClient returns a new Cache storing entries for at most maxTTL using
+// client
+func NewWithClient(client *memcache.Client, maxTTL time.Duration) *Cache {
+	if maxTTL <= time.Duration(0) {
+		panic("maxTTL must be >0")
+	}
+	return &Cache{client: client, maxTTL: maxTTL, Prefix: DefaultPrefix}
+}
+
+// cacheKey modifies an httpcache key for use in memcached, which limits keys
+// to 250 bytes without spaces or control characters
+func (c *Cache) cacheKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.Prefix + hex.EncodeToString(sum[:])
+}
+
+// chunkKey returns the key of chunk i of generation gen of the entry at key
+func chunkKey(key string, gen uint64, i int) string {
+	return key + "-" + strconv.FormatUint(gen, 16) + "-" + strconv.Itoa(i)
+}
+
+// expiration returns the memcached expiration for new items, which is a
+// relative number of seconds up to 30 days and an absolute Unix time beyond
+func (c *Cache) expiration() int32 {
+	secs := int64(c.maxTTL / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	if secs > 30*24*60*60 {
+		return int32(time.Now().Unix() + secs)
+	}
+	return int32(secs)
+}
+
+// Get returns the response corresponding to key if present
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	k := c.cacheKey(key)
+	item, err := c.client.Get(k)
+	if err != nil || len(item.Value) == 0 {
+		return nil, false
+	}
+	switch item.Value[0] {
+	case whole:
+		return item.Value[1:], true
+	case chunked:
+	default:
+		return nil, false
+	}
+
+	if len(item.Value) != 1+16 {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint64(item.Value[1:9]))
+	gen := binary.BigEndian.Uint64(item.Value[9:17])
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = chunkKey(k, gen, i)
+	}
+	items, err := c.client.GetMulti(keys)
+	if err != nil {
+		return nil, false
+	}
+	for _, ck := range keys {
+		chunk, ok := items[ck]
+		if !ok {
+			return nil, false
+		}
+		resp = append(resp, chunk.Value...)
+	}
+	return resp, true
+}
+
+// Set saves a response to the cache as key
+func (c *Cache) Set(key string, resp []byte) {
+	k := c.cacheKey(key)
+	exp := c.expiration()
+	if len(resp) < chunkSize {
+		c.client.Set(&memcache.Item{Key: k, Value: append([]byte{whole}, resp...), Expiration: exp})
+		return
+	}
+
+	// chunks are written under a new generation before the item pointing to
+	// them, so readers never mix chunks from different writes
+	gen := uint64(time.Now().UnixNano())
+	n := 0
+	for ; len(resp) > 0; n++ {
+		size := chunkSize
+		if size > len(resp) {
+			size = len(resp)
+		}
+		if err := c.client.Set(&memcache.Item{Key: chunkKey(k, gen, n), Value: resp[:size], Expiration: exp}); err != nil {
+			return
+		}
+		resp = resp[size:]
+	}
+	head := make([]byte, 1+16)
+	head[0] = chunked
+	binary.BigEndian.PutUint64(head[1:9], uint64(n))
+	binary.BigEndian.PutUint64(head[9:17], gen)
+	c.client.Set(&memcache.Item{Key: k, Value: head, Expiration: exp})
+}
+
+// Delete removes the response with key from the cache. The chunks of a large
+// entry are left to expire, see the package documentation.
+func (c *Cache) Delete(key string) {
+	c.client.Delete(c.cacheKey(key))
+}
+
+var _ httpcache.Cache = (*Cache)(nil)
@@ -0,0 +1,140 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// testServer returns the address of a memcached server: that in
+// $MEMCACHED_ADDR if set, or else one started for the test if memcached is
+// installed. The test is skipped otherwise.
+func testServer(t *testing.T) string {
+	t.Helper()
+	if addr := os.Getenv("MEMCACHED_ADDR"); addr != "" {
+		return addr
+	}
+	bin, err := exec.LookPath("memcached")
+	if err != nil {
+		t.Skip("memcached is not installed and MEMCACHED_ADDR is not set")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	port := addr[strings.LastIndexByte(addr, ':')+1:]
+	ln.Close()
+
+	cmd := exec.Command(bin, "-l", "127.0.0.1", "-p", port, "-U", "0")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("memcached did not start on %s", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestCache returns a Cache on a test server, under a prefix of its own
+func newTestCache(t *testing.T) *Cache {
+	c := New(time.Hour, testServer(t))
+	c.Prefix = "test:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":"
+	return c
+}
+
+func TestCache(t *testing.T) {
+	c := newTestCache(t)
+	tests := []struct {
+		name, key string
+		value     []byte
+	}{
+		{"small", "https://example.com/a", []byte("hello")},
+		{"long key", "https://example.com/" + strings.Repeat("long key with spaces ", 20), []byte("long")},
+		{"exactly one chunk", "https://example.com/one", bytes.Repeat([]byte("o"), chunkSize)},
+		{"several chunks", "https://example.com/large", bytes.Repeat([]byte("0123456789"), (chunkSize*2+1)/10+1)[:chunkSize*2+1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := c.Get(tt.key); ok {
+				t.Fatal("got a hit before Set")
+			}
+			c.Set(tt.key, tt.value)
+			got, ok := c.Get(tt.key)
+			if !ok || !bytes.Equal(got, tt.value) {
+				t.Fatalf("got %d bytes, %v, want the %d bytes set", len(got), ok, len(tt.value))
+			}
+			c.Delete(tt.key)
+			if _, ok := c.Get(tt.key); ok {
+				t.Error("got a hit after Delete")
+			}
+		})
+	}
+}
+
+func TestCacheMissingChunk(t *testing.T) {
+	c := newTestCache(t)
+	key := "https://example.com/large"
+	c.Set(key, bytes.Repeat([]byte("x"), chunkSize*2+1))
+
+	k := c.cacheKey(key)
+	head, err := c.client.Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Value[0] != chunked || binary.BigEndian.Uint64(head.Value[1:9]) != 3 {
+		t.Fatalf("head item %x, want 3 chunks", head.Value)
+	}
+	gen := binary.BigEndian.Uint64(head.Value[9:17])
+	if err := c.client.Delete(chunkKey(k, gen, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(key); ok {
+		t.Error("got a hit with a chunk evicted, want a miss")
+	}
+}
+
+func TestCacheCorruptHead(t *testing.T) {
+	c := newTestCache(t)
+	key := "https://example.com/corrupt"
+	for _, v := range [][]byte{{chunked, 1, 2}, {0xff, 'x'}} {
+		if err := c.client.Set(&memcache.Item{Key: c.cacheKey(key), Value: v}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Get(key); ok {
+			t.Errorf("got a hit for item %x, want a miss", v)
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	if got := (&Cache{maxTTL: time.Hour}).expiration(); got != 3600 {
+		t.Errorf("got %d, want 3600 seconds", got)
+	}
+	if got := (&Cache{maxTTL: time.Millisecond}).expiration(); got != 1 {
+		t.Errorf("got %d, want at least 1 second", got)
+	}
+	ttl := 60 * 24 * time.Hour
+	want := time.Now().Add(ttl).Unix()
+	if got := int64((&Cache{maxTTL: ttl}).expiration()); got < want-1 || got > want+1 {
+		t.Errorf("got %d, want the absolute time %d beyond 30 days", got, want)
+	}
+}
@@ -1,7 +1,12 @@
 package httpcache
 
 import (
+	"container/list"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,10 +15,53 @@ type MemoryCache struct {
 	mu     sync.RWMutex
 	items  map[string][]byte
 	ts     map[string]time.Time
+	hits   map[string]*uint64
 	maxTTL time.Duration
+
+	// jitter is the fraction of maxTTL by which the lifetime of each entry is
+	// randomly lengthened or shortened, with the amount chosen for each key
+	// in offsets
+	jitter  float64
+	offsets map[string]time.Duration
+
+	// maxKeys caps the number of entries; 0 means unlimited
+	maxKeys int
+
+	// recency lists keys from most to least recently used, with an element
+	// for each key in elems. size is the total length of the stored entries,
+	// kept at or below maxBytes unless it is 0, and the number of entries is
+	// kept at or below maxEntries unless it is 0.
+	recency    *list.List
+	elems      map[string]*list.Element
+	size       int64
+	maxBytes   int64
+	maxEntries int
+
+	// pinned holds the keys set by Pin, whose entries are never evicted to
+	// make room, whether or not they are stored
+	pinned map[string]bool
+
+	// evictions counts entries removed other than by Delete, and expirations
+	// those of them removed because they expired, atomically
+	evictions   uint64
+	expirations uint64
+
+	// janitorMu guards stopJanitor, which is non-nil while a janitor started
+	// by StartJanitor is running
+	janitorMu   sync.Mutex
+	stopJanitor chan chan struct{}
+}
+
+// KeyStat describes the popularity of a single cache entry
+type KeyStat struct {
+	Key  string
+	Hits uint64
+	Age  time.Duration
 }
 
 // NewMemoryCache returns a new Cache that will store items in an in-memory map
+// for at most maxTTL. A Transport stops serving responses earlier if their own
+// freshness information (max-age, Expires) says so.
 func NewMemoryCache(maxTTL time.Duration) *MemoryCache {
 	if maxTTL <= time.Duration(0) {
 		panic("maxTTL must be >0")
@@ -21,38 +69,398 @@ func NewMemoryCache(maxTTL time.Duration) *MemoryCache {
 	c := &MemoryCache{
 		items:  make(map[string][]byte),
 		ts:     make(map[string]time.Time),
+		hits:   make(map[string]*uint64),
 		maxTTL: maxTTL,
+
+		offsets: make(map[string]time.Duration),
+
+		recency: list.New(),
+		elems:   make(map[string]*list.Element),
 	}
 	return c
 }
 
+// NewMemoryCacheWithSize returns a new MemoryCache like NewMemoryCache that
+// also holds at most maxBytes of entries, evicting the least recently used
+// ones to make room for new entries. Entries larger than maxBytes are not
+// stored.
+func NewMemoryCacheWithSize(maxTTL time.Duration, maxBytes int64) *MemoryCache {
+	c := NewMemoryCache(maxTTL)
+	c.maxBytes = maxBytes
+	return c
+}
+
+// NewLRUCache returns a new MemoryCache like NewMemoryCache that holds at most
+// maxEntries entries and maxBytes of entries, evicting the least recently used
+// ones to make room for new entries. Either limit may be 0 for none.
+func NewLRUCache(maxTTL time.Duration, maxEntries int, maxBytes int64) *MemoryCache {
+	c := NewMemoryCacheWithSize(maxTTL, maxBytes)
+	c.maxEntries = maxEntries
+	return c
+}
+
+// NewMemoryCacheWithJitter returns a new MemoryCache like NewMemoryCache whose
+// entries each expire at a random point within ±jitterFraction of maxTTL, so
+// that entries stored together don't all expire together
+func NewMemoryCacheWithJitter(maxTTL time.Duration, jitterFraction float64) *MemoryCache {
+	if jitterFraction < 0 || jitterFraction >= 1 {
+		panic("jitterFraction must be >=0 and <1")
+	}
+	c := NewMemoryCache(maxTTL)
+	c.jitter = jitterFraction
+	return c
+}
+
 // Get returns the []byte representation of the response and true if present, false if not
 func (c *MemoryCache) Get(key string) (resp []byte, ok bool) {
 	c.mu.RLock()
 	resp, ok = c.items[key]
-	ts := c.ts[key]
+	expired := ok && c.expired(key, c.ts[key])
+	if ok && !expired {
+		atomic.AddUint64(c.hits[key], 1)
+	}
+	bounded := c.maxBytes > 0 || c.maxEntries > 0
 	c.mu.RUnlock()
 
-	if ok && time.Since(ts) > c.maxTTL {
-		c.Delete(key)
+	if expired {
+		c.mu.Lock()
+		if ts, ok := c.ts[key]; ok && c.expired(key, ts) {
+			c.expire(key)
+		}
+		c.mu.Unlock()
 		return nil, false
 	}
 
+	if ok && bounded {
+		// recency only matters for eviction, so only pay for the write lock
+		// when there is a size limit
+		c.mu.Lock()
+		if e, ok := c.elems[key]; ok {
+			c.recency.MoveToFront(e)
+		}
+		c.mu.Unlock()
+	}
+
 	return resp, ok
 }
 
-// Set saves response resp to the cache with key
+// Set saves response resp to the cache with key. Responses for new keys are
+// not stored once the cache holds as many entries as allowed by SetMaxKeys.
+// If the cache was created by NewMemoryCacheWithSize or NewLRUCache, the least
+// recently used entries that aren't pinned are evicted as needed to keep it
+// within its limits.
 func (c *MemoryCache) Set(key string, resp []byte) {
 	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, exists := c.items[key]
+	if !exists && c.maxKeys > 0 && len(c.items) >= c.maxKeys {
+		return
+	}
+	if c.maxBytes > 0 && int64(len(resp)) > c.maxBytes {
+		// don't leave the previous response for key in place either
+		c.remove(key)
+		return
+	}
+	grow := int64(len(resp) - len(old))
+	for e := c.recency.Back(); e != nil && c.overLimit(exists, grow); {
+		prev := e.Prev()
+		if k := e.Value.(string); k != key && !c.pinned[k] {
+			c.evict(k)
+		}
+		e = prev
+	}
+	if c.overLimit(exists, grow) {
+		// the pinned entries leave no room for it
+		c.remove(key)
+		return
+	}
+
 	c.ts[key] = time.Now()
+	if c.jitter > 0 {
+		c.offsets[key] = time.Duration((2*rand.Float64() - 1) * c.jitter * float64(c.maxTTL))
+	}
 	c.items[key] = resp
+	c.size += int64(len(resp)) - int64(len(old))
+	if _, ok := c.hits[key]; !ok {
+		c.hits[key] = new(uint64)
+	}
+	if e, ok := c.elems[key]; ok {
+		c.recency.MoveToFront(e)
+	} else {
+		c.elems[key] = c.recency.PushFront(key)
+	}
+}
+
+// Pin exempts the entry for key from being evicted to keep the cache within
+// its limits, whether it is stored already or later, until Unpin is called.
+// Pinned entries still expire, can be deleted, and count towards the limits:
+// entries that can only fit by evicting pinned ones are not stored.
+func (c *MemoryCache) Pin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pinned == nil {
+		c.pinned = make(map[string]bool)
+	}
+	c.pinned[key] = true
+}
+
+// Unpin makes the entry for key evictable again
+func (c *MemoryCache) Unpin(key string) {
+	c.mu.Lock()
+	delete(c.pinned, key)
+	c.mu.Unlock()
+}
+
+// overLimit returns true if storing an entry would take the cache past its
+// limits, given whether it replaces an existing one and by how much it grows
+// the total size; c.mu must be held
+func (c *MemoryCache) overLimit(replaces bool, grow int64) bool {
+	if c.maxBytes > 0 && c.size+grow > c.maxBytes {
+		return true
+	}
+	return c.maxEntries > 0 && !replaces && len(c.items) >= c.maxEntries
+}
+
+// SetMaxTTL changes the maximum age of entries. It takes effect immediately,
+// including for entries stored before the change. The jitter of entries
+// already stored stays relative to the previous maxTTL.
+func (c *MemoryCache) SetMaxTTL(maxTTL time.Duration) {
+	if maxTTL <= time.Duration(0) {
+		panic("maxTTL must be >0")
+	}
+	c.mu.Lock()
+	c.maxTTL = maxTTL
+	c.mu.Unlock()
+}
+
+// SetMaxKeys caps the number of entries in the cache at n. Once the cap is
+// reached, new keys are not admitted, rather than evicting existing entries.
+// Expired entries count towards the cap until they are removed. n <= 0 removes
+// the cap.
+func (c *MemoryCache) SetMaxKeys(n int) {
+	c.mu.Lock()
+	c.maxKeys = n
 	c.mu.Unlock()
 }
 
 // Delete removes key from the cache
 func (c *MemoryCache) Delete(key string) {
 	c.mu.Lock()
+	c.remove(key)
+	c.mu.Unlock()
+}
+
+// DeletePrefix removes every entry whose key starts with prefix, and returns
+// the number of entries removed
+func (c *MemoryCache) DeletePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(key)
+			n++
+		}
+	}
+	return n
+}
+
+// remove deletes key from the cache; c.mu must be held for writing
+func (c *MemoryCache) remove(key string) {
+	if e, ok := c.elems[key]; ok {
+		c.recency.Remove(e)
+		delete(c.elems, key)
+	}
+	c.size -= int64(len(c.items[key]))
 	delete(c.ts, key)
 	delete(c.items, key)
-	c.mu.Unlock()
+	delete(c.hits, key)
+	delete(c.offsets, key)
+}
+
+// expired returns true if the entry for key, stored at ts, has outlived its
+// TTL; c.mu must be held
+func (c *MemoryCache) expired(key string, ts time.Time) bool {
+	return time.Since(ts) > c.maxTTL+c.offsets[key]
+}
+
+// evict removes key from the cache to make room or because it expired; c.mu
+// must be held for writing
+func (c *MemoryCache) evict(key string) {
+	c.remove(key)
+	atomic.AddUint64(&c.evictions, 1)
+}
+
+// expire removes key from the cache because it expired; c.mu must be held
+// for writing
+func (c *MemoryCache) expire(key string) {
+	c.evict(key)
+	atomic.AddUint64(&c.expirations, 1)
+}
+
+// Evictions returns the number of entries removed because they expired or to
+// keep the cache within its size, rather than by Delete
+func (c *MemoryCache) Evictions() uint64 {
+	return atomic.LoadUint64(&c.evictions)
+}
+
+// Expirations returns the number of entries removed because they expired,
+// whether by the janitor, Sweep or as they were requested
+func (c *MemoryCache) Expirations() uint64 {
+	return atomic.LoadUint64(&c.expirations)
+}
+
+// Keys returns the keys of all unexpired entries, sorted
+func (c *MemoryCache) Keys() []string {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.items))
+	for key, ts := range c.ts {
+		if !c.expired(key, ts) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Len returns the number of stored entries, including any that have expired
+// but not yet been removed
+func (c *MemoryCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// Size returns the total length of the stored entries, including any that have
+// expired but not yet been removed
+func (c *MemoryCache) Size() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.size
+}
+
+// TopN returns stats for the n most requested entries, ordered by descending
+// hit count and then by key
+func (c *MemoryCache) TopN(n int) []KeyStat {
+	c.mu.RLock()
+	stats := make([]KeyStat, 0, len(c.items))
+	for key := range c.items {
+		stats = append(stats, KeyStat{
+			Key:  key,
+			Hits: atomic.LoadUint64(c.hits[key]),
+			Age:  time.Since(c.ts[key]),
+		})
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Hits != stats[j].Hits {
+			return stats[i].Hits > stats[j].Hits
+		}
+		return stats[i].Key < stats[j].Key
+	})
+
+	if n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// sweepBatch is the number of expired entries removed by the janitor each time
+// it takes the write lock, so that a large sweep doesn't stall other callers
+const sweepBatch = 256
+
+// StartJanitor starts a goroutine that removes expired entries every
+// interval, so that entries which are never requested again don't stay in
+// memory. Call Stop or Close to end it. Calling StartJanitor again replaces
+// the running janitor, e.g. to change its interval.
+func (c *MemoryCache) StartJanitor(interval time.Duration) {
+	if interval <= time.Duration(0) {
+		panic("interval must be >0")
+	}
+	c.janitorMu.Lock()
+	defer c.janitorMu.Unlock()
+	c.stopJanitorLocked()
+
+	stop := make(chan chan struct{})
+	c.stopJanitor = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.sweep()
+			case done := <-stop:
+				close(done)
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the janitor started by StartJanitor, if any, and waits for it to
+// exit
+func (c *MemoryCache) Stop() {
+	c.janitorMu.Lock()
+	defer c.janitorMu.Unlock()
+	c.stopJanitorLocked()
+}
+
+// Close ends the janitor started by StartJanitor, if any, like Stop. The
+// cache may still be used.
+func (c *MemoryCache) Close() error {
+	c.Stop()
+	return nil
+}
+
+func (c *MemoryCache) stopJanitorLocked() {
+	if c.stopJanitor == nil {
+		return
+	}
+	done := make(chan struct{})
+	c.stopJanitor <- done
+	<-done
+	c.stopJanitor = nil
+}
+
+// Sweep removes all expired entries now, as the janitor does, and returns the
+// number of entries removed
+func (c *MemoryCache) Sweep() int {
+	return c.sweep()
+}
+
+// sweep removes all expired entries. They are found under the read lock and
+// removed in batches of sweepBatch under the write lock, rechecking each one
+// in case it was stored again in between.
+func (c *MemoryCache) sweep() int {
+	c.mu.RLock()
+	var expired []string
+	for key, ts := range c.ts {
+		if c.expired(key, ts) {
+			expired = append(expired, key)
+		}
+	}
+	c.mu.RUnlock()
+
+	removed := 0
+	for len(expired) > 0 {
+		n := sweepBatch
+		if n > len(expired) {
+			n = len(expired)
+		}
+		c.mu.Lock()
+		for _, key := range expired[:n] {
+			if ts, ok := c.ts[key]; ok && c.expired(key, ts) {
+				c.expire(key)
+				removed++
+			}
+		}
+		c.mu.Unlock()
+		expired = expired[n:]
+	}
+	return removed
 }
@@ -0,0 +1,331 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryCachePin(t *testing.T) {
+	tests := []struct {
+		name   string
+		pin    []string
+		unpin  []string
+		limits [2]int // maxEntries, maxBytes
+		set    []string
+		want   []string
+	}{
+		{name: "unpinned", limits: [2]int{0, 30}, set: []string{"a", "b", "c", "d"}, want: []string{"b", "c", "d"}},
+		{name: "pinned", pin: []string{"a"}, limits: [2]int{0, 30}, set: []string{"a", "b", "c", "d"}, want: []string{"a", "c", "d"}},
+		{name: "pinned before stored", pin: []string{"d"}, limits: [2]int{0, 30}, set: []string{"a", "b", "c", "d", "e"}, want: []string{"c", "d", "e"}},
+		{name: "all pinned", pin: []string{"a", "b", "c"}, limits: [2]int{0, 30}, set: []string{"a", "b", "c", "d"}, want: []string{"a", "b", "c"}},
+		{name: "unpinned again", pin: []string{"a"}, unpin: []string{"a"}, limits: [2]int{0, 30}, set: []string{"a", "b", "c", "d"}, want: []string{"b", "c", "d"}},
+		{name: "max entries", pin: []string{"a"}, limits: [2]int{2, 0}, set: []string{"a", "b", "c"}, want: []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRUCache(time.Hour, tt.limits[0], int64(tt.limits[1]))
+			for _, k := range tt.pin {
+				c.Pin(k)
+			}
+			for _, k := range tt.unpin {
+				c.Unpin(k)
+			}
+			for _, k := range tt.set {
+				c.Set(k, bytes.Repeat([]byte(k), 10))
+			}
+			if got := c.Keys(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Keys() = %q, want %q", got, tt.want)
+			}
+			if max := int64(tt.limits[1]); max > 0 && c.Size() > max {
+				t.Errorf("Size() = %d, over the limit of %d", c.Size(), max)
+			}
+		})
+	}
+}
+
+func TestMemoryCachePinnedStillExpire(t *testing.T) {
+	c := NewMemoryCacheWithSize(10*time.Millisecond, 100)
+	c.Pin("a")
+	c.Set("a", []byte("a"))
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("pinned entry outlived its TTL")
+	}
+
+	c.Set("a", []byte("a"))
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("pinned entry survived Delete")
+	}
+}
+
+func TestMemoryCacheConcurrentSize(t *testing.T) {
+	const (
+		workers = 8
+		ops     = 2000
+		keys    = 64
+	)
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+		pin        bool
+	}{
+		{name: "unbounded"},
+		{name: "max bytes", maxBytes: 4096},
+		{name: "max entries", maxEntries: 16},
+		{name: "both", maxEntries: 16, maxBytes: 2048},
+		{name: "pinned", maxBytes: 4096, pin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRUCache(time.Hour, tt.maxEntries, tt.maxBytes)
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(seed int64) {
+					defer wg.Done()
+					rnd := rand.New(rand.NewSource(seed))
+					for i := 0; i < ops; i++ {
+						key := fmt.Sprintf("k%d", rnd.Intn(keys))
+						switch n := rnd.Intn(20); {
+						case n == 0:
+							c.Delete(key)
+						case n == 1:
+							c.DeletePrefix(key)
+						case n == 2 && tt.pin:
+							c.Pin(key)
+						case n == 3 && tt.pin:
+							c.Unpin(key)
+						case n < 8:
+							c.Get(key)
+						default:
+							c.Set(key, make([]byte, 1+rnd.Intn(300)))
+						}
+					}
+				}(int64(w))
+			}
+			wg.Wait()
+
+			var sum int64
+			stored := c.Keys()
+			for _, key := range stored {
+				b, ok := c.Get(key)
+				if !ok {
+					t.Fatalf("listed key %q not found", key)
+				}
+				sum += int64(len(b))
+			}
+			if size := c.Size(); size != sum {
+				t.Errorf("Size() = %d, want the %d bytes of the %d entries stored", size, sum, len(stored))
+			}
+			if n := c.Len(); n != len(stored) {
+				t.Errorf("Len() = %d, want %d", n, len(stored))
+			}
+			if tt.maxBytes > 0 && sum > tt.maxBytes {
+				t.Errorf("%d bytes stored, over the limit of %d", sum, tt.maxBytes)
+			}
+			if tt.maxEntries > 0 && len(stored) > tt.maxEntries {
+				t.Errorf("%d entries stored, over the limit of %d", len(stored), tt.maxEntries)
+			}
+			if n := c.recency.Len(); n != len(stored) {
+				t.Errorf("%d keys in the recency list, want %d", n, len(stored))
+			}
+		})
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	// each op is "set k" storing 10 bytes at k, "big k" storing 40, or
+	// "get k"
+	tests := []struct {
+		name          string
+		maxEntries    int
+		maxBytes      int64
+		ops           []string
+		want          []string
+		wantEvictions uint64
+	}{
+		{name: "no limits", ops: []string{"set a", "set b", "set c", "set d"}, want: []string{"a", "b", "c", "d"}},
+		{name: "max entries", maxEntries: 2, ops: []string{"set a", "set b", "set c"}, want: []string{"b", "c"}, wantEvictions: 1},
+		{name: "max bytes", maxBytes: 30, ops: []string{"set a", "set b", "set c", "set d"}, want: []string{"b", "c", "d"}, wantEvictions: 1},
+		{name: "get refreshes", maxEntries: 2, ops: []string{"set a", "set b", "get a", "set c"}, want: []string{"a", "c"}, wantEvictions: 1},
+		{name: "set refreshes", maxEntries: 2, ops: []string{"set a", "set b", "set a", "set c"}, want: []string{"a", "c"}, wantEvictions: 1},
+		{name: "replacing doesn't evict", maxEntries: 2, ops: []string{"set a", "set b", "set b", "set b"}, want: []string{"a", "b"}},
+		{name: "growing evicts", maxBytes: 45, ops: []string{"set a", "set b", "big b"}, want: []string{"b"}, wantEvictions: 1},
+		{name: "both limits", maxEntries: 3, maxBytes: 50, ops: []string{"set a", "set b", "set c", "big d"}, want: []string{"c", "d"}, wantEvictions: 2},
+		{name: "too big", maxBytes: 30, ops: []string{"set a", "big a"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRUCache(time.Hour, tt.maxEntries, tt.maxBytes)
+			for _, op := range tt.ops {
+				var verb, k string
+				fmt.Sscan(op, &verb, &k)
+				switch verb {
+				case "set":
+					c.Set(k, bytes.Repeat([]byte(k), 10))
+				case "big":
+					c.Set(k, bytes.Repeat([]byte(k), 40))
+				case "get":
+					c.Get(k)
+				}
+			}
+			if got := c.Keys(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Keys() = %q, want %q", got, tt.want)
+			}
+			if got := c.Evictions(); got != tt.wantEvictions {
+				t.Errorf("Evictions() = %d, want %d", got, tt.wantEvictions)
+			}
+			var size int64
+			for _, k := range c.Keys() {
+				v, _ := c.Get(k)
+				size += int64(len(v))
+			}
+			if c.Size() != size {
+				t.Errorf("Size() = %d, want %d", c.Size(), size)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheSweep(t *testing.T) {
+	c := NewMemoryCache(20 * time.Millisecond)
+	for i := 0; i < sweepBatch+10; i++ {
+		c.Set(fmt.Sprintf("old%d", i), []byte("x"))
+	}
+	time.Sleep(30 * time.Millisecond)
+	c.Set("new", []byte("x"))
+
+	if n := c.Sweep(); n != sweepBatch+10 {
+		t.Errorf("Sweep() = %d, want %d", n, sweepBatch+10)
+	}
+	if got := c.Keys(); !reflect.DeepEqual(got, []string{"new"}) {
+		t.Errorf("Keys() = %q, want only the unexpired entry", got)
+	}
+	if c.Len() != 1 || c.Size() != 1 {
+		t.Errorf("Len() = %d, Size() = %d, want 1, 1", c.Len(), c.Size())
+	}
+	if got := c.Expirations(); got != sweepBatch+10 {
+		t.Errorf("Expirations() = %d, want %d", got, sweepBatch+10)
+	}
+}
+
+func TestMemoryCacheJanitor(t *testing.T) {
+	c := NewMemoryCache(10 * time.Millisecond)
+	c.StartJanitor(5 * time.Millisecond)
+	// replacing the janitor leaves a single one running
+	c.StartJanitor(5 * time.Millisecond)
+	c.Set("a", []byte("a"))
+
+	deadline := time.Now().Add(time.Second)
+	for c.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("janitor left %d expired entries", c.Len())
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// stopped, it no longer removes entries, which still expire on Get
+	c.Set("b", []byte("b"))
+	time.Sleep(30 * time.Millisecond)
+	if c.Len() != 1 {
+		t.Errorf("Len() = %d after Close, want 1", c.Len())
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expired entry served")
+	}
+	c.Stop()
+}
+
+func TestMemoryCacheTopN(t *testing.T) {
+	c := NewMemoryCache(time.Hour)
+	for _, k := range []string{"a", "b", "c"} {
+		c.Set(k, []byte(k))
+	}
+	for _, k := range []string{"b", "c", "b", "x", "b"} {
+		c.Get(k)
+	}
+	// replacing an entry keeps its hits
+	c.Set("b", []byte("b2"))
+
+	var got []string
+	for _, s := range c.TopN(2) {
+		got = append(got, fmt.Sprintf("%s:%d", s.Key, s.Hits))
+	}
+	if want := []string{"b:3", "c:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TopN(2) = %q, want %q", got, want)
+	}
+	if all := c.TopN(10); len(all) != 3 || all[2].Key != "a" || all[2].Hits != 0 {
+		t.Errorf("TopN(10) = %+v, want every entry, a last", all)
+	}
+}
+
+func TestMemoryCacheKeysSorted(t *testing.T) {
+	c := NewMemoryCache(time.Hour)
+	for _, k := range []string{"//h/z", "//h/a?b=1", "//h/m", "//h/a"} {
+		c.Set(k, []byte(k))
+	}
+	want := []string{"//h/a", "//h/a?b=1", "//h/m", "//h/z"}
+	for i := 0; i < 3; i++ {
+		if got := c.Keys(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Keys() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestMemoryCacheMaxKeys(t *testing.T) {
+	c := NewMemoryCache(time.Hour)
+	c.SetMaxKeys(2)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Set("c", []byte("3"))
+	// existing keys can still be replaced
+	c.Set("a", []byte("4"))
+	if got, _ := c.Get("a"); !reflect.DeepEqual(c.Keys(), []string{"a", "b"}) || string(got) != "4" {
+		t.Errorf("Keys() = %q, a = %q, want a new key refused and a replaced", c.Keys(), got)
+	}
+
+	c.Delete("b")
+	c.Set("c", []byte("3"))
+	c.SetMaxKeys(0)
+	c.Set("d", []byte("5"))
+	if want := []string{"a", "c", "d"}; !reflect.DeepEqual(c.Keys(), want) {
+		t.Errorf("Keys() = %q, want %q", c.Keys(), want)
+	}
+}
+
+func TestMemoryCacheSetMaxTTL(t *testing.T) {
+	c := NewMemoryCache(time.Hour)
+	c.Set("old", []byte("1"))
+	time.Sleep(100 * time.Millisecond)
+	c.Set("new", []byte("2"))
+
+	// shortening the TTL expires entries stored before the change
+	c.SetMaxTTL(50 * time.Millisecond)
+	if _, ok := c.Get("old"); ok {
+		t.Errorf("entry older than the new maxTTL still served")
+	}
+	if _, ok := c.Get("new"); !ok {
+		t.Errorf("entry within the new maxTTL expired")
+	}
+
+	// lengthening it revives entries not yet removed
+	c.Set("revived", []byte("3"))
+	time.Sleep(100 * time.Millisecond)
+	c.SetMaxTTL(time.Hour)
+	if _, ok := c.Get("revived"); !ok {
+		t.Errorf("entry within the lengthened maxTTL expired")
+	}
+	if n := c.Expirations(); n != 1 {
+		t.Errorf("Expirations() = %d, want 1", n)
+	}
+}
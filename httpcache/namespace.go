@@ -0,0 +1,65 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+)
+
+// namespaceKey returns key put in the namespace ns, see
+// Transport.KeyPrefixFunc. Requests in the empty namespace keep their key.
+func namespaceKey(ns, key string) string {
+	if ns == "" {
+		return key
+	}
+	return namespacePrefix(ns) + key
+}
+
+// namespacePrefix returns the prefix of the keys in the namespace ns, escaped
+// so that no namespace is a prefix of another's keys
+func namespacePrefix(ns string) string {
+	return "ns=" + url.QueryEscape(ns) + " "
+}
+
+// KeyPrefixHeader returns a Transport.KeyPrefixFunc putting requests in the
+// namespace named by their header name, e.g. X-Tenant-Id. Requests without
+// it are put in the empty namespace, shared by all of them.
+func KeyPrefixHeader(name string) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// KeyPrefixCredentials is a Transport.KeyPrefixFunc putting requests in a
+// namespace per Authorization header, named after a hash of it so that the
+// credentials don't appear in cache keys. Requests without one are put in the
+// empty namespace, shared by all of them.
+func KeyPrefixCredentials(req *http.Request) string {
+	v := req.Header.Get("Authorization")
+	if v == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:16])
+}
+
+// PurgeNamespace removes every cached response in the namespace ns, as
+// returned by KeyPrefixFunc, and returns the number of entries removed. Like
+// PurgePrefix, it needs a Cache with a DeletePrefix method or a
+// ListableCache; with other Caches it removes nothing.
+func (t *Transport) PurgeNamespace(ns string) int {
+	if ns == "" {
+		return 0
+	}
+	return t.deletePrefix(namespacePrefix(ns))
+}
+
+// purgeInNamespace removes the cached response for u in the namespace ns,
+// like Purge for a request in ns
+func (t *Transport) purgeInNamespace(ns string, u *url.URL) {
+	req := &http.Request{Method: "GET", URL: u, Header: make(http.Header)}
+	if key, ok := t.baseKey(req); ok {
+		t.cacheDelete(req.Context(), ToCacheCtx(t.cache()), namespaceKey(ns, key))
+	}
+}
@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyPrefixNamespaces(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "tenant %q", r.Header.Get("X-Tenant-Id"))
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.KeyPrefixFunc = KeyPrefixHeader("X-Tenant-Id")
+
+	tenants := []string{"a", "a b", "b", ""}
+	for _, tenant := range tenants {
+		mustGet(t, tr, origin.URL, "X-Tenant-Id", tenant)
+	}
+	check := func(when string, wantHits map[string]bool) {
+		t.Helper()
+		for _, tenant := range tenants {
+			resp, body := mustGet(t, tr, origin.URL, "X-Tenant-Id", tenant)
+			if want := fmt.Sprintf("tenant %q", tenant); body != want {
+				t.Errorf("%s: tenant %q got %s, want %s", when, tenant, body, want)
+			}
+			if hit := resp.Header.Get(XFromCache) == "1"; hit != wantHits[tenant] {
+				t.Errorf("%s: tenant %q served from the cache = %v, want %v", when, tenant, hit, wantHits[tenant])
+			}
+		}
+	}
+	check("stored", map[string]bool{"a": true, "a b": true, "b": true, "": true})
+
+	if n := tr.PurgeNamespace("a"); n != 1 {
+		t.Errorf("PurgeNamespace(a) = %d, want 1", n)
+	}
+	if n := tr.PurgeNamespace(""); n != 0 {
+		t.Errorf("PurgeNamespace of the empty namespace = %d, want 0", n)
+	}
+	check("purged a", map[string]bool{"a": false, "a b": true, "b": true, "": true})
+}
+
+func TestKeyPrefixCredentials(t *testing.T) {
+	ns := func(auth string) string {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return KeyPrefixCredentials(req)
+	}
+	if got := ns(""); got != "" {
+		t.Errorf("no credentials: namespace %q, want the empty one", got)
+	}
+	one, two := ns("Bearer one"), ns("Bearer two")
+	if len(one) != 32 || strings.Contains(one, "one") {
+		t.Errorf("namespace %q isn't a hash of the credentials", one)
+	}
+	if one == two || one != ns("Bearer one") {
+		t.Errorf("namespaces %q and %q don't tell the credentials apart", one, two)
+	}
+}
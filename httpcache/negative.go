@@ -0,0 +1,55 @@
+package httpcache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxNegativeTracked bounds the number of keys whose consecutive error
+// responses are counted for NegativeBackoffMax. Keys failing once the bound is
+// reached get the TTLs of StatusTTLs as they are.
+const maxNegativeTracked = 10000
+
+// negatives counts the consecutive error responses stored for each key, see
+// NegativeBackoffMax
+type negatives struct {
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+// ttl records that an error response with a status TTL of base is stored for
+// key, and returns how long it stays fresh: base for the first one, then
+// twice as long as the previous one with up to a tenth added at random, at
+// most max
+func (n *negatives) ttl(key string, base, max time.Duration) time.Duration {
+	n.mu.Lock()
+	if n.failures == nil {
+		n.failures = make(map[string]int)
+	}
+	failures, ok := n.failures[key]
+	if ok || len(n.failures) < maxNegativeTracked {
+		failures++
+		n.failures[key] = failures
+	}
+	n.mu.Unlock()
+
+	ttl := base
+	for i := 1; i < failures && ttl < max; i++ {
+		ttl *= 2
+	}
+	if failures > 1 {
+		ttl += time.Duration(rand.Int63n(int64(ttl)/10 + 1))
+	}
+	if ttl > max {
+		ttl = max
+	}
+	return ttl
+}
+
+// reset forgets the error responses stored for key
+func (n *negatives) reset(key string) {
+	n.mu.Lock()
+	delete(n.failures, key)
+	n.mu.Unlock()
+}
@@ -0,0 +1,69 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNegativeBackoff(t *testing.T) {
+	const base, max = 10 * time.Second, time.Minute
+	steps := []struct {
+		status   int
+		min, max time.Duration // of the TTL of the stored response
+	}{
+		{http.StatusServiceUnavailable, base, base},
+		{http.StatusServiceUnavailable, 2 * base, 2 * base * 11 / 10},
+		{http.StatusServiceUnavailable, 4 * base, 4 * base * 11 / 10},
+		{http.StatusServiceUnavailable, max, max},
+		{http.StatusServiceUnavailable, max, max},
+		// a success starts the key over
+		{http.StatusOK, 0, 0},
+		{http.StatusServiceUnavailable, base, base},
+		{http.StatusNotFound, 2 * base, 2 * base * 11 / 10},
+	}
+
+	origin := newTestOrigin(t, nil)
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.StatusTTLs = map[int]time.Duration{http.StatusServiceUnavailable: base, http.StatusNotFound: base}
+	tr.NegativeBackoffMax = max
+
+	for i, s := range steps {
+		origin.set(statusHandler(s.status, ""))
+		// past the stored response, to the origin
+		resp, _ := mustGet(t, tr, origin.URL, "Cache-Control", "no-cache")
+		if resp.StatusCode != s.status {
+			t.Fatalf("step %d: status = %d, want %d", i, resp.StatusCode, s.status)
+		}
+		if s.status < 400 {
+			continue
+		}
+
+		req := httptest.NewRequest("GET", origin.URL, nil)
+		key, _ := tr.key(req)
+		expires, ok := tr.expiry(ToCacheCtx(tr.Cache), key, req.WithContext(context.Background()))
+		if !ok {
+			t.Fatalf("step %d: response not stored", i)
+		}
+		ttl := time.Until(expires)
+		if ttl < s.min-time.Second || ttl > s.max {
+			t.Errorf("step %d: TTL = %s, want between %s and %s", i, ttl.Round(time.Millisecond), s.min, s.max)
+		}
+	}
+}
+
+func TestNegativeBackoffTracked(t *testing.T) {
+	var n negatives
+	for i := 0; i < maxNegativeTracked+10; i++ {
+		n.ttl(fmt.Sprint(i), time.Second, time.Minute)
+	}
+	if len(n.failures) != maxNegativeTracked {
+		t.Errorf("%d keys tracked, want %d", len(n.failures), maxNegativeTracked)
+	}
+	if ttl := n.ttl(fmt.Sprint(maxNegativeTracked+5), time.Second, time.Minute); ttl != time.Second {
+		t.Errorf("TTL of an untracked key = %s, want the base TTL", ttl)
+	}
+}
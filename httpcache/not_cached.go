@@ -0,0 +1,286 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Reasons a response was not stored, as reported by RequestInfo.NotCached and
+// Transport.NotCachedHeader
+const (
+	// NotCachedDisabled means caching was turned off by SetEnabled
+	NotCachedDisabled = "disabled"
+	// NotCachedContext means the request was made with a context from
+	// WithNoCache
+	NotCachedContext = "context"
+	// NotCachedMethod means the request method is not in CacheableMethods, or
+	// is HEAD, whose requests are only ever answered from stored GET responses
+	NotCachedMethod = "method"
+	// NotCachedRange means the request asked for part of a resource whose
+	// complete response wasn't stored and fresh, so it was passed through
+	NotCachedRange = "range"
+	// NotCachedStreaming means the request asked to upgrade the connection,
+	// e.g. to a WebSocket, or for a stream of server-sent events, or the
+	// response is one
+	NotCachedStreaming = "streaming"
+	// NotCachedRule means the Cacheable hook declined the request or response
+	NotCachedRule = "rule"
+	// NotCachedPolicy means the Policy for the request disables caching
+	NotCachedPolicy = "policy"
+	// NotCachedNoKey means the KeyBuilder declined to key the request
+	NotCachedNoKey = "no-key"
+	// NotCachedSampling means the request fell outside SampleRate
+	NotCachedSampling = "sampling"
+	// NotCachedNoStore means the request or response had Cache-Control:
+	// no-store
+	NotCachedNoStore = "no-store"
+	// NotCachedPrivate means a shared Transport received a private response
+	NotCachedPrivate = "private"
+	// NotCachedImplicit means a StrictSharedCaching Transport received a
+	// response with neither explicit freshness nor a validator
+	NotCachedImplicit = "implicit"
+	// NotCachedRestricted means the request carried credentials and the
+	// response was not explicitly shareable
+	NotCachedRestricted = "restricted"
+	// NotCachedSetCookie means the response set a cookie and was not
+	// explicitly shareable
+	NotCachedSetCookie = "set-cookie"
+	// NotCachedVary means the response had Vary: *, so no request can be
+	// known to match it
+	NotCachedVary = "vary"
+	// NotCachedStatus means the response status can't be stored, such as 206
+	// Partial Content, or is not in CacheableStatusCodes
+	NotCachedStatus = "uncacheable-status"
+	// NotCachedAdmission means the Admitter rejected the response
+	NotCachedAdmission = "admission"
+	// NotCachedContentType means the response's media type isn't listed in
+	// CacheableContentTypes
+	NotCachedContentType = "content-type"
+	// NotCachedOversize means the response body was larger than MaxBodyBytes
+	NotCachedOversize = "oversize"
+	// NotCachedBackend means the Cache failed to store the response
+	NotCachedBackend = "backend-error"
+	// NotCachedSerialize means the response could not be serialized
+	NotCachedSerialize = "serialize-error"
+)
+
+// requestNotCacheable returns why a response to req may not be looked up in
+// or stored to the cache, or "" if it may
+func (t *Transport) requestNotCacheable(req *http.Request) string {
+	if !t.Enabled() {
+		return NotCachedDisabled
+	}
+	if noCacheRequested(req) {
+		return NotCachedContext
+	}
+	if t.Cacheable != nil {
+		if ok, _ := t.Cacheable(req, nil); !ok {
+			return NotCachedRule
+		}
+	} else if !t.cacheableMethod(t.method(req)) && !t.postQuery(req) {
+		return NotCachedMethod
+	}
+	if t.policy(req).NoCache {
+		return NotCachedPolicy
+	}
+	if req.Header.Get("range") != "" {
+		return NotCachedRange
+	}
+	if streamingRequest(req) {
+		return NotCachedStreaming
+	}
+	return ""
+}
+
+// streamingRequest returns true if req asks to upgrade the connection or for
+// server-sent events, whose responses never end and must be passed on as
+// they arrive
+func streamingRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, v := range headerAllCommaSepValues(req.Header, "Accept") {
+		if eventStream(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventStream returns true if the media type v is text/event-stream
+func eventStream(v string) bool {
+	if i := strings.IndexByte(v, ';'); i >= 0 {
+		v = v[:i]
+	}
+	return strings.EqualFold(strings.TrimSpace(v), "text/event-stream")
+}
+
+// responseNotCacheable returns why resp, received for the cacheable request
+// req with Cache-Control directives reqCC, may not be stored, or "" along with
+// the TTL set by the Cacheable hook, if any, if it may. private responses may
+// only be stored by a private cache, see
+// https://tools.ietf.org/html/rfc7234#section-5.2.2.6
+func (t *Transport) responseNotCacheable(req *http.Request, reqCC cacheControl, resp *http.Response) (string, time.Duration) {
+	respCC := t.responseCacheControl(resp)
+	switch {
+	case reqCC.has("no-store") || respCC.has("no-store"):
+		return NotCachedNoStore, 0
+	case t.Shared && respCC.has("private"):
+		return NotCachedPrivate, 0
+	case t.restricted(req) && !explicitlyShareable(resp.Header):
+		return NotCachedRestricted, 0
+	case t.Shared && t.StrictSharedCaching && !t.explicitlyCacheable(resp, respCC):
+		return NotCachedImplicit, 0
+	// a stored Set-Cookie would be replayed into other sessions, unless it is
+	// stripped before storing
+	case len(resp.Header["Set-Cookie"]) > 0 && !explicitlyShareable(resp.Header) && !t.strips("Set-Cookie"):
+		return NotCachedSetCookie, 0
+	case !varyStorable(resp):
+		return NotCachedVary, 0
+	case resp.StatusCode == http.StatusSwitchingProtocols || eventStream(resp.Header.Get("Content-Type")):
+		return NotCachedStreaming, 0
+	case !t.cacheableContentType(resp):
+		return NotCachedContentType, 0
+	// a partial body must never be stored under the full resource's key, even
+	// if the origin sent one for a request without a Range header
+	case resp.StatusCode == http.StatusNotModified || resp.StatusCode == http.StatusPartialContent:
+		return NotCachedStatus, 0
+	case t.Cacheable == nil && !t.cacheableStatus(req, resp, respCC):
+		return NotCachedStatus, 0
+	// HEAD requests share their key with GET and are answered from stored GET
+	// responses, but a bodiless HEAD response must never be stored in its place
+	case t.method(req) == "HEAD":
+		return NotCachedMethod, 0
+	}
+	if t.Cacheable != nil {
+		ok, ttl := t.Cacheable(req, resp)
+		if !ok {
+			return NotCachedRule, 0
+		}
+		return "", ttl
+	}
+	return "", 0
+}
+
+// cacheableContentType returns true if the media type of resp is listed in
+// CacheableContentTypes, or if it is empty
+func (t *Transport) cacheableContentType(resp *http.Response) bool {
+	if len(t.CacheableContentTypes) == 0 {
+		return true
+	}
+	v := resp.Header.Get("Content-Type")
+	if i := strings.IndexByte(v, ';'); i >= 0 {
+		v = v[:i]
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return resp.ContentLength == 0 || resp.StatusCode == http.StatusNoContent
+	}
+	for _, ct := range t.CacheableContentTypes {
+		if strings.HasSuffix(ct, "/*") {
+			if len(v) > len(ct)-1 && strings.EqualFold(v[:len(ct)-1], ct[:len(ct)-1]) {
+				return true
+			}
+		} else if strings.EqualFold(v, ct) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseCacheControl returns the Cache-Control directives of resp that are
+// honoured, none if IgnoreCacheControl is set
+func (t *Transport) responseCacheControl(resp *http.Response) cacheControl {
+	if t.IgnoreCacheControl {
+		return cacheControl{}
+	}
+	return parseCacheControl(resp.Header)
+}
+
+// defaultCacheableMethods are the methods cached when CacheableMethods is empty
+var defaultCacheableMethods = []string{"GET", "HEAD"}
+
+// defaultCacheableStatusCodes are the status codes that are cacheable by
+// default, see https://tools.ietf.org/html/rfc7231#section-6.1. 206 Partial
+// Content is left out as partial responses are never stored.
+var defaultCacheableStatusCodes = []int{200, 203, 204, 300, 301, 404, 405, 410, 414, 501}
+
+// cacheableMethod returns true if requests with method, after MethodAliases
+// are applied, are cached
+func (t *Transport) cacheableMethod(method string) bool {
+	methods := t.CacheableMethods
+	if len(methods) == 0 {
+		methods = defaultCacheableMethods
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultCacheableStatus returns true if code is cacheable by default
+func defaultCacheableStatus(code int) bool {
+	for _, c := range defaultCacheableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheableStatus returns true if the status code of resp, the response to req
+// with Cache-Control directives cc, allows it to be stored. With the default
+// status codes, other responses may be stored if they have explicit freshness
+// information, see https://tools.ietf.org/html/rfc7234#section-3
+func (t *Transport) cacheableStatus(req *http.Request, resp *http.Response, cc cacheControl) bool {
+	if _, ok := t.statusTTL(req, resp.StatusCode); ok {
+		return true
+	}
+	codes := t.CacheableStatusCodes
+	if len(codes) == 0 {
+		codes = defaultCacheableStatusCodes
+	}
+	for _, code := range codes {
+		if code == resp.StatusCode {
+			return true
+		}
+	}
+	if len(t.CacheableStatusCodes) == 0 {
+		_, explicit := t.freshnessLifetime(resp, cc)
+		return explicit || cc.has("public")
+	}
+	return false
+}
+
+// statusTTL returns how long responses to req with status code stay fresh
+// at most, and true if StatusTTLs, or those of the Policy for req, list it
+func (t *Transport) statusTTL(req *http.Request, code int) (time.Duration, bool) {
+	ttls := t.StatusTTLs
+	if p := t.policy(req); p.StatusTTLs != nil {
+		ttls = p.StatusTTLs
+	}
+	ttl, ok := ttls[code]
+	return ttl, ok && ttl > 0
+}
+
+// strips returns true if the header name is in StripHeadersBeforeCache
+func (t *Transport) strips(name string) bool {
+	for _, h := range t.StripHeadersBeforeCache {
+		if http.CanonicalHeaderKey(h) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// explicitlyCacheable returns true if resp, with Cache-Control directives cc,
+// has explicit freshness information or a validator to revalidate it with
+func (t *Transport) explicitlyCacheable(resp *http.Response, cc cacheControl) bool {
+	if _, ok := t.freshnessLifetime(resp, cc); ok {
+		return true
+	}
+	return resp.Header.Get("Etag") != "" || resp.Header.Get("Last-Modified") != ""
+}
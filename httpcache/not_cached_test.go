@@ -0,0 +1,105 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotCachedReasons(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		header    http.Header
+		configure func(*Transport)
+		handler   http.HandlerFunc
+		want      string
+	}{
+		{name: "stored", want: ""},
+		{name: "disabled", configure: func(tr *Transport) { tr.SetEnabled(false) }, want: NotCachedDisabled},
+		{name: "method", method: "DELETE", want: NotCachedMethod},
+		{name: "request no-store", header: http.Header{"Cache-Control": {"no-store"}}, want: NotCachedNoStore},
+		{name: "response no-store", handler: staleHandler("x", "no-store", 0), want: NotCachedNoStore},
+		{name: "private", configure: func(tr *Transport) { tr.Shared = true }, handler: staleHandler("x", "private", 0), want: NotCachedPrivate},
+		{name: "set-cookie", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Set-Cookie", "session=1")
+			staleHandler("x", "", -10*time.Second)(w, r)
+		}, want: NotCachedSetCookie},
+		{name: "vary", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Vary", "*")
+			staleHandler("x", "", -10*time.Second)(w, r)
+		}, want: NotCachedVary},
+		{name: "status", handler: statusHandler(http.StatusInternalServerError, "x"), want: NotCachedStatus},
+		{name: "content type", configure: func(tr *Transport) { tr.CacheableContentTypes = []string{"application/json"} }, want: NotCachedContentType},
+		{name: "oversize", configure: func(tr *Transport) { tr.MaxBodyBytes = 1 }, want: NotCachedOversize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.handler == nil {
+				tt.handler = staleHandler("body", "", -10*time.Second)
+			}
+			origin := newTestOrigin(t, tt.handler)
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.NotCachedHeader = "X-Not-Cached"
+			var info RequestInfo
+			tr.OnRequest = func(req *http.Request, i RequestInfo) { info = i }
+			if tt.configure != nil {
+				tt.configure(tr)
+			}
+
+			if tt.method == "" {
+				tt.method = "GET"
+			}
+			req := httptest.NewRequest(tt.method, origin.URL, nil)
+			req.RequestURI = ""
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("X-Not-Cached"); got != tt.want || info.NotCached != tt.want {
+				t.Errorf("X-Not-Cached = %q, RequestInfo.NotCached = %q, want %q", got, info.NotCached, tt.want)
+			}
+			if stored := resp.Header.Get(XCacheable) == "1"; stored != (tt.want == "") {
+				t.Errorf("%s = %q with reason %q", XCacheable, resp.Header.Get(XCacheable), tt.want)
+			}
+		})
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	tests := []struct {
+		name      string
+		cc        string
+		strip     bool
+		fromCache bool
+	}{
+		{name: "not shareable", cc: "max-age=60"},
+		{name: "public", cc: "public, max-age=60", fromCache: true},
+		{name: "stripped", cc: "max-age=60", strip: true, fromCache: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Set-Cookie", "session=1")
+				staleHandler("body", tt.cc, -10*time.Second)(w, r)
+			})
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			if tt.strip {
+				tr.StripHeadersBeforeCache = []string{"Set-Cookie"}
+			}
+			mustGet(t, tr, origin.URL)
+			resp, _ := mustGet(t, tr, origin.URL)
+			if (resp.Header.Get(XFromCache) == "1") != tt.fromCache {
+				t.Errorf("served from the cache %q, want %v", resp.Header.Get(XFromCache), tt.fromCache)
+			}
+			if tt.strip && resp.Header.Get("Set-Cookie") != "" {
+				t.Errorf("the stripped cookie was served from the cache")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOffline(t *testing.T) {
+	tests := []struct {
+		name       string
+		offline    bool
+		header     string // the value of the X-Offline request header
+		path       string // "/" is stored, stale; other paths aren't stored
+		wantStatus int
+		wantBody   string
+		wantOrigin bool
+	}{
+		{name: "online stale", path: "/", wantStatus: http.StatusOK, wantBody: "new", wantOrigin: true},
+		{name: "offline stale", offline: true, path: "/", wantStatus: http.StatusOK, wantBody: "stale"},
+		{name: "offline not stored", offline: true, path: "/other", wantStatus: http.StatusGatewayTimeout},
+		{name: "header stale", header: "1", path: "/", wantStatus: http.StatusOK, wantBody: "stale"},
+		{name: "header not stored", header: "1", path: "/other", wantStatus: http.StatusGatewayTimeout},
+		{name: "empty header", header: "", path: "/other", wantStatus: http.StatusOK, wantBody: "new", wantOrigin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("stale", "", 100*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.OfflineHeader = "X-Offline"
+			mustGet(t, tr, origin.URL+"/")
+
+			origin.set(staleHandler("new", "", 0))
+			tr.Offline = tt.offline
+			requests := origin.count()
+			resp, body := mustGet(t, tr, origin.URL+tt.path, "X-Offline", tt.header)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if contacted := origin.count() > requests; contacted != tt.wantOrigin {
+				t.Errorf("origin contacted = %v, want %v", contacted, tt.wantOrigin)
+			}
+		})
+	}
+}
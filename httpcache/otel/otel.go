@@ -0,0 +1,164 @@
+// Package otel records the requests made through an httpcache.Transport as
+// OpenTelemetry spans, and propagates their trace context to upstreams. It is
+// kept apart from httpcache so that only users who want it depend on
+// OpenTelemetry.
+//
+// Set a Tracer as the Transport's, and wrap the proxy with its Handler so
+// that the spans join the traces of the clients:
+//
+//	tracer := otel.New(nil, nil)
+//	proxy := apiproxy.NewCachingReverseProxy(target, apiproxy.Options{Tracer: tracer})
+//	http.ListenAndServe(":8080", tracer.Handler(proxy))
+package otel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/bcicen/apiproxy/httpcache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer spans are recorded with
+const instrumentationName = "github.com/bcicen/apiproxy/httpcache/otel"
+
+// Tracer is an httpcache.Tracer recording a span for each request made through
+// a Transport, with children for its cache lookup, upstream fetch and cache
+// store
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New returns a new Tracer recording spans with tp and propagating their
+// context with propagator. If nil, the global TracerProvider and
+// TextMapPropagator are used.
+func New(tp trace.TracerProvider, propagator propagation.TextMapPropagator) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = otel.GetTextMapPropagator()
+	}
+	return &Tracer{
+		tracer:     tp.Tracer(instrumentationName),
+		propagator: propagator,
+	}
+}
+
+// Start implements httpcache.Tracer
+func (t *Tracer) Start(ctx context.Context, stage string, req *http.Request) (context.Context, func(*http.Response, error)) {
+	kind := trace.SpanKindInternal
+	if stage == httpcache.StageFetch {
+		kind = trace.SpanKindClient
+	}
+	ctx, span := t.tracer.Start(ctx, "httpcache."+stage, trace.WithSpanKind(kind))
+	if stage == httpcache.StageRequest || stage == httpcache.StageFetch {
+		span.SetAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		)
+	}
+	if stage == httpcache.StageFetch {
+		t.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+
+	return ctx, func(resp *http.Response, err error) {
+		defer span.End()
+		switch {
+		case err != nil:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		case stage == httpcache.StageLookup:
+			span.SetAttributes(attribute.Bool("apiproxy.cache.found", resp != nil))
+		case resp != nil:
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if stage == httpcache.StageFetch && resp.StatusCode >= 500 {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+		}
+		if stage != httpcache.StageRequest {
+			return
+		}
+		if info, ok := httpcache.RequestInfoFromContext(ctx); ok {
+			span.SetAttributes(attribute.String("apiproxy.cache.status", info.Status))
+			if info.Key != "" {
+				span.SetAttributes(attribute.String("apiproxy.cache.key", info.Key))
+			}
+			if info.NotCached != "" {
+				span.SetAttributes(attribute.String("apiproxy.cache.not_cached", info.NotCached))
+			}
+		}
+	}
+}
+
+// Handler returns a handler passing requests to h, e.g. a proxy built by
+// apiproxy, within a server span continuing the trace of the client, if its
+// request carries one
+func (t *Tracer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, "apiproxy "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// statusWriter records the status code of a response. It passes flushes and
+// hijacks on, so that streamed and upgraded responses keep working.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("otel: ResponseWriter does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+)
+
+// parsedCacheSize bounds the number of parsed responses a Transport keeps.
+// Once it is reached they are all dropped and the set is built up again.
+const parsedCacheSize = 1024
+
+// parsedResponse is a stored response as parsed by http.ReadResponse, kept so
+// that further hits on the same stored bytes are answered without parsing
+// them again
+type parsedResponse struct {
+	// raw is the stored response it was parsed from
+	raw []byte
+	// resp is the parsed response without its Body or Request
+	resp http.Response
+	// body is the part of raw holding the body
+	body []byte
+}
+
+// readEntry returns the response serialized in b, stored at key, as
+// bytesToResp does. Responses read from the same bytes as a previous call for
+// key, as returned by in-memory Caches, reuse the result of parsing them.
+func (t *Transport) readEntry(key string, b []byte, req *http.Request) (*http.Response, error) {
+	if req.Method == "HEAD" {
+		return bytesToResp(b, req)
+	}
+
+	t.parsedMu.RLock()
+	p := t.parsed[key]
+	t.parsedMu.RUnlock()
+	if p != nil && sameBytes(p.raw, b) {
+		return p.response(req), nil
+	}
+
+	resp, err := bytesToResp(b, req)
+	if err != nil {
+		return nil, err
+	}
+	if p = parse(b, resp); p != nil {
+		t.parsedMu.Lock()
+		if t.parsed == nil || len(t.parsed) >= parsedCacheSize {
+			t.parsed = make(map[string]*parsedResponse)
+		}
+		t.parsed[key] = p
+		t.parsedMu.Unlock()
+	}
+	return resp, nil
+}
+
+// parse returns resp, freshly read from b, as a parsedResponse, or nil if its
+// body isn't stored verbatim at the end of b
+func parse(b []byte, resp *http.Response) *parsedResponse {
+	n := resp.ContentLength
+	if n < 0 || len(resp.TransferEncoding) > 0 || int64(len(b)) < n+4 {
+		return nil
+	}
+	end := int64(len(b)) - n
+	if !bytes.Equal(b[end-4:end], []byte("\r\n\r\n")) {
+		return nil
+	}
+
+	p := &parsedResponse{raw: b, resp: *resp, body: b[end:]}
+	p.resp.Header = cloneHeader(resp.Header)
+	p.resp.Body = nil
+	p.resp.Request = nil
+	return p
+}
+
+// response returns a new copy of p answering req
+func (p *parsedResponse) response(req *http.Request) *http.Response {
+	resp := p.resp
+	resp.Header = cloneHeader(p.resp.Header)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(p.body))
+	resp.Request = req
+	return &resp
+}
+
+// sameBytes returns true if a and b are the same slice of the same array
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// cloneHeader returns a copy of h that can be modified without affecting h
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
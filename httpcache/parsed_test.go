@@ -0,0 +1,125 @@
+package httpcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storedResponse returns the wire representation of a response as stored
+func storedResponse(t testing.TB) []byte {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Cache-Control", "max-age=60")
+	rec.Header().Set("Content-Type", "application/json")
+	rec.Header().Set("ETag", `"v1"`)
+	rec.WriteString(`{"users":[1,2,3]}`)
+	resp := rec.Result()
+	resp.ContentLength = int64(rec.Body.Len())
+	b, err := dumpResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReadEntryParsed(t *testing.T) {
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	b := storedResponse(t)
+	req := httptest.NewRequest("GET", "http://example.com/users", nil)
+	want, err := bytesToResp(b, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBody, _ := ioutil.ReadAll(want.Body)
+
+	if _, err := tr.readEntry("k", b, req); err != nil {
+		t.Fatal(err)
+	}
+	if tr.parsed["k"] == nil {
+		t.Fatal("response not kept parsed")
+	}
+	first, _ := tr.readEntry("k", b, req)
+	second, _ := tr.readEntry("k", b, req)
+	for _, resp := range []*http.Response{first, second} {
+		if resp.StatusCode != want.StatusCode || resp.ContentLength != want.ContentLength || resp.Request != req {
+			t.Errorf("got %d, length %d, want %d, length %d, answering req", resp.StatusCode, resp.ContentLength, want.StatusCode, want.ContentLength)
+		}
+		if !reflect.DeepEqual(resp.Header, want.Header) {
+			t.Errorf("header %v, want %v as parsed", resp.Header, want.Header)
+		}
+	}
+
+	// the bodies are independent, and so are the headers
+	half := make([]byte, len(wantBody)/2)
+	first.Body.Read(half)
+	first.Header.Set("Age", "10")
+	first.Header["Etag"][0] = `"changed"`
+	rest, _ := ioutil.ReadAll(first.Body)
+	if got := append(half, rest...); !bytes.Equal(got, wantBody) {
+		t.Errorf("first body %q, want %q", got, wantBody)
+	}
+	if got, _ := ioutil.ReadAll(second.Body); !bytes.Equal(got, wantBody) {
+		t.Errorf("second body %q, want %q", got, wantBody)
+	}
+	third, _ := tr.readEntry("k", b, req)
+	if !reflect.DeepEqual(third.Header, want.Header) {
+		t.Errorf("header %v after changing another copy, want %v", third.Header, want.Header)
+	}
+	if got, _ := ioutil.ReadAll(third.Body); !bytes.Equal(got, wantBody) {
+		t.Errorf("third body %q, want %q", got, wantBody)
+	}
+
+	// other bytes stored at the key are parsed again
+	other := bytes.Replace(append([]byte(nil), b...), []byte("1,2,3"), []byte("4,5,6"), 1)
+	resp, _ := tr.readEntry("k", other, req)
+	if got, _ := ioutil.ReadAll(resp.Body); !strings.Contains(string(got), "4,5,6") {
+		t.Errorf("body %q of new bytes, want the new response", got)
+	}
+}
+
+func BenchmarkFreshHit(b *testing.B) {
+	stored := storedResponse(b)
+	req := httptest.NewRequest("GET", "http://example.com/users", nil)
+	b.Run("parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			resp, _ := bytesToResp(stored, req)
+			ioutil.ReadAll(resp.Body)
+		}
+	})
+	b.Run("parsed", func(b *testing.B) {
+		tr := NewTransport(NewMemoryCache(time.Hour))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			resp, _ := tr.readEntry("k", stored, req)
+			ioutil.ReadAll(resp.Body)
+		}
+	})
+	b.Run("RoundTrip", func(b *testing.B) {
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Write([]byte(`{"users":[1,2,3]}`))
+		}))
+		defer origin.Close()
+		tr := NewTransport(NewMemoryCache(time.Hour))
+		req, _ := http.NewRequest("GET", origin.URL, nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			resp, _ := tr.RoundTrip(req)
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	})
+}
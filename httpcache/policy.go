@@ -0,0 +1,108 @@
+package httpcache
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Policy overrides how a Transport caches the responses to some requests
+type Policy struct {
+	// NoCache passes the requests straight to the underlying transport,
+	// without looking at the cache
+	NoCache bool
+	// TTL, if positive, is how long stored responses stay fresh, in place of
+	// their own freshness information
+	TTL time.Duration
+	// StatusTTLs, if not nil, replaces Transport.StatusTTLs for the requests
+	StatusTTLs map[int]time.Duration
+	// KeyFunc, if set, derives the cache keys of the requests, as
+	// Transport.KeyFunc does
+	KeyFunc func(req *http.Request) string
+}
+
+// A PolicyMatcher picks the Policy for a request. A Transport consults its
+// PolicyMatcher, if any, when deciding whether to cache a request, how to key
+// it and how long to keep its response fresh.
+type PolicyMatcher interface {
+	// Policy returns the Policy for req, and false if the Transport's own
+	// settings apply to it
+	Policy(req *http.Request) (Policy, bool)
+}
+
+// PolicyRule applies a Policy to the requests matching Host, Method and Path
+type PolicyRule struct {
+	// Host, if set, restricts the rule to requests for this host, e.g.
+	// "api.example.com", or for its subdomains with a leading "*.", e.g.
+	// "*.example.com". It is compared without the port unless it has one.
+	Host string
+	// Method, if set, restricts the rule to requests with this method
+	Method string
+	// Path, if set, restricts the rule to requests whose URL path it matches
+	Path *regexp.Regexp
+	Policy
+}
+
+// PolicyRules is a PolicyMatcher applying the first rule that matches a
+// request, e.g.
+//
+//	httpcache.PolicyRules{
+//		{Method: "GET", Path: regexp.MustCompile(`^/v1/users/`), Policy: httpcache.Policy{TTL: time.Minute}},
+//		{Path: regexp.MustCompile(`^/v1/stream$`), Policy: httpcache.Policy{NoCache: true}},
+//		{Host: "*.static.example.com", Policy: httpcache.Policy{TTL: time.Hour}},
+//	}
+//
+// A last rule with no conditions sets the default Policy of the requests no
+// other rule matches.
+type PolicyRules []PolicyRule
+
+// Policy returns the Policy of the first rule matching req
+func (rules PolicyRules) Policy(req *http.Request) (Policy, bool) {
+	for _, rule := range rules {
+		if rule.Host != "" && !matchHost(rule.Host, requestHost(req)) {
+			continue
+		}
+		if rule.Method != "" && rule.Method != req.Method {
+			continue
+		}
+		if rule.Path != nil && !rule.Path.MatchString(req.URL.Path) {
+			continue
+		}
+		return rule.Policy, true
+	}
+	return Policy{}, false
+}
+
+// requestHost returns the host req is for
+func requestHost(req *http.Request) string {
+	if req.URL.Host != "" {
+		return req.URL.Host
+	}
+	return req.Host
+}
+
+// matchHost returns true if host, which may have a port, matches pattern, see
+// PolicyRule.Host
+func matchHost(pattern, host string) bool {
+	if _, _, err := net.SplitHostPort(pattern); err != nil {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return len(host) > len(suffix) && strings.EqualFold(host[len(host)-len(suffix):], suffix)
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+// policy returns the Policy applying to req, the zero Policy if there is none
+func (t *Transport) policy(req *http.Request) Policy {
+	if t.Policies == nil {
+		return Policy{}
+	}
+	p, _ := t.Policies.Policy(req)
+	return p
+}
@@ -0,0 +1,91 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyRulesHost(t *testing.T) {
+	rules := PolicyRules{
+		{Host: "api.example.com", Policy: Policy{TTL: time.Minute}},
+		{Host: "*.static.example.com", Policy: Policy{TTL: time.Hour}},
+		{Host: "localhost:8080", Policy: Policy{NoCache: true}},
+		{Policy: Policy{TTL: time.Second}},
+	}
+	tests := []struct {
+		url     string
+		host    string // the Host of requests with no host in their URL
+		wantTTL time.Duration
+		noCache bool
+	}{
+		{url: "http://api.example.com/users", wantTTL: time.Minute},
+		{url: "http://API.example.com:8443/users", wantTTL: time.Minute},
+		{url: "/users", host: "api.example.com", wantTTL: time.Minute},
+		{url: "http://img.static.example.com/a.png", wantTTL: time.Hour},
+		{url: "http://a.img.static.example.com/a.png", wantTTL: time.Hour},
+		{url: "http://static.example.com/a.png", wantTTL: time.Second},
+		{url: "http://localhost:8080/", noCache: true},
+		{url: "http://localhost:9090/", wantTTL: time.Second},
+		{url: "http://other.example.com/", wantTTL: time.Second},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		if tt.host != "" {
+			req.URL.Host = ""
+			req.Host = tt.host
+		}
+		p, ok := rules.Policy(req)
+		if !ok {
+			t.Errorf("%s: no policy", tt.url)
+			continue
+		}
+		if p.TTL != tt.wantTTL || p.NoCache != tt.noCache {
+			t.Errorf("%s: got TTL %v, NoCache %v, want %v, %v", tt.url, p.TTL, p.NoCache, tt.wantTTL, tt.noCache)
+		}
+	}
+}
+
+// hostsTransport answers every request with its host and the number of
+// requests that host got, with no freshness information
+type hostsTransport struct {
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (ht *hostsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ht.mu.Lock()
+	ht.requests[req.URL.Host]++
+	n := ht.requests[req.URL.Host]
+	ht.mu.Unlock()
+	rec := httptest.NewRecorder()
+	fmt.Fprintf(rec, "%s %d", req.URL.Host, n)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestPolicyHostTTLs(t *testing.T) {
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.Transport = &hostsTransport{requests: map[string]int{}}
+	tr.Policies = PolicyRules{
+		{Host: "slow.example.com", Policy: Policy{TTL: time.Hour}},
+		{Host: "fast.example.com", Policy: Policy{TTL: 50 * time.Millisecond}},
+	}
+	for _, host := range []string{"slow.example.com", "fast.example.com"} {
+		mustGet(t, tr, "http://"+host+"/")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for host, want := range map[string]string{
+		"slow.example.com": "slow.example.com 1",
+		"fast.example.com": "fast.example.com 2",
+	} {
+		if _, body := mustGet(t, tr, "http://"+host+"/"); body != want {
+			t.Errorf("%s: body = %q, want %q", host, body, want)
+		}
+	}
+}
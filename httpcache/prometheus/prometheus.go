@@ -0,0 +1,108 @@
+// Package prometheus exports the counters of an httpcache.Transport as
+// Prometheus metrics. It is kept apart from httpcache so that only users who
+// want it depend on the Prometheus client.
+package prometheus
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	hitsDesc = prometheus.NewDesc("apiproxy_cache_hits_total",
+		"Responses served from the cache.", nil, nil)
+	missesDesc = prometheus.NewDesc("apiproxy_cache_misses_total",
+		"Cacheable requests sent to the origin.", nil, nil)
+	revalidationsDesc = prometheus.NewDesc("apiproxy_cache_revalidations_total",
+		"Stale responses served after the origin confirmed them.", nil, nil)
+	coalescedDesc = prometheus.NewDesc("apiproxy_cache_coalesced_total",
+		"Requests that waited for an identical one in flight to the origin.", nil, nil)
+	entriesDesc = prometheus.NewDesc("apiproxy_cache_entries",
+		"Entries held by the cache, if it reports them.", nil, nil)
+	bytesDesc = prometheus.NewDesc("apiproxy_cache_bytes",
+		"Size of the entries held by the cache, if it reports it.", nil, nil)
+	evictionsDesc = prometheus.NewDesc("apiproxy_cache_evictions_total",
+		"Entries the cache removed because they expired or to make room, if it reports them.", nil, nil)
+	expirationsDesc = prometheus.NewDesc("apiproxy_cache_expirations_total",
+		"Entries the cache removed because they expired, if it reports them.", nil, nil)
+)
+
+// Collector is a prometheus.Collector reporting the Stats of a Transport,
+// along with the latency of the requests it sends upstream. Register it with
+// a prometheus.Registerer to export them.
+type Collector struct {
+	t        *httpcache.Transport
+	upstream prometheus.Histogram
+}
+
+// New returns a new Collector for t. It times upstream requests by wrapping
+// the underlying transport of t, so any custom one must be set beforehand.
+func New(t *httpcache.Transport) *Collector {
+	c := &Collector{
+		t: t,
+		upstream: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "apiproxy_upstream_request_duration_seconds",
+			Help:    "Time spent waiting on the origin, excluding requests served from the cache.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+
+	next := t.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	t.SetTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		c.upstream.Observe(time.Since(start).Seconds())
+		return resp, err
+	}))
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hitsDesc
+	ch <- missesDesc
+	ch <- revalidationsDesc
+	ch <- coalescedDesc
+	ch <- entriesDesc
+	ch <- bytesDesc
+	ch <- evictionsDesc
+	ch <- expirationsDesc
+	c.upstream.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.t.Stats()
+	ch <- prometheus.MustNewConstMetric(hitsDesc, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(missesDesc, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(revalidationsDesc, prometheus.CounterValue, float64(s.Revalidations))
+	ch <- prometheus.MustNewConstMetric(coalescedDesc, prometheus.CounterValue, float64(s.Coalesced))
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(s.Entries))
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(s.Bytes))
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(s.Evictions))
+	ch <- prometheus.MustNewConstMetric(expirationsDesc, prometheus.CounterValue, float64(s.Expirations))
+	c.upstream.Collect(ch)
+}
+
+// Handler returns an http.Handler serving the metrics of c at /metrics and
+// passing every other request to proxy, e.g. a caching reverse proxy using the
+// Transport c reports on
+func Handler(proxy http.Handler, c *Collector) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle("/", proxy)
+	return mux
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
@@ -0,0 +1,169 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// serveRange answers req, which asks for part of a resource with a Range
+// header, from the complete response stored for it, if there is one fresh
+// enough for the client. It returns false if there is none, in which case req
+// is passed through to the origin and its partial response isn't stored.
+func (t *Transport) serveRange(cache CacheCtx, req *http.Request) (*http.Response, bool) {
+	if t.method(req) != "GET" {
+		return nil, false
+	}
+	full := cloneRequest(req)
+	full.Header.Del("Range")
+	full.Header.Del("If-Range")
+	if t.requestNotCacheable(full) != "" {
+		return nil, false
+	}
+	key, ok := t.key(full)
+	if !ok || !t.sampled(key) {
+		return nil, false
+	}
+	resp, fresh, staleFor := t.lookup(cache, key, full)
+	if resp == nil {
+		return nil, false
+	}
+	fresh, constrained := t.requestFreshness(req, parseCacheControl(req.Header), resp, fresh, staleFor)
+	if fresh && constrained {
+		resp.Header.Add("Warning", `110 - "Response is Stale"`)
+	}
+	if !fresh || t.restricted(req) && !explicitlyShareable(resp.Header) {
+		resp.Body.Close()
+		return nil, false
+	}
+	resp.Request = req
+
+	info := requestInfo(req.Context())
+	info.Status = StatusHit
+	info.Key = key
+	atomic.AddUint64(&t.stats.hits, 1)
+
+	resp.Header.Set(XCacheable, "1")
+	if clientNotModified(req, resp) {
+		resp = notModified(req, resp)
+	} else if err := sliceRange(req, resp); err != nil {
+		t.logger().Errorf("%s: %s", key, &Error{ErrSerialize, err})
+		resp.Body.Close()
+		return nil, false
+	}
+	if t.RewriteDateOnServe {
+		resp.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	t.setCacheStatus(resp, key, "", false)
+	t.logger().Debugf("[from-cache] %s (range %s)", req.URL, req.Header.Get("Range"))
+	return resp, true
+}
+
+// sliceRange turns resp, the complete response to req, into a 206 Partial
+// Content response with the byte range asked for by req, or a 416 Range Not
+// Satisfiable one if it lies past the end of the body. resp is left whole if
+// it isn't a 200, if the If-Range precondition of req fails or if req asks
+// for several ranges, which a server may answer with the whole
+// representation, see https://tools.ietf.org/html/rfc7233#section-3.1
+func sliceRange(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK || !ifRangeMatches(req, resp) {
+		return nil
+	}
+	spec := strings.TrimSpace(req.Header.Get("Range"))
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	size := int64(len(body))
+	start, end, ok, satisfiable := parseByteRange(strings.TrimPrefix(spec, "bytes="), size)
+	switch {
+	case !ok:
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil
+	case !satisfiable:
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Status = "416 Requested Range Not Satisfiable"
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		body = nil
+	default:
+		resp.StatusCode = http.StatusPartialContent
+		resp.Status = "206 Partial Content"
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		body = body[start : end+1]
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// parseByteRange parses spec, a single byte range of a Range header without
+// its "bytes=" prefix, for a body of size bytes. It returns the first and last
+// byte positions of the range, false if spec is malformed, in which case the
+// Range header must be ignored, and whether the range is satisfiable.
+func parseByteRange(spec string, size int64) (start, end int64, ok, satisfiable bool) {
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, 0, false, false
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, true, false
+	}
+	return start, end, true, true
+}
+
+// ifRangeMatches returns true if req has no If-Range precondition, or if it
+// matches resp: an entity tag must be strongly equal to resp's ETag, and a
+// date equal to its Last-Modified, see
+// https://tools.ietf.org/html/rfc7233#section-3.2
+func ifRangeMatches(req *http.Request, resp *http.Response) bool {
+	v := strings.TrimSpace(req.Header.Get("If-Range"))
+	if v == "" {
+		return true
+	}
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/") {
+		etag := resp.Header.Get("Etag")
+		return !strings.HasPrefix(v, "W/") && etag != "" && !strings.HasPrefix(etag, "W/") && v == etag
+	}
+	lastModified := resp.Header.Get("Last-Modified")
+	return lastModified != "" && v == lastModified
+}
@@ -0,0 +1,34 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeRequests(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+
+	// a partial response is passed on but not stored
+	resp, body := mustGet(t, tr, origin.URL, "Range", "bytes=2-4")
+	if resp.StatusCode != http.StatusPartialContent || body != "234" {
+		t.Fatalf("got %d %q, want the origin's 206", resp.StatusCode, body)
+	}
+	if resp, body := mustGet(t, tr, origin.URL); body != "0123456789" || resp.Header.Get(XFromCache) != "" {
+		t.Fatalf("got %q, from cache %q, want the full response from the origin", body, resp.Header.Get(XFromCache))
+	}
+
+	// ranges of a stored full response are served from it
+	resp, body = mustGet(t, tr, origin.URL, "Range", "bytes=5-")
+	if resp.StatusCode != http.StatusPartialContent || body != "56789" || resp.Header.Get("Content-Range") != "bytes 5-9/10" {
+		t.Errorf("got %d %q, Content-Range %q, want bytes 5-9 of the stored response", resp.StatusCode, body, resp.Header.Get("Content-Range"))
+	}
+	if n := origin.count(); n != 2 {
+		t.Errorf("origin got %d requests, want 2", n)
+	}
+}
@@ -0,0 +1,41 @@
+package httpcache
+
+// ReadOnly returns a Cache that reads from inner but ignores Set and Delete,
+// e.g. to let inspection tooling attach to a live cache without being able to
+// mutate it. The returned Cache also provides Keys and Len methods, which pass
+// through to inner if it has them.
+func ReadOnly(inner Cache) Cache {
+	return readOnlyCache{inner}
+}
+
+type readOnlyCache struct {
+	inner Cache
+}
+
+// Get returns the []byte representation of the response from the inner cache
+func (c readOnlyCache) Get(key string) (resp []byte, ok bool) {
+	return c.inner.Get(key)
+}
+
+// Set does nothing
+func (c readOnlyCache) Set(key string, resp []byte) {}
+
+// Delete does nothing
+func (c readOnlyCache) Delete(key string) {}
+
+// Keys returns the keys of the inner cache, or nil if it can't list them
+func (c readOnlyCache) Keys() []string {
+	if k, ok := c.inner.(interface{ Keys() []string }); ok {
+		return k.Keys()
+	}
+	return nil
+}
+
+// Len returns the number of entries in the inner cache, or 0 if it can't
+// report it
+func (c readOnlyCache) Len() int {
+	if l, ok := c.inner.(interface{ Len() int }); ok {
+		return l.Len()
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package httpcache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	origin := newTestOrigin(t, staleHandler("body", "max-age=60", -10*time.Second))
+	mem := NewMemoryCache(time.Hour)
+	ro := ReadOnly(mem)
+	tr := NewTransport(ro)
+	mustGet(t, tr, origin.URL+"/a")
+	if mem.Len() != 0 {
+		t.Fatalf("a response was stored through the read-only cache")
+	}
+
+	mustGet(t, NewTransport(mem), origin.URL+"/a")
+	if resp, _ := mustGet(t, tr, origin.URL+"/a"); resp.Header.Get(XFromCache) != "1" {
+		t.Errorf("the stored response was not served through the read-only cache")
+	}
+	key := mem.Keys()[0]
+	ro.Delete(key)
+	if _, ok := mem.Get(key); !ok {
+		t.Errorf("Delete removed the entry")
+	}
+
+	lister := ro.(interface {
+		Keys() []string
+		Len() int
+	})
+	if keys := lister.Keys(); !reflect.DeepEqual(keys, []string{key}) || lister.Len() != 1 {
+		t.Errorf("Keys() = %q, Len() = %d, want those of the inner cache", keys, lister.Len())
+	}
+	// an inner cache that can't list its entries
+	lister = ReadOnly(struct{ Cache }{mem}).(interface {
+		Keys() []string
+		Len() int
+	})
+	if keys := lister.Keys(); keys != nil || lister.Len() != 0 {
+		t.Errorf("Keys() = %q, Len() = %d, want nothing", keys, lister.Len())
+	}
+}
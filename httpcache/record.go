@@ -0,0 +1,123 @@
+package httpcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// RecordingTransport is an implementation of http.RoundTripper that writes
+// every response received through it to a recording, for later replay by a
+// ReplayTransport. Use it as the underlying Transport of a caching Transport
+// to record the origin's side of a session.
+//
+// Recordings use the format of WriteEntries, keyed by request method and URL.
+type RecordingTransport struct {
+	// The RoundTripper interface actually used to make requests
+	// If nil, http.DefaultTransport is used
+	Transport http.RoundTripper
+
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewRecordingTransport returns a new RecordingTransport writing to w
+func NewRecordingTransport(w io.Writer) *RecordingTransport {
+	return &RecordingTransport{w: bufio.NewWriter(w)}
+}
+
+// RoundTrip makes the request with the underlying transport and records the
+// response. An error is returned if the response can't be recorded.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBytes, err := dumpResponse(resp)
+	if err == nil {
+		err = t.record(recordingKey(req), respBytes)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *RecordingTransport) record(key string, respBytes []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := writeChunk(t.w, []byte(key)); err != nil {
+		return err
+	}
+	if err := writeChunk(t.w, respBytes); err != nil {
+		return err
+	}
+	return t.w.Flush()
+}
+
+// ReplayTransport is an implementation of http.RoundTripper that answers
+// requests from a recording made by a RecordingTransport, without making any
+// network requests. Responses recorded several times for the same request are
+// replayed in order, with the last one repeated once they run out.
+type ReplayTransport struct {
+	mu        sync.Mutex
+	responses map[string][][]byte
+}
+
+// NewReplayTransport returns a new ReplayTransport answering from the
+// recording read from r
+func NewReplayTransport(r io.Reader) (*ReplayTransport, error) {
+	t := &ReplayTransport{responses: make(map[string][][]byte)}
+	br := bufio.NewReader(r)
+	for {
+		key, err := readChunk(br)
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		respBytes, err := readChunk(br)
+		if err != nil {
+			if err == io.EOF {
+				err = errHandoffFormat
+			}
+			return nil, err
+		}
+		t.responses[string(key)] = append(t.responses[string(key)], respBytes)
+	}
+}
+
+// RoundTrip returns the next recorded response for req, or an error if none
+// was recorded
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := recordingKey(req)
+
+	t.mu.Lock()
+	recorded := t.responses[key]
+	if len(recorded) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("httpcache: no recorded response for %s", key)
+	}
+	respBytes := recorded[0]
+	if len(recorded) > 1 {
+		t.responses[key] = recorded[1:]
+	}
+	t.mu.Unlock()
+
+	return bytesToResp(respBytes, req)
+}
+
+// recordingKey returns the key req is recorded under
+func recordingKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
@@ -0,0 +1,43 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	version := 0
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		version++
+		fmt.Fprintf(w, "%s v%d", r.URL.Path, version)
+	})
+	var recording bytes.Buffer
+	rec := NewRecordingTransport(&recording)
+	for _, path := range []string{"/a", "/a", "/b"} {
+		mustGet(t, rec, origin.URL+path)
+	}
+
+	replay, err := NewReplayTransport(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ path, want string }{
+		{"/a", "/a v1"},
+		{"/b", "/b v3"},
+		{"/a", "/a v2"},
+		{"/a", "/a v2"},
+	}
+	for _, tt := range tests {
+		if _, body := mustGet(t, replay, origin.URL+tt.path); body != tt.want {
+			t.Errorf("%s: replayed %q, want %q", tt.path, body, tt.want)
+		}
+	}
+	if _, _, err := get(t, replay, origin.URL+"/c"); err == nil {
+		t.Errorf("replayed a response never recorded")
+	}
+	if n := origin.count(); n != 3 {
+		t.Errorf("origin got %d requests, want only those recorded", n)
+	}
+}
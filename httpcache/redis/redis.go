@@ -0,0 +1,113 @@
+// Package redis provides a redis interface for http caching, letting several
+// proxy instances share one cache.
+//
+// It lives apart from package httpcache, which has no dependencies outside
+// the standard library, so that only the programs importing it depend on a
+// redis client. NewWithAuth is what a constructor of package httpcache, such
+// as httpcache.NewRedisCache(addr, password, db, ttl), would have been.
+package redis
+
+import (
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Cache is an implementation of httpcache.Cache that stores responses in a
+// redis server. Entries expire in redis after maxTTL; the Transport applies
+// each response's own freshness on top of that, so stale entries stay
+// available for revalidation until then. Errors talking to redis are
+// reported as misses.
+type Cache struct {
+	pool   *redis.Pool
+	maxTTL time.Duration
+
+	// Prefix is prepended to httpcache keys to avoid collision with other
+	// data stored in redis, such as the entries of other caches. It defaults
+	// to DefaultPrefix.
+	Prefix string
+}
+
+// DefaultPrefix is the default Cache.Prefix
+const DefaultPrefix = "rediscache:"
+
+// cacheKey modifies an httpcache key for use in redis
+func (c *Cache) cacheKey(key string) string {
+	return c.Prefix + key
+}
+
+// New returns a new Cache storing entries in the redis server at addr for at
+// most maxTTL
+func New(addr string, maxTTL time.Duration) *Cache {
+	return NewWithAuth(addr, "", 0, maxTTL)
+}
+
+// NewWithAuth returns a new Cache like New that authenticates with password,
+// unless it is empty, and stores entries in database db
+func NewWithAuth(addr, password string, db int, maxTTL time.Duration) *Cache {
+	return NewWithPool(&redis.Pool{
+		MaxIdle:     8,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr, redis.DialPassword(password), redis.DialDatabase(db))
+		},
+	}, maxTTL)
+}
+
+// NewWithPool returns a new Cache storing entries for at most maxTTL, using
+// connections from pool
+func NewWithPool(pool *redis.Pool, maxTTL time.Duration) *Cache {
+	if maxTTL <= time.Duration(0) {
+		panic("maxTTL must be >0")
+	}
+	return &Cache{pool: pool, maxTTL: maxTTL, Prefix: DefaultPrefix}
+}
+
+// Get returns the response corresponding to key if present
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	resp, err := redis.Bytes(conn.Do("GET", c.cacheKey(key)))
+	if err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+// Set saves a response to the cache as key
+func (c *Cache) Set(key string, resp []byte) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	conn.Do("SET", c.cacheKey(key), resp, "PX", int64(c.maxTTL/time.Millisecond))
+}
+
+// SetMulti saves several responses to the cache, keyed by the keys of
+// entries, in a single round trip to redis
+func (c *Cache) SetMulti(entries map[string][]byte) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	for key, resp := range entries {
+		conn.Send("SET", c.cacheKey(key), resp, "PX", int64(c.maxTTL/time.Millisecond))
+	}
+	// flush the pipeline and wait for all the replies
+	conn.Do("")
+}
+
+// Delete removes the response with key from the cache
+func (c *Cache) Delete(key string) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	conn.Do("DEL", c.cacheKey(key))
+}
+
+// Close releases the connections held by the cache
+func (c *Cache) Close() error {
+	return c.pool.Close()
+}
+
+var _ httpcache.Cache = (*Cache)(nil)
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// testServer returns the address of a redis server: that in $REDIS_ADDR if
+// set, or else one started for the test if redis-server is installed. The
+// test is skipped otherwise.
+func testServer(t *testing.T) string {
+	t.Helper()
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return addr
+	}
+	bin, err := exec.LookPath("redis-server")
+	if err != nil {
+		t.Skip("redis-server is not installed and REDIS_ADDR is not set")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	port := addr[strings.LastIndexByte(addr, ':')+1:]
+	ln.Close()
+
+	cmd := exec.Command(bin, "--bind", "127.0.0.1", "--port", port, "--save", "", "--appendonly", "no")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if conn, err := redis.Dial("tcp", addr); err == nil {
+			_, err = conn.Do("PING")
+			conn.Close()
+			if err == nil {
+				return addr
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("redis did not start on %s", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// countingConn counts the commands sent on a connection, and the round trips
+// made to send them
+type countingConn struct {
+	redis.Conn
+	sends, roundTrips *int
+	// pending counts the commands sent since the last round trip
+	pending *int
+}
+
+func (c countingConn) Send(cmd string, args ...interface{}) error {
+	*c.sends++
+	*c.pending++
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c countingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		*c.sends++
+		*c.pending++
+	}
+	// Do("") with nothing pending, as done when the pool takes the
+	// connection back, doesn't reach the server
+	if *c.pending > 0 {
+		*c.roundTrips++
+		*c.pending = 0
+	}
+	return c.Conn.Do(cmd, args...)
+}
+
+// newTestCache returns a Cache on a test server, under a prefix of its own,
+// counting the commands sent and round trips made on its connections
+func newTestCache(t *testing.T, addr string, sends, roundTrips *int) *Cache {
+	c := NewWithPool(&redis.Pool{Dial: func() (redis.Conn, error) {
+		conn, err := redis.Dial("tcp", addr)
+		return countingConn{conn, sends, roundTrips, new(int)}, err
+	}}, time.Hour)
+	c.Prefix = "test:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":"
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCache(t *testing.T) {
+	var sends, roundTrips int
+	c := newTestCache(t, testServer(t), &sends, &roundTrips)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got a hit before Set")
+	}
+	c.Set("a", []byte("hello"))
+	if got, ok := c.Get("a"); !ok || string(got) != "hello" {
+		t.Errorf("got %q, %v, want hello", got, ok)
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("got a hit after Delete")
+	}
+}
+
+func TestSetMulti(t *testing.T) {
+	var sends, roundTrips int
+	c := newTestCache(t, testServer(t), &sends, &roundTrips)
+	entries := map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}
+	c.SetMulti(entries)
+	if sends != 3 || roundTrips != 1 {
+		t.Errorf("sent %d commands in %d round trips, want 3 in 1", sends, roundTrips)
+	}
+	for key, want := range entries {
+		if got, ok := c.Get(key); !ok || string(got) != string(want) {
+			t.Errorf("%s: got %q, %v, want %q", key, got, ok, want)
+		}
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+	ttl, err := redis.Int64(conn.Do("PTTL", c.Prefix+"a"))
+	if err != nil || ttl <= 0 || ttl > int64(time.Hour/time.Millisecond) {
+		t.Errorf("PTTL = %d, %v, want at most maxTTL", ttl, err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	var sends, roundTrips int
+	addr := testServer(t)
+	a, b := newTestCache(t, addr, &sends, &roundTrips), newTestCache(t, addr, &sends, &roundTrips)
+	b.Prefix = a.Prefix + "other:"
+	a.Set("key", []byte("a"))
+	if _, ok := b.Get("key"); ok {
+		t.Error("a cache with another prefix got a hit")
+	}
+	b.Set("key", []byte("b"))
+	if got, _ := a.Get("key"); string(got) != "a" {
+		t.Errorf("got %q, want a, not overwritten by the other prefix", got)
+	}
+
+	conn := a.pool.Get()
+	defer conn.Close()
+	if n, err := redis.Int(conn.Do("EXISTS", a.Prefix+"key")); err != nil || n != 1 {
+		t.Errorf("EXISTS %skey = %d, %v, want the key stored under the prefix", a.Prefix, n, err)
+	}
+}
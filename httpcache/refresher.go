@@ -0,0 +1,338 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults for the Refresher settings left unset
+const (
+	defaultRefreshLead        = 10 * time.Second
+	defaultRefreshConcurrency = 4
+	defaultRefreshMaxTracked  = 10000
+)
+
+// Refresher keeps popular responses stored by a Transport fresh by revalidating
+// them shortly before they expire, so that hot endpoints never miss. A
+// resource is popular once it has been requested MinHits times since it was
+// last stored or refreshed. Resources registered with Schedule are kept fresh
+// whatever their popularity.
+//
+// Responses without explicit freshness information, which stay fresh for as
+// long as the Cache keeps them, are only refreshed if they are scheduled with
+// an interval, or are among the TopN.
+type Refresher struct {
+	t *Transport
+
+	// MinHits is the number of requests making a resource popular, 1 if
+	// unset
+	MinHits int
+	// Lead is how long before they expire responses are refreshed, 10s if
+	// unset
+	Lead time.Duration
+	// Concurrency bounds the refreshes in flight, 4 if unset
+	Concurrency int
+	// TopN, if positive, additionally revalidates the TopN resources
+	// requested the most since they were first tracked at every check,
+	// however fresh they are, so that hot entries stay verified against the
+	// origin without client traffic. Stored responses are revalidated with
+	// conditional requests, and replaced if the origin answers with a new one.
+	TopN int
+	// MaxTracked bounds the number of resources whose popularity is tracked,
+	// 10000 if unset. Resources requested once the bound is reached are not
+	// tracked until others are dropped.
+	MaxTracked int
+
+	mu      sync.Mutex
+	tracked map[string]*tracked
+
+	stopMu sync.Mutex
+	stop   chan chan struct{}
+}
+
+// tracked is a resource the Refresher may refresh
+type tracked struct {
+	// req is the request the resource is refreshed with
+	req  *http.Request
+	hits int
+	// total is the number of requests for the resource since it was first
+	// tracked, ranking it for TopN
+	total int
+
+	// scheduled is set for resources registered with Schedule. every, if
+	// positive, is how often they are refreshed, and next when they are next
+	// due.
+	scheduled bool
+	every     time.Duration
+	next      time.Time
+
+	inFlight bool
+}
+
+// NewRefresher returns a new Refresher for the responses stored by t, and
+// makes t track the popularity of the resources requested through it. Call
+// Start to begin refreshing.
+func NewRefresher(t *Transport) *Refresher {
+	r := &Refresher{
+		t:       t,
+		tracked: make(map[string]*tracked),
+	}
+	t.mu.Lock()
+	t.refresher = r
+	t.mu.Unlock()
+	return r
+}
+
+var errNotCacheable = errors.New("httpcache: request is not cacheable")
+
+// prefetchKey marks requests made by a Refresher
+type prefetchKey struct{}
+
+// prefetching returns true if req is a refresh made by a Refresher
+func prefetching(req *http.Request) bool {
+	return req.Context().Value(prefetchKey{}) != nil
+}
+
+// getRefresher returns the Refresher tracking t's requests, if any
+func (t *Transport) getRefresher() *Refresher {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.refresher
+}
+
+// observe counts a request for the resource stored at key
+func (r *Refresher) observe(req *http.Request, key string) {
+	if req.Method != "GET" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tr, ok := r.tracked[key]
+	if !ok {
+		max := r.MaxTracked
+		if max <= 0 {
+			max = defaultRefreshMaxTracked
+		}
+		if len(r.tracked) >= max {
+			return
+		}
+		tr = &tracked{req: templateRequest(req)}
+		r.tracked[key] = tr
+	}
+	tr.hits++
+	tr.total++
+}
+
+// top returns the TopN of the resources tracked at keys by total, those
+// requested the most; r.mu must be held
+func (r *Refresher) top(keys []string) map[string]bool {
+	if r.TopN <= 0 {
+		return nil
+	}
+	ranked := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if tr, ok := r.tracked[key]; ok && tr.total > 0 {
+			ranked = append(ranked, key)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		ti, tj := r.tracked[ranked[i]].total, r.tracked[ranked[j]].total
+		return ti > tj || ti == tj && ranked[i] < ranked[j]
+	})
+	if len(ranked) > r.TopN {
+		ranked = ranked[:r.TopN]
+	}
+	top := make(map[string]bool, len(ranked))
+	for _, key := range ranked {
+		top[key] = true
+	}
+	return top
+}
+
+// templateRequest returns a copy of req without its body or context, to be
+// sent again later
+func templateRequest(req *http.Request) *http.Request {
+	tmpl := cloneRequest(req.WithContext(context.Background()))
+	tmpl.Body = nil
+	tmpl.GetBody = nil
+	tmpl.ContentLength = 0
+	return tmpl
+}
+
+// Schedule registers a GET request for rawurl to be kept fresh, and refreshed
+// every interval if it is positive. Scheduled resources are fetched as soon
+// as the Refresher starts, warming the cache.
+func (r *Refresher) Schedule(rawurl string, every time.Duration) error {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return err
+	}
+	key, ok := r.t.key(req)
+	if !ok || r.t.requestNotCacheable(req) != "" {
+		return errNotCacheable
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tracked[key] = &tracked{req: req, scheduled: true, every: every}
+	return nil
+}
+
+// Start starts a goroutine that checks every interval for responses due to
+// be refreshed. Call Stop to end it. Calling Start again replaces the running
+// goroutine.
+func (r *Refresher) Start(interval time.Duration) {
+	r.stopMu.Lock()
+	defer r.stopMu.Unlock()
+	r.stopLocked()
+
+	stop := make(chan chan struct{})
+	r.stop = stop
+	go func() {
+		r.refreshDue()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.refreshDue()
+			case done := <-stop:
+				close(done)
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the goroutine started by Start, if any, and waits for it to exit.
+// Refreshes in flight carry on.
+func (r *Refresher) Stop() {
+	r.stopMu.Lock()
+	defer r.stopMu.Unlock()
+	r.stopLocked()
+}
+
+func (r *Refresher) stopLocked() {
+	if r.stop == nil {
+		return
+	}
+	done := make(chan struct{})
+	r.stop <- done
+	<-done
+	r.stop = nil
+}
+
+// refreshDue refreshes the tracked resources that are due on the Transport's
+// Workers, at most Concurrency at a time, and waits for them
+func (r *Refresher) refreshDue() {
+	now := time.Now()
+	lead := r.Lead
+	if lead <= 0 {
+		lead = defaultRefreshLead
+	}
+	minHits := r.MinHits
+	if minHits <= 0 {
+		minHits = 1
+	}
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultRefreshConcurrency
+	}
+
+	cache := ToCacheCtx(r.t.cache())
+	var due []*tracked
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.tracked))
+	for key := range r.tracked {
+		keys = append(keys, key)
+	}
+	top := r.top(keys)
+	r.mu.Unlock()
+	for _, key := range keys {
+		r.mu.Lock()
+		tr, ok := r.tracked[key]
+		if !ok || tr.inFlight || !tr.scheduled && !top[key] && tr.hits < minHits {
+			r.mu.Unlock()
+			continue
+		}
+		req, scheduled, every, next := tr.req, tr.scheduled, tr.every, tr.next
+		r.mu.Unlock()
+
+		expires, stored := r.t.expiry(cache, key, req)
+		switch {
+		case scheduled && every > 0 && !now.Before(next):
+		case scheduled && !stored:
+		case stored && !expires.IsZero() && expires.Sub(now) < lead:
+		case stored && top[key]:
+		case !scheduled && !stored:
+			// evicted, or never storable: stop tracking it
+			r.mu.Lock()
+			delete(r.tracked, key)
+			r.mu.Unlock()
+			continue
+		default:
+			continue
+		}
+
+		r.mu.Lock()
+		tr.inFlight = true
+		tr.hits = 0
+		if every > 0 {
+			tr.next = now.Add(every)
+		}
+		r.mu.Unlock()
+		due = append(due, tr)
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, tr := range due {
+		sem <- struct{}{}
+		wg.Add(1)
+		tr := tr
+		r.t.Workers.Go(context.Background(), func() {
+			defer func() {
+				r.mu.Lock()
+				tr.inFlight = false
+				r.mu.Unlock()
+				<-sem
+				wg.Done()
+			}()
+			r.refresh(tr.req)
+		})
+	}
+	wg.Wait()
+}
+
+// refresh revalidates the response stored for a copy of req, or fetches it
+// if there is none
+func (r *Refresher) refresh(req *http.Request) {
+	ctx := context.WithValue(context.Background(), prefetchKey{}, true)
+	resp, err := r.t.roundTrip(cloneRequest(req.WithContext(ctx)), false)
+	if err != nil {
+		r.t.logger().Errorf("%s: refresh: %s", req.URL, err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	r.t.logger().Debugf("[refresh] %s", req.URL)
+}
+
+// expiry returns when the response stored at key for req stops being fresh,
+// the zero Time if it has no explicit freshness, and false if there is none
+func (t *Transport) expiry(cache CacheCtx, key string, req *http.Request) (time.Time, bool) {
+	ctx := context.Background()
+	e := t.getEntry(ctx, cache, key)
+	if e != nil && e.vary != nil {
+		e = t.getEntry(ctx, cache, varyKey(key, req, e.vary))
+	}
+	if e == nil || e.vary != nil {
+		return time.Time{}, false
+	}
+	return e.expires, true
+}
@@ -0,0 +1,75 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefresherTopN(t *testing.T) {
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	var mu sync.Mutex
+	conditional := map[string]string{} // If-None-Match and If-Modified-Since of the refreshes, by path
+	version := "v1"
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		v := version
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			conditional[r.URL.Path] = inm + " " + r.Header.Get("If-Modified-Since")
+		}
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"`+v+`"`)
+		w.Header().Set("Last-Modified", lastModified)
+		if r.URL.Path == "/unchanged" && r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintf(w, "%s %s", r.URL.Path, v)
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	r := NewRefresher(tr)
+	// the responses are fresh for an hour, so only the top 2 are refreshed
+	r.TopN = 2
+
+	for path, hits := range map[string]int{"/changed": 3, "/unchanged": 2, "/cold": 1} {
+		for i := 0; i < hits; i++ {
+			mustGet(t, tr, origin.URL+path)
+		}
+	}
+	mu.Lock()
+	version = "v2"
+	mu.Unlock()
+	requests := origin.count()
+
+	// the first check runs as Start is called, and Stop waits for it
+	r.Start(time.Hour)
+	r.Stop()
+
+	if n := origin.count() - requests; n != 2 {
+		t.Errorf("origin got %d refreshes, want 2", n)
+	}
+	mu.Lock()
+	for _, path := range []string{"/changed", "/unchanged"} {
+		if got, want := conditional[path], `"v1" `+lastModified; got != want {
+			t.Errorf("%s: refreshed with If-None-Match and If-Modified-Since %q, want %q", path, got, want)
+		}
+	}
+	if _, ok := conditional["/cold"]; ok {
+		t.Errorf("/cold, outside the top 2, was refreshed")
+	}
+	mu.Unlock()
+
+	for path, want := range map[string]string{"/changed": "/changed v2", "/unchanged": "/unchanged v1", "/cold": "/cold v1"} {
+		resp, body := mustGet(t, tr, origin.URL+path)
+		if body != want || resp.Header.Get(XFromCache) != "1" {
+			t.Errorf("%s: got %q, %s %q, want %q from the cache", path, body, XFromCache, resp.Header.Get(XFromCache), want)
+		}
+		if path == "/unchanged" && !strings.Contains(resp.Header.Get("ETag"), "v2") {
+			t.Errorf("/unchanged: ETag %q, want the one of the 304 merged in", resp.Header.Get("ETag"))
+		}
+	}
+}
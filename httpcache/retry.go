@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// defaultRetryBackoff is the wait before the first retry when RetryBackoff is
+// not set
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// send sends req with transport, retrying it as configured by Retries
+func (t *Transport) send(transport http.RoundTripper, req *http.Request) (*http.Response, error) {
+	resp, err := transport.RoundTrip(req)
+	if t.Retries <= 0 || !retryable(req) {
+		return resp, err
+	}
+
+	start := time.Now()
+	wait := t.RetryBackoff
+	if wait <= 0 {
+		wait = defaultRetryBackoff
+	}
+	for attempt := 0; attempt < t.Retries && t.shouldRetry(resp, err); attempt++ {
+		// up to half the backoff again at random, so that clients that
+		// failed together don't all retry together
+		d := wait + time.Duration(rand.Int63n(int64(wait)/2+1))
+		wait *= 2
+		if t.RetryBudget > 0 && time.Since(start)+d > t.RetryBudget {
+			break
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(d).After(deadline) {
+			break
+		}
+
+		retry := req
+		if req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				break
+			}
+			retry = req.WithContext(req.Context())
+			retry.Body = body
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return resp, err
+		}
+		if resp != nil {
+			drain(resp)
+		}
+		t.logger().Debugf("[retry] %s (attempt %d)", req.URL, attempt+2)
+		resp, err = transport.RoundTrip(retry)
+	}
+	return resp, err
+}
+
+// retryable returns true if req may be sent again: it is a GET or HEAD
+// request whose body, if any, can be replayed
+func retryable(req *http.Request) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// shouldRetry returns true if the attempt that ended with resp or err failed
+// in a way that retrying may fix
+func (t *Transport) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+	default:
+		return false
+	}
+	// a host asking to be left alone is backed off from instead
+	_, ok := retryAfter(resp, time.Now())
+	return !(ok && t.HonorRetryAfter)
+}
+
+// drain reads what's left of the body of resp, within reason, so that its
+// connection can be reused, and closes it
+func drain(resp *http.Response) {
+	io.CopyN(ioutil.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+}
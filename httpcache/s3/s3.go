@@ -0,0 +1,198 @@
+// Package s3 provides an S3 interface for http caching, storing responses as
+// objects in a bucket of Amazon S3 or a compatible object store, for large,
+// durable caches shared by proxy instances across regions.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// expiresHeader is the object metadata recording when an entry expires, as
+// seconds since the Unix epoch
+const expiresHeader = "X-Amz-Meta-Expires"
+
+// Config locates the bucket a Cache stores its entries in
+type Config struct {
+	// Endpoint is the URL of the object store, e.g.
+	// https://s3.eu-west-1.amazonaws.com
+	Endpoint string
+	// Region is the region requests are signed for, us-east-1 if empty
+	Region string
+	Bucket string
+	// Prefix is prepended to the names of the objects, e.g. "httpcache/"
+	Prefix string
+	// PathStyle addresses the bucket in the path of the requests
+	// (https://endpoint/bucket/object) rather than in their host name
+	// (https://bucket.endpoint/object), as most S3-compatible stores need
+	PathStyle bool
+
+	// AccessKeyID, SecretAccessKey and, for temporary credentials,
+	// SessionToken sign the requests. If AccessKeyID is empty, requests are
+	// sent unsigned.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client sends the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// Cache is an implementation of httpcache.CacheCtx that stores responses as
+// objects in an S3 bucket; pass it to httpcache.FromCacheCtx to use it as a
+// Transport's Cache, which then logs its failures. Objects are named after a
+// hash of their key, and expire after maxTTL: the expiry is recorded in their
+// metadata and enforced as they are read, so a lifecycle rule on the bucket
+// should remove objects that are never read again.
+type Cache struct {
+	conf     Config
+	endpoint *url.URL
+	maxTTL   time.Duration
+
+	// Local, if set, buffers the entries read from and written to the bucket,
+	// e.g. a MemoryCache with a shorter TTL than the bucket's, so that hot
+	// entries are served without a round trip. Entries deleted by other
+	// instances stay in Local until it drops them.
+	Local httpcache.Cache
+}
+
+// New returns a new Cache storing entries in the bucket described by conf for
+// at most maxTTL
+func New(conf Config, maxTTL time.Duration) (*Cache, error) {
+	if maxTTL <= time.Duration(0) {
+		panic("maxTTL must be >0")
+	}
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("s3: endpoint must be an http or https URL")
+	}
+	if conf.Bucket == "" {
+		return nil, errors.New("s3: no bucket")
+	}
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+	if conf.Client == nil {
+		conf.Client = http.DefaultClient
+	}
+	return &Cache{conf: conf, endpoint: u, maxTTL: maxTTL}, nil
+}
+
+// objectURL returns the URL of the object holding the entry at key
+func (c *Cache) objectURL(key string) *url.URL {
+	sum := sha256.Sum256([]byte(key))
+	name := c.conf.Prefix + hex.EncodeToString(sum[:])
+	u := *c.endpoint
+	if c.conf.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.conf.Bucket + "/" + name
+	} else {
+		u.Host = c.conf.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	}
+	return &u
+}
+
+// do sends a signed request for the object holding the entry at key
+func (c *Cache) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.conf.AccessKeyID != "" {
+		sign(req, body, c.conf, time.Now())
+	}
+	return c.conf.Client.Do(req)
+}
+
+// statusError returns the error of a response with an unexpected status
+func statusError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("s3: %s: %s", resp.Status, bytes.TrimSpace(b))
+}
+
+// Get returns the response stored at key, unless it has expired
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if c.Local != nil {
+		if b, ok := c.Local.Get(key); ok {
+			return b, true, nil
+		}
+	}
+
+	resp, err := c.do(ctx, "GET", key, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, statusError(resp)
+	}
+	if expires, err := strconv.ParseInt(resp.Header.Get(expiresHeader), 10, 64); err == nil && time.Now().Unix() >= expires {
+		return nil, false, c.Delete(ctx, key)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if c.Local != nil {
+		c.Local.Set(key, b)
+	}
+	return b, true, nil
+}
+
+// Set stores resp at key
+func (c *Cache) Set(ctx context.Context, key string, resp []byte) error {
+	expires := time.Now().Add(c.maxTTL).Unix()
+	header := http.Header{expiresHeader: {strconv.FormatInt(expires, 10)}}
+	r, err := c.do(ctx, "PUT", key, resp, header)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return statusError(r)
+	}
+	if c.Local != nil {
+		c.Local.Set(key, resp)
+	}
+	return nil
+}
+
+// Delete removes the response stored at key
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	if c.Local != nil {
+		c.Local.Delete(key)
+	}
+	resp, err := c.do(ctx, "DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return statusError(resp)
+}
+
+var _ httpcache.CacheCtx = (*Cache)(nil)
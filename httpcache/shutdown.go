@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"context"
+	"sync"
+)
+
+// background tracks the work a Transport does in the background, so that
+// Shutdown can stop and wait for it
+type background struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	shutdown bool
+	done     chan struct{}
+	// cancels holds the cancel funcs of the contexts returned by
+	// withShutdown
+	cancels map[*context.CancelFunc]struct{}
+}
+
+// doneChan returns a channel closed once the Transport is shut down
+func (b *background) doneChan() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done == nil {
+		b.done = make(chan struct{})
+	}
+	return b.done
+}
+
+// start runs f on a worker of workers waited for by Shutdown, and returns
+// false without running it if the Transport is shut down or no worker is free
+func (b *background) start(workers *WorkerPool, f func()) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shutdown {
+		return false
+	}
+	b.wg.Add(1)
+	started := workers.TryGo(func() {
+		defer b.wg.Done()
+		f()
+	})
+	if !started {
+		b.wg.Done()
+	}
+	return started
+}
+
+// withShutdown returns a copy of ctx canceled once the Transport is shut down
+func (t *Transport) withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	b := &t.bg
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shutdown {
+		cancel()
+		return ctx, cancel
+	}
+	if b.cancels == nil {
+		b.cancels = make(map[*context.CancelFunc]struct{})
+	}
+	b.cancels[&cancel] = struct{}{}
+	return ctx, func() {
+		b.mu.Lock()
+		delete(b.cancels, &cancel)
+		b.mu.Unlock()
+		cancel()
+	}
+}
+
+// Shutdown stops the background work of t: stale responses are no longer
+// revalidated in the background, its Refresher, if any, is stopped, and
+// Warm gives up on the URLs left. It then waits for the work in flight to
+// finish storing its responses, or for ctx to end, in which case it returns
+// its error. Requests made through t are still answered.
+//
+// Shutdown is meant to be called once the requests to t have drained, e.g.
+// by http.Server.Shutdown, and before its Cache is closed.
+func (t *Transport) Shutdown(ctx context.Context) error {
+	t.bg.doneChan()
+	t.bg.mu.Lock()
+	if !t.bg.shutdown {
+		t.bg.shutdown = true
+		close(t.bg.done)
+		for cancel := range t.bg.cancels {
+			(*cancel)()
+		}
+		t.bg.cancels = nil
+	}
+	t.bg.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.mu.RLock()
+		r := t.refresher
+		t.mu.RUnlock()
+		if r != nil {
+			r.Stop()
+		}
+		t.bg.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
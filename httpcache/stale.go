@@ -0,0 +1,176 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// staleWhileRevalidate returns true if the cached response resp, stale for
+// staleFor, may still be served while it is revalidated in the background,
+// see https://tools.ietf.org/html/rfc5861#section-3: while its current age is
+// at most its freshness lifetime plus the window. The StaleWhileRevalidate
+// default doesn't apply to responses that must be revalidated before each use
+// or once stale, see https://tools.ietf.org/html/rfc7234#section-4.2.4, and
+// none does past MaxStaleness.
+func (t *Transport) staleWhileRevalidate(resp *http.Response, staleFor time.Duration) bool {
+	if t.tooStale(staleFor) {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	window, ok := deltaSeconds(cc["stale-while-revalidate"])
+	if !ok {
+		if cc.has("no-cache") || t.revalidationRequired(cc) {
+			return false
+		}
+		window = t.StaleWhileRevalidate
+	}
+	return window > 0 && staleFor <= window
+}
+
+// tooStale returns true if a stored response stale for staleFor is past
+// MaxStaleness, and may no longer be served without revalidation
+func (t *Transport) tooStale(staleFor time.Duration) bool {
+	return t.MaxStaleness > 0 && staleFor > t.MaxStaleness
+}
+
+type refreshKey struct{}
+
+// refreshing returns true if req is a background revalidation started by
+// refresh
+func refreshing(req *http.Request) bool {
+	return req.Context().Value(refreshKey{}) != nil
+}
+
+// refresh revalidates the stale response stored at key for req in the
+// background, unless that is already under way, t is shut down or all of its
+// Workers are busy
+func (t *Transport) refresh(req *http.Request, key string) {
+	if _, leader := t.refreshes.join(key); !leader {
+		return
+	}
+
+	// detached from req, which completes as soon as the stale response is
+	// served
+	ctx := context.WithValue(context.Background(), refreshKey{}, true)
+	bg := cloneRequest(req.WithContext(ctx))
+	if req.GetBody != nil {
+		// the client's body may be closed once it is answered
+		if body, err := req.GetBody(); err == nil {
+			bg.Body = body
+		}
+	}
+	started := t.bg.start(t.Workers, func() {
+		defer t.refreshes.leave(key)
+		resp, err := t.roundTrip(bg, false)
+		if err != nil {
+			t.logger().Errorf("%s: background revalidation: %s", key, err)
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	})
+	if !started {
+		t.refreshes.leave(key)
+	}
+}
+
+// staleIfError returns true if the stale response, stale for staleFor, may be
+// served in place of an origin failure for a request with Cache-Control
+// directives reqCC, see https://tools.ietf.org/html/rfc5861#section-4. Its own
+// directives requiring revalidation prevail, even over ServeStaleOnError, and
+// neither the directives nor ServeStaleOnError extend past MaxStaleIfError
+// or MaxStaleness.
+func (t *Transport) staleIfError(stale *http.Response, reqCC cacheControl, staleFor time.Duration) bool {
+	if !t.staleOnFailure(stale, staleFor) {
+		return false
+	}
+	if t.ServeStaleOnError {
+		return true
+	}
+	for _, cc := range []cacheControl{reqCC, parseCacheControl(stale.Header)} {
+		if window, ok := deltaSeconds(cc["stale-if-error"]); ok && staleFor < window {
+			return true
+		}
+	}
+	return false
+}
+
+// staleOnFailure returns true unless the stale response, stale for staleFor,
+// may not be served in place of an origin failure whatever the directives: it
+// must be revalidated, or it is past MaxStaleIfError or MaxStaleness
+func (t *Transport) staleOnFailure(stale *http.Response, staleFor time.Duration) bool {
+	if t.revalidationRequired(parseCacheControl(stale.Header)) || t.tooStale(staleFor) {
+		return false
+	}
+	return t.MaxStaleIfError <= 0 || staleFor < t.MaxStaleIfError
+}
+
+// serveUnreachable returns the answer to req, for which a stale response is
+// stored at key, when the origin isn't contacted because of err: the stale
+// response, with the Age it was looked up with, if staleOnFailure allows it,
+// the MustRevalidateFailure response if it must be revalidated, and false
+// otherwise. stale is closed unless it is returned.
+func (t *Transport) serveUnreachable(req *http.Request, stale *http.Response, key, age string, staleFor time.Duration, err error) (*http.Response, bool) {
+	if t.staleOnFailure(stale, staleFor) {
+		requestInfo(req.Context()).Status = StatusStale
+		return t.serveStale(req, stale, key, age, true), true
+	}
+	stale.Body.Close()
+	if t.revalidationRequired(parseCacheControl(stale.Header)) {
+		t.logger().Errorf("%s: revalidation failed: %s", key, err)
+		return t.mustRevalidateFailure(req, err), true
+	}
+	return nil, false
+}
+
+// mustRevalidateFailure returns the response to req when the stale response
+// it would get must be revalidated and the origin can't be reached because of
+// err, see MustRevalidateFailure
+func (t *Transport) mustRevalidateFailure(req *http.Request, err error) *http.Response {
+	if t.MustRevalidateFailure != nil {
+		return t.MustRevalidateFailure(req, err)
+	}
+	body := []byte("stored response could not be revalidated\n")
+	return &http.Response{
+		Status:        "504 Gateway Timeout",
+		StatusCode:    http.StatusGatewayTimeout,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+}
+
+// serverError returns true for the status codes stale-if-error applies to
+func serverError(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// serveStale prepares the stale response stored at key, with the Age it was
+// looked up with, to be returned for req in place of an origin failure. It
+// carries the RFC 7234 warn-codes for a stale response whose revalidation
+// failed, and if disconnected is true, for a cache that can't reach the
+// origin at all, see https://tools.ietf.org/html/rfc7234#section-5.5
+func (t *Transport) serveStale(req *http.Request, stale *http.Response, key, age string, disconnected bool) *http.Response {
+	if age != "" {
+		stale.Header.Set("Age", age)
+	}
+	stale.Header.Set(XFromCache, "1")
+	stale.Header.Add("Warning", `110 - "Response is Stale"`)
+	stale.Header.Add("Warning", `111 - "Revalidation Failed"`)
+	if disconnected {
+		stale.Header.Add("Warning", `112 - "Disconnected Operation"`)
+	}
+	return t.serveCached(req, stale, key)
+}
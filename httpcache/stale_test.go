@@ -0,0 +1,289 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// servedStale returns true if resp is a stale response served in place of an
+// origin failure
+func servedStale(resp *http.Response) bool {
+	return strings.Contains(strings.Join(resp.Header["Warning"], ","), "111")
+}
+
+func TestStaleIfError(t *testing.T) {
+	tests := []struct {
+		name              string
+		shared            bool
+		serveStaleOnError bool
+		maxStaleIfError   time.Duration
+		cc                string
+		reqCC             string
+		network           bool
+		wantStale         bool
+	}{
+		{name: "no directive", cc: ""},
+		{name: "response directive", cc: "stale-if-error=3600", wantStale: true},
+		{name: "response directive expired", cc: "stale-if-error=50"},
+		{name: "request directive", reqCC: "stale-if-error=3600", wantStale: true},
+		{name: "network error", cc: "stale-if-error=3600", network: true, wantStale: true},
+		{name: "serve stale on error", serveStaleOnError: true, wantStale: true},
+		{name: "inside MaxStaleIfError", maxStaleIfError: 110 * time.Second, cc: "stale-if-error=3600", wantStale: true},
+		{name: "outside MaxStaleIfError", maxStaleIfError: 90 * time.Second, cc: "stale-if-error=3600"},
+		{name: "directive inside MaxStaleIfError", maxStaleIfError: time.Hour, cc: "stale-if-error=50"},
+		{name: "serve stale on error inside MaxStaleIfError", serveStaleOnError: true, maxStaleIfError: 110 * time.Second, wantStale: true},
+		{name: "serve stale on error outside MaxStaleIfError", serveStaleOnError: true, maxStaleIfError: 90 * time.Second},
+		{name: "network error outside MaxStaleIfError", serveStaleOnError: true, maxStaleIfError: 90 * time.Second, network: true},
+		{name: "must-revalidate", cc: "must-revalidate, stale-if-error=3600"},
+		{name: "must-revalidate serve stale on error", serveStaleOnError: true, cc: "must-revalidate"},
+		{name: "proxy-revalidate private", serveStaleOnError: true, cc: "proxy-revalidate", wantStale: true},
+		{name: "proxy-revalidate shared", shared: true, serveStaleOnError: true, cc: "public, proxy-revalidate"},
+		{name: "s-maxage shared", shared: true, serveStaleOnError: true, cc: "s-maxage=10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("stale", tt.cc, 100*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.Shared = tt.shared
+			tr.ServeStaleOnError = tt.serveStaleOnError
+			tr.MaxStaleIfError = tt.maxStaleIfError
+			mustGet(t, tr, origin.URL)
+
+			origin.set(statusHandler(http.StatusInternalServerError, "down"))
+			if tt.network {
+				tr.Transport = errTransport{}
+			}
+			resp, body, err := get(t, tr, origin.URL, "Cache-Control", tt.reqCC)
+			switch {
+			case tt.wantStale:
+				if err != nil {
+					t.Fatalf("got error %v, want the stale response", err)
+				}
+				if body != "stale" || !servedStale(resp) {
+					t.Errorf("got %s %q, warnings %q, want the stale response", resp.Status, body, resp.Header["Warning"])
+				}
+			case tt.network:
+				if err == nil {
+					t.Fatalf("got %s %q, want an error", resp.Status, body)
+				}
+				if !errors.Is(err, ErrUpstream) {
+					t.Errorf("error %v is not an ErrUpstream", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("got error %v, want the origin's response", err)
+				}
+				if resp.StatusCode != http.StatusInternalServerError || body != "down" {
+					t.Errorf("got %s %q, want the origin's 500", resp.Status, body)
+				}
+			}
+		})
+	}
+}
+
+// shutdownOnCleanup shuts tr down at the end of the test, waiting for its
+// background revalidations
+func shutdownOnCleanup(t *testing.T, tr *Transport) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tr.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		shared    bool
+		def       time.Duration
+		cc        string
+		wantStale bool
+	}{
+		{name: "no window", cc: ""},
+		{name: "default", def: time.Hour, wantStale: true},
+		{name: "default expired", def: 50 * time.Second},
+		{name: "response directive", cc: "stale-while-revalidate=3600", wantStale: true},
+		{name: "response directive expired", def: time.Hour, cc: "stale-while-revalidate=50"},
+		// stale for 100s, up to a second more with the rounding of its Date
+		{name: "just inside the window", cc: "stale-while-revalidate=101", wantStale: true},
+		{name: "just outside the window", cc: "stale-while-revalidate=99"},
+		{name: "default no-cache", def: time.Hour, cc: "no-cache"},
+		{name: "default must-revalidate", def: time.Hour, cc: "must-revalidate"},
+		{name: "default proxy-revalidate private", def: time.Hour, cc: "proxy-revalidate", wantStale: true},
+		{name: "default proxy-revalidate shared", shared: true, def: time.Hour, cc: "public, proxy-revalidate"},
+		{name: "default s-maxage private", def: time.Hour, cc: "s-maxage=10", wantStale: true},
+		{name: "default s-maxage shared", shared: true, def: time.Hour, cc: "s-maxage=10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("stale", tt.cc, 100*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.Shared = tt.shared
+			tr.StaleWhileRevalidate = tt.def
+			shutdownOnCleanup(t, tr)
+			mustGet(t, tr, origin.URL)
+
+			origin.set(staleHandler("new", tt.cc, 100*time.Second))
+			resp, body := mustGet(t, tr, origin.URL)
+			want := "new"
+			if tt.wantStale {
+				want = "stale"
+			}
+			if body != want {
+				t.Errorf("got %s %q, want %q", resp.Status, body, want)
+			}
+			if got := strings.Contains(strings.Join(resp.Header["Warning"], ","), "110"); got != tt.wantStale {
+				t.Errorf("stale warning = %v, want %v", got, tt.wantStale)
+			}
+		})
+	}
+}
+
+func TestStaleWhileRevalidateBoundary(t *testing.T) {
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	resp := &http.Response{Header: http.Header{"Cache-Control": {"max-age=10, stale-while-revalidate=60"}}}
+	tests := []struct {
+		staleFor time.Duration
+		want     bool
+	}{
+		{staleFor: time.Second, want: true},
+		{staleFor: 60*time.Second - time.Nanosecond, want: true},
+		{staleFor: 60 * time.Second, want: true},
+		{staleFor: 60*time.Second + time.Nanosecond},
+	}
+	for _, tt := range tests {
+		if got := tr.staleWhileRevalidate(resp, tt.staleFor); got != tt.want {
+			t.Errorf("stale for %v: staleWhileRevalidate = %v, want %v", tt.staleFor, got, tt.want)
+		}
+	}
+	resp.Header.Set("Cache-Control", "max-age=10, stale-while-revalidate=0")
+	if tr.staleWhileRevalidate(resp, 0) {
+		t.Errorf("served with a window of 0")
+	}
+}
+
+func TestMaxStaleness(t *testing.T) {
+	// stored responses are stale for 100s, up to a second more with the
+	// rounding of their Date
+	tests := []struct {
+		name         string
+		path         string
+		maxStaleness time.Duration
+		wantStale    bool
+	}{
+		{name: "swr no cap", path: "swr", wantStale: true},
+		{name: "swr inside", path: "swr", maxStaleness: 102 * time.Second, wantStale: true},
+		{name: "swr outside", path: "swr", maxStaleness: 99 * time.Second},
+		{name: "max-stale no cap", path: "max-stale", wantStale: true},
+		{name: "max-stale inside", path: "max-stale", maxStaleness: 102 * time.Second, wantStale: true},
+		{name: "max-stale outside", path: "max-stale", maxStaleness: 99 * time.Second},
+		{name: "stale-if-error no cap", path: "stale-if-error", wantStale: true},
+		{name: "stale-if-error inside", path: "stale-if-error", maxStaleness: 102 * time.Second, wantStale: true},
+		{name: "stale-if-error outside", path: "stale-if-error", maxStaleness: 99 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("stale", "", 100*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.MaxStaleness = tt.maxStaleness
+			shutdownOnCleanup(t, tr)
+			mustGet(t, tr, origin.URL)
+
+			var header []string
+			want := "new"
+			switch tt.path {
+			case "swr":
+				tr.StaleWhileRevalidate = time.Hour
+				origin.set(staleHandler("new", "", 0))
+			case "max-stale":
+				header = []string{"Cache-Control", "max-stale"}
+				origin.set(staleHandler("new", "", 0))
+			case "stale-if-error":
+				tr.ServeStaleOnError = true
+				origin.set(statusHandler(http.StatusInternalServerError, "down"))
+				want = "down"
+			}
+			requests := origin.count()
+			_, body := mustGet(t, tr, origin.URL, header...)
+			if tt.wantStale {
+				want = "stale"
+			}
+			if body != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+			if !tt.wantStale && origin.count() == requests {
+				t.Errorf("the request didn't wait for the origin")
+			}
+		})
+	}
+}
+
+func TestMustRevalidateFailure(t *testing.T) {
+	custom := func(req *http.Request, err error) *http.Response {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Retry-After", "30")
+		rec.WriteHeader(http.StatusGatewayTimeout)
+		fmt.Fprintf(rec, "custom: %v", errors.Is(err, errUnreachable))
+		return rec.Result()
+	}
+	tests := []struct {
+		name       string
+		shared     bool
+		cc         string
+		hook       func(*http.Request, error) *http.Response
+		network    bool
+		wantStatus int // 0 for an error
+		wantBody   string
+	}{
+		{name: "default", cc: "must-revalidate", network: true, wantStatus: http.StatusGatewayTimeout, wantBody: "stored response could not be revalidated\n"},
+		{name: "custom", cc: "must-revalidate", hook: custom, network: true, wantStatus: http.StatusGatewayTimeout, wantBody: "custom: true"},
+		{name: "proxy-revalidate shared", shared: true, cc: "public, proxy-revalidate", hook: custom, network: true, wantStatus: http.StatusGatewayTimeout, wantBody: "custom: true"},
+		{name: "proxy-revalidate private", cc: "proxy-revalidate", hook: custom, network: true},
+		{name: "no must-revalidate", hook: custom, network: true},
+		{name: "origin error", cc: "must-revalidate", hook: custom, wantStatus: http.StatusInternalServerError, wantBody: "down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newTestOrigin(t, staleHandler("stale", tt.cc, 100*time.Second))
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.Shared = tt.shared
+			tr.MustRevalidateFailure = tt.hook
+			mustGet(t, tr, origin.URL)
+
+			origin.set(statusHandler(http.StatusInternalServerError, "down"))
+			if tt.network {
+				tr.Transport = errTransport{}
+			}
+			resp, body, err := get(t, tr, origin.URL)
+			if tt.wantStatus == 0 {
+				if err == nil {
+					t.Fatalf("got %s %q, want an error", resp.Status, body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want a %d", err, tt.wantStatus)
+			}
+			if resp.StatusCode != tt.wantStatus || body != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("not stored", func(t *testing.T) {
+		tr := NewTransport(NewMemoryCache(time.Hour))
+		tr.Transport = errTransport{}
+		tr.MustRevalidateFailure = custom
+		if resp, _, err := get(t, tr, "http://example.com/"); err == nil {
+			t.Errorf("got %s, want an error", resp.Status)
+		}
+	})
+}
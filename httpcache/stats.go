@@ -0,0 +1,64 @@
+package httpcache
+
+import "sync/atomic"
+
+// Stats counts what a Transport has done with the requests made through it
+type Stats struct {
+	// Hits is the number of responses served from the cache
+	Hits uint64
+	// Misses is the number of cacheable requests sent to the origin,
+	// including those revalidating a stale response
+	Misses uint64
+	// Bypasses is the number of requests sent to the origin without looking
+	// at the cache
+	Bypasses uint64
+	// Revalidations is the number of stale responses served after the origin
+	// confirmed them with a 304 Not Modified
+	Revalidations uint64
+	// Stores is the number of responses stored
+	Stores uint64
+	// Coalesced is the number of requests that waited for an identical one in
+	// flight to the origin instead of being sent, with CoalesceMisses
+	Coalesced uint64
+
+	// Entries, Bytes, Evictions and Expirations describe the Cache, if it
+	// reports them with Len, Size, Evictions and Expirations methods like
+	// MemoryCache. They are 0 otherwise.
+	Entries     int
+	Bytes       int64
+	Evictions   uint64
+	Expirations uint64
+}
+
+// transportStats holds the counters behind Transport.Stats, updated atomically
+type transportStats struct {
+	hits, misses, bypasses, revalidations, stores, coalesced uint64
+}
+
+// Stats returns the transport's counters. It is safe to call while requests
+// are in flight.
+func (t *Transport) Stats() Stats {
+	s := Stats{
+		Hits:          atomic.LoadUint64(&t.stats.hits),
+		Misses:        atomic.LoadUint64(&t.stats.misses),
+		Bypasses:      atomic.LoadUint64(&t.stats.bypasses),
+		Revalidations: atomic.LoadUint64(&t.stats.revalidations),
+		Stores:        atomic.LoadUint64(&t.stats.stores),
+		Coalesced:     atomic.LoadUint64(&t.stats.coalesced),
+	}
+
+	c := t.cache()
+	if l, ok := c.(interface{ Len() int }); ok {
+		s.Entries = l.Len()
+	}
+	if sz, ok := c.(interface{ Size() int64 }); ok {
+		s.Bytes = sz.Size()
+	}
+	if e, ok := c.(interface{ Evictions() uint64 }); ok {
+		s.Evictions = e.Evictions()
+	}
+	if e, ok := c.(interface{ Expirations() uint64 }); ok {
+		s.Expirations = e.Expirations()
+	}
+	return s
+}
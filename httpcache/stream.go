@@ -0,0 +1,84 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamStore makes the body of resp, the response to req to be stored at key,
+// keep a copy of what is read from it, and stores resp, fresh for ttl if it is
+// positive, once it has been read in full, unless it turns out larger than
+// MaxBodyBytes. done, if not nil, is called once the body has been read in
+// full or closed.
+func (t *Transport) streamStore(cache CacheCtx, key string, req *http.Request, resp *http.Response, ttl time.Duration, done func()) {
+	// the client may change the headers of the response it is handed
+	snapshot := *resp
+	snapshot.Header = cloneHeader(resp.Header)
+	resp.Body = &teeBody{
+		ReadCloser: resp.Body,
+		max:        t.MaxBodyBytes,
+		store: func(body []byte) {
+			snapshot.Body = ioutil.NopCloser(bytes.NewReader(body))
+			respBytes, err := dumpResponse(&snapshot)
+			if err != nil {
+				t.logger().Errorf("%s: %s", key, err)
+				return
+			}
+			t.store(cache, key, req, &snapshot, ttl, respBytes)
+		},
+		done: done,
+	}
+}
+
+// teeBody is a response body that copies what is read from it, giving up once
+// it exceeds max bytes unless max is 0, and passes the whole body to store
+// once it has been read to EOF
+type teeBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	max      int64
+	overflow bool
+
+	store func(body []byte)
+	done  func()
+	once  sync.Once
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if b.max > 0 && int64(b.buf.Len()+n) > b.max {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.finish(!b.overflow)
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(false)
+	return err
+}
+
+// finish stores the body if complete is true, the first time it is called
+func (b *teeBody) finish(complete bool) {
+	b.once.Do(func() {
+		if complete {
+			b.store(b.buf.Bytes())
+		}
+		b.buf = bytes.Buffer{}
+		if b.done != nil {
+			b.done()
+		}
+	})
+}
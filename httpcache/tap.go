@@ -0,0 +1,108 @@
+package httpcache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TapFunc receives every entry stored through a TapCache
+type TapFunc func(key string, entry []byte)
+
+// TapCache is an implementation of Cache that stores entries in an underlying
+// Cache and additionally hands each stored entry to a TapFunc, e.g. to feed an
+// event bus or an archive writer.
+//
+// The TapFunc runs on a background goroutine fed by a bounded queue. When the
+// queue is full, entries are dropped (and counted) rather than delaying Set.
+type TapCache struct {
+	Cache Cache
+
+	tap     TapFunc
+	workers *WorkerPool
+	queue   chan tapEntry
+	done    chan struct{}
+	dropped uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type tapEntry struct {
+	key   string
+	entry []byte
+}
+
+// NewTapCache returns a new Cache that stores entries in c and passes them to
+// tap, buffering up to queueSize entries. Close must be called to stop the
+// background goroutine.
+func NewTapCache(c Cache, tap TapFunc, queueSize int) *TapCache {
+	return NewTapCacheWithWorkers(c, tap, queueSize, nil)
+}
+
+// NewTapCacheWithWorkers is NewTapCache with each call to tap counted as one
+// of the workers of workers, e.g. those of the Transport storing in c, so
+// that the queue is consumed only when one is free
+func NewTapCacheWithWorkers(c Cache, tap TapFunc, queueSize int, workers *WorkerPool) *TapCache {
+	t := &TapCache{
+		Cache:   c,
+		tap:     tap,
+		workers: workers,
+		queue:   make(chan tapEntry, queueSize),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (c *TapCache) run() {
+	defer close(c.done)
+	for e := range c.queue {
+		c.workers.Do(func() { c.tap(e.key, e.entry) })
+	}
+}
+
+// Get returns the []byte representation of the response from the underlying
+// cache
+func (c *TapCache) Get(key string) (resp []byte, ok bool) {
+	return c.Cache.Get(key)
+}
+
+// Set saves response resp to the underlying cache with key and queues it for
+// the TapFunc
+func (c *TapCache) Set(key string, resp []byte) {
+	c.Cache.Set(key, resp)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- tapEntry{key, resp}:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+// Delete removes key from the underlying cache
+func (c *TapCache) Delete(key string) {
+	c.Cache.Delete(key)
+}
+
+// Dropped returns the number of entries that were not passed to the TapFunc
+// because the queue was full
+func (c *TapCache) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Close stops queueing entries and waits for the TapFunc to consume those
+// already queued
+func (c *TapCache) Close() {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mu.Unlock()
+	<-c.done
+}
@@ -0,0 +1,37 @@
+package httpcache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTapCache(t *testing.T) {
+	entered, release := make(chan struct{}, 3), make(chan struct{})
+	var tapped []string
+	mem := NewMemoryCache(time.Hour)
+	c := NewTapCache(mem, func(key string, entry []byte) {
+		entered <- struct{}{}
+		<-release
+		tapped = append(tapped, key+"="+string(entry))
+	}, 1)
+
+	c.Set("a", []byte("1"))
+	<-entered
+	// a is being tapped, b fills the queue and c finds it full
+	c.Set("b", []byte("2"))
+	c.Set("c", []byte("3"))
+	close(release)
+	c.Close()
+	c.Set("d", []byte("4"))
+
+	if want := []string{"a=1", "b=2"}; !reflect.DeepEqual(tapped, want) {
+		t.Errorf("tapped %q, want %q", tapped, want)
+	}
+	if n := c.Dropped(); n != 1 {
+		t.Errorf("Dropped() = %d, want 1", n)
+	}
+	if keys := mem.Keys(); !reflect.DeepEqual(keys, []string{"a", "b", "c", "d"}) {
+		t.Errorf("stored %q, want every entry", keys)
+	}
+}
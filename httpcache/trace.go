@@ -0,0 +1,41 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+)
+
+// Stages of a request reported to a Tracer
+const (
+	// StageRequest spans the whole of a request made through the Transport
+	StageRequest = "request"
+	// StageLookup is the lookup of a stored response
+	StageLookup = "lookup"
+	// StageFetch is the request sent upstream, including its retries
+	StageFetch = "fetch"
+	// StageStore is the storing of a response
+	StageStore = "store"
+)
+
+// Tracer is told about the stages of the requests made through a Transport,
+// e.g. to record them as the spans of a distributed trace. The
+// httpcache/otel package provides one for OpenTelemetry.
+type Tracer interface {
+	// Start is called as a stage of req begins. It returns the context the
+	// stage carries on with, e.g. one holding a new span, and a function
+	// called once the stage ends with its response, which is nil for a
+	// lookup that missed, or its error. For StageFetch, req is the request
+	// about to be sent upstream, whose Header Start may modify, e.g. to
+	// propagate the trace context.
+	Start(ctx context.Context, stage string, req *http.Request) (context.Context, func(resp *http.Response, err error))
+}
+
+// trace starts stage of req with the Tracer, if any, and returns req with the
+// context of the stage along with the function ending it
+func (t *Transport) trace(req *http.Request, stage string) (*http.Request, func(*http.Response, error)) {
+	if t.Tracer == nil {
+		return req, func(*http.Response, error) {}
+	}
+	ctx, end := t.Tracer.Start(req.Context(), stage, req)
+	return req.WithContext(ctx), end
+}
@@ -0,0 +1,50 @@
+package httpcache
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// varyHeaders returns the request headers resp varies on, lower-cased and
+// sorted, and false if it varies on "*" and can't be stored
+func varyHeaders(resp *http.Response) ([]string, bool) {
+	var names []string
+	for _, name := range headerAllCommaSepValues(resp.Header, "Vary") {
+		name = strings.ToLower(name)
+		if name == "*" {
+			return nil, false
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// drop duplicates
+	n := 0
+	for i, name := range names {
+		if i == 0 || name != names[n-1] {
+			names[n] = name
+			n++
+		}
+	}
+	return names[:n], true
+}
+
+// varyKey returns the key the variant of a response varying on names that
+// answers req is stored under, given the key of the request. The entry at key
+// itself records names, see varyEntryPrefix.
+func varyKey(key string, req *http.Request, names []string) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = headerKeyPart(req.Header, name)
+	}
+	return key + " vary:" + strings.Join(parts, " ")
+}
+
+// varyStorable returns false if resp varies on "*"
+func varyStorable(resp *http.Response) bool {
+	_, ok := varyHeaders(resp)
+	return ok
+}
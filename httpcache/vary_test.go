@@ -0,0 +1,39 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVaryVariants(t *testing.T) {
+	origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprint(w, r.Header.Get("Accept-Language"))
+	})
+	tr := NewTransport(NewMemoryCache(time.Hour))
+
+	tests := []struct {
+		lang      string
+		fromCache bool
+	}{
+		{"en", false},
+		{"fr", false},
+		{"en", true},
+		{"fr", true},
+		{"", false},
+		{"", true},
+	}
+	for i, tt := range tests {
+		header := []string{"Accept-Language", tt.lang}
+		if tt.lang == "" {
+			header = nil
+		}
+		resp, body := mustGet(t, tr, origin.URL, header...)
+		if body != tt.lang || (resp.Header.Get(XFromCache) == "1") != tt.fromCache {
+			t.Errorf("request %d: got %q, from cache %q, want the %q variant, from cache %v", i, body, resp.Header.Get(XFromCache), tt.lang, tt.fromCache)
+		}
+	}
+}
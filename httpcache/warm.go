@@ -0,0 +1,88 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Warm primes the cache by fetching each of urls through t with a GET
+// request, at most concurrency at a time, e.g. at startup so that the first
+// clients don't pay for the misses. It returns the error of each URL that
+// couldn't be fetched or was answered with a status of 400 or above, or nil
+// if there are none. URLs not yet fetched when ctx ends, or when t is shut
+// down, fail with the error of ctx.
+//
+// If t.Workers is set, the fetches run on its free workers, and in the
+// calling goroutine when none is, so that Warm never waits for a worker,
+// which would never come if it is called on the last one. Call it on a
+// worker, e.g. with t.Workers.Go or Do, for every fetch to count against the
+// pool.
+func (t *Transport) Warm(ctx context.Context, urls []string, concurrency int) map[string]error {
+	ctx, cancel := t.withShutdown(ctx)
+	defer cancel()
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var mu sync.Mutex
+	var errs map[string]error
+	fail := func(u string, err error) {
+		mu.Lock()
+		if errs == nil {
+			errs = make(map[string]error)
+		}
+		errs[u] = err
+		mu.Unlock()
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fail(u, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		u := u
+		fetch := func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := t.warm(ctx, u); err != nil {
+				fail(u, err)
+			}
+		}
+		if !t.Workers.TryGo(fetch) {
+			fetch()
+		}
+	}
+	wg.Wait()
+	return errs
+}
+
+// warm fetches rawurl through t and reads the response in full, so that it is
+// stored
+func (t *Transport) warm(ctx context.Context, rawurl string) error {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("httpcache: %s returned %s", rawurl, resp.Status)
+	}
+	return nil
+}
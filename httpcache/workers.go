@@ -0,0 +1,94 @@
+package httpcache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// WorkerPool bounds the number of goroutines doing background work at once,
+// e.g. the background revalidations, Refresher refreshes and Warm fetches of
+// a Transport, and the health checks of the proxies sharing it, so that a busy
+// proxy doesn't spawn them without limit. A nil *WorkerPool doesn't bound
+// anything.
+type WorkerPool struct {
+	slots  chan struct{}
+	active int32
+}
+
+// NewWorkerPool returns a new WorkerPool running at most size workers at
+// once, 1 if size is not positive
+func NewWorkerPool(size int) *WorkerPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &WorkerPool{slots: make(chan struct{}, size)}
+}
+
+// Go runs f in a new goroutine once a worker is free, waiting for one until
+// ctx ends, in which case it returns false without running f
+func (p *WorkerPool) Go(ctx context.Context, f func()) bool {
+	if p == nil {
+		go f()
+		return true
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	go p.run(f)
+	return true
+}
+
+// TryGo runs f in a new goroutine if a worker is free, and returns false
+// without running it otherwise
+func (p *WorkerPool) TryGo(f func()) bool {
+	if p == nil {
+		go f()
+		return true
+	}
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return false
+	}
+	go p.run(f)
+	return true
+}
+
+// Do runs f in the calling goroutine once a worker is free, counting it as
+// one of the pool's workers meanwhile, e.g. for the work of a long-lived
+// goroutine looping on a ticker
+func (p *WorkerPool) Do(f func()) {
+	if p == nil {
+		f()
+		return
+	}
+	p.slots <- struct{}{}
+	p.run(f)
+}
+
+func (p *WorkerPool) run(f func()) {
+	atomic.AddInt32(&p.active, 1)
+	defer func() {
+		atomic.AddInt32(&p.active, -1)
+		<-p.slots
+	}()
+	f()
+}
+
+// Active returns the number of workers running
+func (p *WorkerPool) Active() int {
+	if p == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&p.active))
+}
+
+// Size returns the number of workers p runs at most, 0 if p is nil
+func (p *WorkerPool) Size() int {
+	if p == nil {
+		return 0
+	}
+	return cap(p.slots)
+}
@@ -0,0 +1,171 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// peak tracks the number of concurrent calls to enter and leave, and the
+// most there were at once
+type peak struct {
+	cur, max int32
+}
+
+func (p *peak) enter() {
+	n := atomic.AddInt32(&p.cur, 1)
+	for {
+		m := atomic.LoadInt32(&p.max)
+		if n <= m || atomic.CompareAndSwapInt32(&p.max, m, n) {
+			return
+		}
+	}
+}
+
+func (p *peak) leave() { atomic.AddInt32(&p.cur, -1) }
+
+func (p *peak) get() int { return int(atomic.LoadInt32(&p.max)) }
+
+func TestWorkerPool(t *testing.T) {
+	const size = 3
+	pool := NewWorkerPool(size)
+	var p peak
+	work := func() {
+		p.enter()
+		defer p.leave()
+		if n := pool.Active(); n > size {
+			t.Errorf("%d workers active, want at most %d", n, size)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(3)
+		pool.Go(context.Background(), func() { defer wg.Done(); work() })
+		if !pool.TryGo(func() { defer wg.Done(); work() }) {
+			wg.Done()
+		}
+		go func() { defer wg.Done(); pool.Do(work) }()
+	}
+	wg.Wait()
+	if got := p.get(); got > size {
+		t.Errorf("%d workers ran at once, want at most %d", got, size)
+	}
+	if n := pool.Active(); n != 0 {
+		t.Errorf("%d workers active once done, want 0", n)
+	}
+
+	release := make(chan struct{})
+	for i := 0; i < size; i++ {
+		pool.Go(context.Background(), func() { <-release })
+	}
+	if pool.TryGo(func() {}) {
+		t.Error("TryGo ran f with every worker busy")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if pool.Go(ctx, func() {}) {
+		t.Error("Go ran f with every worker busy until ctx ended")
+	}
+	close(release)
+
+	var nilPool *WorkerPool
+	done := make(chan struct{})
+	if !nilPool.Go(context.Background(), func() { close(done) }) {
+		t.Error("Go on a nil pool didn't run f")
+	}
+	<-done
+}
+
+func TestTransportWorkers(t *testing.T) {
+	const size = 2
+	var p peak
+	origin := newTestOrigin(t, staleHandler("stale", "", 100*time.Second))
+	tr := NewTransport(NewMemoryCache(time.Hour))
+	tr.StaleWhileRevalidate = time.Hour
+	tr.Workers = NewWorkerPool(size)
+	shutdownOnCleanup(t, tr)
+
+	const n = 10
+	url := func(i int) string { return fmt.Sprintf("%s/%d", origin.URL, i) }
+	for i := 0; i < n; i++ {
+		mustGet(t, tr, url(i))
+	}
+	origin.set(func(w http.ResponseWriter, r *http.Request) {
+		p.enter()
+		defer p.leave()
+		if active := tr.Workers.Active(); active > size {
+			t.Errorf("%d workers active, want at most %d", active, size)
+		}
+		time.Sleep(10 * time.Millisecond)
+		staleHandler("new", "", 0)(w, r)
+	})
+
+	// stale responses revalidated in the background, and new ones warmed
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, body, err := get(t, tr, url(i)); err != nil || body != "stale" {
+				t.Errorf("GET %s: got %q, %v, want the stale response", url(i), body, err)
+			}
+		}(i)
+	}
+	warm := make([]string, n)
+	for i := range warm {
+		warm[i] = url(n + i)
+	}
+	tr.Workers.Do(func() {
+		if errs := tr.Warm(context.Background(), warm, n); errs != nil {
+			t.Errorf("Warm: %v", errs)
+		}
+	})
+	wg.Wait()
+	if got := p.get(); got > size {
+		t.Errorf("origin got %d background requests at once, want at most %d", got, size)
+	}
+}
+
+func TestWarmWorkers(t *testing.T) {
+	for _, size := range []int{1, 3} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			var p peak
+			origin := newTestOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+				p.enter()
+				defer p.leave()
+				time.Sleep(5 * time.Millisecond)
+				w.Header().Set("Cache-Control", "max-age=3600")
+			})
+			tr := NewTransport(NewMemoryCache(time.Hour))
+			tr.Workers = NewWorkerPool(size)
+			shutdownOnCleanup(t, tr)
+
+			urls := make([]string, 12)
+			for i := range urls {
+				urls[i] = fmt.Sprintf("%s/%d", origin.URL, i)
+			}
+			done := make(chan map[string]error)
+			tr.Workers.Go(context.Background(), func() { done <- tr.Warm(context.Background(), urls, 8) })
+			select {
+			case errs := <-done:
+				if errs != nil {
+					t.Errorf("Warm: %v", errs)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Warm on a worker did not return")
+			}
+			if origin.count() != len(urls) {
+				t.Errorf("warmed %d URLs, want %d", origin.count(), len(urls))
+			}
+			if got := p.get(); got > size {
+				t.Errorf("%d URLs fetched at once, want at most the %d workers", got, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Package zstd implements httpcache.CodecZstd, zstd compression of cache
+// entries at rest, for the best ratio at some cost in speed. Importing it
+// registers the codec, so that httpcache.CompressingCache can write and read
+// entries with it, e.g.
+//
+//	import _ "github.com/bcicen/apiproxy/httpcache/zstd"
+package zstd
+
+import (
+	"github.com/bcicen/apiproxy/httpcache"
+	"github.com/klauspost/compress/zstd"
+)
+
+// codec compresses entries with a shared encoder and decoder, which are safe
+// for concurrent use through EncodeAll and DecodeAll
+type codec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func init() {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic(err)
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		panic(err)
+	}
+	httpcache.RegisterCodec(httpcache.CodecZstd, &codec{enc, dec})
+}
+
+func (c *codec) Compress(b []byte) ([]byte, error) {
+	return c.enc.EncodeAll(b, nil), nil
+}
+
+func (c *codec) Decompress(b []byte) ([]byte, error) {
+	return c.dec.DecodeAll(b, nil)
+}
+
+// NewCache returns a new httpcache.CompressingCache that zstd-compresses
+// entries before storing them in c
+func NewCache(c httpcache.Cache) *httpcache.CompressingCache {
+	return httpcache.NewCompressingCache(c, httpcache.CodecZstd)
+}
@@ -0,0 +1,50 @@
+package zstd_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+	"github.com/bcicen/apiproxy/httpcache/zstd"
+)
+
+func TestRoundTrip(t *testing.T) {
+	entry := bytes.Repeat([]byte(`{"id":1,"name":"x"},`), 100)
+	tests := []struct {
+		name             string
+		written, current httpcache.CompressionCodec
+	}{
+		{"zstd", httpcache.CodecZstd, httpcache.CodecZstd},
+		{"zstd read as gzip", httpcache.CodecZstd, httpcache.CodecGzip},
+		{"gzip read as zstd", httpcache.CodecGzip, httpcache.CodecZstd},
+		{"none read as zstd", httpcache.CodecNone, httpcache.CodecZstd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := httpcache.NewMemoryCache(time.Hour)
+			httpcache.NewCompressingCache(mem, tt.written).Set("k", entry)
+			if tt.written == httpcache.CodecZstd && mem.Size() >= int64(len(entry)) {
+				t.Errorf("stored %d bytes for an entry of %d", mem.Size(), len(entry))
+			}
+			got, ok := httpcache.NewCompressingCache(mem, tt.current).Get("k")
+			if !ok || !bytes.Equal(got, entry) {
+				t.Errorf("Get = %.20q, %v, want the entry", got, ok)
+			}
+		})
+	}
+}
+
+func TestCorruptEntry(t *testing.T) {
+	mem := httpcache.NewMemoryCache(time.Hour)
+	c := zstd.NewCache(mem)
+	c.Set("k", bytes.Repeat([]byte("x"), 1000))
+	stored, _ := mem.Get("k")
+	mem.Set("k", stored[:len(stored)/2])
+	if _, ok := c.Get("k"); ok {
+		t.Errorf("corrupt entry read")
+	}
+	if _, ok := mem.Get("k"); ok {
+		t.Errorf("corrupt entry not removed")
+	}
+}
@@ -0,0 +1,220 @@
+package apiproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// NewMultiHostReverseProxy constructs a reverse proxy handler that rotates
+// requests across targets, which serve the same API and differ only in scheme
+// and host; the path of the first target is used for all of them.
+//
+// Requests are directed to the first target and then sent to the chosen one
+// by the proxy's Transport, a *HostPool, so a caching transport wrapping it
+// keys responses the same whichever target served them:
+//
+//	pool := proxy.Transport.(*HostPool)
+//	proxy.Transport = &httpcache.Transport{Transport: pool, Cache: cache}
+//
+// Health checks are off until started on the pool with StartHealthChecks.
+func NewMultiHostReverseProxy(targets []*url.URL) *httputil.ReverseProxy {
+	proxy := NewSingleHostReverseProxy(targets[0])
+	proxy.Transport = NewHostPool(targets...)
+	return proxy
+}
+
+// NewCachingLoadBalancedReverseProxy constructs a caching reverse proxy
+// handler spreading requests across targets, like NewMultiHostReverseProxy,
+// with strategy, and caching them as configured by opts like
+// NewCachingReverseProxy. All targets share one cache, keyed on the first
+// target.
+//
+// The pool choosing the targets is returned too, so that health checks can
+// be started on it with StartHealthChecks.
+func NewCachingLoadBalancedReverseProxy(targets []*url.URL, strategy Strategy, opts Options) (*httputil.ReverseProxy, *HostPool) {
+	pool := NewHostPool(targets...)
+	pool.Strategy = strategy
+	pool.Transport = opts.Transport
+	opts.Transport = pool
+	proxy := NewCachingReverseProxy(targets[0], opts)
+	pool.Workers = proxy.Transport.(*httpcache.Transport).Workers
+	return proxy, pool
+}
+
+// Strategy selects the target of each request sent through a HostPool
+type Strategy int
+
+const (
+	// RoundRobin sends requests to each target in turn
+	RoundRobin Strategy = iota
+	// LeastConnections sends each request to the target with the fewest
+	// requests in flight, taking turns between those tied
+	LeastConnections
+)
+
+// HostPool is an implementation of net/http.RoundTripper that spreads requests
+// across its targets as chosen by Strategy, skipping targets marked down by
+// health checks. If every target is down, all of them are tried in turn
+// regardless.
+type HostPool struct {
+	// Transport is the underlying transport. If nil, net/http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	Strategy Strategy
+
+	// PassiveFailures, if positive, marks a target down once that many
+	// consecutive requests to it fail with an error, such as a timeout, or a
+	// 5xx response. It is tried again after PassiveCooldown, 30s if unset, or
+	// as soon as a health check succeeds.
+	PassiveFailures int
+	PassiveCooldown time.Duration
+
+	// OnStateChange, if set, is called whenever a target is marked down or up
+	// again, e.g. to export its state as a metric. It must not block.
+	OnStateChange func(target *url.URL, healthy bool)
+
+	// Workers, if set, runs the health checks, e.g. to share the
+	// httpcache.Transport.Workers of the proxy fronting the pool, so that
+	// checks wait for a free worker
+	Workers *httpcache.WorkerPool
+
+	targets []*url.URL
+	next    uint32
+
+	// down flags the targets marked down, retryUntil is the time in Unix
+	// nanoseconds at which those marked down by passive checks are tried
+	// again, failures counts the consecutive failed requests to each target,
+	// and active the requests in flight to each target until their body is
+	// closed, all atomically
+	down       []int32
+	retryUntil []int64
+	failures   []int32
+	active     []int32
+
+	checkMu sync.Mutex
+	stop    chan struct{}
+	checks  sync.WaitGroup
+}
+
+// NewHostPool returns a new HostPool rotating across targets
+func NewHostPool(targets ...*url.URL) *HostPool {
+	return &HostPool{
+		targets:    targets,
+		down:       make([]int32, len(targets)),
+		retryUntil: make([]int64, len(targets)),
+		failures:   make([]int32, len(targets)),
+		active:     make([]int32, len(targets)),
+	}
+}
+
+// RoundTrip sends req to the healthy target chosen by Strategy, rewriting its
+// URL scheme, host and Host header to the target's.
+func (p *HostPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := p.pick()
+	target := p.targets[i]
+	u := *req.URL
+	u.Scheme, u.Host = target.Scheme, target.Host
+	req = cloneRequest(req)
+	req.URL = &u
+	req.Host = target.Host
+
+	atomic.AddInt32(&p.active[i], 1)
+	resp, err := p.transport().RoundTrip(req)
+	if err != nil {
+		atomic.AddInt32(&p.active[i], -1)
+		if req.Context().Err() == nil {
+			p.observe(i, false)
+		}
+		return nil, err
+	}
+	p.observe(i, resp.StatusCode < 500)
+	body := &activeBody{ReadCloser: resp.Body, active: &p.active[i]}
+	if rw, ok := resp.Body.(io.ReadWriteCloser); ok {
+		// the connection of a 101 Switching Protocols response, which the
+		// proxy must be able to write to
+		resp.Body = activeConn{body, rw}
+	} else {
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// pick returns the index of the target for the next request, skipping those
+// marked down unless all are
+func (p *HostPool) pick() int {
+	n := len(p.targets)
+	start := int(atomic.AddUint32(&p.next, 1) - 1)
+	now := time.Now().UnixNano()
+	best := -1
+	var bestActive int32
+	for i := 0; i < n; i++ {
+		j := (start + i) % n
+		if atomic.LoadInt32(&p.down[j]) != 0 {
+			retry := atomic.LoadInt64(&p.retryUntil[j])
+			if retry == 0 || now < retry {
+				continue
+			}
+			// a single failure marks it down again
+			p.setDown(j, false)
+			atomic.StoreInt32(&p.failures[j], int32(p.PassiveFailures-1))
+		}
+		if p.Strategy != LeastConnections {
+			return j
+		}
+		if a := atomic.LoadInt32(&p.active[j]); best < 0 || a < bestActive {
+			best, bestActive = j, a
+		}
+	}
+	if best < 0 {
+		return start % n
+	}
+	return best
+}
+
+// activeBody is the body of a response from a HostPool target, which counts
+// as in flight until the body is closed
+type activeBody struct {
+	io.ReadCloser
+	active *int32
+	once   sync.Once
+}
+
+func (b *activeBody) Close() error {
+	b.once.Do(func() { atomic.AddInt32(b.active, -1) })
+	return b.ReadCloser.Close()
+}
+
+// activeConn is an activeBody that can be written to
+type activeConn struct {
+	*activeBody
+	w io.Writer
+}
+
+func (c activeConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+func (p *HostPool) transport() http.RoundTripper {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return http.DefaultTransport
+}
+
+// Healthy returns the targets not currently marked down
+func (p *HostPool) Healthy() []*url.URL {
+	var healthy []*url.URL
+	for i, target := range p.targets {
+		if atomic.LoadInt32(&p.down[i]) == 0 {
+			healthy = append(healthy, target)
+		}
+	}
+	return healthy
+}
@@ -0,0 +1,94 @@
+package apiproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// namedBackends returns n backends answering with their name, a, b, c...,
+// with max-age set to maxAge, and their URLs
+func namedBackends(t *testing.T, n int, maxAge int) ([]*backend, []*url.URL) {
+	var backends []*backend
+	var urls []*url.URL
+	for i := 0; i < n; i++ {
+		name := string(rune('a' + i))
+		b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			if maxAge > 0 {
+				w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+			}
+			fmt.Fprintf(w, "%s %s %s", name, r.Host, r.URL.Path)
+		})
+		u, _ := url.Parse(b.URL)
+		backends, urls = append(backends, b), append(urls, u)
+	}
+	return backends, urls
+}
+
+func TestHostPoolRoundRobin(t *testing.T) {
+	backends, urls := namedBackends(t, 3, 0)
+	pool := NewHostPool(urls...)
+	for i := 0; i < 6; i++ {
+		_, body := roundTrip(t, pool, "http://api.example.com/users")
+		want := fmt.Sprintf("%c %s /users", 'a'+i%3, urls[i%3].Host)
+		if body != want {
+			t.Errorf("request %d: got %q, want %q", i, body, want)
+		}
+	}
+	for i, b := range backends {
+		if n := b.count(); n != 2 {
+			t.Errorf("target %d got %d requests, want 2", i, n)
+		}
+	}
+}
+
+func TestHostPoolLeastConnections(t *testing.T) {
+	_, urls := namedBackends(t, 2, 0)
+	pool := NewHostPool(urls...)
+	pool.Strategy = LeastConnections
+
+	// a response whose body is still open keeps its target busy
+	req, _ := http.NewRequest("GET", "http://api.example.com/", nil)
+	open, err := pool.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy := open.Request.URL.Host
+	for i := 0; i < 3; i++ {
+		resp, _ := roundTrip(t, pool, "http://api.example.com/")
+		if resp.Request.URL.Host == busy {
+			t.Errorf("request %d sent to the busy target", i)
+		}
+	}
+	open.Body.Close()
+
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		resp, _ := roundTrip(t, pool, "http://api.example.com/")
+		seen[resp.Request.URL.Host] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("idle targets used: %v, want both taking turns", seen)
+	}
+}
+
+func TestCachingLoadBalancedReverseProxy(t *testing.T) {
+	backends, urls := namedBackends(t, 2, 60)
+	proxy, _ := NewCachingLoadBalancedReverseProxy(urls, RoundRobin, Options{MaxTTL: time.Minute})
+
+	first := serve(proxy, "http://proxy.example.com/users").Body.String()
+	for i := 0; i < 3; i++ {
+		if body := serve(proxy, "http://proxy.example.com/users").Body.String(); body != first {
+			t.Errorf("request %d: got %q, want the cached %q", i, body, first)
+		}
+	}
+	serve(proxy, "http://proxy.example.com/repos")
+	if n := backends[0].count() + backends[1].count(); n != 2 {
+		t.Errorf("targets got %d requests, want one per path with the cache shared", n)
+	}
+	if backends[0].count() != 1 || backends[1].count() != 1 {
+		t.Errorf("targets got %d and %d requests, want the misses spread", backends[0].count(), backends[1].count())
+	}
+}
@@ -1,24 +1,298 @@
 package apiproxy
 
 import (
+	"bytes"
+	"context"
 	"github.com/bcicen/apiproxy/httpcache"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"time"
 )
 
-// NewCachingSingleHostReverseProxy constructs a caching reverse proxy handler for
-// target. If cache is nil, a volatile, in-memory cache is used.
-func NewCachingSingleHostReverseProxy(target *url.URL, cache httpcache.Cache, maxTTL time.Duration) *httputil.ReverseProxy {
+// Options configures a caching reverse proxy built by NewCachingReverseProxy.
+type Options struct {
+	// Cache stores the proxied responses. If nil, a volatile, in-memory cache
+	// is used, keeping entries for at most MaxTTL; responses without
+	// freshness information of their own are served from it for that long.
+	Cache httpcache.Cache
+	// MaxTTL must be >0 if Cache is nil.
+	MaxTTL time.Duration
+	// MaxBytes, if positive and Cache is nil, bounds the size of the
+	// in-memory cache, see httpcache.NewMemoryCacheWithSize.
+	MaxBytes int64
+	// JanitorInterval, if positive and Cache is nil, sweeps the expired
+	// entries out of the in-memory cache every interval, so that responses
+	// never requested again don't stay in memory, see
+	// httpcache.MemoryCache.StartJanitor. The janitor runs until the cache is
+	// closed, e.g. by Server.Shutdown with the proxy's Transport's Cache in
+	// Server.Caches, or by Proxy.Shutdown for the routes of a Proxy.
+	JanitorInterval time.Duration
+	// Compression, if set, compresses the entries stored in the cache, e.g.
+	// to fit more large JSON payloads in memory, see
+	// httpcache.CompressingCache. httpcache.CodecZstd requires the
+	// httpcache/zstd package to be imported.
+	Compression httpcache.CompressionCodec
+
+	// Transport is the transport used to reach the target, e.g. one with
+	// custom timeouts or TLS configuration. If nil,
+	// net/http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Upstream, if set and Transport is nil, configures the connections to
+	// the target, e.g. to time out requests to a slow one, see
+	// NewUpstreamTransport. Requests that time out are answered with 504
+	// Gateway Timeout.
+	Upstream *Upstream
+
+	// Logger receives the caching transport's messages. If nil, nothing is
+	// logged.
+	Logger httpcache.Logger
+
+	// Tracer, if set, is told about the stages of the proxied requests, e.g.
+	// to record them as OpenTelemetry spans with the httpcache/otel package,
+	// see httpcache.Transport.Tracer.
+	Tracer httpcache.Tracer
+
+	// StatusTTLs, if set, caches responses with the status codes it lists for
+	// at most the given time, e.g. to cache 404s briefly, see
+	// httpcache.Transport.StatusTTLs.
+	StatusTTLs map[int]time.Duration
+
+	// MaxBodyBytes, if positive, is the largest response body stored, and
+	// CacheableContentTypes, if set, lists the only media types stored, e.g.
+	// to keep large downloads out of a memory cache, see
+	// httpcache.Transport.MaxBodyBytes and CacheableContentTypes.
+	MaxBodyBytes          int64
+	CacheableContentTypes []string
+
+	// HeuristicFreshness, if positive, keeps responses without explicit
+	// freshness information fresh for that fraction of the time since they
+	// were last modified, see httpcache.Transport.HeuristicFraction.
+	HeuristicFreshness float64
+
+	// CacheHeader and CacheKeyHeader, if set, name headers reporting how
+	// each response was produced, e.g. X-Cache: HIT, and its cache key, and
+	// SuppressAproxyHeaders removes the X-Aproxy ones, see
+	// httpcache.Transport.CacheHeader.
+	CacheHeader           string
+	CacheKeyHeader        string
+	SuppressAproxyHeaders bool
+
+	// Offline serves only the responses in the cache, fresh or not, and
+	// answers the other requests with 504 Gateway Timeout without contacting
+	// the target, and OfflineHeader, if set, names a request header doing so
+	// for the requests carrying it, see httpcache.Transport.Offline.
+	Offline       bool
+	OfflineHeader string
+
+	// Shared makes the proxy behave as a cache shared between clients, see
+	// httpcache.NewSharedTransport.
+	Shared bool
+
+	// KeyPrefixFunc, if set, partitions the cache by the namespace it returns
+	// for each request, e.g. ClientKeyPrefix, so that responses differing
+	// per client are never served to another, see
+	// httpcache.Transport.KeyPrefixFunc.
+	KeyPrefixFunc func(req *http.Request) string
+
+	// Auth, if set, adds credentials to the requests sent to the target, see
+	// AuthTransport.
+	Auth Authenticator
+
+	// RateLimit, if set, caps the rate of requests the cache can't answer
+	// that are sent to the target, see RateLimiter.
+	RateLimit *RateLimit
+
+	// Hooks, if set, run around the requests the cache can't answer, before
+	// they are rate limited. Its Transport is replaced with the one reaching
+	// the target.
+	Hooks *Hooks
+
+	// Rewrite, if set, rewrites the paths and headers of the requests before
+	// they are looked up in the cache, and the headers and bodies of the
+	// responses before they are stored, see Rewrite.
+	Rewrite *Rewrite
+
+	// Fixtures, if set, is a HAR archive whose responses are stored in the
+	// cache once the proxy is built, e.g. one written by httpcache.WriteHAR
+	// to replay an API in integration tests, along with Offline. Failures
+	// are reported to Logger.
+	Fixtures []byte
+
+	// Warm lists URLs, relative to the target, fetched in the background
+	// once the proxy is built to prime its cache, WarmConcurrency (4 if
+	// unset) at a time, and on at most BackgroundWorkers goroutines if it is
+	// set, so its Transport must not be configured further. Failures are
+	// reported to Logger.
+	Warm            []string
+	WarmConcurrency int
+
+	// BackgroundWorkers, if positive, bounds the goroutines doing the
+	// background work of the proxy's Transport, and the health checks of the
+	// pool of NewCachingLoadBalancedReverseProxy, see
+	// httpcache.Transport.Workers.
+	BackgroundWorkers int
+}
+
+// NewCachingReverseProxy constructs a caching reverse proxy handler for target
+// configured by opts.
+//
+// The proxy's Transport is the *httpcache.Transport doing the caching, which
+// may be configured further before the proxy is used, e.g. to set its
+// cacheability options, or passed to httpcache.PurgeHandler to serve a purge
+// endpoint.
+func NewCachingReverseProxy(target *url.URL, opts Options) *httputil.ReverseProxy {
 	proxy := NewSingleHostReverseProxy(target)
+	cache := opts.Cache
 	if cache == nil {
-		cache = httpcache.NewMemoryCache(maxTTL)
+		mc := newMemoryCache(opts)
+		if opts.JanitorInterval > 0 {
+			mc.StartJanitor(opts.JanitorInterval)
+		}
+		cache = mc
+	}
+	if opts.Compression != httpcache.CodecNone {
+		cc := httpcache.NewCompressingCache(cache, opts.Compression)
+		cc.Logger = opts.Logger
+		cache = cc
+	}
+	t := httpcache.NewTransport(cache)
+	if opts.Shared {
+		t = httpcache.NewSharedTransport(cache)
+	}
+	t.Transport = opts.Transport
+	if t.Transport == nil && opts.Upstream != nil {
+		t.Transport = NewUpstreamTransport(*opts.Upstream)
+		proxy.ErrorHandler = upstreamErrorHandler(func(format string, args ...interface{}) {
+			if opts.Logger != nil {
+				opts.Logger.Errorf(format, args...)
+			}
+		})
+	}
+	if opts.Auth != nil {
+		t.Transport = &AuthTransport{Transport: t.Transport, Auth: opts.Auth}
+	}
+	if opts.RateLimit != nil {
+		l := NewRateLimiter(*opts.RateLimit)
+		l.Transport = t.Transport
+		t.Transport = l
+	}
+	if opts.Hooks != nil {
+		opts.Hooks.Transport = t.Transport
+		t.Transport = opts.Hooks
+	}
+	if rw := opts.Rewrite; rw != nil {
+		t.Transport = &rewriteTransport{transport: t.Transport, rewrite: rw}
+		director := proxy.Director
+		proxy.Director = func(r *http.Request) {
+			rw.rewriteRequest(r)
+			director(r)
+		}
+	}
+	t.Logger = opts.Logger
+	t.Tracer = opts.Tracer
+	t.StatusTTLs = opts.StatusTTLs
+	t.MaxBodyBytes = opts.MaxBodyBytes
+	t.CacheableContentTypes = opts.CacheableContentTypes
+	t.HeuristicFraction = opts.HeuristicFreshness
+	t.KeyPrefixFunc = opts.KeyPrefixFunc
+	t.Offline = opts.Offline
+	t.OfflineHeader = opts.OfflineHeader
+	t.CacheHeader = opts.CacheHeader
+	t.CacheKeyHeader = opts.CacheKeyHeader
+	t.SuppressAproxyHeaders = opts.SuppressAproxyHeaders
+	if opts.BackgroundWorkers > 0 {
+		t.Workers = httpcache.NewWorkerPool(opts.BackgroundWorkers)
+	}
+	proxy.Transport = t
+	if len(opts.Fixtures) > 0 {
+		if _, err := t.ImportHAR(bytes.NewReader(opts.Fixtures)); err != nil && opts.Logger != nil {
+			opts.Logger.Errorf("fixtures: %s", err)
+		}
+	}
+	if len(opts.Warm) > 0 {
+		t.Workers.Go(context.Background(), func() { warm(proxy, target, opts) })
 	}
-	proxy.Transport = httpcache.NewTransport(cache)
 	return proxy
 }
 
+// newMemoryCache returns the in-memory cache used when opts set no Cache
+func newMemoryCache(opts Options) *httpcache.MemoryCache {
+	if opts.MaxBytes > 0 {
+		return httpcache.NewMemoryCacheWithSize(opts.MaxTTL, opts.MaxBytes)
+	}
+	return httpcache.NewMemoryCache(opts.MaxTTL)
+}
+
+// warm fetches the URLs in opts.Warm, relative to target, through the
+// caching transport of proxy
+func warm(proxy *httputil.ReverseProxy, target *url.URL, opts Options) {
+	t := proxy.Transport.(*httpcache.Transport)
+	urls := make([]string, 0, len(opts.Warm))
+	for _, ref := range opts.Warm {
+		// resolved as the proxy would resolve a request for ref
+		req, err := http.NewRequest("GET", ref, nil)
+		if err != nil {
+			if t.Logger != nil {
+				t.Logger.Errorf("warm %s: %s", ref, err)
+			}
+			continue
+		}
+		proxy.Director(req)
+		urls = append(urls, req.URL.String())
+	}
+	concurrency := opts.WarmConcurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	for u, err := range t.Warm(context.Background(), urls, concurrency) {
+		if t.Logger != nil {
+			t.Logger.Errorf("warm %s: %s", u, err)
+		}
+	}
+}
+
+// NewCachingSingleHostReverseProxy constructs a caching reverse proxy handler for
+// target. If cache is nil, a volatile, in-memory cache is used, keeping entries
+// for at most maxTTL; responses without freshness information of their own are
+// served from it for that long. It is equivalent to NewCachingReverseProxy with
+// only Cache and MaxTTL set.
+//
+// The proxy's Transport is the *httpcache.Transport doing the caching, which
+// may be configured further before the proxy is used, e.g. to set its
+// Logger (nothing is logged by default), or passed to httpcache.PurgeHandler
+// to serve a purge endpoint.
+func NewCachingSingleHostReverseProxy(target *url.URL, cache httpcache.Cache, maxTTL time.Duration) *httputil.ReverseProxy {
+	return NewCachingReverseProxy(target, Options{Cache: cache, MaxTTL: maxTTL})
+}
+
+// PurgeMethodHandler returns a handler answering PURGE requests by removing
+// the response cached by proxy for the URL they are made to, and passing all
+// other requests to proxy. proxy's Transport must be the *httpcache.Transport
+// doing the caching, as set by NewCachingReverseProxy.
+func PurgeMethodHandler(proxy *httputil.ReverseProxy) http.Handler {
+	t, ok := proxy.Transport.(*httpcache.Transport)
+	if !ok {
+		panic("proxy.Transport must be a *httpcache.Transport")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PURGE" {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		// the cache is keyed on the URL requests are proxied to
+		out := cloneRequest(r)
+		u := *r.URL
+		out.URL = &u
+		proxy.Director(out)
+		t.Purge(out.URL)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
 // NewSingleHostReverseProxy wraps net/http/httputil.NewSingleHostReverseProxy
 // and sets the Host header based on the target URL.
 func NewSingleHostReverseProxy(url *url.URL) *httputil.ReverseProxy {
@@ -30,3 +304,88 @@ func NewSingleHostReverseProxy(url *url.URL) *httputil.ReverseProxy {
 	}
 	return proxy
 }
+
+// Route maps the requests it matches to an upstream target, proxied with its
+// own caching options.
+type Route struct {
+	// Host, if set, restricts the route to requests for this host name,
+	// compared without port and case-insensitively.
+	Host string
+	// Prefix, if set, restricts the route to requests whose path is Prefix or
+	// lies below it. The prefix is stripped before the request is proxied, so
+	// a route with Prefix "/github" sends "/github/users" to "/users" on
+	// Target.
+	Prefix string
+
+	Target  *url.URL
+	Options Options
+}
+
+// NewCachingMultiHostReverseProxy constructs a handler proxying each request to
+// the target of the first of routes that matches it, through a caching
+// reverse proxy built from the route's Options by NewCachingReverseProxy.
+// Requests matching no route get a 404 Not Found. See Proxy for one whose
+// routes can be changed while it serves.
+func NewCachingMultiHostReverseProxy(routes []Route) http.Handler {
+	return newRouteSet(routes)
+}
+
+// routeSet proxies requests to the first of its routes that matches them
+type routeSet struct {
+	routes  []Route
+	proxies []*httputil.ReverseProxy
+}
+
+func newRouteSet(routes []Route) *routeSet {
+	s := &routeSet{routes: routes, proxies: make([]*httputil.ReverseProxy, len(routes))}
+	for i, route := range routes {
+		s.proxies[i] = NewCachingReverseProxy(route.Target, route.Options)
+	}
+	return s
+}
+
+// shutdown stops the background work of the caching transports of s, see
+// httpcache.Transport.Shutdown
+func (s *routeSet) shutdown(ctx context.Context) error {
+	for _, proxy := range s.proxies {
+		if err := proxy.Transport.(*httpcache.Transport).Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *routeSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for i := range s.routes {
+		if r2, ok := s.routes[i].match(r); ok {
+			s.proxies[i].ServeHTTP(w, r2)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// match returns r as it is proxied by route, and true if route matches it
+func (route *Route) match(r *http.Request) (*http.Request, bool) {
+	if route.Host != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, route.Host) {
+			return nil, false
+		}
+	}
+
+	prefix := strings.TrimSuffix(route.Prefix, "/")
+	if prefix == "" {
+		return r, true
+	}
+	u := *r.URL
+	if !stripPathPrefix(&u, prefix) {
+		return nil, false
+	}
+	r2 := cloneRequest(r)
+	r2.URL = &u
+	return r2, true
+}
@@ -0,0 +1,178 @@
+package apiproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// backend is a target server counting the requests it gets
+type backend struct {
+	*httptest.Server
+	requests int32
+}
+
+func newBackend(t *testing.T, h http.HandlerFunc) *backend {
+	b := &backend{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&b.requests, 1)
+		h(w, r)
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+// count returns the number of requests b got
+func (b *backend) count() int {
+	return int(atomic.LoadInt32(&b.requests))
+}
+
+// roundTrip sends a GET for url through rt and returns the response with its
+// body read
+func roundTrip(t *testing.T, rt http.RoundTripper, url string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+// serve sends a GET for target, with the headers given as name and value
+// pairs, to h and returns the recorded response
+func serve(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		route   Route
+		target  string
+		want    string
+		wantRaw string
+	}{
+		{Route{}, "http://example.com/a", "/a", ""},
+		{Route{Host: "Example.com"}, "http://example.com:8080/a", "/a", ""},
+		{Route{Host: "other.com"}, "http://example.com/a", "", ""},
+		{Route{Prefix: "/github"}, "http://example.com/github", "/", ""},
+		{Route{Prefix: "/github/"}, "http://example.com/github/users", "/users", ""},
+		{Route{Prefix: "/github"}, "http://example.com/githubx", "", ""},
+		{Route{Prefix: "/github"}, "http://example.com/github/repos/a%2Fb/issues", "/repos/a/b/issues", "/repos/a%2Fb/issues"},
+		{Route{Prefix: "/git hub"}, "http://example.com/git%20hub/a%2Fb", "/a/b", "/a%2Fb"},
+		{Route{Host: "example.com", Prefix: "/api"}, "http://example.com/api/x", "/x", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		before := r.URL.String()
+		r2, ok := tt.route.match(r)
+		if ok != (tt.want != "") {
+			t.Errorf("%+v matching %s: got %v, want %v", tt.route, tt.target, ok, !ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if r2.URL.Path != tt.want || r2.URL.RawPath != tt.wantRaw {
+			t.Errorf("%+v matching %s: got path %q, raw %q, want %q, %q", tt.route, tt.target, r2.URL.Path, r2.URL.RawPath, tt.want, tt.wantRaw)
+		}
+		if r.URL.String() != before {
+			t.Errorf("%+v matching %s: the request was modified in place", tt.route, tt.target)
+		}
+	}
+}
+
+func TestCachingMultiHostReverseProxy(t *testing.T) {
+	echo := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=3600")
+			fmt.Fprint(w, name, " ", r.URL.EscapedPath())
+		}
+	}
+	github, gitlab, internal := newBackend(t, echo("github")), newBackend(t, echo("gitlab")), newBackend(t, echo("internal"))
+	route := func(host, prefix string, b *backend) Route {
+		target, _ := url.Parse(b.URL)
+		return Route{Host: host, Prefix: prefix, Target: target, Options: Options{MaxTTL: time.Hour}}
+	}
+	h := NewCachingMultiHostReverseProxy([]Route{
+		route("internal.example.com", "", internal),
+		route("", "/github", github),
+		route("", "/gitlab/", gitlab),
+	})
+
+	tests := []struct {
+		target, want string
+		code         int
+	}{
+		{"http://example.com/github/users", "github /users", 200},
+		{"http://example.com/gitlab", "gitlab /", 200},
+		{"http://example.com/github/repos/a%2Fb/issues", "github /repos/a%2Fb/issues", 200},
+		{"http://internal.example.com/github/users", "internal /github/users", 200},
+		{"http://example.com/bitbucket/users", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := serve(h, tt.target)
+		if rec.Code != tt.code || tt.code == 200 && rec.Body.String() != tt.want {
+			t.Errorf("GET %s: got %d %q, want %d %q", tt.target, rec.Code, rec.Body.String(), tt.code, tt.want)
+		}
+	}
+
+	// the responses are cached by the proxy of each route
+	serve(h, "http://example.com/github/users")
+	serve(h, "http://example.com/gitlab")
+	if github.count() != 2 || gitlab.count() != 1 {
+		t.Errorf("got %d requests to github and %d to gitlab, want 2 and 1", github.count(), gitlab.count())
+	}
+}
+
+func TestCachingReverseProxyWarm(t *testing.T) {
+	const workers = 2
+	var cur, max int32
+	b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		defer atomic.AddInt32(&cur, -1)
+		for m := atomic.LoadInt32(&max); n > m && !atomic.CompareAndSwapInt32(&max, m, n); m = atomic.LoadInt32(&max) {
+		}
+		time.Sleep(5 * time.Millisecond)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		fmt.Fprint(w, r.URL.Path)
+	})
+	target, _ := url.Parse(b.URL)
+	paths := make([]string, 10)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/%d", i)
+	}
+	proxy := NewCachingReverseProxy(target, Options{MaxTTL: time.Hour, Warm: paths, WarmConcurrency: 8, BackgroundWorkers: workers})
+
+	workerPool := proxy.Transport.(*httpcache.Transport).Workers
+	for deadline := time.Now().Add(5 * time.Second); b.count() < len(paths) || workerPool.Active() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("warmed %d of %d paths", b.count(), len(paths))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&max); got > workers {
+		t.Errorf("%d paths warmed at once, want at most the %d background workers", got, workers)
+	}
+	for _, path := range paths {
+		serve(proxy, path)
+	}
+	if b.count() != len(paths) {
+		t.Errorf("got %d requests to the target, want the warmed paths served from the cache", b.count())
+	}
+}
@@ -0,0 +1,171 @@
+package apiproxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is a limit of Requests per interval Per, e.g. 5000 per hour,
+// enforced with a token bucket holding at most Burst requests, or Requests if
+// Burst is 0.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+
+	// MaxWait is how long a request may be held back waiting for its turn.
+	// Requests that would have to wait longer are answered with 429 Too Many
+	// Requests without being sent. If 0, requests over the limit are never
+	// held back.
+	MaxWait time.Duration
+}
+
+// rate returns the number of requests allowed per second
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// burst returns the size of the token bucket
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// RateLimiter is an implementation of net/http.RoundTripper that caps the rate
+// of requests sent to each upstream host, e.g. to stay within the quota of a
+// third-party API. Behind a caching transport it only counts the requests the
+// cache can't answer.
+type RateLimiter struct {
+	// Transport is the underlying transport. If nil, net/http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	Limit RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket is the token bucket of a single host
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a new RateLimiter enforcing limit on each host
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	if limit.Requests <= 0 || limit.Per <= 0 {
+		panic("limit.Requests and limit.Per must be >0")
+	}
+	return &RateLimiter{
+		Limit:   limit,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// RoundTrip sends req once its host's limit allows, or returns a 429 Too Many
+// Requests response with a Retry-After header if that is more than MaxWait
+// away. If req's context ends while it waits, its error is returned.
+func (l *RateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait, ok := l.reserve(req.URL.Host, time.Now())
+	if !ok {
+		return tooManyRequests(req, wait), nil
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			l.cancel(req.URL.Host)
+			return nil, req.Context().Err()
+		}
+	}
+	return l.transport().RoundTrip(req)
+}
+
+// reserve takes a token from host's bucket at now, returning how long the
+// request must wait for it, and false, without taking it, if that is longer
+// than MaxWait
+func (l *RateLimiter) reserve(host string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[host]
+	if !ok {
+		b = newBucket(l.Limit, now)
+		l.buckets[host] = b
+	}
+	return b.take(l.Limit, now)
+}
+
+// newBucket returns a full bucket for limit at now
+func newBucket(limit RateLimit, now time.Time) *bucket {
+	return &bucket{tokens: limit.burst(), last: now}
+}
+
+// take takes a token from b at now, refilled at the rate of limit, returning
+// how long the request must wait for it, and false, without taking it, if
+// that is longer than limit.MaxWait
+func (b *bucket) take(limit RateLimit, now time.Time) (time.Duration, bool) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * limit.rate()
+		if max := limit.burst(); b.tokens > max {
+			b.tokens = max
+		}
+		b.last = now
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	wait := time.Duration(-b.tokens / limit.rate() * float64(time.Second))
+	if wait > limit.MaxWait {
+		b.tokens++
+		return wait, false
+	}
+	return wait, true
+}
+
+// cancel returns the token taken by a request to host that was not sent
+func (l *RateLimiter) cancel(host string) {
+	l.mu.Lock()
+	if b, ok := l.buckets[host]; ok {
+		b.tokens++
+	}
+	l.mu.Unlock()
+}
+
+func (l *RateLimiter) transport() http.RoundTripper {
+	if l.Transport != nil {
+		return l.Transport
+	}
+	return http.DefaultTransport
+}
+
+// tooManyRequests returns a 429 Too Many Requests response to req, telling the
+// client to retry after wait
+func tooManyRequests(req *http.Request, wait time.Duration) *http.Response {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	body := []byte("upstream rate limit exceeded\n")
+	return &http.Response{
+		Status:     "429 Too Many Requests",
+		StatusCode: http.StatusTooManyRequests,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": {"text/plain; charset=utf-8"},
+			"Retry-After":  {strconv.FormatInt(secs, 10)},
+		},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+}
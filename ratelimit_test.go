@@ -0,0 +1,102 @@
+package apiproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	type take struct {
+		at       time.Duration
+		wantWait time.Duration
+		wantOK   bool
+	}
+	tests := []struct {
+		name  string
+		limit RateLimit
+		takes []take
+	}{
+		{
+			name:  "fail fast",
+			limit: RateLimit{Requests: 2, Per: time.Second},
+			takes: []take{{0, 0, true}, {0, 0, true}, {0, 500 * time.Millisecond, false}, {500 * time.Millisecond, 0, true}, {500 * time.Millisecond, 500 * time.Millisecond, false}},
+		},
+		{
+			name:  "burst",
+			limit: RateLimit{Requests: 10, Per: time.Second, Burst: 1},
+			takes: []take{{0, 0, true}, {0, 100 * time.Millisecond, false}, {time.Second, 0, true}, {time.Second, 100 * time.Millisecond, false}},
+		},
+		{
+			name:  "queue up to MaxWait",
+			limit: RateLimit{Requests: 1, Per: time.Second, MaxWait: 2 * time.Second},
+			takes: []take{{0, 0, true}, {0, time.Second, true}, {0, 2 * time.Second, true}, {0, 3 * time.Second, false}, {time.Second, 2 * time.Second, true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			b := newBucket(tt.limit, start)
+			for i, take := range tt.takes {
+				wait, ok := b.take(tt.limit, start.Add(take.at))
+				if wait != take.wantWait || ok != take.wantOK {
+					t.Errorf("take %d at %v: got %v, %v, want %v, %v", i, take.at, wait, ok, take.wantWait, take.wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "ok") }
+	a, b := newBackend(t, ok), newBackend(t, ok)
+	l := NewRateLimiter(RateLimit{Requests: 2, Per: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if resp, body := roundTrip(t, l, a.URL); resp.StatusCode != http.StatusOK || body != "ok" {
+			t.Fatalf("request %d: got %d %q, want it sent", i, resp.StatusCode, body)
+		}
+	}
+	resp, body := roundTrip(t, l, a.URL)
+	if resp.StatusCode != http.StatusTooManyRequests || body != "upstream rate limit exceeded\n" {
+		t.Errorf("over the limit: got %d %q, want a 429", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "1800" {
+		t.Errorf("Retry-After = %q, want 1800", got)
+	}
+	if n := a.count(); n != 2 {
+		t.Errorf("target got %d requests, want 2", n)
+	}
+
+	// each host has a bucket of its own
+	if resp, _ := roundTrip(t, l, b.URL); resp.StatusCode != http.StatusOK {
+		t.Errorf("other host: got %d, want it sent", resp.StatusCode)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	target := newBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	l := NewRateLimiter(RateLimit{Requests: 20, Per: time.Second, Burst: 1, MaxWait: time.Second})
+	roundTrip(t, l, target.URL)
+
+	start := time.Now()
+	if resp, _ := roundTrip(t, l, target.URL); resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d, want the request held back and sent", resp.StatusCode)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("sent after %v, want it held back for about 50ms", waited)
+	}
+
+	// a request given up while waiting returns its token
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", target.URL, nil)
+	if _, err := l.RoundTrip(req); err != context.Canceled {
+		t.Errorf("canceled while waiting: error %v, want %v", err, context.Canceled)
+	}
+	if n := target.count(); n != 2 {
+		t.Errorf("target got %d requests, want 2", n)
+	}
+}
@@ -0,0 +1,162 @@
+package apiproxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// Config is the configuration of a Proxy
+type Config struct {
+	// Routes are tried in order, as by NewCachingMultiHostReverseProxy
+	Routes []Route
+}
+
+// Proxy is a handler proxying requests like NewCachingMultiHostReverseProxy,
+// whose Config can be replaced while it serves, e.g. to change targets, TTLs
+// or policies. Requests in flight carry on with the configuration they
+// started with.
+//
+// Routes that keep their Host and Prefix across a reload keep the memory
+// cache built for them when their Options set no Cache, with its MaxTTL and
+// JanitorInterval brought up to date; it is only replaced if MaxBytes
+// changes. Caches set in Options are the caller's to keep across reloads.
+type Proxy struct {
+	mu      sync.Mutex
+	current atomic.Value // *routeSet
+	caches  map[string]*routeCache
+	// retired counts the route sets replaced by Reload whose background work
+	// is being shut down
+	retired  sync.WaitGroup
+	shutdown bool
+}
+
+// routeCache is the memory cache of a route whose Options set no Cache, swept
+// every janitor if it is positive
+type routeCache struct {
+	cache    *httpcache.MemoryCache
+	maxBytes int64
+	janitor  time.Duration
+}
+
+// NewProxy returns a new Proxy serving conf
+func NewProxy(conf Config) *Proxy {
+	p := &Proxy{caches: make(map[string]*routeCache)}
+	p.Reload(conf)
+	return p
+}
+
+// Reload replaces the configuration of p with conf. It has no effect once p
+// is shut down.
+func (p *Proxy) Reload(conf Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.shutdown {
+		return
+	}
+
+	routes := make([]Route, len(conf.Routes))
+	caches := make(map[string]*routeCache)
+	for i, route := range conf.Routes {
+		if route.Options.Cache == nil {
+			key := strings.ToLower(route.Host) + " " + strings.TrimSuffix(route.Prefix, "/")
+			rc := p.caches[key]
+			if rc == nil || rc.maxBytes != route.Options.MaxBytes {
+				rc = &routeCache{cache: newMemoryCache(route.Options), maxBytes: route.Options.MaxBytes}
+			} else {
+				rc.cache.SetMaxTTL(route.Options.MaxTTL)
+			}
+			if interval := route.Options.JanitorInterval; interval != rc.janitor {
+				if interval > 0 {
+					rc.cache.StartJanitor(interval)
+				} else {
+					rc.cache.Stop()
+				}
+				rc.janitor = interval
+			}
+			caches[key] = rc
+			route.Options.Cache = rc.cache
+		}
+		routes[i] = route
+	}
+	for key, rc := range p.caches {
+		if caches[key] != rc {
+			// still serving the requests in flight, but no longer swept
+			rc.cache.Stop()
+		}
+	}
+	p.caches = caches
+	old, _ := p.current.Load().(*routeSet)
+	p.current.Store(newRouteSet(routes))
+	if old != nil {
+		// the requests in flight on old carry on, but no longer start
+		// background revalidations
+		p.retired.Add(1)
+		go func() {
+			defer p.retired.Done()
+			old.shutdown(context.Background())
+		}()
+	}
+}
+
+// Shutdown stops the background work of the routes of p, such as
+// revalidations of stale responses and the janitors of their memory caches,
+// and waits for it to finish storing its responses, or for ctx to end, in
+// which case it returns its error, see httpcache.Transport.Shutdown.
+// Requests are still answered, so it is meant to be called once they have
+// drained, e.g. by Server.Shutdown.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.shutdown = true
+	current := p.current.Load().(*routeSet)
+	for _, rc := range p.caches {
+		rc.cache.Stop()
+	}
+	p.mu.Unlock()
+
+	if err := current.shutdown(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		p.retired.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ServeHTTP proxies r with the current configuration
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.current.Load().(*routeSet).ServeHTTP(w, r)
+}
+
+// ReloadHandler returns a handler reloading p with the Config returned by
+// load when it receives a POST, e.g. to mount at /-/reload. If load fails,
+// the configuration is left as is and the error returned to the client, so
+// the handler must not be reachable by untrusted clients.
+func (p *Proxy) ReloadHandler(load func() (Config, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		conf, err := load()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.Reload(conf)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,87 @@
+package apiproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProxyReload(t *testing.T) {
+	a := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		fmt.Fprint(w, "a ", r.URL.Path)
+	})
+	b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "b ", r.URL.Path)
+	})
+	aURL, _ := url.Parse(a.URL)
+	bURL, _ := url.Parse(b.URL)
+	opts := Options{MaxTTL: time.Hour}
+
+	p := NewProxy(Config{Routes: []Route{{Prefix: "/api", Target: aURL, Options: opts}}})
+	defer p.Shutdown(context.Background())
+	if got := serve(p, "/api/x").Body.String(); got != "a /x" {
+		t.Fatalf("got %q, want a /x", got)
+	}
+
+	p.Reload(Config{Routes: []Route{
+		{Prefix: "/api/", Target: aURL, Options: Options{MaxTTL: 2 * time.Hour}},
+		{Prefix: "/other", Target: bURL, Options: opts},
+	}})
+	if got := serve(p, "/api/x").Body.String(); got != "a /x" || a.count() != 1 {
+		t.Errorf("got %q after %d requests to the target, want a /x from the cache kept by the route", got, a.count())
+	}
+	if got := serve(p, "/other/y").Body.String(); got != "b /y" {
+		t.Errorf("got %q from the added route, want b /y", got)
+	}
+
+	p.Reload(Config{Routes: []Route{{Prefix: "/v2", Target: aURL, Options: opts}}})
+	if code := serve(p, "/api/x").Code; code != http.StatusNotFound {
+		t.Errorf("got %d from a removed route, want 404", code)
+	}
+	if got := serve(p, "/v2/x").Body.String(); got != "a /x" || a.count() != 2 {
+		t.Errorf("got %q after %d requests to the target, want a /x from the target for a new route", got, a.count())
+	}
+}
+
+func TestProxyReloadHandler(t *testing.T) {
+	b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	})
+	target, _ := url.Parse(b.URL)
+	p := NewProxy(Config{Routes: []Route{{Prefix: "/old", Target: target, Options: Options{MaxTTL: time.Hour}}}})
+	defer p.Shutdown(context.Background())
+
+	var conf Config
+	var loadErr error
+	h := p.ReloadHandler(func() (Config, error) { return conf, loadErr })
+	reload := func(method string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/-/reload", nil))
+		return rec.Code
+	}
+
+	if code := reload("GET"); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET got %d, want 405", code)
+	}
+	loadErr = errors.New("malformed config")
+	if code := reload("POST"); code != http.StatusInternalServerError {
+		t.Errorf("POST with a failing load got %d, want 500", code)
+	}
+	if code := serve(p, "/old/x").Code; code != http.StatusOK {
+		t.Errorf("after a failed reload got %d, want the previous config kept", code)
+	}
+
+	conf, loadErr = Config{Routes: []Route{{Prefix: "/new", Target: target, Options: Options{MaxTTL: time.Hour}}}}, nil
+	if code := reload("POST"); code != http.StatusNoContent {
+		t.Errorf("POST got %d, want 204", code)
+	}
+	if code := serve(p, "/new/x").Code; code != http.StatusOK {
+		t.Errorf("got %d from the reloaded route, want 200", code)
+	}
+}
@@ -0,0 +1,192 @@
+package apiproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rewrite declares how a caching reverse proxy rewrites the requests it
+// proxies and the responses it gets back, see Options.Rewrite. Requests are
+// rewritten before they are looked up in the cache, so the rewritten request
+// is the one keyed on, and responses before they are stored, so cached
+// responses are served as rewritten.
+type Rewrite struct {
+	// StripPrefix, if set, is removed from the path of the requests starting
+	// with it, so that with "/v1" a request for "/v1/users" is proxied to
+	// "/users" on the target
+	StripPrefix string
+
+	// Request and Response rewrite the headers of the requests sent to the
+	// target and of the responses it returns
+	Request  HeaderRewrite
+	Response HeaderRewrite
+
+	// Body rewrites the bodies of the responses, e.g. to turn the absolute
+	// URLs of the target in JSON payloads into URLs of the proxy
+	Body []BodyRewrite
+}
+
+// HeaderRewrite rewrites a set of headers: Rename is applied first, then
+// Remove, then Set
+type HeaderRewrite struct {
+	// Rename maps the headers to rename to their new names
+	Rename map[string]string
+	// Remove lists the headers to remove
+	Remove []string
+	// Set maps headers to the values they are set to, replacing any they had
+	Set map[string]string
+}
+
+// BodyRewrite replaces the matches of Pattern in the bodies of the responses
+// whose media type is listed in ContentTypes with Replacement, which may
+// refer to submatches as regexp.Regexp.Expand does, e.g. $1. ContentTypes may
+// list a whole type as "type/*", e.g. "text/*"; if empty, every body is
+// rewritten.
+//
+// Bodies are read whole to be rewritten. Those compressed with gzip are
+// decompressed and served uncompressed; those with any other Content-Encoding
+// are left as they are.
+type BodyRewrite struct {
+	ContentTypes []string
+	Pattern      *regexp.Regexp
+	Replacement  string
+}
+
+// rewrite returns a copy of h rewritten by hr
+func (hr *HeaderRewrite) rewrite(h http.Header) http.Header {
+	if len(hr.Rename) == 0 && len(hr.Remove) == 0 && len(hr.Set) == 0 {
+		return h
+	}
+	h = h.Clone()
+	for from, to := range hr.Rename {
+		if v, ok := h[http.CanonicalHeaderKey(from)]; ok {
+			h.Del(from)
+			h[http.CanonicalHeaderKey(to)] = v
+		}
+	}
+	for _, k := range hr.Remove {
+		h.Del(k)
+	}
+	for k, v := range hr.Set {
+		h.Set(k, v)
+	}
+	return h
+}
+
+// rewriteRequest rewrites r, which the proxy's Director is then called with,
+// in place
+func (rw *Rewrite) rewriteRequest(r *http.Request) {
+	if prefix := strings.TrimSuffix(rw.StripPrefix, "/"); prefix != "" {
+		stripPathPrefix(r.URL, prefix)
+	}
+	r.Header = rw.Request.rewrite(r.Header)
+}
+
+// rewriteResponse rewrites resp in place
+func (rw *Rewrite) rewriteResponse(resp *http.Response) error {
+	resp.Header = rw.Response.rewrite(resp.Header)
+	if len(rw.Body) == 0 || !bodyAllowed(resp) {
+		return nil
+	}
+	var rules []BodyRewrite
+	for _, br := range rw.Body {
+		if br.Pattern != nil && matchesContentType(resp.Header.Get("Content-Type"), br.ContentTypes) {
+			rules = append(rules, br)
+		}
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if len(rules) == 0 || (encoding != "" && encoding != "identity" && encoding != "gzip") {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		if b, err = ioutil.ReadAll(zr); err != nil {
+			return err
+		}
+		resp.Header.Del("Content-Encoding")
+		resp.Uncompressed = true
+	}
+	for _, br := range rules {
+		b = br.Pattern.ReplaceAll(b, []byte(br.Replacement))
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	return nil
+}
+
+// bodyAllowed returns false for the responses that have no body
+func bodyAllowed(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == "HEAD" {
+		return false
+	}
+	switch {
+	case resp.StatusCode >= 100 && resp.StatusCode < 200,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// matchesContentType returns true if the media type of the Content-Type
+// header v is listed in types, or if types is empty
+func matchesContentType(v string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	if i := strings.IndexByte(v, ';'); i >= 0 {
+		v = v[:i]
+	}
+	v = strings.TrimSpace(v)
+	for _, ct := range types {
+		if strings.HasSuffix(ct, "/*") {
+			if len(v) > len(ct)-1 && strings.EqualFold(v[:len(ct)-1], ct[:len(ct)-1]) {
+				return true
+			}
+		} else if strings.EqualFold(v, ct) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteTransport is an implementation of net/http.RoundTripper rewriting the
+// responses of the underlying transport
+type rewriteTransport struct {
+	transport http.RoundTripper
+	rewrite   *Rewrite
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Request == nil {
+		resp.Request = req
+	}
+	if err := t.rewrite.rewriteResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,128 @@
+package apiproxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewrite(t *testing.T) {
+	b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Server", "origin/1.0")
+		w.Header().Set("X-Powered-By", "php")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintf(w, `{"path":%q,"auth":%q,"accept":%q,"next":"https://api.example.com/v1/page/2"}`,
+			r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Accept"))
+	})
+	target, _ := url.Parse(b.URL)
+	proxy := NewCachingReverseProxy(target, Options{MaxTTL: time.Hour, Rewrite: &Rewrite{
+		StripPrefix: "/v1/",
+		Request: HeaderRewrite{
+			Rename: map[string]string{"x-client-token": "Authorization"},
+			Set:    map[string]string{"Accept": "application/json"},
+		},
+		Response: HeaderRewrite{
+			Remove: []string{"Server", "X-Powered-By"},
+			Set:    map[string]string{"X-Rewritten": "1"},
+		},
+		Body: []BodyRewrite{{
+			ContentTypes: []string{"application/json"},
+			Pattern:      regexp.MustCompile(`https://api\.example\.com/v1(/\S*?)"`),
+			Replacement:  `https://proxy.example.com/example$1"`,
+		}},
+	}})
+
+	want := `{"path":"/users","auth":"secret","accept":"application/json","next":"https://proxy.example.com/example/page/2"}`
+	for i := 0; i < 2; i++ {
+		rec := serve(proxy, "/v1/users", "X-Client-Token", "secret", "Accept", "text/html")
+		if got := rec.Body.String(); got != want {
+			t.Errorf("request %d: got %s, want %s", i, got, want)
+		}
+		h := rec.Header()
+		if h.Get("Server") != "" || h.Get("X-Powered-By") != "" || h.Get("X-Rewritten") != "1" {
+			t.Errorf("request %d: response headers not rewritten: %v", i, h)
+		}
+		if h.Get("Content-Length") != fmt.Sprint(len(want)) {
+			t.Errorf("request %d: Content-Length = %s, want %d", i, h.Get("Content-Length"), len(want))
+		}
+	}
+	if b.count() != 1 {
+		t.Errorf("got %d requests to the target, want the rewritten response cached", b.count())
+	}
+	if got := serve(proxy, "/v1").Body.String(); !strings.Contains(got, `"path":"/"`) {
+		t.Errorf("GET /v1 got %s, want the prefix stripped to /", got)
+	}
+	if got := serve(proxy, "/v10/x").Body.String(); !strings.Contains(got, `"path":"/v10/x"`) {
+		t.Errorf("GET /v10/x got %s, want the path kept", got)
+	}
+	req := httptest.NewRequest("GET", "/v1/a%2Fb", nil)
+	(&Rewrite{StripPrefix: "/v1"}).rewriteRequest(req)
+	if got := req.URL.EscapedPath(); got != "/a%2Fb" {
+		t.Errorf("stripping /v1 from /v1/a%%2Fb got %s, want the escape kept", got)
+	}
+}
+
+func TestRewriteBody(t *testing.T) {
+	b := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		if r.URL.Query().Get("gzip") != "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			fmt.Fprint(zw, "hello world")
+			zw.Close()
+			return
+		}
+		fmt.Fprint(w, "hello world")
+	})
+	target, _ := url.Parse(b.URL)
+	proxy := NewCachingReverseProxy(target, Options{MaxTTL: time.Hour, Rewrite: &Rewrite{
+		Body: []BodyRewrite{{ContentTypes: []string{"text/*"}, Pattern: regexp.MustCompile(`w(or)ld`), Replacement: "${1}acle"}},
+	}})
+
+	tests := []struct {
+		name, query, want string
+	}{
+		{"matching type", "type=text/plain", "hello oracle"},
+		{"other type", "type=application/octet-stream", "hello world"},
+		{"gzip", "type=text/plain&gzip=1", "hello oracle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(proxy, "/?"+tt.query, "Accept-Encoding", "gzip")
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+				t.Errorf("Content-Encoding = %q, want the rewritten body served uncompressed", ce)
+			}
+		})
+	}
+}
+
+func TestMatchesContentType(t *testing.T) {
+	tests := []struct {
+		v     string
+		types []string
+		want  bool
+	}{
+		{"application/json", nil, true},
+		{"application/json; charset=utf-8", []string{"application/json"}, true},
+		{"Application/JSON", []string{"application/json"}, true},
+		{"text/html", []string{"text/*"}, true},
+		{"text/", []string{"text/*"}, false},
+		{"textual/html", []string{"text/*"}, false},
+		{"application/xml", []string{"application/json", "text/*"}, false},
+	}
+	for _, tt := range tests {
+		if got := matchesContentType(tt.v, tt.types); got != tt.want {
+			t.Errorf("matchesContentType(%q, %q) = %v, want %v", tt.v, tt.types, got, tt.want)
+		}
+	}
+}
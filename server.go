@@ -0,0 +1,135 @@
+package apiproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// Server runs an HTTP server for a proxy, which Shutdown stops without
+// dropping the requests in flight or losing the writes pending to its caches,
+// e.g. on SIGTERM.
+type Server struct {
+	// Addr is the address to listen on, ":http" if empty
+	Addr    string
+	Handler http.Handler
+	// TLSConfig, if set, serves over HTTPS with its certificates, as
+	// ListenAndServeTLS does
+	TLSConfig *tls.Config
+
+	// Proxy, if set, is the Proxy served by Handler, whose background work
+	// is stopped once requests have drained
+	Proxy *Proxy
+	// Transports are the caching transports of the proxies served by Handler
+	// other than Proxy, e.g. those built by NewCachingReverseProxy, whose
+	// background work is stopped once requests have drained
+	Transports []*httpcache.Transport
+	// Caches are closed last, e.g. to flush a bolt database to disk, if they
+	// have a Close method
+	Caches []httpcache.Cache
+
+	mu       sync.Mutex
+	srv      *http.Server
+	serveErr chan error
+}
+
+// NewServer returns a new Server on addr for h
+func NewServer(addr string, h http.Handler) *Server {
+	return &Server{Addr: addr, Handler: h}
+}
+
+// Start starts listening on s.Addr and serving requests in the background. It
+// returns an error if s can't listen.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv != nil {
+		return errors.New("apiproxy: server already started")
+	}
+
+	srv := &http.Server{Addr: s.Addr, Handler: s.Handler, ReadHeaderTimeout: serverReadHeaderTimeout}
+	addr := s.Addr
+	if s.TLSConfig != nil {
+		config, err := serverTLSConfig(s.TLSConfig)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = config
+		if addr == "" {
+			addr = ":https"
+		}
+	} else if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	s.srv, s.serveErr = srv, serveErr
+	go func() {
+		if srv.TLSConfig != nil {
+			serveErr <- srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops s gracefully: it stops listening, waits for the requests in
+// flight to be answered, stops the background work of s.Proxy and
+// s.Transports, then closes s.Caches. If ctx ends first, the connections
+// left are closed, the caches closed all the same, and the error of ctx
+// returned. Shutdown also returns the error s stopped serving with, if it
+// did before being shut down.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv, serveErr := s.srv, s.serveErr
+	s.serveErr = nil
+	s.mu.Unlock()
+	if srv == nil {
+		return errors.New("apiproxy: server not started")
+	}
+	if serveErr == nil {
+		return errors.New("apiproxy: server already shut down")
+	}
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		srv.Close()
+	}
+	if serr := <-serveErr; serr != http.ErrServerClosed && err == nil {
+		err = serr
+	}
+	if s.Proxy != nil {
+		if perr := s.Proxy.Shutdown(ctx); err == nil {
+			err = perr
+		}
+	}
+	for _, t := range s.Transports {
+		if terr := t.Shutdown(ctx); err == nil {
+			err = terr
+		}
+	}
+	for _, c := range s.Caches {
+		var cerr error
+		switch c := c.(type) {
+		case io.Closer:
+			cerr = c.Close()
+		case interface{ Close() }:
+			c.Close()
+		}
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
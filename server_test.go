@@ -0,0 +1,117 @@
+package apiproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// closingCache is a cache recording whether it was closed
+type closingCache struct {
+	httpcache.Cache
+	closed chan struct{}
+}
+
+func (c *closingCache) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// startServer starts a server for h on a free local port and returns it
+// along with its URL
+func startServer(t *testing.T, h http.Handler, caches ...httpcache.Cache) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	s := NewServer(addr, h)
+	s.Caches = caches
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return s, "http://" + addr
+}
+
+func TestServerShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	cache := &closingCache{Cache: httpcache.NewMemoryCache(time.Hour), closed: make(chan struct{})}
+	s, url := startServer(t, h, cache)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	case <-cache.closed:
+		t.Fatal("the cache was closed with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("a new connection was accepted while shutting down")
+	}
+
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("the request in flight got %q, want done", got)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	select {
+	case <-cache.closed:
+	default:
+		t.Error("the cache was not closed")
+	}
+	if err := s.Shutdown(context.Background()); err == nil {
+		t.Error("got no error shutting down twice")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	cache := &closingCache{Cache: httpcache.NewMemoryCache(time.Hour), closed: make(chan struct{})}
+	s, url := startServer(t, h, cache)
+	go http.Get(url)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-cache.closed:
+	default:
+		t.Error("the cache was not closed after the timeout")
+	}
+}
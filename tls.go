@@ -0,0 +1,205 @@
+package apiproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// CertReloader holds a certificate and key loaded from files, which it can
+// reload without a restart, e.g. once they are renewed. Its GetCertificate
+// method serves it to the clients of a TLS server, and GetClientCertificate
+// presents it to upstreams requiring mutual TLS.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	// Logger receives a message for each reload, and its failures. If nil,
+	// they are discarded.
+	Logger httpcache.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader returns a new CertReloader for the PEM encoded certificate
+// and key in certFile and keyFile, which are loaded right away
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate and key again. If they can't be loaded, the
+// previous ones are kept.
+func (r *CertReloader) Reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	if r.Logger != nil {
+		r.Logger.Debugf("[tls] loaded %s", r.CertFile)
+	}
+	return nil
+}
+
+// filesModTime returns the latest modification time of the certificate and
+// key files
+func (r *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.CertFile, r.KeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Watch starts a goroutine reloading the certificate and key when the process
+// receives SIGHUP, or when the files change, which is checked every interval
+// if it is positive. Call the returned function to stop it.
+func (r *CertReloader) Watch(interval time.Duration) (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+			case <-tick:
+				modTime, err := r.filesModTime()
+				r.mu.RLock()
+				changed := err == nil && !modTime.Equal(r.modTime)
+				r.mu.RUnlock()
+				if !changed {
+					continue
+				}
+			case <-done:
+				return
+			}
+			if err := r.Reload(); err != nil && r.Logger != nil {
+				r.Logger.Errorf("[tls] reloading %s: %s", r.CertFile, err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(hup)
+			if ticker != nil {
+				ticker.Stop()
+			}
+			close(done)
+		})
+	}
+}
+
+// GetCertificate returns the current certificate, for use as the
+// tls.Config.GetCertificate of a server
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate returns the current certificate, for use as the
+// tls.Config.GetClientCertificate of a client
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ClientTLSConfig returns a TLS configuration for reaching an upstream, to set
+// as Upstream.TLSClientConfig. If certs is not nil, its certificate is
+// presented to upstreams requiring mutual TLS. If caFile is not empty, the
+// upstream's certificate must be signed by one of the PEM encoded
+// certificates it holds, rather than by a system root.
+func ClientTLSConfig(certs *CertReloader, caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certs != nil {
+		config.GetClientCertificate = certs.GetClientCertificate
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("apiproxy: no certificates in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// ListenAndServeTLS runs an HTTPS server on addr passing requests to h, e.g. a
+// proxy built by this package, with the server certificates of config: its
+// GetCertificate may be that of a CertReloader, or of a Let's Encrypt
+// client such as golang.org/x/crypto/acme/autocert's Manager, whose
+// TLSConfig method returns a complete config. TLS versions before 1.2 are
+// refused unless config allows them.
+func ListenAndServeTLS(addr string, h http.Handler, config *tls.Config) error {
+	config, err := serverTLSConfig(config)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		TLSConfig:         config,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+	}
+	return srv.ListenAndServeTLS("", "")
+}
+
+// serverReadHeaderTimeout bounds the wait for the headers of requests to the
+// servers run by this package
+const serverReadHeaderTimeout = 30 * time.Second
+
+// serverTLSConfig returns a copy of config, refusing TLS versions before 1.2
+// unless it allows them
+func serverTLSConfig(config *tls.Config) (*tls.Config, error) {
+	if config == nil || config.GetCertificate == nil && len(config.Certificates) == 0 {
+		return nil, errors.New("apiproxy: TLS config has no certificate")
+	}
+	config = config.Clone()
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	return config, nil
+}
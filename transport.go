@@ -2,10 +2,11 @@ package apiproxy
 
 import (
 	"bytes"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
 )
 
 // RevalidationTransport is an implementation of net/http.RoundTripper that
@@ -28,18 +29,22 @@ type RevalidationTransport struct {
 
 	// Transport is the underlying transport. If nil, net/http.DefaultTransport is used.
 	Transport http.RoundTripper
+
+	// Logger receives a message for each revalidation decision. If nil, they
+	// are discarded.
+	Logger httpcache.Logger
 }
 
 // RoundTrip takes a Request and returns a Response.
 func (t *RevalidationTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 	if t.Check != nil && hasCacheValidator(req.Header) {
-		fmt.Println("VALIDATING")
+		t.debugf("VALIDATING %s", req.URL)
 		agestr := req.Header.Get("Age")
 		if agestr != "" {
 			var age time.Duration
 			age, err = time.ParseDuration(agestr + "s")
 			if err == nil && t.Check.Valid(req.URL, age) {
-				fmt.Println("NOT MODIFIED")
+				t.debugf("NOT MODIFIED %s", req.URL)
 				resp = &http.Response{
 					Request:          req,
 					TransferEncoding: req.TransferEncoding,
@@ -59,6 +64,13 @@ func (t *RevalidationTransport) RoundTrip(req *http.Request) (resp *http.Respons
 	return transport.RoundTrip(req)
 }
 
+// debugf passes a message to t.Logger, if set
+func (t *RevalidationTransport) debugf(format string, args ...interface{}) {
+	if t.Logger != nil {
+		t.Logger.Debugf(format, args...)
+	}
+}
+
 // hasCacheValidator returns true if the headers contain cache validators. See
 // http://www.w3.org/Protocols/rfc2616/rfc2616-sec13.html#sec13.3 for more
 // information.
@@ -0,0 +1,125 @@
+package apiproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Upstream configures the connections a proxy makes to its target. Zero
+// fields keep the settings of net/http.DefaultTransport, which has no
+// response header or request timeout, so a target that stops answering holds
+// requests up forever.
+type Upstream struct {
+	// DialTimeout bounds establishing a connection
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for the response headers once a
+	// request has been sent
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout is how long idle connections are kept open
+	IdleConnTimeout time.Duration
+	// MaxIdleConnsPerHost bounds the idle connections kept open to each
+	// host, 2 if unset
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections open to each host, unbounded if
+	// unset
+	MaxConnsPerHost int
+
+	// TLSClientConfig, if set, configures the TLS connections to the target,
+	// e.g. to present a client certificate to one requiring mutual TLS, see
+	// ClientTLSConfig
+	TLSClientConfig *tls.Config
+
+	// RequestTimeout bounds each request as a whole, from sending it to
+	// reading the last byte of its response. Requests for WebSockets and
+	// server-sent events, which are meant to last, are exempt.
+	RequestTimeout time.Duration
+}
+
+// NewUpstreamTransport returns a new transport reaching upstreams as configured
+// by u
+func NewUpstreamTransport(u Upstream) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if u.DialTimeout > 0 {
+		dialer := &net.Dialer{Timeout: u.DialTimeout, KeepAlive: 30 * time.Second}
+		t.DialContext = dialer.DialContext
+	}
+	if u.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = u.TLSHandshakeTimeout
+	}
+	if u.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = u.ResponseHeaderTimeout
+	}
+	if u.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = u.IdleConnTimeout
+	}
+	if u.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = u.MaxIdleConnsPerHost
+		if t.MaxIdleConns > 0 && t.MaxIdleConns < u.MaxIdleConnsPerHost {
+			t.MaxIdleConns = u.MaxIdleConnsPerHost
+		}
+	}
+	t.MaxConnsPerHost = u.MaxConnsPerHost
+	if u.TLSClientConfig != nil {
+		t.TLSClientConfig = u.TLSClientConfig.Clone()
+	}
+	if u.RequestTimeout > 0 {
+		return &deadlineTransport{Transport: t, timeout: u.RequestTimeout}
+	}
+	return t
+}
+
+// deadlineTransport bounds the requests passed to Transport by timeout
+type deadlineTransport struct {
+	Transport http.RoundTripper
+	timeout   time.Duration
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Upgrade") != "" || strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		return t.Transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody is a response body releasing the deadline of its request once
+// closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// upstreamErrorHandler answers the requests the proxy failed to get a
+// response to with 504 Gateway Timeout if the target was too slow, and 502
+// Bad Gateway otherwise, like httputil.ReverseProxy
+func upstreamErrorHandler(logf func(format string, args ...interface{})) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		logf("%s: %s", r.URL, err)
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,87 @@
+package apiproxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bcicen/apiproxy/httpcache"
+)
+
+// httpTransport returns the *http.Transport rt is, or wraps in a
+// deadlineTransport
+func httpTransport(t *testing.T, rt http.RoundTripper) *http.Transport {
+	t.Helper()
+	if dt, ok := rt.(*deadlineTransport); ok {
+		rt = dt.Transport
+	}
+	ht, ok := rt.(*http.Transport)
+	if !ok {
+		t.Fatalf("transport is a %T, want an *http.Transport", rt)
+	}
+	return ht
+}
+
+func TestNewUpstreamTransport(t *testing.T) {
+	def := http.DefaultTransport.(*http.Transport)
+	tests := []struct {
+		name                string
+		u                   Upstream
+		wantIdlePerHost     int
+		wantMaxIdle         int
+		wantIdleTimeout     time.Duration
+		wantConnsPerHost    int
+		wantDeadlineWrapper bool
+	}{
+		{name: "defaults", wantIdlePerHost: 0, wantMaxIdle: def.MaxIdleConns, wantIdleTimeout: def.IdleConnTimeout},
+		{
+			name:            "pool",
+			u:               Upstream{MaxIdleConnsPerHost: 64, MaxConnsPerHost: 128, IdleConnTimeout: time.Minute},
+			wantIdlePerHost: 64, wantMaxIdle: def.MaxIdleConns, wantIdleTimeout: time.Minute, wantConnsPerHost: 128,
+		},
+		{
+			name:            "more idle per host than in all",
+			u:               Upstream{MaxIdleConnsPerHost: def.MaxIdleConns + 50},
+			wantIdlePerHost: def.MaxIdleConns + 50, wantMaxIdle: def.MaxIdleConns + 50, wantIdleTimeout: def.IdleConnTimeout,
+		},
+		{
+			name:            "request timeout",
+			u:               Upstream{MaxIdleConnsPerHost: 8, RequestTimeout: time.Second},
+			wantIdlePerHost: 8, wantMaxIdle: def.MaxIdleConns, wantIdleTimeout: def.IdleConnTimeout, wantDeadlineWrapper: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewUpstreamTransport(tt.u)
+			if _, ok := rt.(*deadlineTransport); ok != tt.wantDeadlineWrapper {
+				t.Errorf("request deadline = %v, want %v", ok, tt.wantDeadlineWrapper)
+			}
+			ht := httpTransport(t, rt)
+			if ht == def {
+				t.Fatal("http.DefaultTransport modified in place")
+			}
+			if ht.MaxIdleConnsPerHost != tt.wantIdlePerHost {
+				t.Errorf("MaxIdleConnsPerHost = %d, want %d", ht.MaxIdleConnsPerHost, tt.wantIdlePerHost)
+			}
+			if ht.MaxIdleConns != tt.wantMaxIdle {
+				t.Errorf("MaxIdleConns = %d, want %d", ht.MaxIdleConns, tt.wantMaxIdle)
+			}
+			if ht.IdleConnTimeout != tt.wantIdleTimeout {
+				t.Errorf("IdleConnTimeout = %v, want %v", ht.IdleConnTimeout, tt.wantIdleTimeout)
+			}
+			if ht.MaxConnsPerHost != tt.wantConnsPerHost {
+				t.Errorf("MaxConnsPerHost = %d, want %d", ht.MaxConnsPerHost, tt.wantConnsPerHost)
+			}
+		})
+	}
+}
+
+func TestCachingReverseProxyUpstream(t *testing.T) {
+	target, _ := url.Parse("http://example.com")
+	proxy := NewCachingReverseProxy(target, Options{MaxTTL: time.Minute, Upstream: &Upstream{MaxIdleConnsPerHost: 32}})
+	ht := httpTransport(t, proxy.Transport.(*httpcache.Transport).Transport)
+	if ht.MaxIdleConnsPerHost != 32 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want 32", ht.MaxIdleConnsPerHost)
+	}
+}
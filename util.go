@@ -2,6 +2,8 @@ package apiproxy
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 // cloneRequest returns a clone of the provided *http.Request. The clone is a
@@ -18,3 +20,25 @@ func cloneRequest(r *http.Request) *http.Request {
 	}
 	return r2
 }
+
+// stripPathPrefix removes prefix, which has no trailing slash, from the path
+// of u in place if it is prefix or lies below it, and returns true if it did.
+// As with net/http.StripPrefix, the escaped path is stripped as well, so that
+// escapes such as %2F in the rest of the path reach the target unchanged.
+func stripPathPrefix(u *url.URL, prefix string) bool {
+	if u.Path != prefix && !strings.HasPrefix(u.Path, prefix+"/") {
+		return false
+	}
+	u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, prefix), "/")
+	if u.RawPath != "" {
+		rawPrefix := (&url.URL{Path: prefix}).EscapedPath()
+		if u.RawPath == rawPrefix || strings.HasPrefix(u.RawPath, rawPrefix+"/") {
+			u.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(u.RawPath, rawPrefix), "/")
+		} else {
+			// the prefix itself is escaped, which is rare enough to let the
+			// path be escaped anew
+			u.RawPath = ""
+		}
+	}
+	return true
+}